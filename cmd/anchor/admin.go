@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/proxy"
+	"github.com/transientvariable/log-go"
+)
+
+// newAdminHandler returns the http.Handler for the admin endpoints:
+//
+//	GET  /healthz  readiness of the proxy, 503 while draining
+//	GET  /status   state of the balancers and routes as JSON
+//	GET  /config   current configuration as JSON
+//	POST /reload   reload the configuration file
+//	GET  /metrics  metrics in the Prometheus text exposition format
+func newAdminHandler(path string, router *proxy.Router, m *metrics, draining *atomic.Bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok\n")
+	})
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, router.String())
+	})
+
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, router.Config().String())
+	})
+
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := proxy.LoadConfig(path)
+		if err == nil {
+			err = router.Apply(cfg)
+		}
+
+		if err != nil {
+			log.Error("[anchor:admin] could not reload config", log.Err(err))
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		_, _ = io.WriteString(w, "reloaded\n")
+	})
+
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.write(w, router)
+	})
+	return mux
+}
+
+// metrics records request metrics for the proxy.
+type metrics struct {
+	duration float64
+	inflight atomic.Int64
+	mutex    sync.Mutex
+	requests map[string]int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: make(map[string]int64)}
+}
+
+// middleware records the number, status, and duration of the requests served by the provided http.Handler.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inflight.Add(1)
+		defer m.inflight.Add(-1)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.requests[strconv.Itoa(sw.status)]++
+		m.duration += time.Since(start).Seconds()
+	})
+}
+
+// write writes the metrics, including the state of the hosts of each balancer, in the Prometheus text format.
+func (m *metrics) write(w io.Writer, router *proxy.Router) {
+	m.mutex.Lock()
+	codes := make([]string, 0, len(m.requests))
+	var total int64
+	for code, n := range m.requests {
+		codes = append(codes, code)
+		total += n
+	}
+	sort.Strings(codes)
+
+	fmt.Fprintln(w, "# HELP anchor_requests_total Number of requests served by the proxy.")
+	fmt.Fprintln(w, "# TYPE anchor_requests_total counter")
+	for _, code := range codes {
+		fmt.Fprintf(w, "anchor_requests_total{code=%q} %d\n", code, m.requests[code])
+	}
+
+	fmt.Fprintln(w, "# HELP anchor_request_duration_seconds Duration of requests served by the proxy.")
+	fmt.Fprintln(w, "# TYPE anchor_request_duration_seconds summary")
+	fmt.Fprintf(w, "anchor_request_duration_seconds_sum %g\n", m.duration)
+	fmt.Fprintf(w, "anchor_request_duration_seconds_count %d\n", total)
+	m.mutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP anchor_requests_inflight Number of requests currently being served by the proxy.")
+	fmt.Fprintln(w, "# TYPE anchor_requests_inflight gauge")
+	fmt.Fprintf(w, "anchor_requests_inflight %d\n", m.inflight.Load())
+
+	balancers := router.Balancers()
+	names := make([]string, 0, len(balancers))
+	for name := range balancers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(*proxy.Host) (float64, bool)
+	}{
+		{"anchor_host_active", "Whether the host is active.", func(h *proxy.Host) (float64, bool) {
+			if h.Active() {
+				return 1, true
+			}
+			return 0, true
+		}},
+		{"anchor_host_failures", "Number of consecutive failures for the host.", func(h *proxy.Host) (float64, bool) {
+			return float64(h.Failures()), true
+		}},
+		{"anchor_host_concurrency_limit", "Current concurrency limit for the host.", func(h *proxy.Host) (float64, bool) {
+			if l := h.Limiter(); l != nil {
+				return float64(l.Limit()), true
+			}
+			return 0, false
+		}},
+		{"anchor_host_inflight", "Number of requests in flight to the host, including upgraded connections.", func(h *proxy.Host) (float64, bool) {
+			return float64(h.Inflight()), true
+		}},
+		{"anchor_host_upgraded_connections", "Number of open connections to the host upgraded from HTTP.", func(h *proxy.Host) (float64, bool) {
+			return float64(h.Upgrades().Active), true
+		}},
+	}
+
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, name := range names {
+			for _, h := range balancers[name].Pool().Hosts() {
+				v, ok := g.value(h)
+				if !ok {
+					continue
+				}
+
+				target := ""
+				if t, err := h.Target(); err == nil {
+					target = t.String()
+				}
+				fmt.Fprintf(w, "%s{balancer=\"%s\",target=\"%s\"} %g\n", g.name, escape(name), escape(target), v)
+			}
+		}
+	}
+}
+
+// statusWriter wraps a http.ResponseWriter for capturing the status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader records the status code and writes it to the underlying http.ResponseWriter.
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// escape escapes a Prometheus label value.
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
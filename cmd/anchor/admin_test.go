@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandler(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	path := writeConfig(t, upstream.URL)
+	router, err := proxy.LoadRouter(path)
+	require.NoError(t, err)
+	defer router.Close()
+
+	var draining atomic.Bool
+	m := newMetrics()
+	f := &proxyFlags{}
+	handler := f.newHandler(router, m)
+	admin := newAdminHandler(path, router, m, &draining)
+
+	get := func(method string, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := get(http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	draining.Store(true)
+	assert.Equal(t, http.StatusServiceUnavailable, get(http.MethodGet, "/healthz").Code)
+
+	w = get(http.MethodGet, "/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), upstream.URL)
+
+	w = get(http.MethodGet, "/config")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"api"`)
+
+	// requests in flight to hosts without a concurrency limiter are reported
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+
+	inflight := fmt.Sprintf("anchor_host_inflight{balancer=\"api\",target=%q} 1", upstream.URL)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(get(http.MethodGet, "/metrics").Body.String(), inflight)
+	}, time.Second, 10*time.Millisecond)
+	close(release)
+	<-done
+
+	w = get(http.MethodGet, "/metrics")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `anchor_requests_total{code="200"} 1`)
+	assert.Contains(t, body, "anchor_request_duration_seconds_count 1")
+	assert.Contains(t, body, "anchor_requests_inflight 0")
+	assert.Contains(t, body, fmt.Sprintf("anchor_host_active{balancer=\"api\",target=%q} 1", upstream.URL))
+	assert.Contains(t, body, fmt.Sprintf("anchor_host_inflight{balancer=\"api\",target=%q} 0", upstream.URL))
+	assert.NotContains(t, body, "anchor_host_concurrency_limit{")
+
+	// invalid configurations are rejected, leaving the current one in place
+	cfg := router.Config()
+	require.NoError(t, os.WriteFile(path, []byte("balancers: ["), 0o600))
+	assert.Equal(t, http.StatusUnprocessableEntity, get(http.MethodPost, "/reload").Code)
+	assert.Same(t, cfg, router.Config())
+
+	writeConfig(t, upstream.URL, path)
+	assert.Equal(t, http.StatusOK, get(http.MethodPost, "/reload").Code)
+	assert.NotSame(t, cfg, router.Config())
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, `a\\b\"c\nd`, escape("a\\b\"c\nd"))
+}
+
+// writeConfig writes a configuration file with the "api" balancer for the target, returning its path. The path of an
+// existing file can be provided for replacing it.
+func writeConfig(t *testing.T, target string, path ...string) string {
+	p := filepath.Join(t.TempDir(), "proxy.yaml")
+	if len(path) > 0 {
+		p = path[0]
+	}
+
+	cfg := fmt.Sprintf("balancers:\n  - name: api\n    hosts:\n      - target: %s\n", target)
+	require.NoError(t, os.WriteFile(p, []byte(cfg), 0o600))
+	return p
+}
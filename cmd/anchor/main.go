@@ -0,0 +1,77 @@
+// Command anchor runs the components of the anchor module as standalone services.
+//
+// Usage:
+//
+//	anchor <command> [flags]
+//
+// The commands are:
+//
+//	proxy    run a reverse proxy using a balancer configuration file
+//	version  print the version
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Set at build time using -ldflags "-X main.commit=...".
+var commit = "dev"
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+func run(args []string, stderr io.Writer) int {
+	commands := []command{
+		{name: "proxy", summary: "run a reverse proxy using a balancer configuration file", run: runProxy},
+		{name: "version", summary: "print the version", run: runVersion},
+	}
+
+	usage := func() {
+		fmt.Fprintf(stderr, "Usage: anchor <command> [flags]\n\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(stderr, "  %-10s %s\n", c.name, c.summary)
+		}
+	}
+
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			if err := c.run(args[1:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					return 2
+				}
+				fmt.Fprintf(stderr, "anchor %s: %v\n", c.name, err)
+				return 1
+			}
+			return 0
+		}
+	}
+
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage()
+		return 0
+	}
+	fmt.Fprintf(stderr, "anchor: unknown command %q\n\n", args[0])
+	usage()
+	return 2
+}
+
+func runVersion([]string) error {
+	fmt.Println("anchor", commit)
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/proxy"
+	"github.com/transientvariable/log-go"
+
+	anet "github.com/transientvariable/anchor/net"
+)
+
+type proxyFlags struct {
+	accessLog             bool
+	admin                 string
+	config                string
+	drainDelay            time.Duration
+	drainTimeout          time.Duration
+	idleTimeout           time.Duration
+	listen                string
+	logLevel              string
+	proxyProtocol         string
+	proxyProtocolRequired bool
+	readHeaderTimeout     time.Duration
+	tlsCert               string
+	tlsKey                string
+	tlsListen             string
+	watchInterval         time.Duration
+}
+
+func runProxy(args []string) error {
+	f := &proxyFlags{}
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	fs.BoolVar(&f.accessLog, "access-log", true, "log a record for every proxied request")
+	fs.StringVar(&f.admin, "admin", "127.0.0.1:9090", "address for the admin and metrics endpoints, empty to disable")
+	fs.StringVar(&f.config, "config", "", "path to the balancer configuration file (YAML or JSON)")
+	fs.DurationVar(&f.drainDelay, "drain-delay", 5*time.Second, "duration the proxy keeps serving after reporting it is not ready on shutdown, 0 to disable")
+	fs.DurationVar(&f.drainTimeout, "drain-timeout", 30*time.Second, "maximum duration for draining in-flight requests on shutdown")
+	fs.DurationVar(&f.idleTimeout, "idle-timeout", 120*time.Second, "maximum duration of idle keep-alive connections")
+	fs.StringVar(&f.listen, "listen", ":8080", "address for the HTTP listener, empty to disable")
+	fs.StringVar(&f.logLevel, "log-level", "info", "log level: trace, debug, info, warn, or error")
+	fs.StringVar(&f.proxyProtocol, "proxy-protocol", "", "comma-separated list of CIDR prefixes trusted to send a PROXY protocol header, empty to disable")
+	fs.BoolVar(&f.proxyProtocolRequired, "proxy-protocol-required", false, "require a PROXY protocol header on connections from trusted prefixes")
+	fs.DurationVar(&f.readHeaderTimeout, "read-header-timeout", 10*time.Second, "maximum duration for reading request headers")
+	fs.StringVar(&f.tlsCert, "tls-cert", "", "path to the PEM encoded certificate for the HTTPS listener")
+	fs.StringVar(&f.tlsKey, "tls-key", "", "path to the PEM encoded private key for the HTTPS listener")
+	fs.StringVar(&f.tlsListen, "tls-listen", "", "address for the HTTPS listener, empty to disable")
+	fs.DurationVar(&f.watchInterval, "watch-interval", proxy.WatchInterval, "interval for checking the configuration file for changes, 0 to only reload on SIGHUP")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := f.validate(); err != nil {
+		fs.Usage()
+		return err
+	}
+
+	if err := log.SetDefault(log.New(log.WithLevel(f.logLevel))); err != nil {
+		return err
+	}
+
+	router, err := proxy.LoadRouter(f.config)
+	if err != nil {
+		return err
+	}
+	defer router.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	metrics := newMetrics()
+	handler := f.newHandler(router, metrics)
+
+	var servers []*http.Server
+	if f.listen != "" {
+		servers = append(servers, f.newServer(f.listen, handler))
+	}
+
+	if f.tlsListen != "" {
+		cert, err := tls.LoadX509KeyPair(f.tlsCert, f.tlsKey)
+		if err != nil {
+			return fmt.Errorf("could not load TLS key pair: %w", err)
+		}
+		s := f.newServer(f.tlsListen, handler)
+		s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		servers = append(servers, s)
+	}
+
+	var draining atomic.Bool
+	if f.admin != "" {
+		servers = append(servers, f.newServer(f.admin, newAdminHandler(f.config, router, metrics, &draining)))
+	}
+
+	errs := make(chan error, len(servers)+1)
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		l, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			stop()
+			shutdown(servers, f.drainTimeout)
+			return err
+		}
+
+		if f.proxyProtocol != "" && s.Addr != f.admin {
+			if l, err = f.proxyProtocolListener(l); err != nil {
+				stop()
+				shutdown(servers, f.drainTimeout)
+				return err
+			}
+		}
+		log.Info("[anchor:proxy] listening", log.String("addr", l.Addr().String()), log.Bool("tls", s.TLSConfig != nil))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if s.TLSConfig != nil {
+				err = s.ServeTLS(l, "", "")
+			} else {
+				err = s.Serve(l)
+			}
+
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("%s: %w", s.Addr, err)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := proxy.Watch(ctx, f.config, router, proxy.WithWatchInterval(f.watchInterval)); err != nil {
+			errs <- err
+		}
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		log.Info("[anchor:proxy] shutting down", log.String("drain_timeout", f.drainTimeout.String()))
+	case runErr = <-errs:
+		log.Error("[anchor:proxy] server failed", log.Err(runErr))
+	}
+
+	// restore the default signal behavior, so that a second signal terminates the proxy while it is draining
+	stop()
+	f.drain(&draining)
+	shutdown(servers, f.drainTimeout)
+	wg.Wait()
+	log.Info("[anchor:proxy] stopped")
+	return runErr
+}
+
+func (f *proxyFlags) validate() error {
+	if f.config == "" {
+		return errors.New("-config is required")
+	}
+
+	if f.drainDelay < 0 {
+		return errors.New("-drain-delay must not be negative")
+	}
+
+	if f.listen == "" && f.tlsListen == "" {
+		return errors.New("at least one of -listen or -tls-listen is required")
+	}
+
+	if f.tlsListen != "" && (f.tlsCert == "" || f.tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key are required with -tls-listen")
+	}
+	return nil
+}
+
+// proxyProtocolListener wraps the listener for reading the PROXY protocol header of connections from the trusted
+// prefixes, so that the client addresses are used for the Forwarded header and access logs.
+func (f *proxyFlags) proxyProtocolListener(l net.Listener) (net.Listener, error) {
+	var trusted []netip.Prefix
+	for _, s := range strings.Split(f.proxyProtocol, ",") {
+		p, err := netip.ParsePrefix(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("-proxy-protocol: %w", err)
+		}
+		trusted = append(trusted, p)
+	}
+
+	return anet.NewProxyProtocolListener(l,
+		anet.WithProxyProtocolTrusted(trusted...),
+		anet.WithProxyProtocolRequired(f.proxyProtocolRequired))
+}
+
+// newHandler returns the http.Handler dispatching requests using the Router, after assigning a request ID, and
+// recording the access log, if enabled, and metrics.
+func (f *proxyFlags) newHandler(router *proxy.Router, m *metrics) http.Handler {
+	middleware := []proxy.Middleware{proxy.RequestID()}
+	if f.accessLog {
+		middleware = append(middleware, proxy.AccessLog())
+	}
+	middleware = append(middleware, m.middleware)
+
+	var handler http.Handler = router
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+func (f *proxyFlags) newServer(addr string, handler http.Handler) *http.Server {
+	// unencrypted HTTP/2 with prior knowledge (h2c) is accepted alongside HTTP/1, so that gRPC clients can connect
+	// without TLS, while HTTP/2 over TLS is negotiated using ALPN
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		IdleTimeout:       f.idleTimeout,
+		Protocols:         protocols,
+		ReadHeaderTimeout: f.readHeaderTimeout,
+	}
+}
+
+// drain stops advertising readiness, then keeps serving for the drain delay, so that load balancers polling the
+// readiness of the proxy stop sending new requests before its listeners are closed.
+func (f *proxyFlags) drain(draining *atomic.Bool) {
+	draining.Store(true)
+	if f.drainDelay > 0 {
+		log.Info("[anchor:proxy] reporting not ready before draining", log.String("drain_delay", f.drainDelay.String()))
+		time.Sleep(f.drainDelay)
+	}
+}
+
+// shutdown gracefully shuts down the servers, waiting for in-flight requests to complete until the drain timeout has
+// elapsed, after which remaining connections are closed.
+func shutdown(servers []*http.Server, drainTimeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Warn("[anchor:proxy] drain timeout exceeded, closing connections", log.String("addr", s.Addr))
+				_ = s.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler(t *testing.T) {
+	var upstreamID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get(proxy.RequestIDHeader)
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	router, err := proxy.LoadRouter(writeConfig(t, upstream.URL))
+	require.NoError(t, err)
+	defer router.Close()
+
+	m := newMetrics()
+	f := &proxyFlags{accessLog: true}
+	srv := httptest.NewServer(f.newHandler(router, m))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/items")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/items", string(b))
+	assert.NotEmpty(t, upstreamID)
+	assert.Equal(t, upstreamID, resp.Header.Get(proxy.RequestIDHeader))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	assert.Equal(t, int64(1), m.requests["200"])
+}
+
+func TestProxyFlagsValidate(t *testing.T) {
+	for _, f := range []proxyFlags{
+		{listen: ":8080"},
+		{config: "proxy.yaml"},
+		{config: "proxy.yaml", tlsListen: ":8443"},
+		{config: "proxy.yaml", drainDelay: -time.Second, listen: ":8080"},
+	} {
+		assert.Error(t, f.validate())
+	}
+	assert.NoError(t, (&proxyFlags{config: "proxy.yaml", listen: ":8080"}).validate())
+	assert.NoError(t, (&proxyFlags{config: "proxy.yaml", tlsCert: "c", tlsKey: "k", tlsListen: ":8443"}).validate())
+}
+
+func TestProxyFlagsDrain(t *testing.T) {
+	var draining atomic.Bool
+	f := &proxyFlags{drainDelay: 50 * time.Millisecond}
+
+	// readiness is reported as failing for the whole delay before the servers are shut down
+	start := time.Now()
+	f.drain(&draining)
+	assert.True(t, draining.Load())
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestRun(t *testing.T) {
+	var stderr bytes.Buffer
+	assert.Equal(t, 2, run(nil, &stderr))
+	assert.Contains(t, stderr.String(), "proxy")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"unknown"}, &stderr))
+	assert.Contains(t, stderr.String(), `unknown command "unknown"`)
+
+	// configurations that cannot be loaded fail the proxy command
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"proxy", "-admin=", "-config=" + t.TempDir() + "/missing.yaml"}, &stderr))
+	assert.Contains(t, stderr.String(), "anchor proxy:")
+}
@@ -41,6 +41,8 @@ func New(target string, options ...func(*Option)) (*grpc.ClientConn, error) {
 			grpc.MaxCallRecvMsgSize(int(opts.messageSizeMaxReceive)),
 			grpc.MaxCallSendMsgSize(int(opts.messageSizeMaxSend)),
 		),
+		grpc.WithChainUnaryInterceptor(RequestIDUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(RequestIDStreamInterceptor),
 	}
 
 	if opts.socks5Enabled {
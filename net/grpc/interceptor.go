@@ -0,0 +1,50 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/transientvariable/anchor/net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDUnaryInterceptor is a grpc.UnaryClientInterceptor that propagates the request ID stored in the call
+// context as outgoing metadata.
+func RequestIDUnaryInterceptor(
+	ctx context.Context,
+	method string,
+	req any,
+	reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	return invoker(withRequestIDMetadata(ctx), method, req, reply, cc, opts...)
+}
+
+// RequestIDStreamInterceptor is a grpc.StreamClientInterceptor that propagates the request ID stored in the call
+// context as outgoing metadata.
+func RequestIDStreamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	return streamer(withRequestIDMetadata(ctx), desc, cc, method, opts...)
+}
+
+// withRequestIDMetadata appends the request ID from the context to the outgoing metadata, unless already present.
+func withRequestIDMetadata(ctx context.Context) context.Context {
+	id, ok := net.RequestIDFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(net.MetadataRequestID)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, net.MetadataRequestID, id)
+}
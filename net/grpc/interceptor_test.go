@@ -0,0 +1,51 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/transientvariable/anchor/net"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDInterceptors(t *testing.T) {
+	var md metadata.MD
+	unary := func(ctx context.Context) {
+		err := RequestIDUnaryInterceptor(ctx, "/svc/Method", nil, nil, nil,
+			func(ctx context.Context, _ string, _ any, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+				md, _ = metadata.FromOutgoingContext(ctx)
+				return nil
+			})
+		require.NoError(t, err)
+	}
+	stream := func(ctx context.Context) {
+		_, err := RequestIDStreamInterceptor(ctx, &grpc.StreamDesc{}, nil, "/svc/Stream",
+			func(ctx context.Context, _ *grpc.StreamDesc, _ *grpc.ClientConn, _ string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+				md, _ = metadata.FromOutgoingContext(ctx)
+				return nil, nil
+			})
+		require.NoError(t, err)
+	}
+
+	for name, call := range map[string]func(context.Context){"unary": unary, "stream": stream} {
+		// the request ID from the context is propagated as outgoing metadata
+		md = nil
+		call(net.ContextWithRequestID(context.Background(), "id"))
+		assert.Equal(t, []string{"id"}, md.Get(net.MetadataRequestID), name)
+
+		// request IDs already present in the outgoing metadata are left unchanged
+		ctx := metadata.AppendToOutgoingContext(context.Background(), net.MetadataRequestID, "explicit")
+		call(net.ContextWithRequestID(ctx, "id"))
+		assert.Equal(t, []string{"explicit"}, md.Get(net.MetadataRequestID), name)
+
+		// calls without a request ID in their context are left unchanged
+		md = nil
+		call(context.Background())
+		assert.Empty(t, md.Get(net.MetadataRequestID), name)
+	}
+}
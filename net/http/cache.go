@@ -0,0 +1,291 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/cache"
+	"github.com/transientvariable/log-go"
+
+	gohttp "net/http"
+)
+
+// CacheResult defines the outcome of a request sent through the Cache Middleware.
+type CacheResult string
+
+// Enumeration of CacheResult values.
+const (
+	// CacheResultBypass indicates that the cache was not used for the request, e.g. for requests with unsafe methods or
+	// the no-store directive.
+	CacheResultBypass CacheResult = "bypass"
+
+	// CacheResultHit indicates that the response was served from the cache without contacting the server.
+	CacheResultHit CacheResult = "hit"
+
+	// CacheResultMiss indicates that no usable response was stored, so the request was sent to the server.
+	CacheResultMiss CacheResult = "miss"
+
+	// CacheResultRevalidated indicates that a stored response was served after the server confirmed it was not modified.
+	CacheResultRevalidated CacheResult = "revalidated"
+
+	// CacheResultStale indicates that a stored response was revalidated and replaced with a new response from the
+	// server, or that a stale response was served because the server failed.
+	CacheResultStale CacheResult = "stale"
+)
+
+type cacheResultKey struct{}
+
+// ContextWithCacheResult returns a copy of the provided context in which the Cache Middleware records the CacheResult of
+// a request sent using the context.
+func ContextWithCacheResult(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheResultKey{}, new(CacheResult))
+}
+
+// CacheResultFromContext returns the CacheResult recorded in the provided context, if any. The context must have been
+// created by ContextWithCacheResult.
+func CacheResultFromContext(ctx context.Context) (CacheResult, bool) {
+	r, ok := ctx.Value(cacheResultKey{}).(*CacheResult)
+	if !ok || *r == "" {
+		return "", false
+	}
+	return *r, true
+}
+
+// Cache returns a Middleware that serves responses to outbound requests from a private cache, as defined by RFC 9111,
+// using the provided cache.Store, e.g. a cache.MemoryStore or cache.DiskStore, and the cache.Policy options.
+//
+// Responses to GET requests are stored according to their Cache-Control, Expires, ETag and Last-Modified headers, and
+// are selected for subsequent requests according to their Vary header. Fresh responses are served without contacting
+// the server. Stale responses are revalidated using If-None-Match and If-Modified-Since, and are served while being
+// revalidated in the background or when the server fails if allowed by the stale-while-revalidate and stale-if-error
+// directives. Concurrent misses for the same response are collapsed into a single request. Successful requests using
+// unsafe methods invalidate the stored response for the same URL.
+//
+// The outcome for each request is reported using the Cache-Status header defined by RFC 9211, and recorded in the
+// request context if it was created by ContextWithCacheResult.
+func Cache(store cache.Store, options ...func(*cache.Option)) Middleware {
+	policy := cache.NewPolicy(store, false, options...)
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		c := &clientCache{
+			next:   next,
+			policy: policy,
+		}
+		return RoundTripperFunc(c.roundTrip)
+	}
+}
+
+// NewCacheTransport creates a new http.RoundTripper that serves responses from a private cache using the provided
+// cache.Store, sending the requests that cannot be served from the cache using the provided http.RoundTripper.
+func NewCacheTransport(transport gohttp.RoundTripper, store cache.Store, options ...func(*cache.Option)) gohttp.RoundTripper {
+	return Chain(transport, Cache(store, options...))
+}
+
+// clientCache is the state of the Cache Middleware for a http.RoundTripper.
+type clientCache struct {
+	group  cache.Group[*clientCacheResult]
+	next   gohttp.RoundTripper
+	policy *cache.Policy
+}
+
+// clientCacheResult is the outcome of sending a request to the server.
+type clientCacheResult struct {
+	// entry is the Entry to serve, or nil if the response could not be stored.
+	entry *cache.Entry
+
+	// resp is the response to return if the response could not be stored. It is only returned to the caller that sent
+	// the request.
+	resp *gohttp.Response
+
+	// result is the CacheResult for the request.
+	result CacheResult
+
+	// status is the Cache-Status parameters describing the outcome.
+	status string
+}
+
+func (c *clientCache) roundTrip(req *gohttp.Request) (*gohttp.Response, error) {
+	l := c.policy.Lookup(req, time.Now())
+	switch l.Action {
+	case cache.ActionInvalidate:
+		resp, err := c.next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		c.policy.Invalidate(req, resp.StatusCode)
+		return resp, nil
+	case cache.ActionBypass:
+		return c.bypass(req, CacheResultBypass, "fwd=bypass")
+	case cache.ActionHit:
+		return c.serve(req, l.Entry, CacheResultHit, "hit"), nil
+	case cache.ActionHitStale:
+		c.revalidate(l.Key, req, l.Entry)
+		return c.serve(req, l.Entry, CacheResultHit, "hit"), nil
+	case cache.ActionUnsatisfiable:
+		return c.unsatisfiable(req), nil
+	}
+
+	res, shared, err := c.group.Do(req.Method+" "+l.Key, func() (*clientCacheResult, error) {
+		return c.forward(req, l.Key, l.Entry)
+	})
+
+	switch {
+	case err != nil && !shared:
+		return nil, err
+	case err != nil || (shared && (res.entry == nil || !res.entry.Matches(req))):
+		// the response for the collapsed request could not be shared, so the request is sent separately
+		return c.bypass(req, CacheResultMiss, "fwd=miss")
+	case res.entry != nil && shared:
+		return c.serve(req, res.entry, res.result, res.status+"; collapsed"), nil
+	case res.entry != nil:
+		return c.serve(req, res.entry, res.result, res.status), nil
+	}
+
+	c.record(req, res.result)
+	res.resp.Header.Set(HeaderCacheStatus, c.policy.Status(res.status))
+	return res.resp, nil
+}
+
+// forward sends the request to the server, revalidating the stale Entry if provided. The response is returned in the
+// clientCacheResult as an Entry if it can be stored or replaces the stale Entry, otherwise it is returned as is.
+func (c *clientCache) forward(req *gohttp.Request, key string, stale *cache.Entry) (*clientCacheResult, error) {
+	r, conditional := c.policy.Conditional(req, stale)
+
+	requestTime := time.Now()
+	resp, err := c.next.RoundTrip(r)
+	if err != nil {
+		if req.Context().Err() == nil && c.policy.StaleIfError(stale, requestTime) {
+			log.Debug("[http:cache] serving stale response on error", log.String("key", key), log.Err(err))
+			return &clientCacheResult{entry: stale, result: CacheResultStale, status: "fwd=stale"}, nil
+		}
+		return nil, err
+	}
+	responseTime := time.Now()
+
+	result := CacheResultMiss
+	if stale != nil {
+		result = CacheResultStale
+	}
+	status := cache.Forwarded(stale != nil, resp.StatusCode)
+
+	switch c.policy.Dispose(r, stale, conditional, resp.StatusCode, resp.Header, requestTime) {
+	case cache.DispositionRevalidated:
+		drain(resp.Body)
+		e := c.policy.Revalidated(key, stale, resp.Header, requestTime, responseTime)
+		return &clientCacheResult{entry: e, result: CacheResultRevalidated, status: status}, nil
+	case cache.DispositionStale:
+		log.Debug("[http:cache] serving stale response on server error",
+			log.String("key", key),
+			log.Int("status", resp.StatusCode))
+		drain(resp.Body)
+		return &clientCacheResult{entry: stale, result: CacheResultStale, status: status}, nil
+	case cache.DispositionPass:
+		return &clientCacheResult{resp: resp, result: result, status: status}, nil
+	}
+
+	maxEntrySize := c.policy.MaxEntrySize()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEntrySize+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+
+	if int64(len(body)) > maxEntrySize {
+		// the response is returned with the bytes already read replayed before the remainder of the body
+		resp.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return &clientCacheResult{resp: resp, result: result, status: status}, nil
+	}
+	_ = resp.Body.Close()
+
+	e, stored := c.policy.Store(key, r, resp.StatusCode, resp.Header, body, requestTime, responseTime)
+	if stored {
+		status += "; stored"
+	}
+	return &clientCacheResult{entry: e, result: result, status: status}, nil
+}
+
+// revalidate revalidates the stale Entry in the background. The request is cloned before the revalidation is started,
+// since the caller may reuse it once RoundTrip has returned.
+func (c *clientCache) revalidate(key string, req *gohttp.Request, stale *cache.Entry) {
+	r, cancel := c.policy.Revalidation(req)
+	go func() {
+		defer cancel()
+		res, _, err := c.group.Do(r.Method+" "+key, func() (*clientCacheResult, error) {
+			return c.forward(r, key, stale)
+		})
+
+		switch {
+		case err != nil:
+			log.Debug("[http:cache] could not revalidate cached response", log.String("key", key), log.Err(err))
+		case res.resp != nil:
+			drain(res.resp.Body)
+		}
+	}()
+}
+
+// bypass sends the request without using the cache.
+func (c *clientCache) bypass(req *gohttp.Request, result CacheResult, status string) (*gohttp.Response, error) {
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	c.record(req, result)
+	resp.Header.Set(HeaderCacheStatus, c.policy.Status(status))
+	return resp, nil
+}
+
+// serve returns a response to the request created from the Entry.
+func (c *clientCache) serve(req *gohttp.Request, e *cache.Entry, result CacheResult, status string) *gohttp.Response {
+	code, h, body := c.policy.Response(req, e, status, time.Now())
+	c.record(req, result)
+
+	resp := &gohttp.Response{
+		Body:          gohttp.NoBody,
+		ContentLength: int64(len(body)),
+		Header:        h,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Status:        fmt.Sprintf("%d %s", code, gohttp.StatusText(code)),
+		StatusCode:    code,
+	}
+
+	if len(body) > 0 {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return resp
+}
+
+// unsatisfiable returns the response to a request with the only-if-cached directive that cannot be served from the
+// cache.
+func (c *clientCache) unsatisfiable(req *gohttp.Request) *gohttp.Response {
+	c.record(req, CacheResultMiss)
+	h := make(gohttp.Header)
+	h.Set(HeaderCacheStatus, c.policy.Status("fwd=miss"))
+	return &gohttp.Response{
+		Body:       gohttp.NoBody,
+		Header:     h,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Status:     fmt.Sprintf("%d %s", gohttp.StatusGatewayTimeout, gohttp.StatusText(gohttp.StatusGatewayTimeout)),
+		StatusCode: gohttp.StatusGatewayTimeout,
+	}
+}
+
+// record records the CacheResult in the request context, if it was created by ContextWithCacheResult.
+func (c *clientCache) record(req *gohttp.Request, result CacheResult) {
+	if r, ok := req.Context().Value(cacheResultKey{}).(*CacheResult); ok {
+		*r = result
+	}
+}
+
+// peekedBody is a response body whose leading bytes have been read, replaying them before the remainder of the body.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,386 @@
+// Package cache provides the building blocks for caching HTTP responses as defined by RFC 9111: parsing of the
+// Cache-Control header, calculation of freshness and age, conditional requests for revalidation, and pluggable stores
+// for the cached responses.
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeuristicFraction is the fraction of the time since the Last-Modified date of a response that is used as its
+	// heuristic freshness lifetime when no explicit lifetime is provided.
+	HeuristicFraction = 0.1
+
+	// HeuristicMaxLifetime is the upper bound of the heuristic freshness lifetime of a response.
+	HeuristicMaxLifetime = 24 * time.Hour
+)
+
+const (
+	headerAge             = "Age"
+	headerAuthorization   = "Authorization"
+	headerCacheControl    = "Cache-Control"
+	headerContentLength   = "Content-Length"
+	headerDate            = "Date"
+	headerETag            = "ETag"
+	headerExpires         = "Expires"
+	headerIfModifiedSince = "If-Modified-Since"
+	headerIfNoneMatch     = "If-None-Match"
+	headerLastModified    = "Last-Modified"
+	headerSetCookie       = "Set-Cookie"
+	headerVary            = "Vary"
+)
+
+var (
+	// ErrNotFound is returned by a Store when no entry exists for a key.
+	ErrNotFound = errors.New("cache: entry not found")
+
+	// ErrTooLarge is returned by a Store when an entry exceeds the maximum size of the Store.
+	ErrTooLarge = errors.New("cache: entry too large")
+)
+
+// Store defines the behavior for storing cached responses.
+//
+// Entries are shared between callers once stored, so they must not be modified after being passed to Set or returned
+// by Get.
+type Store interface {
+	// Get returns the Entry for the key, or ErrNotFound if none exists.
+	Get(key string) (*Entry, error)
+
+	// Set stores the Entry for the key, replacing any existing Entry.
+	Set(key string, e *Entry) error
+
+	// Delete removes the Entry for the key, if any.
+	Delete(key string) error
+}
+
+// Entry is a cached response.
+type Entry struct {
+	// Body is the response body.
+	Body []byte
+
+	// Header is the response header.
+	Header http.Header
+
+	// RequestTime is the time the request resulting in the response was sent.
+	RequestTime time.Time
+
+	// ResponseTime is the time the response was received.
+	ResponseTime time.Time
+
+	// Status is the response status code.
+	Status int
+
+	// Vary is the request header fields nominated by the Vary header of the response, used for selecting the Entry
+	// for subsequent requests.
+	Vary http.Header
+}
+
+// NewEntry creates a new Entry for a response to the request.
+func NewEntry(r *http.Request, status int, header http.Header, body []byte, requestTime, responseTime time.Time) *Entry {
+	e := &Entry{
+		Body:         body,
+		Header:       header.Clone(),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Status:       status,
+	}
+
+	for _, name := range varyFields(header) {
+		if e.Vary == nil {
+			e.Vary = make(http.Header)
+		}
+		e.Vary[name] = r.Header.Values(name)
+	}
+	return e
+}
+
+// Age returns the current age of the Entry at the provided time, as defined by RFC 9111, section 4.2.3.
+func (e *Entry) Age(now time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(e.Header.Get(headerDate)); err == nil {
+		apparentAge = max(0, e.ResponseTime.Sub(date))
+	}
+
+	var ageValue time.Duration
+	if s, err := strconv.ParseInt(strings.TrimSpace(e.Header.Get(headerAge)), 10, 64); err == nil && s > 0 {
+		ageValue = seconds(s)
+	}
+
+	correctedAgeValue := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	return max(apparentAge, correctedAgeValue) + now.Sub(e.ResponseTime)
+}
+
+// Lifetime returns the freshness lifetime of the Entry, as defined by RFC 9111, section 4.2.1. The s-maxage directive
+// is only used by shared caches.
+func (e *Entry) Lifetime(shared bool) time.Duration {
+	cc := ParseCacheControl(e.Header)
+	if shared {
+		if d, ok := cc.Duration(DirectiveSMaxAge); ok {
+			return d
+		}
+	}
+
+	if d, ok := cc.Duration(DirectiveMaxAge); ok {
+		return d
+	}
+
+	if v := e.Header.Values(headerExpires); len(v) > 0 {
+		expires, err := http.ParseTime(v[0])
+		if err != nil || len(v) > 1 {
+			// invalid dates represent a time in the past
+			return 0
+		}
+
+		date, err := http.ParseTime(e.Header.Get(headerDate))
+		if err != nil {
+			date = e.ResponseTime
+		}
+		return max(0, expires.Sub(date))
+	}
+
+	if !heuristicallyCacheable(e.Status) && !cc.Has(DirectivePublic) {
+		return 0
+	}
+
+	lastModified, err := http.ParseTime(e.Header.Get(headerLastModified))
+	if err != nil {
+		return 0
+	}
+
+	date, err := http.ParseTime(e.Header.Get(headerDate))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	return min(HeuristicMaxLifetime, time.Duration(float64(max(0, date.Sub(lastModified)))*HeuristicFraction))
+}
+
+// Fresh returns whether the Entry can be used without revalidation at the provided time.
+func (e *Entry) Fresh(now time.Time, shared bool) bool {
+	if ParseCacheControl(e.Header).Has(DirectiveNoCache) {
+		return false
+	}
+	return e.Lifetime(shared) > e.Age(now)
+}
+
+// StaleWhileRevalidate returns whether the stale Entry can be used at the provided time while it is revalidated in the
+// background, as defined by RFC 5861.
+func (e *Entry) StaleWhileRevalidate(now time.Time, shared bool) bool {
+	return e.staleWithin(now, shared, DirectiveStaleWhileRevalidate)
+}
+
+// StaleIfError returns whether the stale Entry can be used at the provided time when revalidation fails with an error
+// or a 5xx response, as defined by RFC 5861.
+func (e *Entry) StaleIfError(now time.Time, shared bool) bool {
+	return e.staleWithin(now, shared, DirectiveStaleIfError)
+}
+
+// Matches returns whether the Entry can be used for the request, i.e. the request header fields nominated by the Vary
+// header of the response match those of the request the Entry was stored for.
+func (e *Entry) Matches(r *http.Request) bool {
+	for _, name := range varyFields(e.Header) {
+		if name == "*" || normalize(r.Header.Values(name)) != normalize(e.Vary.Values(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Conditional sets the If-None-Match and If-Modified-Since headers of the request using the validators of the Entry,
+// returning whether the Entry has any validators.
+func (e *Entry) Conditional(r *http.Request) bool {
+	var ok bool
+	if etag := e.Header.Get(headerETag); etag != "" {
+		r.Header.Set(headerIfNoneMatch, etag)
+		ok = true
+	}
+
+	if lastModified := e.Header.Get(headerLastModified); lastModified != "" {
+		r.Header.Set(headerIfModifiedSince, lastModified)
+		ok = true
+	}
+	return ok
+}
+
+// NotModified returns whether the conditional headers of the request match the Entry, in which case a 304 (Not
+// Modified) response can be sent instead of the Entry.
+func (e *Entry) NotModified(r *http.Request) bool {
+	if e.Status != http.StatusOK {
+		return false
+	}
+
+	if inm := r.Header.Get(headerIfNoneMatch); inm != "" {
+		etag := strings.TrimPrefix(e.Header.Get(headerETag), "W/")
+		if etag == "" {
+			return false
+		}
+
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get(headerIfModifiedSince))
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(e.Header.Get(headerLastModified))
+	return err == nil && !lastModified.After(ims)
+}
+
+// Revalidated returns a new Entry updated with the header of a 304 (Not Modified) response received when revalidating
+// the Entry, as defined by RFC 9111, section 4.3.4.
+func (e *Entry) Revalidated(header http.Header, requestTime, responseTime time.Time) *Entry {
+	h := e.Header.Clone()
+	for name, values := range header {
+		if name == headerContentLength {
+			continue
+		}
+		h[name] = values
+	}
+
+	return &Entry{
+		Body:         e.Body,
+		Header:       h,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Status:       e.Status,
+		Vary:         e.Vary,
+	}
+}
+
+// Size returns the approximate number of bytes used by the Entry.
+func (e *Entry) Size() int64 {
+	size := int64(len(e.Body))
+	for _, h := range []http.Header{e.Header, e.Vary} {
+		for name, values := range h {
+			for _, v := range values {
+				size += int64(len(name) + len(v))
+			}
+		}
+	}
+	return size
+}
+
+func (e *Entry) staleWithin(now time.Time, shared bool, directive string) bool {
+	cc := ParseCacheControl(e.Header)
+	if cc.Has(DirectiveMustRevalidate) || (shared && cc.Has(DirectiveProxyRevalidate)) {
+		return false
+	}
+
+	window, ok := cc.Duration(directive)
+	if !ok {
+		return false
+	}
+	return e.Age(now)-e.Lifetime(shared) < window
+}
+
+// Key returns the key identifying the responses to the request, consisting of the absolute URL of the request without
+// the fragment. The request method is not included, since only responses to GET requests are stored.
+func Key(r *http.Request) string {
+	if r.URL.IsAbs() {
+		u := *r.URL
+		u.Fragment = ""
+		u.RawFragment = ""
+		return u.String()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// Storable returns whether the response to the request can be stored, as defined by RFC 9111, section 3. Responses
+// without explicit freshness are only stored if they have a validator for revalidation. Shared caches do not store
+// responses marked as private, responses to requests with an Authorization header unless explicitly allowed, and
+// responses setting cookies.
+func Storable(r *http.Request, status int, header http.Header, shared bool) bool {
+	if r.Method != http.MethodGet || ParseCacheControl(r.Header).Has(DirectiveNoStore) {
+		return false
+	}
+
+	cc := ParseCacheControl(header)
+	if cc.Has(DirectiveNoStore) || status == http.StatusPartialContent || status == http.StatusNotModified {
+		return false
+	}
+
+	for _, name := range varyFields(header) {
+		if name == "*" {
+			return false
+		}
+	}
+
+	if shared {
+		if cc.Has(DirectivePrivate) || len(header.Values(headerSetCookie)) > 0 {
+			return false
+		}
+
+		if r.Header.Get(headerAuthorization) != "" &&
+			!cc.Has(DirectiveMustRevalidate) && !cc.Has(DirectivePublic) && !cc.Has(DirectiveSMaxAge) {
+			return false
+		}
+	}
+
+	return cc.Has(DirectivePublic) ||
+		cc.Has(DirectiveMaxAge) ||
+		(shared && cc.Has(DirectiveSMaxAge)) ||
+		header.Get(headerExpires) != "" ||
+		(heuristicallyCacheable(status) && (header.Get(headerETag) != "" || header.Get(headerLastModified) != ""))
+}
+
+// heuristicallyCacheable returns whether responses with the status code are heuristically cacheable, as defined by
+// RFC 9110, section 15.1.
+func heuristicallyCacheable(status int) bool {
+	switch status {
+	case http.StatusOK,
+		http.StatusNonAuthoritativeInfo,
+		http.StatusNoContent,
+		http.StatusPartialContent,
+		http.StatusMultipleChoices,
+		http.StatusMovedPermanently,
+		http.StatusPermanentRedirect,
+		http.StatusNotFound,
+		http.StatusMethodNotAllowed,
+		http.StatusGone,
+		http.StatusRequestURITooLong,
+		http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// varyFields returns the canonical names of the request header fields nominated by the Vary header.
+func varyFields(h http.Header) []string {
+	var fields []string
+	for _, v := range h.Values(headerVary) {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, textproto.CanonicalMIMEHeaderKey(f))
+			}
+		}
+	}
+	return fields
+}
+
+// normalize combines the header field values, removing whitespace around the list members.
+func normalize(values []string) string {
+	var members []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			members = append(members, strings.TrimSpace(m))
+		}
+	}
+	return strings.Join(members, ",")
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	h := make(http.Header)
+	h.Add(headerCacheControl, `Max-Age=60, private="Set-Cookie, X-Token", no-cache`)
+	h.Add(headerCacheControl, "max-age=10, s-maxage=abc, stale-if-error=99999999999999999999")
+
+	d := ParseCacheControl(h)
+	assert.True(t, d.Has(DirectiveNoCache))
+	assert.Equal(t, "Set-Cookie, X-Token", d[DirectivePrivate])
+
+	maxAge, ok := d.Duration(DirectiveMaxAge)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, maxAge)
+
+	_, ok = d.Duration(DirectiveSMaxAge)
+	assert.False(t, ok)
+
+	sie, ok := d.Duration(DirectiveStaleIfError)
+	assert.True(t, ok)
+	assert.Positive(t, sie)
+
+	assert.Equal(t, `max-age=60, no-cache, private="Set-Cookie, X-Token", s-maxage=abc, stale-if-error=99999999999999999999`,
+		d.String())
+}
+
+func TestEntryFreshness(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	entry := func(header ...string) *Entry {
+		h := make(http.Header)
+		h.Set(headerDate, now.Add(-10*time.Second).Format(http.TimeFormat))
+		for i := 0; i < len(header); i += 2 {
+			h.Set(header[i], header[i+1])
+		}
+		return NewEntry(r, http.StatusOK, h, nil, now.Add(-time.Second), now)
+	}
+
+	// the apparent age is greater than the corrected age value
+	e := entry(headerCacheControl, "max-age=60, s-maxage=5")
+	assert.Equal(t, 15*time.Second, e.Age(now.Add(5*time.Second)))
+	assert.Equal(t, 5*time.Second, e.Lifetime(true))
+	assert.Equal(t, time.Minute, e.Lifetime(false))
+	assert.False(t, e.Fresh(now, true))
+	assert.True(t, e.Fresh(now, false))
+
+	e = entry(headerAge, "30", headerExpires, now.Add(time.Minute).Format(http.TimeFormat))
+	assert.Equal(t, 31*time.Second, e.Age(now))
+	assert.Equal(t, 70*time.Second, e.Lifetime(true))
+
+	e = entry(headerExpires, "0", headerCacheControl, "stale-while-revalidate=30")
+	assert.Zero(t, e.Lifetime(true))
+	assert.True(t, e.StaleWhileRevalidate(now, true))
+	assert.False(t, e.StaleWhileRevalidate(now.Add(30*time.Second), true))
+	assert.False(t, e.StaleIfError(now, true))
+
+	e = entry(headerLastModified, now.Add(-100*time.Hour).Format(http.TimeFormat))
+	assert.Equal(t, 10*time.Hour, e.Lifetime(true).Round(time.Minute))
+
+	e = entry(headerCacheControl, "max-age=60, no-cache")
+	assert.False(t, e.Fresh(now, true))
+
+	e = entry(headerCacheControl, "max-age=0, must-revalidate, stale-if-error=60")
+	assert.False(t, e.StaleIfError(now, true))
+}
+
+func TestStorable(t *testing.T) {
+	get := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	auth := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	auth.Header.Set(headerAuthorization, "Bearer token")
+
+	for _, tc := range []struct {
+		header   []string
+		r        *http.Request
+		shared   bool
+		status   int
+		storable bool
+	}{
+		{r: get, status: http.StatusOK, header: []string{headerCacheControl, "max-age=60"}, shared: true, storable: true},
+		{r: get, status: http.StatusOK, header: []string{headerETag, `"v1"`}, shared: true, storable: true},
+		{r: get, status: http.StatusOK, shared: true},
+		{r: get, status: http.StatusCreated, header: []string{headerETag, `"v1"`}, shared: true},
+		{r: get, status: http.StatusOK, header: []string{headerCacheControl, "max-age=60, no-store"}, shared: true},
+		{r: get, status: http.StatusOK, header: []string{headerCacheControl, "private, max-age=60"}, shared: true},
+		{r: get, status: http.StatusOK, header: []string{headerCacheControl, "private, max-age=60"}, storable: true},
+		{r: get, status: http.StatusOK, header: []string{headerCacheControl, "max-age=60", headerVary, "*"}, shared: true},
+		{r: get, status: http.StatusOK, header: []string{headerCacheControl, "max-age=60", headerSetCookie, "a=b"}, shared: true},
+		{r: auth, status: http.StatusOK, header: []string{headerCacheControl, "max-age=60"}, shared: true},
+		{r: auth, status: http.StatusOK, header: []string{headerCacheControl, "s-maxage=60"}, shared: true, storable: true},
+		{r: httptest.NewRequest(http.MethodPost, "http://example.com/", nil), status: http.StatusOK,
+			header: []string{headerCacheControl, "max-age=60"}, shared: true},
+	} {
+		h := make(http.Header)
+		for i := 0; i < len(tc.header); i += 2 {
+			h.Set(tc.header[i], tc.header[i+1])
+		}
+		assert.Equal(t, tc.storable, Storable(tc.r, tc.status, h, tc.shared), "%s %d %v", tc.r.Method, tc.status, h)
+	}
+}
+
+func TestEntryValidation(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("Accept-Encoding", "gzip,  br")
+
+	h := make(http.Header)
+	h.Set(headerETag, `W/"v1"`)
+	h.Set(headerLastModified, "Wed, 01 Jan 2025 00:00:00 GMT")
+	h.Set(headerVary, "accept-encoding")
+	h.Set(headerContentLength, "5")
+	e := NewEntry(r, http.StatusOK, h, []byte("hello"), time.Now(), time.Now())
+
+	other := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	other.Header.Set("Accept-Encoding", "gzip, br")
+	assert.True(t, e.Matches(other))
+	other.Header.Set("Accept-Encoding", "identity")
+	assert.False(t, e.Matches(other))
+
+	assert.True(t, e.Conditional(other))
+	assert.Equal(t, `W/"v1"`, other.Header.Get(headerIfNoneMatch))
+	assert.True(t, e.NotModified(other))
+
+	other.Header.Set(headerIfNoneMatch, `"v2"`)
+	assert.False(t, e.NotModified(other))
+
+	updated := e.Revalidated(http.Header{headerETag: {`W/"v1"`}, "X-Version": {"2"}, headerContentLength: {"0"}},
+		time.Now(), time.Now())
+	assert.Equal(t, "2", updated.Header.Get("X-Version"))
+	assert.Equal(t, "5", updated.Header.Get(headerContentLength))
+	assert.Empty(t, e.Header.Get("X-Version"))
+	assert.Equal(t, e.Body, updated.Body)
+
+	client, err := http.NewRequest(http.MethodGet, "http://example.com/a?b=c#d", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/a?b=c", Key(client))
+	server := httptest.NewRequest(http.MethodGet, "/a?b=c", nil)
+	server.Host = "example.com"
+	assert.Equal(t, "http://example.com/a?b=c", Key(server))
+}
+
+func TestGroup(t *testing.T) {
+	var g Group[int]
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	go func() {
+		_, _, _ = g.Do("key", func() (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+	}()
+	<-started
+
+	results := make(chan bool)
+	go func() {
+		v, shared, err := g.Do("key", func() (int, error) { return 2, nil })
+		assert.NoError(t, err)
+		assert.Equal(t, 1, v)
+		results <- shared
+	}()
+
+	// the second call waits for the first call to complete
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	assert.True(t, <-results)
+
+	v, shared, err := g.Do("key", func() (int, error) { return 3, nil })
+	assert.NoError(t, err)
+	assert.False(t, shared)
+	assert.Equal(t, 3, v)
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Enumeration of supported Cache-Control directives.
+const (
+	DirectiveMaxAge               = "max-age"
+	DirectiveMaxStale             = "max-stale"
+	DirectiveMinFresh             = "min-fresh"
+	DirectiveMustRevalidate       = "must-revalidate"
+	DirectiveNoCache              = "no-cache"
+	DirectiveNoStore              = "no-store"
+	DirectiveNoTransform          = "no-transform"
+	DirectiveOnlyIfCached         = "only-if-cached"
+	DirectivePrivate              = "private"
+	DirectiveProxyRevalidate      = "proxy-revalidate"
+	DirectivePublic               = "public"
+	DirectiveSMaxAge              = "s-maxage"
+	DirectiveStaleIfError         = "stale-if-error"
+	DirectiveStaleWhileRevalidate = "stale-while-revalidate"
+)
+
+// Directives is the set of directives of a Cache-Control header, mapping the lowercase directive names to their
+// arguments. Directives without an argument map to an empty string.
+type Directives map[string]string
+
+// ParseCacheControl parses the directives of all Cache-Control header fields. When a directive is repeated, the first
+// occurrence is used.
+func ParseCacheControl(h http.Header) Directives {
+	d := make(Directives)
+	for _, v := range h.Values(headerCacheControl) {
+		for len(v) > 0 {
+			var directive string
+			directive, v = nextDirective(v)
+
+			name, arg, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+
+			if _, ok := d[name]; !ok {
+				d[name] = unquote(strings.TrimSpace(arg))
+			}
+		}
+	}
+	return d
+}
+
+// Has returns whether the directive is present.
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Duration returns the delta-seconds argument of the directive as a time.Duration. The returned bool is false if the
+// directive is missing or its argument is not a valid delta-seconds value.
+func (d Directives) Duration(name string) (time.Duration, bool) {
+	arg, ok := d[name]
+	if !ok || arg == "" || strings.TrimLeft(arg, "0123456789") != "" {
+		return 0, false
+	}
+
+	s, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		// values too large to represent are treated as the greatest positive value
+		s = math.MaxInt64
+	}
+	return seconds(s), true
+}
+
+// String returns the Cache-Control header value for the directives, sorted by name.
+func (d Directives) String() string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		arg := d[name]
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+
+		if arg != "" {
+			b.WriteByte('=')
+			if strings.ContainsAny(arg, " ,\"") {
+				b.WriteString(strconv.Quote(arg))
+			} else {
+				b.WriteString(arg)
+			}
+		}
+	}
+	return b.String()
+}
+
+// nextDirective returns the next comma separated directive, ignoring commas within quoted strings, along with the
+// remainder of the header value.
+func nextDirective(v string) (string, string) {
+	var quoted bool
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && quoted:
+			i++
+		case c == ',' && !quoted:
+			return v[:i], v[i+1:]
+		}
+	}
+	return v, ""
+}
+
+// unquote removes the quotes and escapes of a quoted-string argument.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// seconds converts the number of seconds to a time.Duration, saturating instead of overflowing.
+func seconds(s int64) time.Duration {
+	if s > int64(math.MaxInt64/time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(s) * time.Second
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+var errGroupPanicked = errors.New("cache: function panicked")
+
+// Group collapses concurrent calls for the same key into a single call, so that concurrent misses for the same
+// response result in a single request.
+//
+// The zero value is ready to use.
+type Group[T any] struct {
+	calls map[string]*groupCall[T]
+	mutex sync.Mutex
+}
+
+type groupCall[T any] struct {
+	done  chan struct{}
+	err   error
+	value T
+}
+
+// Do calls the function for the key, unless a call for the key is already in progress, in which case Do waits for it
+// to complete and returns its result. The returned bool is true if the result was shared with other callers.
+//
+// If the function panics, callers waiting on the call receive an error and the panic is propagated to the caller that
+// executed the function.
+func (g *Group[T]) Do(key string, fn func() (T, error)) (T, bool, error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*groupCall[T])
+	}
+
+	if c, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		<-c.done
+		return c.value, true, c.err
+	}
+
+	c := &groupCall[T]{done: make(chan struct{}), err: errGroupPanicked}
+	g.calls[key] = c
+	g.mutex.Unlock()
+
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		close(c.done)
+	}()
+
+	c.value, c.err = fn()
+	return c.value, false, c.err
+}
@@ -0,0 +1,348 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+)
+
+const (
+	// MaxEntrySize sets the default maximum size of a response body stored by a Policy. Larger responses are passed
+	// through without being stored.
+	MaxEntrySize = 1 * anchor.MiB
+
+	// Name sets the default name identifying the cache in the Cache-Status header of responses.
+	Name = "anchor"
+
+	// RevalidateTimeout sets the default timeout for revalidating stale responses in the background.
+	RevalidateTimeout = 30 * time.Second
+)
+
+const (
+	headerCacheStatus = "Cache-Status"
+	headerRange       = "Range"
+)
+
+// Action defines how a request is handled by a cache, as decided by Policy.Lookup.
+type Action int
+
+// Enumeration of Action values.
+const (
+	// ActionForward indicates that the request is forwarded, revalidating the stale Entry of the Lookup, if any.
+	ActionForward Action = iota
+
+	// ActionBypass indicates that the request is forwarded without using the cache, e.g. for requests with the
+	// no-store directive.
+	ActionBypass
+
+	// ActionHit indicates that the fresh Entry of the Lookup is served without forwarding the request.
+	ActionHit
+
+	// ActionHitStale indicates that the stale Entry of the Lookup is served while it is revalidated in the background.
+	ActionHitStale
+
+	// ActionInvalidate indicates that the request uses an unsafe method, so it is forwarded and the stored response
+	// for its URL is removed using Policy.Invalidate.
+	ActionInvalidate
+
+	// ActionUnsatisfiable indicates that the request has the only-if-cached directive, but no Entry can be served.
+	ActionUnsatisfiable
+)
+
+// Disposition defines what a cache does with the response to a forwarded request, as decided by Policy.Dispose.
+type Disposition int
+
+// Enumeration of Disposition values.
+const (
+	// DispositionPass indicates that the response is passed through without being stored.
+	DispositionPass Disposition = iota
+
+	// DispositionRevalidated indicates that the response confirms the stale Entry is not modified, so the Entry
+	// returned by Policy.Revalidated is served instead.
+	DispositionRevalidated
+
+	// DispositionStale indicates that the request failed, so the stale Entry is served instead.
+	DispositionStale
+
+	// DispositionStore indicates that the response is stored using Policy.Store, unless its body exceeds the maximum
+	// entry size.
+	DispositionStore
+)
+
+// Lookup is the outcome of looking up the stored response for a request.
+type Lookup struct {
+	// Action is how the request is handled.
+	Action Action
+
+	// Entry is the stored Entry selected for the request, if any.
+	Entry *Entry
+
+	// Key is the key identifying the responses to the request.
+	Key string
+}
+
+// Option is a container for optional properties that can be used for initializing a Policy.
+type Option struct {
+	maxEntrySize      int64
+	name              string
+	revalidateTimeout time.Duration
+}
+
+// WithMaxEntrySize sets the maximum size of a response body stored by the cache.
+func WithMaxEntrySize(size int64) func(*Option) {
+	return func(o *Option) {
+		o.maxEntrySize = size
+	}
+}
+
+// WithName sets the name identifying the cache in the Cache-Status header of responses.
+func WithName(name string) func(*Option) {
+	return func(o *Option) {
+		o.name = name
+	}
+}
+
+// WithRevalidateTimeout sets the timeout for revalidating stale responses in the background.
+func WithRevalidateTimeout(timeout time.Duration) func(*Option) {
+	return func(o *Option) {
+		o.revalidateTimeout = timeout
+	}
+}
+
+// String returns a string representation of the Option.
+func (o *Option) String() string {
+	return string(anchor.ToJSONFormatted(o.toMap()))
+}
+
+// toMap returns a map representing the Option attributes.
+func (o *Option) toMap() map[string]any {
+	options := make(map[string]any)
+	options["max_entry_size"] = o.maxEntrySize
+	options["name"] = o.name
+	options["revalidate_timeout"] = o.revalidateTimeout.String()
+	return options
+}
+
+// Policy implements the decisions of a private or shared cache, as defined by RFC 9111, for responses stored using a
+// Store: which stored response is served for a request, which responses are stored, and when a stale response is used
+// instead of the response from the server. Forwarding the requests and returning the responses is left to the caller.
+//
+// The outcome for each request is described using the Cache-Status header defined by RFC 9211.
+type Policy struct {
+	options *Option
+	shared  bool
+	store   Store
+}
+
+// NewPolicy creates a new Policy for the Store using the provided options. Shared caches, e.g. reverse proxies, apply
+// the stricter rules of RFC 9111 for storing responses, and use the s-maxage directive.
+func NewPolicy(store Store, shared bool, options ...func(*Option)) *Policy {
+	opts := &Option{
+		maxEntrySize:      MaxEntrySize,
+		name:              Name,
+		revalidateTimeout: RevalidateTimeout,
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+	return &Policy{options: opts, shared: shared, store: store}
+}
+
+// MaxEntrySize returns the maximum size of a response body stored by the Policy.
+func (p *Policy) MaxEntrySize() int64 {
+	return p.options.maxEntrySize
+}
+
+// Lookup returns how the request is handled at the provided time, and the stored Entry selected for it, if any.
+func (p *Policy) Lookup(r *http.Request, now time.Time) Lookup {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return Lookup{Action: ActionInvalidate}
+	}
+
+	cc := ParseCacheControl(r.Header)
+	if cc.Has(DirectiveNoStore) || r.Header.Get(headerRange) != "" {
+		return Lookup{Action: ActionBypass}
+	}
+
+	key := Key(r)
+	e, err := p.store.Get(key)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Error("[cache] could not get cached response", log.String("key", key), log.Err(err))
+		}
+		e = nil
+	}
+
+	if e != nil && !e.Matches(r) {
+		e = nil
+	}
+
+	if e != nil && !cc.Has(DirectiveNoCache) {
+		fresh := e.Fresh(now, p.shared)
+		if maxAge, ok := cc.Duration(DirectiveMaxAge); ok && e.Age(now) > maxAge {
+			fresh = false
+		}
+
+		if fresh {
+			return Lookup{Action: ActionHit, Entry: e, Key: key}
+		}
+
+		if e.StaleWhileRevalidate(now, p.shared) {
+			return Lookup{Action: ActionHitStale, Entry: e, Key: key}
+		}
+	}
+
+	if cc.Has(DirectiveOnlyIfCached) {
+		return Lookup{Action: ActionUnsatisfiable, Key: key}
+	}
+	return Lookup{Action: ActionForward, Entry: e, Key: key}
+}
+
+// Conditional returns the request to forward for revalidating the stale Entry, and whether it is conditional. The
+// returned request is a clone with the validators of the Entry, unless the request has its own conditional headers or
+// no Entry is provided, in which case the request itself is returned.
+func (p *Policy) Conditional(r *http.Request, stale *Entry) (*http.Request, bool) {
+	if stale == nil || r.Header.Get(headerIfNoneMatch) != "" || r.Header.Get(headerIfModifiedSince) != "" {
+		return r, false
+	}
+
+	req := r.Clone(r.Context())
+	return req, stale.Conditional(req)
+}
+
+// Revalidation returns the request for revalidating the stale Entry for the request in the background, which is
+// canceled once the revalidation timeout has elapsed or the returned function is called. The request is cloned, so
+// that it is not affected by the caller reusing the request once it has been handled.
+func (p *Policy) Revalidation(r *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), p.options.revalidateTimeout)
+	req := r.Clone(ctx)
+	req.Method = http.MethodGet
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	req.GetBody = nil
+	return req, cancel
+}
+
+// Dispose returns what is done with the response to the forwarded request, given the stale Entry it revalidates, if
+// any, whether the request was made conditional using its validators, and the time the request was sent.
+func (p *Policy) Dispose(r *http.Request, stale *Entry, conditional bool, status int, header http.Header,
+	requestTime time.Time) Disposition {
+	switch {
+	case status == http.StatusNotModified && conditional:
+		return DispositionRevalidated
+	case status >= http.StatusInternalServerError && p.StaleIfError(stale, requestTime):
+		return DispositionStale
+	}
+
+	if n, err := strconv.ParseInt(header.Get(headerContentLength), 10, 64); err == nil && n > p.options.maxEntrySize {
+		return DispositionPass
+	}
+
+	if !Storable(r, status, header, p.shared) {
+		return DispositionPass
+	}
+	return DispositionStore
+}
+
+// StaleIfError returns whether the stale Entry is served instead of an error returned when sending the request at the
+// provided time.
+func (p *Policy) StaleIfError(stale *Entry, requestTime time.Time) bool {
+	return stale != nil && stale.StaleIfError(requestTime, p.shared)
+}
+
+// Revalidated stores and returns the stale Entry updated with the header of the 304 (Not Modified) response received
+// when revalidating it.
+func (p *Policy) Revalidated(key string, stale *Entry, header http.Header, requestTime, responseTime time.Time) *Entry {
+	e := stale.Revalidated(header, requestTime, responseTime)
+	p.set(key, e)
+	return e
+}
+
+// Store creates a new Entry for the response to the request and stores it, returning the Entry and whether it was
+// stored.
+func (p *Policy) Store(key string, r *http.Request, status int, header http.Header, body []byte, requestTime,
+	responseTime time.Time) (*Entry, bool) {
+	e := NewEntry(r, status, header, body, requestTime, responseTime)
+	return e, p.set(key, e)
+}
+
+// Invalidate removes the stored response for the URL of a request using an unsafe method if the response to it has a
+// non-error status, as defined by RFC 9111, section 4.4.
+func (p *Policy) Invalidate(r *http.Request, status int) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return
+	}
+
+	if status < http.StatusBadRequest {
+		key := Key(r)
+		if err := p.store.Delete(key); err != nil {
+			log.Error("[cache] could not invalidate cached response", log.String("key", key), log.Err(err))
+		}
+	}
+}
+
+// Response returns the status code, header, and body of the response served from the Entry at the provided time,
+// with the Cache-Status header set using the parameters.
+func (p *Policy) Response(r *http.Request, e *Entry, params string, now time.Time) (int, http.Header, []byte) {
+	h := e.Header.Clone()
+
+	age := e.Age(now)
+	h.Set(headerAge, strconv.FormatInt(int64(age/time.Second), 10))
+
+	if strings.HasPrefix(params, "hit") {
+		params += "; ttl=" + strconv.FormatInt(int64((e.Lifetime(p.shared)-age)/time.Second), 10)
+	}
+	h.Set(headerCacheStatus, p.Status(params))
+
+	if e.NotModified(r) {
+		h.Del(headerContentLength)
+		return http.StatusNotModified, h, nil
+	}
+
+	h.Set(headerContentLength, strconv.Itoa(len(e.Body)))
+	if r.Method == http.MethodHead {
+		return e.Status, h, nil
+	}
+	return e.Status, h, e.Body
+}
+
+// Status returns the value of the Cache-Status header for the parameters describing the outcome of a request.
+func (p *Policy) Status(params string) string {
+	return p.options.name + "; " + params
+}
+
+// String returns a string representation of the Policy.
+func (p *Policy) String() string {
+	m := p.options.toMap()
+	m["shared"] = p.shared
+	return string(anchor.ToJSONFormatted(m))
+}
+
+// set stores the Entry, returning whether it was stored.
+func (p *Policy) set(key string, e *Entry) bool {
+	if err := p.store.Set(key, e); err != nil {
+		if !errors.Is(err, ErrTooLarge) {
+			log.Error("[cache] could not store response", log.String("key", key), log.Err(err))
+		}
+		return false
+	}
+	return true
+}
+
+// Forwarded returns the Cache-Status parameters for a response forwarded with the status code, revalidating a stale
+// Entry or not.
+func Forwarded(stale bool, status int) string {
+	fwd := "miss"
+	if stale {
+		fwd = "stale"
+	}
+	return fmt.Sprintf("fwd=%s; fwd-status=%d", fwd, status)
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyLookup(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore(0)
+	p := NewPolicy(store, true)
+
+	request := func(method string, path string, header ...string) *http.Request {
+		r := httptest.NewRequest(method, "http://example.com"+path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		return r
+	}
+
+	set := func(path string, cacheControl string) *Entry {
+		r := request(http.MethodGet, path)
+		h := make(http.Header)
+		h.Set(headerCacheControl, cacheControl)
+		h.Set(headerETag, `"v1"`)
+		e := NewEntry(r, http.StatusOK, h, []byte(path), now.Add(-10*time.Second), now.Add(-10*time.Second))
+		require.NoError(t, store.Set(Key(r), e))
+		return e
+	}
+
+	fresh := set("/fresh", "max-age=60")
+	stale := set("/stale", "s-maxage=5")
+	swr := set("/swr", "s-maxage=5, stale-while-revalidate=60")
+
+	for _, tc := range []struct {
+		action Action
+		entry  *Entry
+		r      *http.Request
+	}{
+		{action: ActionInvalidate, r: request(http.MethodPost, "/fresh")},
+		{action: ActionBypass, r: request(http.MethodGet, "/fresh", headerCacheControl, "no-store")},
+		{action: ActionBypass, r: request(http.MethodGet, "/fresh", headerRange, "bytes=0-1")},
+		{action: ActionHit, entry: fresh, r: request(http.MethodGet, "/fresh")},
+		{action: ActionHit, entry: fresh, r: request(http.MethodHead, "/fresh")},
+		{action: ActionForward, entry: fresh, r: request(http.MethodGet, "/fresh", headerCacheControl, "max-age=5")},
+		{action: ActionForward, entry: fresh, r: request(http.MethodGet, "/fresh", headerCacheControl, "no-cache")},
+		{action: ActionForward, entry: stale, r: request(http.MethodGet, "/stale")},
+		{action: ActionHitStale, entry: swr, r: request(http.MethodGet, "/swr")},
+		{action: ActionForward, r: request(http.MethodGet, "/missing")},
+		{action: ActionUnsatisfiable, r: request(http.MethodGet, "/stale", headerCacheControl, "only-if-cached")},
+	} {
+		l := p.Lookup(tc.r, now)
+		assert.Equal(t, tc.action, l.Action, "%s %s %v", tc.r.Method, tc.r.URL.Path, tc.r.Header)
+		assert.Same(t, tc.entry, l.Entry, "%s %s %v", tc.r.Method, tc.r.URL.Path, tc.r.Header)
+	}
+
+	// private caches ignore s-maxage
+	l := NewPolicy(store, false).Lookup(request(http.MethodGet, "/stale"), now)
+	assert.Equal(t, ActionForward, l.Action)
+	l = NewPolicy(store, false).Lookup(request(http.MethodGet, "/fresh"), now)
+	assert.Equal(t, ActionHit, l.Action)
+
+	// stale responses are revalidated using their validators, unless the request has its own
+	r := request(http.MethodGet, "/stale")
+	req, conditional := p.Conditional(r, stale)
+	assert.True(t, conditional)
+	assert.NotSame(t, r, req)
+	assert.Equal(t, `"v1"`, req.Header.Get(headerIfNoneMatch))
+	assert.Empty(t, r.Header.Get(headerIfNoneMatch))
+
+	r = request(http.MethodGet, "/stale", headerIfNoneMatch, `"v0"`)
+	req, conditional = p.Conditional(r, stale)
+	assert.False(t, conditional)
+	assert.Same(t, r, req)
+
+	// successful requests using unsafe methods invalidate the stored response
+	p.Invalidate(request(http.MethodPost, "/fresh"), http.StatusInternalServerError)
+	assert.Equal(t, ActionHit, p.Lookup(request(http.MethodGet, "/fresh"), now).Action)
+	p.Invalidate(request(http.MethodPost, "/fresh"), http.StatusOK)
+	assert.Equal(t, ActionForward, p.Lookup(request(http.MethodGet, "/fresh"), now).Action)
+}
+
+func TestPolicyDispose(t *testing.T) {
+	now := time.Now()
+	p := NewPolicy(NewMemoryStore(0), true, WithMaxEntrySize(8))
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	header := func(kv ...string) http.Header {
+		h := make(http.Header)
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	stale := NewEntry(r, http.StatusOK, header(headerCacheControl, "max-age=0, stale-if-error=60"), nil, now, now)
+	for _, tc := range []struct {
+		conditional bool
+		disposition Disposition
+		header      http.Header
+		stale       *Entry
+		status      int
+	}{
+		{disposition: DispositionStore, header: header(headerCacheControl, "max-age=60"), status: http.StatusOK},
+		{disposition: DispositionPass, header: header(headerCacheControl, "private"), status: http.StatusOK},
+		{disposition: DispositionPass, header: header(headerCacheControl, "max-age=60", headerContentLength, "9"),
+			status: http.StatusOK},
+		{disposition: DispositionRevalidated, conditional: true, stale: stale, status: http.StatusNotModified},
+		{disposition: DispositionPass, stale: stale, status: http.StatusNotModified},
+		{disposition: DispositionStale, header: header(headerContentLength, "1024"), stale: stale,
+			status: http.StatusServiceUnavailable},
+		{disposition: DispositionPass, status: http.StatusServiceUnavailable},
+	} {
+		d := p.Dispose(r, tc.stale, tc.conditional, tc.status, tc.header, now)
+		assert.Equal(t, tc.disposition, d, "%d %v", tc.status, tc.header)
+	}
+
+	e, stored := p.Store(Key(r), r, http.StatusOK, header(headerCacheControl, "max-age=60"), []byte("body"), now, now)
+	assert.True(t, stored)
+	assert.Equal(t, []byte("body"), e.Body)
+
+	assert.Equal(t, "fwd=miss; fwd-status=200", Forwarded(false, http.StatusOK))
+	assert.Equal(t, "fwd=stale; fwd-status=304", Forwarded(true, http.StatusNotModified))
+}
+
+func TestPolicyResponse(t *testing.T) {
+	now := time.Now()
+	p := NewPolicy(NewMemoryStore(0), false, WithName("edge"))
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	h := make(http.Header)
+	h.Set(headerCacheControl, "max-age=60")
+	h.Set(headerETag, `"v1"`)
+	e := NewEntry(r, http.StatusOK, h, []byte("body"), now.Add(-10*time.Second), now.Add(-10*time.Second))
+
+	status, header, body := p.Response(r, e, "hit", now)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "10", header.Get(headerAge))
+	assert.Equal(t, "edge; hit; ttl=50", header.Get(headerCacheStatus))
+	assert.Equal(t, "4", header.Get(headerContentLength))
+	assert.Equal(t, []byte("body"), body)
+	assert.Empty(t, e.Header.Get(headerCacheStatus))
+
+	_, header, body = p.Response(httptest.NewRequest(http.MethodHead, "http://example.com/", nil), e, "fwd=miss", now)
+	assert.Equal(t, "edge; fwd=miss", header.Get(headerCacheStatus))
+	assert.Equal(t, "4", header.Get(headerContentLength))
+	assert.Nil(t, body)
+
+	r.Header.Set(headerIfNoneMatch, `"v1"`)
+	status, header, body = p.Response(r, e, "hit", now)
+	assert.Equal(t, http.StatusNotModified, status)
+	assert.Empty(t, header.Get(headerContentLength))
+	assert.Nil(t, body)
+}
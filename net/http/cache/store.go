@@ -0,0 +1,358 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/transientvariable/anchor"
+)
+
+const (
+	// DiskStoreMaxSize is the default maximum number of bytes used by the entries of a DiskStore.
+	DiskStoreMaxSize = 1 * anchor.GiB
+
+	// MemoryStoreMaxSize is the default maximum number of bytes used by the entries of a MemoryStore.
+	MemoryStoreMaxSize = 64 * anchor.MiB
+
+	diskStoreExt     = ".entry"
+	diskStoreTempExt = ".tmp"
+)
+
+// MemoryStore is a Store keeping entries in memory, evicting the least recently used entries once its maximum size
+// has been reached.
+type MemoryStore struct {
+	lru   *lru
+	mutex sync.Mutex
+}
+
+// NewMemoryStore creates a new MemoryStore bounded by the maximum number of bytes used by its entries. If maxSize is
+// not greater than zero, MemoryStoreMaxSize is used.
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	if maxSize <= 0 {
+		maxSize = MemoryStoreMaxSize
+	}
+	return &MemoryStore{lru: newLRU(maxSize)}
+}
+
+// Get returns the Entry for the key, or ErrNotFound if none exists.
+func (s *MemoryStore) Get(key string) (*Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if v, ok := s.lru.get(key); ok {
+		return v.(*Entry), nil
+	}
+	return nil, ErrNotFound
+}
+
+// Set stores the Entry for the key, evicting the least recently used entries as needed. ErrTooLarge is returned if the
+// Entry exceeds the maximum size of the MemoryStore.
+func (s *MemoryStore) Set(key string, e *Entry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.lru.add(key, e, e.Size()+int64(len(key))) {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// Delete removes the Entry for the key, if any.
+func (s *MemoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lru.remove(key)
+	return nil
+}
+
+// Len returns the number of entries in the MemoryStore.
+func (s *MemoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lru.list.Len()
+}
+
+// Size returns the number of bytes used by the entries of the MemoryStore.
+func (s *MemoryStore) Size() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lru.size
+}
+
+// String returns a string representation of the MemoryStore.
+func (s *MemoryStore) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return string(anchor.ToJSON(map[string]any{
+		"entries":  s.lru.list.Len(),
+		"max_size": s.lru.maxSize,
+		"size":     s.lru.size,
+	}))
+}
+
+// DiskStore is a Store keeping entries as files in a directory, evicting the least recently used entries once its
+// maximum size has been reached.
+//
+// Entries found in the directory when the DiskStore is created are retained, ordered by their modification time.
+type DiskStore struct {
+	dir   string
+	lru   *lru
+	mutex sync.Mutex
+}
+
+// NewDiskStore creates a new DiskStore using the directory, which is created if it does not exist, bounded by the
+// maximum number of bytes used by its entries. If maxSize is not greater than zero, DiskStoreMaxSize is used.
+func NewDiskStore(dir string, maxSize int64) (*DiskStore, error) {
+	if maxSize <= 0 {
+		maxSize = DiskStoreMaxSize
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cache: %w", err)
+	}
+
+	s := &DiskStore{dir: dir, lru: newLRU(maxSize)}
+	s.lru.evict = s.evict
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cache: %w", err)
+	}
+
+	type file struct {
+		info fs.FileInfo
+		name string
+	}
+
+	var files []file
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case diskStoreTempExt:
+			// left behind by an interrupted write
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+		case diskStoreExt:
+			if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+				files = append(files, file{info: info, name: e.Name()})
+			}
+		}
+	}
+
+	slices.SortFunc(files, func(a, b file) int {
+		return a.info.ModTime().Compare(b.info.ModTime())
+	})
+
+	for _, f := range files {
+		s.lru.add(strings.TrimSuffix(f.name, diskStoreExt), nil, f.info.Size())
+	}
+	return s, nil
+}
+
+// Get returns the Entry for the key, or ErrNotFound if none exists.
+func (s *DiskStore) Get(key string) (*Entry, error) {
+	name := s.name(key)
+
+	s.mutex.Lock()
+	_, ok := s.lru.get(name)
+	s.mutex.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	b, err := os.ReadFile(s.path(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("cache: %w", err)
+	}
+
+	var de diskEntry
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&de); err != nil {
+		s.remove(name)
+		return nil, fmt.Errorf("cache: could not decode entry for key %q: %w", key, err)
+	}
+
+	if de.Key != key {
+		return nil, ErrNotFound
+	}
+	return de.Entry, nil
+}
+
+// Set stores the Entry for the key, evicting the least recently used entries as needed. ErrTooLarge is returned if the
+// Entry exceeds the maximum size of the DiskStore.
+func (s *DiskStore) Set(key string, e *Entry) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(diskEntry{Entry: e, Key: key}); err != nil {
+		return fmt.Errorf("cache: could not encode entry for key %q: %w", key, err)
+	}
+
+	name := s.name(key)
+	if int64(buf.Len()) > s.lru.maxSize {
+		s.remove(name)
+		return ErrTooLarge
+	}
+
+	f, err := os.CreateTemp(s.dir, "*"+diskStoreTempExt)
+	if err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("cache: %w", err)
+	}
+
+	// the file is renamed while holding the mutex, so that it cannot be removed by a concurrent eviction of a
+	// previous entry for the same key
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := os.Rename(f.Name(), s.path(name)); err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("cache: %w", err)
+	}
+	s.lru.add(name, nil, int64(buf.Len()))
+	return nil
+}
+
+// Delete removes the Entry for the key, if any.
+func (s *DiskStore) Delete(key string) error {
+	name := s.name(key)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lru.remove(name)
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cache: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of entries in the DiskStore.
+func (s *DiskStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lru.list.Len()
+}
+
+// Size returns the number of bytes used by the entries of the DiskStore.
+func (s *DiskStore) Size() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lru.size
+}
+
+// String returns a string representation of the DiskStore.
+func (s *DiskStore) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return string(anchor.ToJSON(map[string]any{
+		"dir":      s.dir,
+		"entries":  s.lru.list.Len(),
+		"max_size": s.lru.maxSize,
+		"size":     s.lru.size,
+	}))
+}
+
+// evict removes the file of an entry evicted from the index. The mutex is held by the caller.
+func (s *DiskStore) evict(name string) {
+	_ = os.Remove(s.path(name))
+}
+
+func (s *DiskStore) remove(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lru.remove(name)
+	_ = os.Remove(s.path(name))
+}
+
+// name returns the file name, without extension, for the key.
+func (s *DiskStore) name(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *DiskStore) path(name string) string {
+	return filepath.Join(s.dir, name+diskStoreExt)
+}
+
+// diskEntry is the encoded representation of an Entry stored by a DiskStore. The key is stored for detecting
+// collisions of the file names.
+type diskEntry struct {
+	Entry *Entry
+	Key   string
+}
+
+// lru is an index of sized values bounded by the total size, ordered from the most to the least recently used.
+type lru struct {
+	evict   func(key string)
+	items   map[string]*list.Element
+	list    *list.List
+	maxSize int64
+	size    int64
+}
+
+type lruItem struct {
+	key   string
+	size  int64
+	value any
+}
+
+func newLRU(maxSize int64) *lru {
+	return &lru{
+		items:   make(map[string]*list.Element),
+		list:    list.New(),
+		maxSize: maxSize,
+	}
+}
+
+// get returns the value for the key, marking it as the most recently used.
+func (l *lru) get(key string) (any, bool) {
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.list.MoveToFront(e)
+	return e.Value.(*lruItem).value, true
+}
+
+// add adds or replaces the value for the key, evicting the least recently used values until the total size is within
+// the maximum. Values larger than the maximum size are not added, and any existing value for the key is removed.
+func (l *lru) add(key string, value any, size int64) bool {
+	l.remove(key)
+	if size > l.maxSize {
+		return false
+	}
+
+	l.items[key] = l.list.PushFront(&lruItem{key: key, size: size, value: value})
+	l.size += size
+	for l.size > l.maxSize {
+		item := l.list.Back().Value.(*lruItem)
+		l.remove(item.key)
+		if l.evict != nil {
+			l.evict(item.key)
+		}
+	}
+	return true
+}
+
+func (l *lru) remove(key string) {
+	if e, ok := l.items[key]; ok {
+		l.list.Remove(e)
+		delete(l.items, key)
+		l.size -= e.Value.(*lruItem).size
+	}
+}
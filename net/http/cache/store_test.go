@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(100)
+	entry := func(size int) *Entry {
+		return &Entry{Body: make([]byte, size), Header: make(http.Header), Status: http.StatusOK}
+	}
+
+	assert.NoError(t, s.Set("a", entry(40)))
+	assert.NoError(t, s.Set("b", entry(40)))
+	_, err := s.Get("a")
+	assert.NoError(t, err)
+
+	// the least recently used entry is evicted
+	assert.NoError(t, s.Set("c", entry(40)))
+	_, err = s.Get("b")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, int64(82), s.Size())
+
+	assert.ErrorIs(t, s.Set("a", entry(200)), ErrTooLarge)
+	_, err = s.Get("a")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, s.Delete("c"))
+	assert.Zero(t, s.Len())
+	assert.Zero(t, s.Size())
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "partial"+diskStoreTempExt), []byte("x"), 0o600))
+
+	s, err := NewDiskStore(dir, 2048)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "partial"+diskStoreTempExt))
+
+	e := &Entry{
+		Body:   []byte("hello"),
+		Header: http.Header{"Etag": {`"v1"`}},
+		Status: http.StatusOK,
+		Vary:   http.Header{"Accept-Encoding": {"gzip"}},
+	}
+	assert.NoError(t, s.Set("http://example.com/", e))
+
+	stored, err := s.Get("http://example.com/")
+	assert.NoError(t, err)
+	assert.Equal(t, e, stored)
+
+	_, err = s.Get("http://example.com/other")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// entries are retained across instances
+	s, err = NewDiskStore(dir, 2048)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.Len())
+	stored, err = s.Get("http://example.com/")
+	assert.NoError(t, err)
+	assert.Equal(t, e.Body, stored.Body)
+
+	// the least recently used entries are evicted along with their files
+	for i := range 10 {
+		assert.NoError(t, s.Set(fmt.Sprintf("http://example.com/%d", i), &Entry{Body: make([]byte, 500)}))
+	}
+	assert.LessOrEqual(t, s.Size(), int64(2048))
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, s.Len())
+	_, err = s.Get("http://example.com/")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, s.Set("large", &Entry{Body: make([]byte, 4096)}), ErrTooLarge)
+	assert.NoError(t, s.Delete("http://example.com/9"))
+	assert.NoError(t, s.Delete("http://example.com/9"))
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/cache"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestCache(t *testing.T) {
+	var (
+		failing  atomic.Bool
+		hits     sync.Map
+		revalids atomic.Int64
+		tenant   atomic.Value
+	)
+
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		n, _ := hits.LoadOrStore(r.URL.Path, new(atomic.Int64))
+		n.(*atomic.Int64).Add(1)
+
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set(HeaderCacheControl, "max-age=60")
+		case "/revalidate":
+			w.Header().Set(HeaderCacheControl, "no-cache")
+			w.Header().Set(HeaderETag, `"v1"`)
+			if r.Header.Get(HeaderIfNoneMatch) == `"v1"` {
+				revalids.Add(1)
+				w.WriteHeader(gohttp.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set(HeaderCacheControl, "max-age=60")
+			w.Header().Set(HeaderVary, HeaderAccept)
+			_, _ = io.WriteString(w, r.Header.Get(HeaderAccept))
+			return
+		case "/swr":
+			tenant.Store(r.Header.Get("X-Tenant"))
+			w.Header().Set(HeaderCacheControl, "max-age=0, stale-while-revalidate=60")
+		case "/sie":
+			if failing.Load() {
+				gohttp.Error(w, "unavailable", gohttp.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set(HeaderCacheControl, "max-age=0, stale-if-error=60")
+		case "/large":
+			w.Header().Set(HeaderCacheControl, "max-age=60")
+			_, _ = io.WriteString(w, strings.Repeat("a", 64))
+		}
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	count := func(path string) int64 {
+		if n, ok := hits.Load(path); ok {
+			return n.(*atomic.Int64).Load()
+		}
+		return 0
+	}
+
+	store, err := cache.NewDiskStore(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	client := &gohttp.Client{
+		Transport: NewCacheTransport(srv.Client().Transport, store, cache.WithMaxEntrySize(32)),
+	}
+	do := func(method string, path string, header ...string) (*gohttp.Response, string, CacheResult) {
+		ctx := ContextWithCacheResult(context.Background())
+		req, err := gohttp.NewRequestWithContext(ctx, method, srv.URL+path, nil)
+		require.NoError(t, err)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		result, _ := CacheResultFromContext(ctx)
+		return resp, string(b), result
+	}
+
+	resp, body, result := do(gohttp.MethodGet, "/fresh")
+	assert.Equal(t, "/fresh", body)
+	assert.Equal(t, CacheResultMiss, result)
+	assert.Equal(t, "anchor; fwd=miss; fwd-status=200; stored", resp.Header.Get(HeaderCacheStatus))
+
+	resp, body, result = do(gohttp.MethodGet, "/fresh")
+	assert.Equal(t, "/fresh", body)
+	assert.Equal(t, CacheResultHit, result)
+	assert.True(t, strings.HasPrefix(resp.Header.Get(HeaderCacheStatus), "anchor; hit; ttl="))
+	assert.NotEmpty(t, resp.Header.Get(HeaderAge))
+	assert.Equal(t, int64(1), count("/fresh"))
+
+	// a successful request using an unsafe method invalidates the stored response
+	do(gohttp.MethodPost, "/fresh")
+	_, _, result = do(gohttp.MethodGet, "/fresh")
+	assert.Equal(t, CacheResultMiss, result)
+	assert.Equal(t, int64(3), count("/fresh"))
+
+	// stored responses requiring revalidation are revalidated using their validators
+	do(gohttp.MethodGet, "/revalidate")
+	resp, body, result = do(gohttp.MethodGet, "/revalidate")
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/revalidate", body)
+	assert.Equal(t, CacheResultRevalidated, result)
+	assert.Equal(t, int64(1), revalids.Load())
+
+	// conditional requests matching the stored response are answered with 304
+	resp, _, _ = do(gohttp.MethodGet, "/revalidate", HeaderIfNoneMatch, `"v1"`)
+	assert.Equal(t, gohttp.StatusNotModified, resp.StatusCode)
+
+	// responses are selected using the request header fields nominated by the Vary header
+	_, body, _ = do(gohttp.MethodGet, "/vary", HeaderAccept, "text/plain")
+	assert.Equal(t, "text/plain", body)
+	_, body, result = do(gohttp.MethodGet, "/vary", HeaderAccept, "application/json")
+	assert.Equal(t, "application/json", body)
+	assert.Equal(t, CacheResultMiss, result)
+	assert.Equal(t, int64(2), count("/vary"))
+
+	// stale responses are served while being revalidated in the background, using the request as it was sent even if
+	// it is changed once RoundTrip has returned
+	do(gohttp.MethodGet, "/swr")
+	req, err := gohttp.NewRequest(gohttp.MethodGet, srv.URL+"/swr", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Tenant", "a")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	req.Header.Set("X-Tenant", "b")
+	assert.True(t, strings.HasPrefix(resp.Header.Get(HeaderCacheStatus), "anchor; hit"))
+	assert.Eventually(t, func() bool { return count("/swr") == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "a", tenant.Load())
+
+	// stale responses are served when the server fails if allowed by stale-if-error
+	do(gohttp.MethodGet, "/sie")
+	failing.Store(true)
+	resp, body, result = do(gohttp.MethodGet, "/sie")
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/sie", body)
+	assert.Equal(t, CacheResultStale, result)
+	assert.Equal(t, "anchor; fwd=stale; fwd-status=503", resp.Header.Get(HeaderCacheStatus))
+
+	// responses larger than the maximum entry size are returned in full without being stored
+	_, body, _ = do(gohttp.MethodGet, "/large")
+	assert.Len(t, body, 64+len("/large"))
+	_, _, result = do(gohttp.MethodGet, "/large")
+	assert.Equal(t, CacheResultMiss, result)
+
+	// only-if-cached requests that cannot be served from the cache are answered with 504
+	resp, _, _ = do(gohttp.MethodGet, "/uncached", HeaderCacheControl, "only-if-cached")
+	assert.Equal(t, gohttp.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, int64(0), count("/uncached"))
+
+	// requests with the no-store directive bypass the cache
+	_, _, result = do(gohttp.MethodGet, "/fresh", HeaderCacheControl, "no-store")
+	assert.Equal(t, CacheResultBypass, result)
+}
@@ -0,0 +1,308 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+
+	gohttp "net/http"
+)
+
+// RedirectMax sets the maximum number of redirects followed by an http.Client created by NewClient.
+const RedirectMax = 10
+
+// ErrTooManyRedirects is returned by CheckRedirect once the maximum number of redirects has been reached.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// ErrorClass defines a category of failure for an HTTP request.
+type ErrorClass int
+
+// Enumeration of ErrorClass values.
+const (
+	// ErrorClassNone indicates that the request succeeded with a status code below 400.
+	ErrorClassNone ErrorClass = iota
+
+	// ErrorClassUnknown indicates a failure that does not belong to any other ErrorClass.
+	ErrorClassUnknown
+
+	// ErrorClassCanceled indicates that the context of the request was canceled.
+	ErrorClassCanceled
+
+	// ErrorClassDNS indicates that the host name could not be resolved.
+	ErrorClassDNS
+
+	// ErrorClassConnRefused indicates that the connection was refused.
+	ErrorClassConnRefused
+
+	// ErrorClassConnReset indicates that the connection was reset or closed unexpectedly.
+	ErrorClassConnReset
+
+	// ErrorClassDialTimeout indicates a timeout while establishing the connection.
+	ErrorClassDialTimeout
+
+	// ErrorClassTLSHandshakeTimeout indicates a timeout during the TLS handshake.
+	ErrorClassTLSHandshakeTimeout
+
+	// ErrorClassResponseHeaderTimeout indicates a timeout while sending the request or awaiting the response headers.
+	ErrorClassResponseHeaderTimeout
+
+	// ErrorClassBodyTimeout indicates a timeout while reading the response body.
+	ErrorClassBodyTimeout
+
+	// ErrorClassTimeout indicates a timeout for which the phase of the request is unknown.
+	ErrorClassTimeout
+
+	// ErrorClassTLSVerification indicates that the certificate of the server could not be verified.
+	ErrorClassTLSVerification
+
+	// ErrorClassTLS indicates a TLS failure other than a verification failure, e.g. a handshake alert.
+	ErrorClassTLS
+
+	// ErrorClassTooManyRedirects indicates that the maximum number of redirects was reached.
+	ErrorClassTooManyRedirects
+
+	// ErrorClassUnsupportedScheme indicates that the scheme of the request URL is not supported.
+	ErrorClassUnsupportedScheme
+
+	// ErrorClassStatusClient indicates a response with a 4xx status code.
+	ErrorClassStatusClient
+
+	// ErrorClassStatusServer indicates a response with a 5xx status code.
+	ErrorClassStatusServer
+)
+
+var errorClassNames = map[ErrorClass]string{
+	ErrorClassNone:                  "none",
+	ErrorClassUnknown:               "unknown",
+	ErrorClassCanceled:              "canceled",
+	ErrorClassDNS:                   "dns",
+	ErrorClassConnRefused:           "conn_refused",
+	ErrorClassConnReset:             "conn_reset",
+	ErrorClassDialTimeout:           "dial_timeout",
+	ErrorClassTLSHandshakeTimeout:   "tls_handshake_timeout",
+	ErrorClassResponseHeaderTimeout: "response_header_timeout",
+	ErrorClassBodyTimeout:           "body_timeout",
+	ErrorClassTimeout:               "timeout",
+	ErrorClassTLSVerification:       "tls_verification",
+	ErrorClassTLS:                   "tls",
+	ErrorClassTooManyRedirects:      "too_many_redirects",
+	ErrorClassUnsupportedScheme:     "unsupported_scheme",
+	ErrorClassStatusClient:          "status_client",
+	ErrorClassStatusServer:          "status_server",
+}
+
+// String returns the name of the ErrorClass.
+func (c ErrorClass) String() string {
+	if n, ok := errorClassNames[c]; ok {
+		return n
+	}
+	return fmt.Sprintf("ErrorClass(%d)", int(c))
+}
+
+// Timeout returns whether the ErrorClass is one of the timeout classes.
+func (c ErrorClass) Timeout() bool {
+	switch c {
+	case ErrorClassDialTimeout,
+		ErrorClassTLSHandshakeTimeout,
+		ErrorClassResponseHeaderTimeout,
+		ErrorClassBodyTimeout,
+		ErrorClassTimeout:
+		return true
+	}
+	return false
+}
+
+// ClassifiedError is an error annotated with its ErrorClass, as returned by http.RoundTripper instances wrapped with the
+// ClassifyErrors Middleware.
+type ClassifiedError struct {
+	Class ErrorClass
+	Err   error
+}
+
+// Error returns the message of the underlying error.
+func (e *ClassifiedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ClassifiedError) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the ErrorClass for the result of an HTTP request.
+//
+// Errors are classified using their type, and the ErrorClass of a ClassifiedError is returned as is. As the errors of
+// an http.Transport do not indicate the phase of the request in which a timeout occurred, timeouts other than dial
+// timeouts are only classified by phase for requests sent through the ClassifyErrors Middleware, and are classified as
+// ErrorClassTimeout otherwise.
+func Classify(resp *gohttp.Response, err error) ErrorClass {
+	if err == nil {
+		switch {
+		case resp == nil || resp.StatusCode < 400:
+			return ErrorClassNone
+		case resp.StatusCode < 500:
+			return ErrorClassStatusClient
+		default:
+			return ErrorClassStatusServer
+		}
+	}
+
+	var ce *ClassifiedError
+	if errors.As(err, &ce) {
+		return ce.Class
+	}
+	return classify(err, phaseUnknown)
+}
+
+// CheckRedirect is a function for http.Client.CheckRedirect that stops after RedirectMax redirects, returning
+// ErrTooManyRedirects.
+func CheckRedirect(req *gohttp.Request, via []*gohttp.Request) error {
+	if len(via) >= RedirectMax {
+		return fmt.Errorf("stopped after %d redirects: %w", len(via), ErrTooManyRedirects)
+	}
+	return nil
+}
+
+// ClassifyErrors returns a Middleware that wraps the errors of outbound requests, including errors reading the
+// response body, in a ClassifiedError. The phase of the request is traced using httptrace, so that timeouts are
+// classified by phase.
+func ClassifyErrors() Middleware {
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			var p atomic.Int32
+			p.Store(int32(phaseConnect))
+			set := func(ph phase) { p.Store(int32(ph)) }
+
+			ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+				DNSStart:             func(httptrace.DNSStartInfo) { set(phaseDNS) },
+				ConnectStart:         func(string, string) { set(phaseConnect) },
+				TLSHandshakeStart:    func() { set(phaseTLS) },
+				GotConn:              func(httptrace.GotConnInfo) { set(phaseResponseHeader) },
+				GotFirstResponseByte: func() { set(phaseBody) },
+			})
+
+			resp, err := next.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				// the error of an http.Transport for an unsupported scheme is only identifiable by its message
+				if !supportedScheme(req.URL) {
+					return resp, &ClassifiedError{Class: ErrorClassUnsupportedScheme, Err: err}
+				}
+				return resp, classifyWrap(err, phase(p.Load()))
+			}
+			// the body of a 101 Switching Protocols response is the upgraded connection, which is written to as well
+			if resp.StatusCode != gohttp.StatusSwitchingProtocols {
+				resp.Body = &classifiedBody{ReadCloser: resp.Body}
+			}
+			return resp, nil
+		})
+	}
+}
+
+// classifiedBody is a response body wrapping its read errors in a ClassifiedError.
+type classifiedBody struct {
+	io.ReadCloser
+}
+
+func (b *classifiedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = classifyWrap(err, phaseBody)
+	}
+	return n, err
+}
+
+// phase defines the phase of an HTTP request in which an error occurred.
+type phase int
+
+const (
+	phaseUnknown phase = iota
+	phaseDNS
+	phaseConnect
+	phaseTLS
+	phaseResponseHeader
+	phaseBody
+)
+
+func classifyWrap(err error, p phase) error {
+	var ce *ClassifiedError
+	if errors.As(err, &ce) {
+		return err
+	}
+	return &ClassifiedError{Class: classify(err, p), Err: err}
+}
+
+// classify returns the ErrorClass for an error that occurred in the provided phase.
+func classify(err error, p phase) ErrorClass {
+	var (
+		dnsErr         *net.DNSError
+		hostnameErr    x509.HostnameError
+		invalidErr     x509.CertificateInvalidError
+		opErr          *net.OpError
+		rootsErr       x509.SystemRootsError
+		alertErr       tls.AlertError
+		recordErr      tls.RecordHeaderError
+		timeoutErr     interface{ Timeout() bool }
+		unknownAuthErr x509.UnknownAuthorityError
+		urlErr         *url.Error
+		verifyErr      *tls.CertificateVerificationError
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, ErrTooManyRedirects):
+		return ErrorClassTooManyRedirects
+	case errors.As(err, &verifyErr),
+		errors.As(err, &unknownAuthErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &invalidErr),
+		errors.As(err, &rootsErr):
+		return ErrorClassTLSVerification
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassConnRefused
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorClassConnReset
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &timeoutErr) && timeoutErr.Timeout():
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return ErrorClassDialTimeout
+		}
+
+		switch p {
+		case phaseDNS, phaseConnect:
+			return ErrorClassDialTimeout
+		case phaseTLS:
+			return ErrorClassTLSHandshakeTimeout
+		case phaseResponseHeader:
+			return ErrorClassResponseHeaderTimeout
+		case phaseBody:
+			return ErrorClassBodyTimeout
+		}
+		return ErrorClassTimeout
+	case errors.As(err, &alertErr), errors.As(err, &recordErr):
+		return ErrorClassTLS
+	case errors.As(err, &urlErr):
+		if u, perr := url.Parse(urlErr.URL); perr == nil && u.Scheme != "http" && u.Scheme != "https" {
+			return ErrorClassUnsupportedScheme
+		}
+	}
+	return ErrorClassUnknown
+}
+
+// supportedScheme returns whether the scheme of the URL is supported by an http.Transport.
+func supportedScheme(u *url.URL) bool {
+	return u != nil && (u.Scheme == "http" || u.Scheme == "https")
+}
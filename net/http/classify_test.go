@@ -0,0 +1,192 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestClassify(t *testing.T) {
+	mux := gohttp.NewServeMux()
+	mux.HandleFunc("/close", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		conn, _, err := gohttp.NewResponseController(w).Hijack()
+		if assert.NoError(t, err) {
+			_ = conn.Close()
+		}
+	})
+	mux.HandleFunc("/redirect", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		gohttp.Redirect(w, r, "/redirect", gohttp.StatusFound)
+	})
+	mux.HandleFunc("/slow-body", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		_, _ = io.WriteString(w, "partial")
+		_ = gohttp.NewResponseController(w).Flush()
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/slow-header", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/status/{code}", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		switch r.PathValue("code") {
+		case "404":
+			w.WriteHeader(gohttp.StatusNotFound)
+		case "503":
+			w.WriteHeader(gohttp.StatusServiceUnavailable)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tlsSrv := httptest.NewTLSServer(mux)
+	defer tlsSrv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refused := "http://" + l.Addr().String()
+	require.NoError(t, l.Close())
+
+	// the timeout also covers establishing the connection, so it is only used for the slow responses
+	client := NewClient(WithTimeout(0))
+	slowClient := NewClient(WithTimeout(0), WithMiddleware(RequestTimeout(200*time.Millisecond)))
+	classify := func(ctx context.Context, client *gohttp.Client, url string) ErrorClass {
+		req, err := gohttp.NewRequestWithContext(ctx, gohttp.MethodGet, url, nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return Classify(resp, err)
+		}
+		defer resp.Body.Close()
+
+		if _, err := io.ReadAll(resp.Body); err != nil {
+			return Classify(nil, err)
+		}
+		return Classify(resp, nil)
+	}
+
+	canceled, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	for _, tc := range []struct {
+		client *gohttp.Client
+		ctx    context.Context
+		url    string
+		want   ErrorClass
+	}{
+		{url: srv.URL + "/status/200", want: ErrorClassNone},
+		{url: srv.URL + "/status/404", want: ErrorClassStatusClient},
+		{url: srv.URL + "/status/503", want: ErrorClassStatusServer},
+		{url: srv.URL + "/close", want: ErrorClassConnReset},
+		{url: srv.URL + "/redirect", want: ErrorClassTooManyRedirects},
+		{client: slowClient, url: srv.URL + "/slow-body", want: ErrorClassBodyTimeout},
+		{client: slowClient, url: srv.URL + "/slow-header", want: ErrorClassResponseHeaderTimeout},
+		{url: srv.URL + "/status/200", ctx: canceled, want: ErrorClassCanceled},
+		{url: tlsSrv.URL + "/status/200", want: ErrorClassTLSVerification},
+		{url: refused, want: ErrorClassConnRefused},
+		{url: "http://anchor.invalid", want: ErrorClassDNS},
+		{url: "ftp://127.0.0.1/file", want: ErrorClassUnsupportedScheme},
+		{
+			client: NewClient(WithTimeout(0),
+				WithMiddleware(RequestTimeout(100*time.Millisecond)),
+				WithDialContext(func(ctx context.Context, network string, addr string) (net.Conn, error) {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				})),
+			url:  srv.URL + "/status/200",
+			want: ErrorClassDialTimeout,
+		},
+		{
+			// without the ClassifyErrors middleware the phase of the timeout is unknown
+			client: &gohttp.Client{Transport: Chain(srv.Client().Transport, RequestTimeout(100*time.Millisecond))},
+			url:    srv.URL + "/slow-header",
+			want:   ErrorClassTimeout,
+		},
+	} {
+		if tc.client == nil {
+			tc.client = client
+		}
+
+		if tc.ctx == nil {
+			tc.ctx = t.Context()
+		}
+		assert.Equal(t, tc.want, classify(tc.ctx, tc.client, tc.url), tc.url)
+	}
+
+	assert.Equal(t, ErrorClassUnknown, Classify(nil, errors.New("unknown")))
+	assert.Equal(t, ErrorClassDNS, Classify(nil, &ClassifiedError{Class: ErrorClassDNS, Err: errors.New("dns")}))
+	assert.Equal(t, "response_header_timeout", ErrorClassResponseHeaderTimeout.String())
+	assert.True(t, ErrorClassBodyTimeout.Timeout())
+	assert.False(t, ErrorClassConnReset.Timeout())
+}
+
+func TestClassifyUpgrade(t *testing.T) {
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		conn, rw, err := gohttp.NewResponseController(w).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = rw.Flush()
+		_, _ = io.Copy(conn, rw)
+	}))
+	defer srv.Close()
+
+	// the body of upgraded connections is written to through the middleware wrapping it
+	client := NewClient(WithTimeout(0), WithMiddleware(RequestTimeout(time.Minute)))
+	req, err := gohttp.NewRequestWithContext(t.Context(), gohttp.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, gohttp.StatusSwitchingProtocols, resp.StatusCode)
+
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	require.True(t, ok)
+
+	_, err = io.WriteString(rwc, "ping")
+	require.NoError(t, err)
+
+	b := make([]byte, 4)
+	_, err = io.ReadFull(rwc, b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b))
+}
+
+func TestRetry(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		retry  bool
+		status int
+	}{
+		{status: gohttp.StatusOK},
+		{status: gohttp.StatusNotFound},
+		{status: gohttp.StatusTooManyRequests, retry: true},
+		{status: gohttp.StatusServiceUnavailable, retry: true},
+		{status: gohttp.StatusNotImplemented},
+		{err: errors.New("unknown"), retry: true},
+		{err: context.Canceled},
+		{err: ErrTooManyRedirects},
+	} {
+		var resp *gohttp.Response
+		if tc.err == nil {
+			resp = &gohttp.Response{Status: gohttp.StatusText(tc.status), StatusCode: tc.status}
+		}
+
+		retry, _ := Retry(resp, tc.err)
+		assert.Equal(t, tc.retry, retry, tc)
+	}
+}
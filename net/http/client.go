@@ -1,75 +1,102 @@
 package http
 
 import (
-	"crypto/x509"
-	"errors"
 	"fmt"
 	"net"
-	"net/url"
-	"regexp"
 	"runtime"
+	"slices"
 	"time"
 
 	"github.com/transientvariable/anchor"
-	"github.com/transientvariable/log-go"
-
-	"github.com/cenkalti/backoff/v4"
 
 	gohttp "net/http"
 )
 
 const (
-	BufferSizeRead    = 4 * anchor.KiB
-	BufferSizeWrite   = 4 * anchor.KiB
-	ConnIdleTimeout   = 90 * time.Second
-	ConnMaxPerHost    = 512
-	ConnMaxIdle       = 100
-	DialKeepAlive     = 30 * time.Second
-	DialTimeout       = 30 * time.Second
-	DisableKeepAlives = true
+	BufferSizeRead        = 4 * anchor.KiB
+	BufferSizeWrite       = 4 * anchor.KiB
+	ConnIdleTimeout       = 90 * time.Second
+	ConnMaxPerHost        = 512
+	ConnMaxIdle           = 100
+	DialKeepAlive         = 30 * time.Second
+	DialTimeout           = 30 * time.Second
+	DisableKeepAlives     = true
+	ExpectContinueTimeout = 3 * time.Second
 
 	// RetryMax sets the number times an HTTP request is retried.
 	RetryMax = 2
 
-	Timeout = 90 * time.Second
+	Timeout             = 90 * time.Second
+	TLSHandshakeTimeout = 10 * time.Second
 )
 
-var (
-	errRedirectsPattern  = regexp.MustCompile(`stopped after \d+ redirects\z`)
-	errSchemePattern     = regexp.MustCompile(`unsupported protocol scheme`)
-	errNotTrustedPattern = regexp.MustCompile(`certificate is not trusted`)
-)
+// NewClient returns a new http.Client using an http.Transport created by NewTransport with the provided options.
+//
+// Outbound requests pass through the provided Middleware in order, then the RetryPolicy and HedgePolicy Middleware, if
+// any, so that each attempt and hedge passes through the RequestID Middleware, which propagates the request ID from the
+// request context, and the ClassifyErrors Middleware. Redirects are followed using CheckRedirect.
+func NewClient(options ...func(*Option)) *gohttp.Client {
+	opts := newOption(options...)
+
+	middleware := slices.Clone(opts.middleware)
+	if opts.retryPolicy != nil {
+		middleware = append(middleware, opts.retryPolicy.Middleware())
+	}
+
+	if opts.hedgePolicy != nil {
+		middleware = append(middleware, opts.hedgePolicy.Middleware())
+	}
 
-// NewClient ...
-func NewClient() *gohttp.Client {
 	return &gohttp.Client{
-		Timeout:   Timeout,
-		Transport: NewTransport(),
+		CheckRedirect: CheckRedirect,
+		Timeout:       opts.timeout,
+		Transport:     Chain(newTransport(opts), append(middleware, RequestID(), ClassifyErrors())...),
 	}
 }
 
 // DefaultClient returns a new http.Client with similar default values to http.Client, but with a non-shared
-// http.Transport which has keep-alives disabled.
+// http.Transport which has keep-alives disabled. The request ID from the request context is propagated on outbound
+// requests.
 func DefaultClient() *gohttp.Client {
 	return &gohttp.Client{
 		Timeout:   Timeout,
-		Transport: DefaultTransport(),
+		Transport: NewRequestIDTransport(DefaultTransport()),
 	}
 }
 
-// NewTransport ...
-func NewTransport() *gohttp.Transport {
+// NewTransport returns a new http.Transport configured using the provided options. Without options, keep-alives are
+// disabled, and the connection limits and buffer sizes are set using the package defaults.
+//
+// Options that only apply to an http.Client, such as the timeout, retry and hedge policies, and middleware, are ignored.
+func NewTransport(options ...func(*Option)) *gohttp.Transport {
+	return newTransport(newOption(options...))
+}
+
+func newTransport(opts *Option) *gohttp.Transport {
+	dialContext := opts.dialContext
+	if dialContext == nil {
+		dialContext = (&net.Dialer{
+			KeepAlive: opts.dialKeepAlive,
+			Timeout:   opts.dialTimeout,
+		}).DialContext
+	}
+
 	transport := DefaultTransport()
-	transport.DialContext = (&net.Dialer{
-		KeepAlive: DialKeepAlive,
-		Timeout:   DialTimeout,
-	}).DialContext
-	transport.IdleConnTimeout = ConnIdleTimeout
-	transport.MaxConnsPerHost = ConnMaxPerHost
-	transport.MaxIdleConns = ConnMaxIdle
-	transport.ReadBufferSize = BufferSizeRead
-	transport.WriteBufferSize = BufferSizeWrite
-	transport.DisableKeepAlives = DisableKeepAlives
+	transport.DialContext = dialContext
+	transport.DisableKeepAlives = opts.disableKeepAlives
+	transport.ExpectContinueTimeout = opts.expectContinueTimeout
+	transport.ForceAttemptHTTP2 = opts.http2
+	transport.HTTP2 = opts.http2Config
+	transport.IdleConnTimeout = opts.connIdleTimeout
+	transport.MaxConnsPerHost = opts.connMaxPerHost
+	transport.MaxIdleConns = opts.connMaxIdle
+	transport.MaxIdleConnsPerHost = opts.connMaxIdlePerHost
+	transport.Proxy = opts.proxy
+	transport.ReadBufferSize = opts.bufferSizeRead
+	transport.ResponseHeaderTimeout = opts.responseHeaderTimeout
+	transport.TLSClientConfig = opts.tlsConfig
+	transport.TLSHandshakeTimeout = opts.tlsHandshakeTimeout
+	transport.WriteBufferSize = opts.bufferSizeWrite
 	return transport
 }
 
@@ -80,7 +107,7 @@ func DefaultTransport() *gohttp.Transport {
 			Timeout:   DialTimeout,
 			KeepAlive: DialKeepAlive,
 		}).DialContext,
-		ExpectContinueTimeout: 3 * time.Second,
+		ExpectContinueTimeout: ExpectContinueTimeout,
 		ForceAttemptHTTP2:     true,
 		IdleConnTimeout:       ConnIdleTimeout,
 		MaxConnsPerHost:       ConnMaxPerHost,
@@ -88,64 +115,30 @@ func DefaultTransport() *gohttp.Transport {
 		MaxIdleConnsPerHost:   runtime.GOMAXPROCS(0) + 1,
 		Proxy:                 gohttp.ProxyFromEnvironment,
 		ReadBufferSize:        BufferSizeRead,
-		TLSHandshakeTimeout:   10 * time.Second,
+		TLSHandshakeTimeout:   TLSHandshakeTimeout,
 		WriteBufferSize:       BufferSizeWrite,
 	}
 }
 
-// DoWithRetry ...
+// DoWithRetry sends the request using the http.Client, retrying it according to a RetryPolicy with default values.
 func DoWithRetry(client *gohttp.Client, req *gohttp.Request) (*gohttp.Response, error) {
-	resp, err := client.Do(req)
-	if err != nil {
-		return resp, err
-	}
-
-	if req.Context().Err() != nil {
-		return resp, req.Context().Err()
-	}
-
-	if retry, _ := Retry(resp, err); retry {
-		err = backoff.Retry(func() error {
-			log.Trace("[anchor] retry", log.String("url", req.URL.String()))
-			rr, err := client.Do(req)
-			if err != nil {
-				if rr.Body != nil {
-					if err := rr.Body.Close(); err != nil {
-						log.Error("[anchor] retry", log.Err(err))
-					}
-				}
-				return err
-			}
-			resp = rr
-			return nil
-		}, backoff.WithMaxRetries(backoff.NewExponentialBackOff(), RetryMax))
-	}
-	return resp, err
+	return NewRetryPolicy().Do(client, req)
 }
 
-// Retry ...
+// Retry returns whether the result of an HTTP request is retryable based on its ErrorClass, along with the reason if
+// it is not a success. Requests failing with canceled, TLS verification, redirect or unsupported scheme errors are not
+// retried, and neither are responses with status codes other than 429 and 5xx, except 501.
 func Retry(resp *gohttp.Response, err error) (bool, error) {
-	if err != nil {
-		var urlErr *url.Error
-		if errors.As(err, &urlErr) {
-			if errors.Is(urlErr.Err, x509.UnknownAuthorityError{}) {
-				return false, err
-			}
-
-			e := urlErr.Error()
-			if errRedirectsPattern.MatchString(e) || errSchemePattern.MatchString(e) || errNotTrustedPattern.MatchString(e) {
-				return false, urlErr
-			}
-		}
+	switch c := Classify(resp, err); c {
+	case ErrorClassNone:
+		return false, nil
+	case ErrorClassCanceled, ErrorClassTLSVerification, ErrorClassTooManyRedirects, ErrorClassUnsupportedScheme:
+		return false, err
+	case ErrorClassStatusClient:
+		return resp.StatusCode == gohttp.StatusTooManyRequests, nil
+	case ErrorClassStatusServer:
+		return resp.StatusCode != gohttp.StatusNotImplemented, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	default:
 		return true, nil
 	}
-
-	if resp.StatusCode == gohttp.StatusTooManyRequests {
-		return true, nil
-	}
-
-	if resp.StatusCode == 0 || (resp.StatusCode >= 500 && resp.StatusCode != gohttp.StatusNotImplemented) {
-		return true, fmt.Errorf("unexpected HTTP status %s", resp.Status)
-	}
-	return false, nil
 }
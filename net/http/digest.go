@@ -0,0 +1,460 @@
+package http
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/transientvariable/log-go"
+
+	gohttp "net/http"
+)
+
+// Enumeration of supported digest algorithms, as registered for the Content-Digest and Repr-Digest headers defined by
+// RFC 9530.
+const (
+	DigestSHA256 = "sha-256"
+	DigestSHA512 = "sha-512"
+)
+
+// digestMD5 is the legacy algorithm of the Content-MD5 header and the Digest header defined by RFC 3230, supported
+// for verification only.
+const digestMD5 = "md5"
+
+var (
+	// ErrDigestMismatch is returned when reading a body whose digest does not match the digest provided in its headers.
+	ErrDigestMismatch = errors.New("digest mismatch")
+
+	// ErrDigestMissing is returned for responses without a supported digest when digests are required.
+	ErrDigestMissing = errors.New("digest missing")
+)
+
+// DigestOption is a container for optional properties that can be used for initializing the Digest Middleware and
+// DigestHandler.
+type DigestOption struct {
+	algorithm string
+	required  bool
+}
+
+// WithDigestAlgorithm sets the algorithm used for computing the digest of request bodies and requesting the digest of
+// response bodies. Either DigestSHA256 or DigestSHA512.
+func WithDigestAlgorithm(algorithm string) func(*DigestOption) {
+	return func(o *DigestOption) {
+		o.algorithm = algorithm
+	}
+}
+
+// WithDigestRequired sets whether bodies without a supported digest are rejected.
+func WithDigestRequired(required bool) func(*DigestOption) {
+	return func(o *DigestOption) {
+		o.required = required
+	}
+}
+
+// newDigestOption returns a new DigestOption with default values, updated using the provided options.
+func newDigestOption(options ...func(*DigestOption)) *DigestOption {
+	opts := &DigestOption{algorithm: DigestSHA256}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	if opts.algorithm = strings.ToLower(opts.algorithm); opts.algorithm != DigestSHA512 {
+		opts.algorithm = DigestSHA256
+	}
+	return opts
+}
+
+// Digest returns a Middleware that attaches the Content-Digest header to outbound requests with a body, and verifies the
+// digest of response bodies while they are read, as defined by RFC 9530.
+//
+// The digest of a request body is computed using http.Request.GetBody if set, otherwise the body is read into memory.
+// Responses are requested to provide a digest using the Want-Content-Digest header, and are verified using their
+// Content-Digest header or trailer, their Repr-Digest header for complete responses, or the legacy Digest and
+// Content-MD5 headers, in that order. The strongest supported algorithm of a header is used, and the Repr-Digest header
+// is ignored for responses with a content coding.
+//
+// Reading a response body fails with ErrDigestMismatch once the end of a body that does not match its digest has been
+// reached. Responses decompressed by the http.Transport are not verified, since the digest applies to the compressed
+// content.
+func Digest(options ...func(*DigestOption)) Middleware {
+	opts := newDigestOption(options...)
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			r, err := opts.digestRequest(req)
+			if err != nil {
+				if req.Body != nil {
+					_ = req.Body.Close()
+				}
+				return nil, fmt.Errorf("digest: %w", err)
+			}
+
+			resp, err := next.RoundTrip(r)
+			if err != nil || resp.Body == nil || !hasBody(req.Method, resp.StatusCode) {
+				return resp, err
+			}
+
+			if resp.Uncompressed {
+				log.Trace("[http:digest] not verifying decompressed response", log.String("url", req.URL.Redacted()))
+				return resp, nil
+			}
+
+			if alg, sum, ok := expectedDigest(resp.Header, resp.StatusCode); ok {
+				resp.Body = &digestBody{ReadCloser: resp.Body, algorithm: alg, expected: sum, hash: newDigestHash(alg)}
+				return resp, nil
+			}
+
+			if _, ok := resp.Trailer[HeaderContentDigest]; ok {
+				resp.Body = &digestBody{
+					ReadCloser: resp.Body,
+					algorithm:  opts.algorithm,
+					hash:       newDigestHash(opts.algorithm),
+					required:   opts.required,
+					trailer:    resp.Trailer,
+				}
+				return resp, nil
+			}
+
+			if opts.required {
+				_ = resp.Body.Close()
+				return nil, fmt.Errorf("digest: %w: %s", ErrDigestMissing, req.URL.Redacted())
+			}
+			return resp, nil
+		})
+	}
+}
+
+// NewDigestTransport creates a new http.RoundTripper that attaches the Content-Digest header to requests sent using the
+// provided http.RoundTripper, and verifies the digest of their responses.
+func NewDigestTransport(transport gohttp.RoundTripper, options ...func(*DigestOption)) gohttp.RoundTripper {
+	return Chain(transport, Digest(options...))
+}
+
+// DigestHandler returns an http.Handler that verifies the digest of request bodies while they are read, and provides
+// the Content-Digest trailer for responses to requests with the Want-Content-Digest header, using the preferred
+// supported algorithm.
+//
+// Reading a request body fails with ErrDigestMismatch once the end of a body that does not match its digest has been
+// reached. If digests are required, requests with a body but without a supported digest are rejected with
+// http.StatusBadRequest. Responses whose handler sets the Content-Digest header are left unchanged.
+func DigestHandler(next gohttp.Handler, options ...func(*DigestOption)) gohttp.Handler {
+	opts := newDigestOption(options...)
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.Body != nil && r.Body != gohttp.NoBody {
+			if alg, sum, ok := expectedDigest(r.Header, gohttp.StatusOK); ok {
+				r.Body = &digestBody{ReadCloser: r.Body, algorithm: alg, expected: sum, hash: newDigestHash(alg)}
+			} else if opts.required && r.ContentLength != 0 {
+				gohttp.Error(w, ErrDigestMissing.Error(), gohttp.StatusBadRequest)
+				return
+			}
+		}
+
+		alg := negotiateDigest(r.Header.Values(HeaderWantContentDigest))
+		if alg == "" || r.Method == gohttp.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		dw := &digestWriter{ResponseWriter: w, algorithm: alg, hash: newDigestHash(alg)}
+		next.ServeHTTP(dw, r)
+		dw.close()
+	})
+}
+
+// digestRequest returns a clone of the request with the Content-Digest header set for its body, if any, and the
+// Want-Content-Digest header set for its response. Headers that are already set are left unchanged.
+func (o *DigestOption) digestRequest(req *gohttp.Request) (*gohttp.Request, error) {
+	r := req.Clone(req.Context())
+	if r.Header.Get(HeaderWantContentDigest) == "" {
+		r.Header.Set(HeaderWantContentDigest, o.algorithm+"=10")
+	}
+
+	if req.Body == nil || req.Body == gohttp.NoBody || req.Header.Get(HeaderContentDigest) != "" {
+		return r, nil
+	}
+
+	h := newDigestHash(o.algorithm)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+
+		if _, err := io.Copy(h, body); err != nil {
+			return nil, err
+		}
+	} else {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		h.Write(b)
+
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+		r.ContentLength = int64(len(b))
+	}
+	r.Header.Set(HeaderContentDigest, formatDigest(o.algorithm, h.Sum(nil)))
+	return r, nil
+}
+
+// digestBody is a body verifying its digest once its end has been reached. The expected digest is either provided
+// upfront, or read from the Content-Digest trailer.
+type digestBody struct {
+	io.ReadCloser
+	algorithm string
+	err       error
+	expected  []byte
+	hash      hash.Hash
+	required  bool
+	trailer   gohttp.Header
+}
+
+func (b *digestBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF {
+		if verr := b.verify(); verr != nil {
+			b.err = verr
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+// verify compares the digest of the body with the expected digest.
+func (b *digestBody) verify() error {
+	expected := b.expected
+	if expected == nil {
+		var ok bool
+		if expected, ok = parseDigests(b.trailer.Values(HeaderContentDigest), true)[b.algorithm]; !ok {
+			if b.required {
+				return fmt.Errorf("%w: %s", ErrDigestMissing, b.algorithm)
+			}
+			return nil
+		}
+	}
+
+	if !bytes.Equal(b.hash.Sum(nil), expected) {
+		return fmt.Errorf("%w: %s", ErrDigestMismatch, b.algorithm)
+	}
+	return nil
+}
+
+// digestWriter is a http.ResponseWriter computing the digest of the response body, which is sent as the Content-Digest
+// trailer.
+type digestWriter struct {
+	gohttp.ResponseWriter
+	algorithm string
+	hash      hash.Hash
+	state     digestState
+}
+
+type digestState int
+
+const (
+	digestPending digestState = iota
+	digestComputing
+	digestPassing
+)
+
+// WriteHeader declares the Content-Digest trailer for responses with a body. Informational responses are written as is.
+func (dw *digestWriter) WriteHeader(status int) {
+	if dw.state != digestPending || status < gohttp.StatusOK {
+		dw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	h := dw.Header()
+	if hasBody(gohttp.MethodGet, status) && h.Get(HeaderContentDigest) == "" {
+		// the trailer is only sent if the body is not delimited by the Content-Length header
+		h.Add("Trailer", HeaderContentDigest)
+		h.Del(HeaderContentLength)
+		dw.state = digestComputing
+	} else {
+		dw.state = digestPassing
+	}
+	dw.ResponseWriter.WriteHeader(status)
+}
+
+// Write adds the data to the digest of the body before writing it.
+func (dw *digestWriter) Write(b []byte) (int, error) {
+	if dw.state == digestPending {
+		dw.WriteHeader(gohttp.StatusOK)
+	}
+
+	n, err := dw.ResponseWriter.Write(b)
+	if dw.state == digestComputing {
+		dw.hash.Write(b[:n])
+	}
+	return n, err
+}
+
+// Flush flushes the response.
+func (dw *digestWriter) Flush() {
+	if dw.state == digestPending {
+		dw.WriteHeader(gohttp.StatusOK)
+	}
+	_ = gohttp.NewResponseController(dw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (dw *digestWriter) Unwrap() gohttp.ResponseWriter {
+	return dw.ResponseWriter
+}
+
+// close sets the Content-Digest trailer once the handler has completed.
+func (dw *digestWriter) close() {
+	if dw.state == digestPending {
+		dw.WriteHeader(gohttp.StatusOK)
+	}
+
+	if dw.state == digestComputing {
+		dw.Header().Set(HeaderContentDigest, formatDigest(dw.algorithm, dw.hash.Sum(nil)))
+	}
+}
+
+// expectedDigest returns the algorithm and expected digest of a body using the strongest supported algorithm of the
+// Content-Digest header, the Repr-Digest header unless the status indicates partial content, or the legacy Digest and
+// Content-MD5 headers, in that order. The Repr-Digest header is ignored if a content coding other than identity is
+// applied, since it applies to the representation before it is encoded, unlike the legacy Digest header, which applies
+// to the encoded representation as defined by RFC 3230.
+func expectedDigest(h gohttp.Header, status int) (string, []byte, bool) {
+	ce := strings.TrimSpace(h.Get(HeaderContentEncoding))
+	encoded := ce != "" && !strings.EqualFold(ce, "identity")
+
+	fields := []struct {
+		name       string
+		structured bool
+	}{
+		{name: HeaderContentDigest, structured: true},
+		{name: HeaderReprDigest, structured: true},
+		{name: HeaderDigest},
+	}
+
+	for _, f := range fields {
+		if f.name == HeaderReprDigest && status == gohttp.StatusPartialContent {
+			continue
+		}
+
+		if f.name == HeaderReprDigest && encoded {
+			continue
+		}
+
+		digests := parseDigests(h.Values(f.name), f.structured)
+		for _, alg := range []string{DigestSHA512, DigestSHA256, digestMD5} {
+			if sum, ok := digests[alg]; ok && (!f.structured || alg != digestMD5) {
+				return alg, sum, true
+			}
+		}
+	}
+
+	if v := strings.TrimSpace(h.Get(HeaderContentMD5)); v != "" {
+		if sum, err := base64.StdEncoding.DecodeString(v); err == nil {
+			return digestMD5, sum, true
+		}
+	}
+	return "", nil, false
+}
+
+// negotiateDigest returns the supported algorithm with the highest preference of the Want-Content-Digest header values,
+// or an empty string if none is acceptable.
+func negotiateDigest(values []string) string {
+	var (
+		alg    string
+		weight int64
+	)
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			name, w, _ := strings.Cut(strings.TrimSpace(m), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != DigestSHA256 && name != DigestSHA512 {
+				continue
+			}
+
+			n, err := strconv.ParseInt(strings.TrimSpace(w), 10, 64)
+			if err != nil || n <= 0 || n > 10 {
+				continue
+			}
+
+			if n > weight || (n == weight && name == DigestSHA512) {
+				alg, weight = name, n
+			}
+		}
+	}
+	return alg
+}
+
+// parseDigests parses the digests of the header values, keyed by lowercase algorithm. Structured values use the byte
+// sequence format of RFC 9530, e.g. sha-256=:base64:, and legacy values the format of RFC 3230, e.g. SHA-256=base64.
+// Invalid members are ignored.
+func parseDigests(values []string, structured bool) map[string][]byte {
+	digests := make(map[string][]byte)
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(m), "=")
+			if !ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+
+			if structured {
+				if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+					continue
+				}
+				value = value[1 : len(value)-1]
+			}
+
+			sum, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				continue
+			}
+
+			if name = strings.ToLower(strings.TrimSpace(name)); name == DigestSHA256 || name == DigestSHA512 || name == digestMD5 {
+				if _, exists := digests[name]; !exists {
+					digests[name] = sum
+				}
+			}
+		}
+	}
+	return digests
+}
+
+// formatDigest returns the structured representation of a digest for the Content-Digest header.
+func formatDigest(algorithm string, sum []byte) string {
+	return algorithm + "=:" + base64.StdEncoding.EncodeToString(sum) + ":"
+}
+
+// newDigestHash returns a new hash.Hash for the algorithm.
+func newDigestHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case DigestSHA512:
+		return sha512.New()
+	case digestMD5:
+		return md5.New()
+	}
+	return sha256.New()
+}
+
+// hasBody returns whether a response to a request using the method with the status code has a body.
+func hasBody(method string, status int) bool {
+	if method == gohttp.MethodHead {
+		return false
+	}
+	return status >= gohttp.StatusOK && status != gohttp.StatusNoContent && status != gohttp.StatusNotModified
+}
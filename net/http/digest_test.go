@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("payload"))
+	md5Sum := md5.Sum([]byte("payload"))
+
+	mux := gohttp.NewServeMux()
+	mux.HandleFunc("/echo", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			gohttp.Error(w, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(b)
+	})
+	mux.HandleFunc("/mismatch", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set(HeaderContentDigest, formatDigest(DigestSHA256, sum[:]))
+		_, _ = io.WriteString(w, "tampered")
+	})
+	mux.HandleFunc("/legacy", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set(HeaderContentMD5, base64.StdEncoding.EncodeToString(md5Sum[:]))
+		_, _ = io.WriteString(w, r.URL.Query().Get("body"))
+	})
+
+	var encoded bytes.Buffer
+	gw := gzip.NewWriter(&encoded)
+	_, _ = io.WriteString(gw, "payload")
+	require.NoError(t, gw.Close())
+	encodedSum := sha256.Sum256(encoded.Bytes())
+
+	mux.HandleFunc("/gzip", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set(HeaderContentEncoding, "gzip")
+		w.Header().Set(HeaderReprDigest, formatDigest(DigestSHA256, sum[:]))
+		if r.URL.Query().Has("legacy") {
+			// the legacy Digest header applies to the encoded representation
+			digest := encodedSum
+			if r.URL.Query().Get("legacy") == "decoded" {
+				digest = sum
+			}
+			w.Header().Set(HeaderDigest, "sha-256="+base64.StdEncoding.EncodeToString(digest[:]))
+		}
+		_, _ = w.Write(encoded.Bytes())
+	})
+
+	srv := httptest.NewServer(DigestHandler(mux))
+	defer srv.Close()
+
+	client := &gohttp.Client{Transport: NewDigestTransport(srv.Client().Transport)}
+	do := func(req *gohttp.Request) (*gohttp.Response, string, error) {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return resp, string(b), err
+	}
+
+	// the digest of the request body is attached, and the digest of the response is sent as a trailer
+	resp, body, err := do(mustRequest(t, gohttp.MethodPost, srv.URL+"/echo", io.NopCloser(strings.NewReader("payload"))))
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+	assert.Equal(t, "payload", body)
+	assert.Equal(t, formatDigest(DigestSHA256, sum[:]), resp.Trailer.Get(HeaderContentDigest))
+
+	// request bodies not matching their digest fail to be read by the handler
+	req := mustRequest(t, gohttp.MethodPost, srv.URL+"/echo", strings.NewReader("tampered"))
+	req.Header.Set(HeaderContentDigest, formatDigest(DigestSHA256, sum[:]))
+	resp, body, err = do(req)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, ErrDigestMismatch.Error())
+
+	// response bodies not matching their digest fail to be read
+	_, _, err = do(mustRequest(t, gohttp.MethodGet, srv.URL+"/mismatch", nil))
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+
+	_, body, err = do(mustRequest(t, gohttp.MethodGet, srv.URL+"/legacy?body=payload", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "payload", body)
+
+	_, _, err = do(mustRequest(t, gohttp.MethodGet, srv.URL+"/legacy?body=tampered", nil))
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+
+	// the digest of the representation is not verified against an encoded body, unlike the legacy Digest header
+	gzipped := func(query string) (string, error) {
+		req := mustRequest(t, gohttp.MethodGet, srv.URL+"/gzip"+query, nil)
+		req.Header.Set(HeaderAcceptEncoding, "gzip")
+		req.Header.Set(HeaderWantContentDigest, "unsupported=1")
+		resp, body, err := do(req)
+		if err != nil {
+			return "", err
+		}
+		assert.Equal(t, "gzip", resp.Header.Get(HeaderContentEncoding))
+		assert.Empty(t, resp.Trailer.Get(HeaderContentDigest))
+		return body, nil
+	}
+
+	for _, query := range []string{"", "?legacy"} {
+		body, err = gzipped(query)
+		require.NoError(t, err, query)
+		assert.Equal(t, encoded.String(), body, query)
+	}
+
+	_, err = gzipped("?legacy=decoded")
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+
+	// responses without a digest are rejected if digests are required
+	plain := httptest.NewServer(mux)
+	defer plain.Close()
+
+	client = &gohttp.Client{Transport: NewDigestTransport(plain.Client().Transport, WithDigestRequired(true))}
+	_, _, err = do(mustRequest(t, gohttp.MethodGet, plain.URL+"/echo", nil))
+	assert.ErrorIs(t, err, ErrDigestMissing)
+}
+
+func TestNegotiateDigest(t *testing.T) {
+	assert.Equal(t, DigestSHA256, negotiateDigest([]string{"sha-512=3, sha-256=10"}))
+	assert.Equal(t, DigestSHA512, negotiateDigest([]string{"sha-256=5", "SHA-512=5"}))
+	assert.Empty(t, negotiateDigest([]string{"sha-256=0, md5=10"}))
+	assert.Empty(t, negotiateDigest(nil))
+}
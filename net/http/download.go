@@ -0,0 +1,577 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+
+	"github.com/cenkalti/backoff/v4"
+
+	gohttp "net/http"
+)
+
+const (
+	// DownloadChunkSize sets the default size of the ranges of a resource that are downloaded concurrently.
+	DownloadChunkSize = 8 * anchor.MiB
+
+	// DownloadConcurrency sets the default number of ranges of a resource that are downloaded concurrently.
+	DownloadConcurrency = 4
+)
+
+var (
+	// ErrChecksumMismatch is returned when the checksum of a downloaded resource does not match the expected checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrDownloadChanged is returned when a resource changes while it is being downloaded using range requests.
+	ErrDownloadChanged = errors.New("resource changed")
+)
+
+// DownloadOption is a container for optional properties that can be used for configuring Download.
+type DownloadOption struct {
+	checksum    []byte
+	chunkSize   int64
+	client      *gohttp.Client
+	concurrency int
+	maxAttempts int
+	newBackOff  func() backoff.BackOff
+	newHash     func() hash.Hash
+	offset      int64
+	validator   string
+}
+
+// WithDownloadBackOff sets the function creating the backoff.BackOff strategy used for the attempts of a range.
+func WithDownloadBackOff(newBackOff func() backoff.BackOff) func(*DownloadOption) {
+	return func(o *DownloadOption) {
+		o.newBackOff = newBackOff
+	}
+}
+
+// WithDownloadChecksum sets the hash function and expected checksum the downloaded resource is verified with.
+func WithDownloadChecksum(newHash func() hash.Hash, sum []byte) func(*DownloadOption) {
+	return func(o *DownloadOption) {
+		o.newHash = newHash
+		o.checksum = sum
+	}
+}
+
+// WithDownloadChunkSize sets the size of the ranges of a resource that are downloaded concurrently.
+func WithDownloadChunkSize(size int64) func(*DownloadOption) {
+	return func(o *DownloadOption) {
+		o.chunkSize = size
+	}
+}
+
+// WithDownloadClient sets the http.Client used for sending requests. If nil, an http.Client created by NewClient
+// without a timeout and with keep-alives enabled is used.
+func WithDownloadClient(client *gohttp.Client) func(*DownloadOption) {
+	return func(o *DownloadOption) {
+		o.client = client
+	}
+}
+
+// WithDownloadConcurrency sets the number of ranges of a resource that are downloaded concurrently.
+func WithDownloadConcurrency(n int) func(*DownloadOption) {
+	return func(o *DownloadOption) {
+		o.concurrency = n
+	}
+}
+
+// WithDownloadMaxAttempts sets the maximum number of attempts for downloading a range, including the first.
+func WithDownloadMaxAttempts(n int) func(*DownloadOption) {
+	return func(o *DownloadOption) {
+		o.maxAttempts = n
+	}
+}
+
+// WithDownloadResume sets the number of leading bytes of the resource that have already been written by a previous
+// download, along with the validator returned by it as DownloadResult.Validator.
+func WithDownloadResume(offset int64, validator string) func(*DownloadOption) {
+	return func(o *DownloadOption) {
+		o.offset = offset
+		o.validator = validator
+	}
+}
+
+// DownloadResult describes the outcome of Download.
+type DownloadResult struct {
+	// Completed is the number of leading bytes of the resource that have been written. If the download failed, it can
+	// be resumed from Completed using WithDownloadResume.
+	Completed int64
+
+	// Ranged reports whether the resource was downloaded using range requests.
+	Ranged bool
+
+	// Resumed reports whether the download was resumed from the offset set using WithDownloadResume.
+	Resumed bool
+
+	// Size is the size of the resource, or -1 if unknown.
+	Size int64
+
+	// Validator is the strong entity tag or the last modification date of the resource, used for resuming the
+	// download.
+	Validator string
+}
+
+// Download downloads the resource identified by the URL, writing it to the io.WriterAt.
+//
+// The resource is first probed using a HEAD request. If the server accepts byte ranges and provides the size of the
+// resource, the resource is split into ranges that are downloaded concurrently, otherwise it is downloaded using a
+// single request. Range requests are conditional on the validator of the resource using the If-Range header, and fail
+// with ErrDownloadChanged if the resource changes while it is being downloaded. Failed ranges are retried, continuing
+// from the last byte written, up to the maximum number of attempts.
+//
+// A partial download is resumed using WithDownloadResume if the server accepts byte ranges and the validator of the
+// resource is unchanged, otherwise the resource is downloaded from the beginning.
+//
+// Once written, the resource is verified using the checksum set using WithDownloadChecksum, or the digest provided by
+// the server in the probe response, reading it back if the io.WriterAt also implements io.ReaderAt. If the io.WriterAt
+// implements Truncate, such as os.File, it is truncated to the size of the resource, including when the size is only
+// known once the resource has been downloaded.
+//
+// By default, ranges of DownloadChunkSize bytes are downloaded by DownloadConcurrency concurrent requests, and attempted
+// up to RetryMax+1 times using exponential backoff.
+func Download(ctx context.Context, url string, w io.WriterAt, options ...func(*DownloadOption)) (*DownloadResult, error) {
+	opts := &DownloadOption{
+		chunkSize:   DownloadChunkSize,
+		concurrency: DownloadConcurrency,
+		maxAttempts: RetryMax + 1,
+		newBackOff: func() backoff.BackOff {
+			b := backoff.NewExponentialBackOff()
+			b.MaxElapsedTime = 0
+			return b
+		},
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+	opts.chunkSize = max(1, opts.chunkSize)
+	opts.concurrency = max(1, opts.concurrency)
+	opts.maxAttempts = max(1, opts.maxAttempts)
+
+	if opts.client == nil {
+		opts.client = NewClient(
+			WithConnMaxIdlePerHost(opts.concurrency),
+			WithDisableKeepAlives(false),
+			WithTimeout(0))
+	}
+
+	if opts.newHash != nil {
+		if _, ok := w.(io.ReaderAt); !ok {
+			return nil, errors.New("download: verifying the checksum requires an io.ReaderAt")
+		}
+	}
+
+	d := &downloader{opts: opts, url: url, w: w}
+	result, err := d.download(ctx)
+	if err != nil {
+		return result, fmt.Errorf("download: %w", err)
+	}
+	return result, nil
+}
+
+// downloader downloads a single resource.
+type downloader struct {
+	algorithm string
+	checksum  []byte
+	opts      *DownloadOption
+	ranged    bool
+	size      int64
+	url       string
+	validator string
+	w         io.WriterAt
+}
+
+// download probes the resource, then downloads its remaining ranges and verifies it.
+func (d *downloader) download(ctx context.Context) (*DownloadResult, error) {
+	if err := d.probe(ctx); err != nil {
+		return nil, err
+	}
+
+	offset := d.offset()
+	result := &DownloadResult{
+		Completed: offset,
+		Ranged:    d.ranged,
+		Resumed:   offset > 0,
+		Size:      d.size,
+		Validator: d.validator,
+	}
+
+	if d.size >= 0 {
+		if err := d.truncate(d.size); err != nil {
+			return result, err
+		}
+	}
+
+	log.Trace("[http:download] downloading resource",
+		log.String("url", d.url),
+		log.Int64("size", d.size),
+		log.Int64("offset", offset),
+		log.Bool("ranged", d.ranged))
+
+	chunks := d.chunks(offset)
+	err := d.fetchAll(ctx, chunks)
+	result.Completed = completed(offset, chunks)
+	if err != nil {
+		return result, err
+	}
+
+	if d.size < 0 {
+		result.Size = result.Completed
+		if err := d.truncate(result.Size); err != nil {
+			return result, err
+		}
+	}
+	return result, d.verify(result.Completed)
+}
+
+// probe requests the headers of the resource, determining its size, validator and whether it can be downloaded using
+// range requests.
+func (d *downloader) probe(ctx context.Context) error {
+	d.size = -1
+
+	var resp *gohttp.Response
+	err := d.retry(ctx, func() (bool, error) {
+		req, err := d.newRequest(ctx, gohttp.MethodHead)
+		if err != nil {
+			return false, err
+		}
+
+		resp, err = d.opts.client.Do(req)
+		if err != nil {
+			retry, _ := Retry(resp, err)
+			return retry, err
+		}
+		drain(resp.Body)
+
+		if retry, err := Retry(resp, nil); retry {
+			if err == nil {
+				err = fmt.Errorf("unexpected HTTP status %s", resp.Status)
+			}
+			return true, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// servers not supporting HEAD are downloaded using a single request, which reports any other failure
+	if resp.StatusCode != gohttp.StatusOK {
+		return nil
+	}
+
+	if d.opts.newHash != nil {
+		d.checksum = d.opts.checksum
+	} else if alg, sum, ok := expectedDigest(resp.Header, resp.StatusCode); ok {
+		if _, ok := d.w.(io.ReaderAt); ok {
+			d.algorithm = alg
+			d.checksum = sum
+		}
+	}
+
+	d.size = resp.ContentLength
+	d.validator = validator(resp.Header)
+	d.ranged = d.size > 0 && acceptsRanges(resp.Header)
+	return nil
+}
+
+// offset returns the offset the download is resumed from, which is zero unless the resource is downloaded using range
+// requests and its validator matches the validator of the previous download.
+func (d *downloader) offset() int64 {
+	o := d.opts
+	if !d.ranged || o.offset <= 0 || o.offset > d.size || o.validator == "" || o.validator != d.validator {
+		return 0
+	}
+	return o.offset
+}
+
+// chunks splits the remaining bytes of the resource into ranges.
+func (d *downloader) chunks(offset int64) []*downloadChunk {
+	if !d.ranged {
+		return []*downloadChunk{{start: 0, end: d.size - 1}}
+	}
+
+	var chunks []*downloadChunk
+	for start := offset; start < d.size; start += d.opts.chunkSize {
+		chunks = append(chunks, &downloadChunk{start: start, end: min(start+d.opts.chunkSize, d.size) - 1})
+	}
+	return chunks
+}
+
+// fetchAll downloads the ranges concurrently, returning the first error. The remaining ranges are canceled after a
+// range fails.
+func (d *downloader) fetchAll(ctx context.Context, chunks []*downloadChunk) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	queue := make(chan *downloadChunk, len(chunks))
+	for _, c := range chunks {
+		queue <- c
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	for range min(d.opts.concurrency, len(chunks)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if err := d.fetch(ctx, c); err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fetch downloads a range, retrying it from the last byte written until it succeeds, or is no longer retryable.
+func (d *downloader) fetch(ctx context.Context, c *downloadChunk) error {
+	return d.retry(ctx, func() (bool, error) {
+		return d.fetchOnce(ctx, c)
+	})
+}
+
+// fetchOnce sends a single request for the remaining bytes of a range and writes the response body, returning whether
+// the request can be retried if it fails.
+func (d *downloader) fetchOnce(ctx context.Context, c *downloadChunk) (bool, error) {
+	req, err := d.newRequest(ctx, gohttp.MethodGet)
+	if err != nil {
+		return false, err
+	}
+
+	// bytes written by a failed attempt can only be kept if the remainder can be requested, and are truncated if the
+	// size of the resource is unknown, since the resource may be shorter once it is downloaded again
+	if !d.ranged && c.written.Swap(0) > 0 && d.size < 0 {
+		if err := d.truncate(0); err != nil {
+			return false, err
+		}
+	}
+
+	start := c.start + c.written.Load()
+	if d.ranged {
+		req.Header.Set(HeaderRange, fmt.Sprintf("bytes=%d-%d", start, c.end))
+		if d.validator != "" {
+			req.Header.Set(HeaderIfRange, d.validator)
+		}
+	}
+
+	resp, err := d.opts.client.Do(req)
+	if err != nil {
+		retry, _ := Retry(resp, err)
+		return retry, err
+	}
+	defer drain(resp.Body)
+
+	switch {
+	case d.ranged && resp.StatusCode == gohttp.StatusPartialContent:
+		if s, ok := contentRangeStart(resp.Header.Get(HeaderContentRange)); !ok || s != start {
+			return false, fmt.Errorf("unexpected content range %q for range starting at %d",
+				resp.Header.Get(HeaderContentRange), start)
+		}
+	case d.ranged && resp.StatusCode == gohttp.StatusOK:
+		if d.validator == "" {
+			return false, fmt.Errorf("range request ignored: %s", d.url)
+		}
+		return false, fmt.Errorf("%w: %s", ErrDownloadChanged, d.url)
+	case !d.ranged && resp.StatusCode == gohttp.StatusOK:
+	default:
+		retry, err := Retry(resp, nil)
+		if err == nil {
+			err = fmt.Errorf("unexpected HTTP status %s", resp.Status)
+		}
+		return retry, err
+	}
+
+	// only failures to read the response are retried, since failures to write, e.g. when the disk is full, are not
+	// resolved by sending the request again
+	cw := &chunkWriter{chunk: c, w: d.w}
+	if c.end < 0 {
+		_, err = io.Copy(cw, resp.Body)
+		return err != nil && cw.err == nil && ctx.Err() == nil, err
+	}
+
+	if _, err = io.CopyN(cw, resp.Body, c.end+1-start); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return cw.err == nil && ctx.Err() == nil, err
+	}
+	return false, nil
+}
+
+// retry calls the function until it succeeds, returns an error that cannot be retried, or the maximum number of
+// attempts has been reached.
+func (d *downloader) retry(ctx context.Context, fn func() (bool, error)) error {
+	b := d.opts.newBackOff()
+	b.Reset()
+
+	for attempt := 1; ; attempt++ {
+		retry, err := fn()
+		if err == nil || !retry || attempt >= d.opts.maxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := b.NextBackOff()
+		if delay == backoff.Stop {
+			return err
+		}
+
+		log.Trace("[http:download] retrying request",
+			log.String("url", d.url),
+			log.Int("attempt", attempt),
+			log.String("delay", delay.String()),
+			log.Err(err))
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return context.Cause(ctx)
+		case <-t.C:
+		}
+	}
+}
+
+// truncate truncates the io.WriterAt to the size, if it implements Truncate.
+func (d *downloader) truncate(size int64) error {
+	if t, ok := d.w.(interface{ Truncate(int64) error }); ok {
+		return t.Truncate(size)
+	}
+	return nil
+}
+
+// verify compares the checksum of the first n bytes written with the expected checksum, if any.
+func (d *downloader) verify(n int64) error {
+	if d.checksum == nil {
+		return nil
+	}
+
+	h := newDigestHash(d.algorithm)
+	if d.opts.newHash != nil {
+		h = d.opts.newHash()
+	}
+
+	if _, err := io.Copy(h, io.NewSectionReader(d.w.(io.ReaderAt), 0, n)); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(h.Sum(nil), d.checksum) {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, d.url)
+	}
+	return nil
+}
+
+// newRequest returns a new request for the resource. Content codings are refused, so that ranges and the size of the
+// resource refer to the bytes written.
+func (d *downloader) newRequest(ctx context.Context, method string) (*gohttp.Request, error) {
+	req, err := gohttp.NewRequestWithContext(ctx, method, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(HeaderAcceptEncoding, "identity")
+	return req, nil
+}
+
+// downloadChunk is an inclusive byte range of a resource, with the number of bytes written so far. The end is -1 if
+// the size of the resource is unknown.
+type downloadChunk struct {
+	end     int64
+	start   int64
+	written atomic.Int64
+}
+
+// chunkWriter writes to the io.WriterAt at the offset following the bytes written for a range, recording the error
+// returned by the io.WriterAt, if any.
+type chunkWriter struct {
+	chunk *downloadChunk
+	err   error
+	w     io.WriterAt
+}
+
+// Write implements io.Writer.
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.WriteAt(p, cw.chunk.start+cw.chunk.written.Load())
+	cw.chunk.written.Add(int64(n))
+	if err != nil {
+		cw.err = err
+	}
+	return n, err
+}
+
+// completed returns the number of leading bytes of the resource that have been written, starting at the offset.
+func completed(offset int64, chunks []*downloadChunk) int64 {
+	n := offset
+	for _, c := range chunks {
+		w := c.written.Load()
+		n += w
+		if c.end < 0 || w < c.end+1-c.start {
+			break
+		}
+	}
+	return n
+}
+
+// acceptsRanges returns whether the Accept-Ranges header indicates that byte ranges are supported.
+func acceptsRanges(h gohttp.Header) bool {
+	for _, v := range h.Values(HeaderAcceptRanges) {
+		for _, unit := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validator returns the strong entity tag of a resource, or its last modification date, for use with the If-Range
+// header. Weak entity tags cannot be used for range requests.
+func validator(h gohttp.Header) string {
+	if etag := strings.TrimSpace(h.Get(HeaderETag)); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	if lm := h.Get(HeaderLastModified); lm != "" {
+		if _, err := gohttp.ParseTime(lm); err == nil {
+			return lm
+		}
+	}
+	return ""
+}
+
+// contentRangeStart returns the first byte position of a Content-Range header value, e.g. "bytes 0-499/1234".
+func contentRangeStart(v string) (int64, bool) {
+	v, ok := strings.CutPrefix(strings.TrimSpace(v), "bytes ")
+	if !ok {
+		return 0, false
+	}
+
+	first, _, ok := strings.Cut(v, "-")
+	if !ok {
+		return 0, false
+	}
+
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	return start, err == nil
+}
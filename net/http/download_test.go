@@ -0,0 +1,176 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 640)
+	sum := sha256.Sum256(content)
+	modTime := time.Now()
+
+	var (
+		aborted  sync.Map
+		minStart atomic.Int64
+		ranges   atomic.Int64
+		streams  atomic.Int64
+	)
+	mux := gohttp.NewServeMux()
+	mux.HandleFunc("/ranged", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if v := r.Header.Get(HeaderRange); v != "" {
+			ranges.Add(1)
+			if s, ok := contentRangeStart(strings.Replace(v, "=", " ", 1)); ok && s < minStart.Load() {
+				minStart.Store(s)
+			}
+
+			// the first request for each range fails after sending part of the body
+			if _, loaded := aborted.LoadOrStore(v, true); !loaded {
+				w.Header().Set(HeaderContentLength, "1024")
+				w.WriteHeader(gohttp.StatusPartialContent)
+				_, _ = w.Write(content[:100])
+				panic(gohttp.ErrAbortHandler)
+			}
+		}
+		w.Header().Set(HeaderETag, `"v1"`)
+		gohttp.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+	})
+	mux.HandleFunc("/changing", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set(HeaderETag, `"`+r.Method+`"`)
+		gohttp.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+	})
+	mux.HandleFunc("/plain", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set(HeaderReprDigest, formatDigest(DigestSHA256, sum[:]))
+		if r.URL.Query().Has("tampered") {
+			_, _ = w.Write(content[1:])
+			return
+		}
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("/streamed", func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		// the size is unknown, and the first response fails after sending more than the complete resource
+		_ = gohttp.NewResponseController(w).Flush()
+		if r.Method != gohttp.MethodGet {
+			return
+		}
+
+		if streams.Add(1) == 1 {
+			_, _ = w.Write(content)
+			panic(gohttp.ErrAbortHandler)
+		}
+		_, _ = w.Write(content[:100])
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	download := func(path string, partial []byte, options ...func(*DownloadOption)) (*DownloadResult, []byte, error) {
+		f, err := os.Create(filepath.Join(t.TempDir(), "download"))
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = f.Write(partial)
+		require.NoError(t, err)
+
+		options = append([]func(*DownloadOption){
+			WithDownloadBackOff(func() backoff.BackOff { return &backoff.ZeroBackOff{} }),
+			WithDownloadChunkSize(1024),
+			WithDownloadClient(srv.Client()),
+		}, options...)
+
+		result, err := Download(context.Background(), srv.URL+path, f, options...)
+		b, rerr := os.ReadFile(f.Name())
+		require.NoError(t, rerr)
+		return result, b, err
+	}
+
+	// the resource is downloaded using concurrent range requests, retrying each range from the last byte written
+	result, b, err := download("/ranged", nil, WithDownloadChecksum(sha256.New, sum[:]))
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.True(t, result.Ranged)
+	assert.False(t, result.Resumed)
+	assert.Equal(t, int64(len(content)), result.Size)
+	assert.Equal(t, int64(len(content)), result.Completed)
+	assert.Equal(t, `"v1"`, result.Validator)
+	assert.Equal(t, int64(20), ranges.Load())
+
+	_, _, err = download("/ranged", nil, WithDownloadChecksum(sha256.New, make([]byte, sha256.Size)))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// partial downloads are resumed if the validator is unchanged
+	minStart.Store(int64(len(content)))
+	result, b, err = download("/ranged", content[:3000], WithDownloadResume(3000, `"v1"`))
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.True(t, result.Resumed)
+	assert.Equal(t, int64(3000), minStart.Load())
+
+	minStart.Store(int64(len(content)))
+	result, b, err = download("/ranged", bytes.Repeat([]byte("x"), 3000), WithDownloadResume(3000, `"v0"`))
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.False(t, result.Resumed)
+	assert.Equal(t, int64(0), minStart.Load())
+
+	// resources changing during the download are detected using If-Range
+	_, _, err = download("/changing", nil)
+	assert.ErrorIs(t, err, ErrDownloadChanged)
+
+	// resources are downloaded using a single request if ranges are not supported, and verified using their digest
+	result, b, err = download("/plain", nil)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.False(t, result.Ranged)
+
+	_, _, err = download("/plain?tampered", nil)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// bytes written by a failed attempt are truncated when the download is restarted
+	result, b, err = download("/streamed", bytes.Repeat([]byte("x"), 20000))
+	require.NoError(t, err)
+	assert.Equal(t, content[:100], b)
+	assert.Equal(t, int64(100), result.Size)
+	assert.Equal(t, int64(2), streams.Load())
+
+	// failures to write are not retried
+	streams.Store(1)
+	w := &writerAt{err: errors.New("no space left on device")}
+	_, err = Download(context.Background(), srv.URL+"/streamed", w, WithDownloadClient(srv.Client()),
+		WithDownloadBackOff(func() backoff.BackOff { return &backoff.ZeroBackOff{} }))
+	assert.ErrorIs(t, err, w.err)
+	assert.Equal(t, int64(2), streams.Load())
+
+	// verifying a checksum requires reading back the resource
+	_, err = Download(context.Background(), srv.URL+"/plain", &writerAt{}, WithDownloadChecksum(sha256.New, sum[:]))
+	assert.Error(t, err)
+}
+
+// writerAt is an io.WriterAt that does not implement io.ReaderAt, failing with the error, if any.
+type writerAt struct {
+	err error
+}
+
+func (w writerAt) WriteAt(p []byte, _ int64) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,367 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/anchor/net"
+	"github.com/transientvariable/anchor/net/http/proxy"
+	"github.com/transientvariable/log-go"
+
+	gonet "net"
+	gohttp "net/http"
+)
+
+// Enumeration of supported egress proxy URL schemes.
+const (
+	EgressSchemeHTTP    = "http"
+	EgressSchemeHTTPS   = "https"
+	EgressSchemeSOCKS5  = "socks5"
+	EgressSchemeSOCKS5H = "socks5h"
+)
+
+// EgressOption is a container for optional properties that can be used for initializing an EgressPool.
+type EgressOption struct {
+	dialer      *gonet.Dialer
+	poolOptions []func(*proxy.PoolOption)
+	tlsConfig   *tls.Config
+}
+
+// WithEgressDialer sets the net.Dialer used for connecting to egress proxies, and for resolving destination hosts for
+// socks5 proxies.
+func WithEgressDialer(dialer *gonet.Dialer) func(*EgressOption) {
+	return func(o *EgressOption) {
+		o.dialer = dialer
+	}
+}
+
+// WithEgressPoolOptions appends to the options used for creating the proxy.Pool of egress proxies, e.g. for setting the
+// proxy.Selector or the failure threshold.
+func WithEgressPoolOptions(options ...func(*proxy.PoolOption)) func(*EgressOption) {
+	return func(o *EgressOption) {
+		o.poolOptions = append(o.poolOptions, options...)
+	}
+}
+
+// WithEgressTLSConfig sets the tls.Config used for connecting to HTTPS egress proxies.
+func WithEgressTLSConfig(config *tls.Config) func(*EgressOption) {
+	return func(o *EgressOption) {
+		o.tlsConfig = config
+	}
+}
+
+// EgressPool routes outbound connections through a pool of forward proxies, selecting a proxy for each connection
+// using the proxy.Selector of the underlying proxy.Pool.
+//
+// HTTP and HTTPS proxies are used by tunneling connections with the CONNECT method, and SOCKS5 proxies using the SOCKS5
+// CONNECT command. Destination hosts are resolved locally for socks5 proxies, and by the proxy for socks5h proxies. The
+// user information of the proxy URL, if any, is used for authenticating with the proxy.
+//
+// Proxies that cannot be connected to are marked failed with the pool, and are marked healthy once connected to again.
+// Proxies rejecting a tunnel with a status other than http.StatusProxyAuthRequired are not marked failed, as the
+// rejection is usually caused by the destination.
+type EgressPool struct {
+	dialer    *gonet.Dialer
+	mutex     sync.Mutex
+	pool      *proxy.Pool
+	socks     map[*proxy.Host]func(context.Context, string, string) (gonet.Conn, error)
+	tlsConfig *tls.Config
+}
+
+// NewEgressPool creates a new EgressPool from the provided proxy URLs and options.
+func NewEgressPool(proxyURLs []string, options ...func(*EgressOption)) (*EgressPool, error) {
+	opts := &EgressOption{}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	var hosts []*proxy.Host
+	for _, u := range proxyURLs {
+		h, err := proxy.NewHost(u)
+		if err != nil {
+			return nil, fmt.Errorf("egress: %w", err)
+		}
+
+		t, err := h.Target()
+		if err != nil {
+			return nil, fmt.Errorf("egress: %w", err)
+		}
+
+		switch t.Scheme {
+		case EgressSchemeHTTP, EgressSchemeHTTPS, EgressSchemeSOCKS5, EgressSchemeSOCKS5H:
+		default:
+			return nil, fmt.Errorf("egress: unsupported proxy scheme %q", t.Scheme)
+		}
+
+		if t.Host == "" {
+			return nil, fmt.Errorf("egress: proxy address is required: %s", t.Redacted())
+		}
+		hosts = append(hosts, h)
+	}
+
+	pool, err := proxy.NewPool(hosts, opts.poolOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("egress: %w", err)
+	}
+
+	e := &EgressPool{
+		dialer:    opts.dialer,
+		pool:      pool,
+		socks:     make(map[*proxy.Host]func(context.Context, string, string) (gonet.Conn, error)),
+		tlsConfig: opts.tlsConfig,
+	}
+
+	if e.dialer == nil {
+		e.dialer = &gonet.Dialer{
+			KeepAlive: DialKeepAlive,
+			Timeout:   DialTimeout,
+		}
+	}
+	return e, nil
+}
+
+// NewEgressTransport returns a new http.Transport created by NewTransport with the provided options that connects
+// through the EgressPool instead of the proxies from the environment.
+func NewEgressTransport(egress *EgressPool, options ...func(*Option)) *gohttp.Transport {
+	return NewTransport(append(options, WithDialContext(egress.DialContext), WithProxy(nil))...)
+}
+
+// Close stops active health checking of the egress proxies, if enabled.
+func (e *EgressPool) Close() error {
+	return e.pool.Close()
+}
+
+// DialContext connects to the address on the named network through an egress proxy selected from the pool. Only TCP
+// networks are supported.
+func (e *EgressPool) DialContext(ctx context.Context, network string, addr string) (gonet.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("egress: unsupported network %q", network)
+	}
+
+	h, err := e.pool.Select()
+	if err != nil {
+		return nil, fmt.Errorf("egress: %w", err)
+	}
+
+	t, err := h.Target()
+	if err != nil {
+		return nil, fmt.Errorf("egress: %w", err)
+	}
+
+	// failing to resolve the host does not mark the proxy failed, as it is not caused by the proxy
+	dialAddr := addr
+	if t.Scheme == EgressSchemeSOCKS5 {
+		if dialAddr, err = e.resolve(ctx, network, addr); err != nil {
+			return nil, fmt.Errorf("egress: %w", err)
+		}
+	}
+
+	var conn gonet.Conn
+	switch t.Scheme {
+	case EgressSchemeSOCKS5, EgressSchemeSOCKS5H:
+		conn, err = e.dialSOCKS5(ctx, h, t, network, dialAddr)
+	default:
+		conn, err = e.dialConnect(ctx, t, addr)
+	}
+
+	if err != nil {
+		var rejected *egressRejectedError
+		if ctx.Err() == nil && (!errors.As(err, &rejected) || rejected.status == gohttp.StatusProxyAuthRequired) {
+			log.Debug("[http:egress] proxy failed",
+				log.String("proxy", t.Redacted()),
+				log.String("addr", addr),
+				log.Err(err))
+			e.pool.MarkFailed(h)
+		}
+		return nil, fmt.Errorf("egress: proxy %s: %w", t.Redacted(), err)
+	}
+	e.pool.MarkHealthy(h)
+	return conn, nil
+}
+
+// Pool returns the proxy.Pool of egress proxies.
+func (e *EgressPool) Pool() *proxy.Pool {
+	return e.pool
+}
+
+// String returns a string representation of the EgressPool.
+func (e *EgressPool) String() string {
+	var proxies []string
+	for _, h := range e.pool.Hosts() {
+		if t, err := h.Target(); err == nil {
+			proxies = append(proxies, t.Redacted())
+		}
+	}
+	return string(anchor.ToJSON(map[string]any{
+		"active":  len(e.pool.Active()),
+		"proxies": proxies,
+	}))
+}
+
+// dialConnect connects to the address through an HTTP or HTTPS proxy using the CONNECT method.
+func (e *EgressPool) dialConnect(ctx context.Context, target *url.URL, addr string) (gonet.Conn, error) {
+	proxyAddr := target.Host
+	if target.Port() == "" {
+		port := "80"
+		if target.Scheme == EgressSchemeHTTPS {
+			port = "443"
+		}
+		proxyAddr = gonet.JoinHostPort(target.Hostname(), port)
+	}
+
+	conn, err := e.dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the deadline of the context applies to the handshake with the proxy, and the connection is interrupted if the
+	// context is cancelled before the tunnel is established
+	if d, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(d)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	conn, err = e.connect(ctx, conn, target, addr)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connect establishes a tunnel to the address over the connection to an HTTP or HTTPS proxy.
+func (e *EgressPool) connect(ctx context.Context, conn gonet.Conn, target *url.URL, addr string) (gonet.Conn, error) {
+	if target.Scheme == EgressSchemeHTTPS {
+		cfg := &tls.Config{}
+		if e.tlsConfig != nil {
+			cfg = e.tlsConfig.Clone()
+		}
+
+		if cfg.ServerName == "" {
+			cfg.ServerName = target.Hostname()
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return conn, err
+		}
+		conn = tlsConn
+	}
+
+	req := &gohttp.Request{
+		Method: gohttp.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(gohttp.Header),
+	}
+
+	if u := target.User; u != nil {
+		password, _ := u.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set(HeaderProxyAuthorization, "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := gohttp.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return conn, &egressRejectedError{addr: addr, status: resp.StatusCode}
+	}
+
+	if br.Buffered() > 0 {
+		return conn, errors.New("unexpected data received from proxy before tunnel data")
+	}
+	return conn, nil
+}
+
+// dialSOCKS5 connects to the address through a SOCKS5 proxy, reusing the dialer created for the Host.
+func (e *EgressPool) dialSOCKS5(ctx context.Context, h *proxy.Host, target *url.URL, network string, addr string) (gonet.Conn, error) {
+	e.mutex.Lock()
+	dial, ok := e.socks[h]
+	if !ok {
+		var err error
+		if dial, err = net.NewSOCKS5DialContextWithDialer(target, e.dialer); err != nil {
+			e.mutex.Unlock()
+			return nil, err
+		}
+		e.socks[h] = dial
+	}
+	e.mutex.Unlock()
+	return dial(ctx, network, addr)
+}
+
+// resolve returns the address with its host resolved to an IP address for the named network using the resolver of
+// the net.Dialer, using the first address returned.
+func (e *EgressPool) resolve(ctx context.Context, network string, addr string) (string, error) {
+	host, port, err := gonet.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		return addr, nil
+	}
+
+	resolver := e.dialer.Resolver
+	if resolver == nil {
+		resolver = gonet.DefaultResolver
+	}
+
+	ipNetwork := "ip"
+	switch network {
+	case "tcp4":
+		ipNetwork = "ip4"
+	case "tcp6":
+		ipNetwork = "ip6"
+	}
+
+	ips, err := resolver.LookupNetIP(ctx, ipNetwork, host)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ips) == 0 {
+		return "", &gonet.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return gonet.JoinHostPort(ips[0].Unmap().String(), port), nil
+}
+
+// egressRejectedError is returned when an HTTP proxy responds to a CONNECT request with an unsuccessful status.
+type egressRejectedError struct {
+	addr   string
+	status int
+}
+
+func (e *egressRejectedError) Error() string {
+	return fmt.Sprintf("tunnel to %s rejected: %d %s", e.addr, e.status, gohttp.StatusText(e.status))
+}
@@ -0,0 +1,260 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/transientvariable/anchor/net/http/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gonet "net"
+	gohttp "net/http"
+)
+
+func TestEgressPool(t *testing.T) {
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	var tunnels atomic.Int64
+	connectProxy := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.Method != gohttp.MethodConnect {
+			w.WriteHeader(gohttp.StatusMethodNotAllowed)
+			return
+		}
+
+		credentials := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		if r.Header.Get(HeaderProxyAuthorization) != "Basic "+credentials {
+			w.WriteHeader(gohttp.StatusProxyAuthRequired)
+			return
+		}
+
+		if r.Host != upstream.Listener.Addr().String() {
+			w.WriteHeader(gohttp.StatusBadGateway)
+			return
+		}
+		tunnels.Add(1)
+		tunnel(t, w, r.Host)
+	}))
+	defer connectProxy.Close()
+
+	socksProxy := newSOCKS5Server(t)
+	defer socksProxy.Close()
+
+	// reserve an address that refuses connections
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	deadProxy := "http://" + l.Addr().String()
+	require.NoError(t, l.Close())
+
+	egress, err := NewEgressPool([]string{
+		deadProxy,
+		"http://user:secret@" + connectProxy.Listener.Addr().String(),
+		"socks5://" + socksProxy.Addr().String(),
+	}, WithEgressPoolOptions(proxy.WithPoolFailureThreshold(1)))
+	require.NoError(t, err)
+	defer egress.Close()
+
+	client := &gohttp.Client{Transport: NewEgressTransport(egress)}
+	get := func() (string, error) {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	// the dead proxy is selected first and marked failed
+	_, err = get()
+	assert.ErrorContains(t, err, "egress: proxy "+deadProxy)
+	assert.Len(t, egress.Pool().Active(), 2)
+
+	for range 4 {
+		body, err := get()
+		require.NoError(t, err)
+		assert.Equal(t, "ok", body)
+	}
+	assert.Equal(t, int64(2), tunnels.Load())
+	assert.Equal(t, int64(2), socksProxy.connects.Load())
+
+	// rejected tunnels do not mark the proxy failed
+	rejecting, err := NewEgressPool([]string{"http://" + connectProxy.Listener.Addr().String() + "/"},
+		WithEgressPoolOptions(proxy.WithPoolFailureThreshold(1)))
+	require.NoError(t, err)
+
+	_, err = rejecting.DialContext(t.Context(), "tcp", upstream.Listener.Addr().String())
+	assert.ErrorContains(t, err, "407 Proxy Authentication Required")
+	assert.Empty(t, rejecting.Pool().Active())
+
+	rejecting, err = NewEgressPool([]string{"http://user:secret@" + connectProxy.Listener.Addr().String()},
+		WithEgressPoolOptions(proxy.WithPoolFailureThreshold(1)))
+	require.NoError(t, err)
+
+	_, err = rejecting.DialContext(t.Context(), "tcp", "127.0.0.1:1")
+	assert.ErrorContains(t, err, "502 Bad Gateway")
+	assert.Len(t, rejecting.Pool().Active(), 1)
+
+	_, err = NewEgressPool([]string{"ftp://127.0.0.1:21"})
+	assert.ErrorContains(t, err, `unsupported proxy scheme "ftp"`)
+
+	_, err = NewEgressPool(nil)
+	assert.Error(t, err)
+}
+
+func TestEgressPoolSOCKS5(t *testing.T) {
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {}))
+	defer upstream.Close()
+
+	socksProxy := newSOCKS5Server(t)
+	defer socksProxy.Close()
+
+	var dials atomic.Int64
+	dialer := &gonet.Dialer{
+		Control: func(network string, address string, c syscall.RawConn) error {
+			dials.Add(1)
+			return nil
+		},
+	}
+
+	_, port, err := gonet.SplitHostPort(upstream.Listener.Addr().String())
+	require.NoError(t, err)
+	addr := gonet.JoinHostPort("localhost", port)
+
+	// the destination host is resolved locally for socks5, and by the proxy for socks5h
+	for scheme, host := range map[string]string{
+		EgressSchemeSOCKS5:  "127.0.0.1",
+		EgressSchemeSOCKS5H: "localhost",
+	} {
+		egress, err := NewEgressPool([]string{scheme + "://" + socksProxy.Addr().String()}, WithEgressDialer(dialer))
+		require.NoError(t, err)
+
+		conn, err := egress.DialContext(t.Context(), "tcp4", addr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+		assert.Equal(t, host, socksProxy.host.Load(), scheme)
+	}
+
+	// the proxy is connected to using the configured dialer
+	assert.Equal(t, int64(2), dials.Load())
+
+	// hosts that cannot be resolved do not mark the proxy failed
+	egress, err := NewEgressPool([]string{"socks5://" + socksProxy.Addr().String()},
+		WithEgressPoolOptions(proxy.WithPoolFailureThreshold(1)))
+	require.NoError(t, err)
+
+	_, err = egress.DialContext(t.Context(), "tcp", "invalid.test:80")
+	assert.Error(t, err)
+	assert.Len(t, egress.Pool().Active(), 1)
+}
+
+// tunnel hijacks the connection of the CONNECT request and copies data between it and the address.
+func tunnel(t *testing.T, w gohttp.ResponseWriter, addr string) {
+	dst, err := gonet.Dial("tcp", addr)
+	if err != nil {
+		w.WriteHeader(gohttp.StatusBadGateway)
+		return
+	}
+
+	src, _, err := gohttp.NewResponseController(w).Hijack()
+	if !assert.NoError(t, err) {
+		_ = dst.Close()
+		return
+	}
+	_, _ = io.WriteString(src, "HTTP/1.1 200 Connection established\r\n\r\n")
+	pipe(src, dst)
+}
+
+func pipe(a gonet.Conn, b gonet.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		_ = a.Close()
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		_ = b.Close()
+	}()
+}
+
+// socks5Server is a minimal SOCKS5 server supporting the CONNECT command without authentication.
+type socks5Server struct {
+	gonet.Listener
+	connects atomic.Int64
+	host     atomic.Value
+}
+
+func newSOCKS5Server(t *testing.T) *socks5Server {
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &socks5Server{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) serve(conn gonet.Conn) {
+	// greeting: version, number of methods, methods
+	b := make([]byte, 262)
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	if _, err := io.ReadFull(conn, b[:b[1]]); err != nil {
+		_ = conn.Close()
+		return
+	}
+	_, _ = conn.Write([]byte{5, 0})
+
+	// request: version, command, reserved, address type, address, port
+	if _, err := io.ReadFull(conn, b[:4]); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	var host string
+	switch b[3] {
+	case 1:
+		_, _ = io.ReadFull(conn, b[:4])
+		host = gonet.IP(b[:4]).String()
+	case 3:
+		_, _ = io.ReadFull(conn, b[:1])
+		n := int(b[0])
+		_, _ = io.ReadFull(conn, b[:n])
+		host = string(b[:n])
+	default:
+		_ = conn.Close()
+		return
+	}
+	_, _ = io.ReadFull(conn, b[:2])
+	port := binary.BigEndian.Uint16(b[:2])
+	s.host.Store(host)
+
+	dst, err := gonet.Dial("tcp", gonet.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		_ = conn.Close()
+		return
+	}
+	s.connects.Add(1)
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(conn, dst)
+}
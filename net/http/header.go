@@ -7,14 +7,19 @@ const (
 	HeaderAcceptDatetime     = "Accept-Datetime"
 	HeaderAcceptEncoding     = "Accept-Encoding"
 	HeaderAcceptLanguage     = "Accept-Language"
+	HeaderAcceptRanges       = "Accept-Ranges"
+	HeaderAge                = "Age"
 	HeaderAuthorization      = "Authorization"
 	HeaderCacheControl       = "Cache-Control"
+	HeaderCacheStatus        = "Cache-Status"
 	HeaderConnection         = "Connection"
+	HeaderContentDigest      = "Content-Digest"
 	HeaderContentDisposition = "Content-Disposition"
 	HeaderContentEncoding    = "Content-Encoding"
 	HeaderContentLanguage    = "Content-Language"
 	HeaderContentLength      = "Content-Length"
 	HeaderContentMD5         = "Content-MD5"
+	HeaderContentRange       = "Content-Range"
 	HeaderContentType        = "Content-Type"
 	HeaderCookie             = "Cookie"
 	HeaderDate               = "Date"
@@ -29,20 +34,29 @@ const (
 	HeaderIfMatch            = "If-Match"
 	HeaderIfModifiedSince    = "If-Modified-Since"
 	HeaderIfNoneMatch        = "If-None-Match"
+	HeaderIfRange            = "If-Range"
 	HeaderIfUnmodifiedSince  = "If-Unmodified-Since"
 	HeaderXIpfsCid           = "X-Ipfs-Cid"
 	HeaderXIpfsPath          = "X-Ipfs-path"
 	HeaderXIpfsRoots         = "X-Ipfs-Roots"
+	HeaderXRequestID         = "X-Request-Id"
 	HeaderLastModified       = "Last-Modified"
 	HeaderLocation           = "Location"
 	HeaderOrigin             = "Origin"
 	HeaderProxyAuthorization = "Proxy-Authorization"
 	HeaderRange              = "Range"
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
 	HeaderReferer            = "Referer"
+	HeaderReprDigest         = "Repr-Digest"
+	HeaderRetryAfter         = "Retry-After"
 	HeaderTransferEncoding   = "Transfer-Encoding"
 	HeaderUserAgent          = "User-Agent"
+	HeaderVary               = "Vary"
 	HeaderWantDigest         = "Want-Digest"
 	HeaderWantContentDigest  = "Want-Content-Digest"
+	HeaderWantReprDigest     = "Want-Repr-Digest"
 )
 
 // Headers returns a list of all supported HTTP headers.
@@ -53,14 +67,19 @@ func Headers() []string {
 		HeaderAcceptDatetime,
 		HeaderAcceptEncoding,
 		HeaderAcceptLanguage,
+		HeaderAcceptRanges,
+		HeaderAge,
 		HeaderAuthorization,
 		HeaderCacheControl,
+		HeaderCacheStatus,
 		HeaderConnection,
+		HeaderContentDigest,
 		HeaderContentDisposition,
 		HeaderContentEncoding,
 		HeaderContentLanguage,
 		HeaderContentLength,
 		HeaderContentMD5,
+		HeaderContentRange,
 		HeaderContentType,
 		HeaderCookie,
 		HeaderDate,
@@ -75,19 +94,28 @@ func Headers() []string {
 		HeaderIfMatch,
 		HeaderIfModifiedSince,
 		HeaderIfNoneMatch,
+		HeaderIfRange,
 		HeaderIfUnmodifiedSince,
 		HeaderXIpfsCid,
 		HeaderXIpfsPath,
 		HeaderXIpfsRoots,
+		HeaderXRequestID,
 		HeaderLastModified,
 		HeaderLocation,
 		HeaderOrigin,
 		HeaderProxyAuthorization,
 		HeaderRange,
+		HeaderRateLimitLimit,
+		HeaderRateLimitRemaining,
+		HeaderRateLimitReset,
 		HeaderReferer,
+		HeaderReprDigest,
+		HeaderRetryAfter,
 		HeaderTransferEncoding,
 		HeaderUserAgent,
+		HeaderVary,
 		HeaderWantDigest,
 		HeaderWantContentDigest,
+		HeaderWantReprDigest,
 	}
 }
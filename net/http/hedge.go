@@ -0,0 +1,352 @@
+package http
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+
+	gohttp "net/http"
+)
+
+const (
+	// HedgeDelay sets the default delay after which a request that has not received its response headers is hedged.
+	HedgeDelay = 100 * time.Millisecond
+
+	// HedgeRatio sets the default maximum ratio of hedged requests to requests.
+	HedgeRatio = 0.1
+
+	// HedgeWindowSize sets the number of most recent response latencies from which the hedge delay is derived when a
+	// percentile is set.
+	HedgeWindowSize = 128
+
+	// hedgeBudgetMax sets the maximum number of hedges that can be accumulated by the hedge budget.
+	hedgeBudgetMax = 10
+
+	// hedgeMinSamples sets the number of response latencies required before the hedge delay is derived from them.
+	hedgeMinSamples = 20
+)
+
+// HedgeOption is a container for optional properties that can be used for initializing a HedgePolicy.
+type HedgeOption struct {
+	delay      time.Duration
+	maxHedges  int
+	methods    []string
+	percentile float64
+	ratio      float64
+}
+
+// WithHedgeDelay sets the delay after which a request that has not received its response headers is hedged. If a
+// percentile is set, the delay is used until enough response latencies have been observed.
+func WithHedgeDelay(d time.Duration) func(*HedgeOption) {
+	return func(o *HedgeOption) {
+		o.delay = d
+	}
+}
+
+// WithHedgeMaxHedges sets the maximum number of duplicate requests sent for a request.
+func WithHedgeMaxHedges(n int) func(*HedgeOption) {
+	return func(o *HedgeOption) {
+		o.maxHedges = n
+	}
+}
+
+// WithHedgeMethods sets the request methods that are hedged. Requests with other methods are only hedged if they have
+// the Idempotency-Key header set.
+func WithHedgeMethods(methods ...string) func(*HedgeOption) {
+	return func(o *HedgeOption) {
+		o.methods = methods
+	}
+}
+
+// WithHedgePercentile sets the percentile, between 0 and 1, of the most recent response latencies used as the hedge
+// delay, e.g. 0.95 for hedging requests slower than 95% of requests. Zero means the fixed delay is always used.
+func WithHedgePercentile(p float64) func(*HedgeOption) {
+	return func(o *HedgeOption) {
+		o.percentile = p
+	}
+}
+
+// WithHedgeRatio sets the maximum ratio of hedged requests to requests, e.g. 0.1 for hedging at most one request in
+// ten, so that hedging does not amplify the load of a slow server.
+func WithHedgeRatio(ratio float64) func(*HedgeOption) {
+	return func(o *HedgeOption) {
+		o.ratio = ratio
+	}
+}
+
+// HedgePolicy defines when HTTP requests are hedged to reduce tail latency.
+//
+// A request that has not received its response headers within the hedge delay is sent again, up to the maximum number
+// of hedges, provided its method is idempotent, or it has the Idempotency-Key header set, and its body can be replayed
+// using http.Request.GetBody. The first successful response, i.e. without error and with a status code below 500, is
+// returned, and the other requests are canceled. If all requests fail, the last failure is returned.
+//
+// Hedges are limited by a budget, earning a fraction of a hedge for each request based on the hedge ratio, so that at
+// most that ratio of requests is hedged over time.
+//
+// A HedgePolicy is safe for concurrent use, and is used as a Middleware. It can be combined with a RetryPolicy, in which
+// case each attempt of a request is hedged.
+type HedgePolicy struct {
+	budget     float64
+	delay      time.Duration
+	latencies  []time.Duration
+	maxHedges  int
+	methods    []string
+	mutex      sync.Mutex
+	next       int
+	percentile float64
+	ratio      float64
+}
+
+// NewHedgePolicy creates a new HedgePolicy using the provided options.
+//
+// By default, requests using the GET, HEAD, OPTIONS, TRACE, PUT and DELETE methods are hedged once after HedgeDelay,
+// and at most HedgeRatio of requests are hedged.
+func NewHedgePolicy(options ...func(*HedgeOption)) *HedgePolicy {
+	opts := &HedgeOption{
+		delay:     HedgeDelay,
+		maxHedges: 1,
+		methods: []string{
+			MethodDelete,
+			MethodGet,
+			MethodHead,
+			MethodOptions,
+			MethodPut,
+			MethodTrace,
+		},
+		ratio: HedgeRatio,
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	return &HedgePolicy{
+		delay:      max(0, opts.delay),
+		latencies:  make([]time.Duration, 0, HedgeWindowSize),
+		maxHedges:  max(0, opts.maxHedges),
+		methods:    opts.methods,
+		percentile: min(max(0, opts.percentile), 1),
+		ratio:      max(0, opts.ratio),
+	}
+}
+
+// Delay returns the current hedge delay, derived from the most recent response latencies if a percentile is set.
+func (p *HedgePolicy) Delay() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.percentile == 0 || len(p.latencies) < hedgeMinSamples {
+		return p.delay
+	}
+
+	latencies := slices.Clone(p.latencies)
+	slices.Sort(latencies)
+	return latencies[min(len(latencies)-1, int(p.percentile*float64(len(latencies))))]
+}
+
+// Middleware returns a Middleware that hedges requests according to the HedgePolicy.
+func (p *HedgePolicy) Middleware() Middleware {
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			return p.do(req, next.RoundTrip)
+		})
+	}
+}
+
+// String returns a string representation of the HedgePolicy.
+func (p *HedgePolicy) String() string {
+	return string(anchor.ToJSON(p.toMap()))
+}
+
+// hedgeResult is the result of one of the requests sent for a hedged request.
+type hedgeResult struct {
+	cancel context.CancelFunc
+	err    error
+	index  int
+	resp   *gohttp.Response
+}
+
+// do sends the request using the provided function, hedging it if it has not received its response headers within the
+// hedge delay.
+func (p *HedgePolicy) do(req *gohttp.Request, send func(*gohttp.Request) (*gohttp.Response, error)) (*gohttp.Response, error) {
+	if !p.hedgeable(req) {
+		return p.observe(req, send)
+	}
+	p.earn()
+
+	ctx := req.Context()
+	results := make(chan hedgeResult, p.maxHedges+1)
+	cancels := make([]context.CancelFunc, 0, p.maxHedges+1)
+	start := func(r *gohttp.Request) {
+		rctx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			began := time.Now()
+			resp, err := send(r.WithContext(rctx))
+			if err == nil && resp.StatusCode < gohttp.StatusInternalServerError {
+				p.record(time.Since(began))
+			}
+			results <- hedgeResult{cancel: cancel, err: err, index: index, resp: resp}
+		}()
+	}
+	start(req)
+
+	timer := time.NewTimer(p.Delay())
+	defer timer.Stop()
+
+	var (
+		last    hedgeResult
+		pending = 1
+	)
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) > p.maxHedges || !p.spend() {
+				continue
+			}
+
+			r := req.Clone(ctx)
+			if req.GetBody != nil && req.Body != nil && req.Body != gohttp.NoBody {
+				body, err := req.GetBody()
+				if err != nil {
+					continue
+				}
+				r.Body = body
+			}
+
+			log.Trace("[http:hedge] hedging request",
+				log.String("url", req.URL.Redacted()),
+				log.Int("hedge", len(cancels)))
+
+			start(r)
+			pending++
+			timer.Reset(p.Delay())
+		case res := <-results:
+			pending--
+			if res.err == nil && res.resp.StatusCode < gohttp.StatusInternalServerError {
+				// the context of the winning request is canceled once its body is closed
+				for i, cancel := range cancels {
+					if i != res.index {
+						cancel()
+					}
+				}
+
+				if last.resp != nil {
+					drain(last.resp.Body)
+				}
+				go discard(results, pending)
+				res.resp.Body = newCancelBody(res.resp.Body, res.cancel)
+				return res.resp, nil
+			}
+
+			if last.resp != nil {
+				drain(last.resp.Body)
+			}
+
+			if last.cancel != nil {
+				last.cancel()
+			}
+			last = res
+
+			if pending == 0 {
+				if last.err != nil {
+					last.cancel()
+					return nil, last.err
+				}
+				last.resp.Body = newCancelBody(last.resp.Body, last.cancel)
+				return last.resp, nil
+			}
+		}
+	}
+}
+
+// observe sends a request that is not hedged, recording its response latency.
+func (p *HedgePolicy) observe(req *gohttp.Request, send func(*gohttp.Request) (*gohttp.Response, error)) (*gohttp.Response, error) {
+	began := time.Now()
+	resp, err := send(req)
+	if err == nil && resp.StatusCode < gohttp.StatusInternalServerError {
+		p.record(time.Since(began))
+	}
+	return resp, err
+}
+
+// earn adds the fraction of a hedge earned by a hedgeable request to the budget.
+func (p *HedgePolicy) earn() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.budget = min(p.budget+p.ratio, hedgeBudgetMax)
+}
+
+// hedgeable returns whether the request can be hedged based on its method and body.
+func (p *HedgePolicy) hedgeable(req *gohttp.Request) bool {
+	if p.maxHedges < 1 || p.ratio <= 0 {
+		return false
+	}
+
+	if !slices.Contains(p.methods, req.Method) && req.Header.Get(HeaderIdempotencyKey) == "" {
+		return false
+	}
+	return req.Body == nil || req.Body == gohttp.NoBody || req.GetBody != nil
+}
+
+// record adds the response latency to the window of most recent latencies.
+func (p *HedgePolicy) record(latency time.Duration) {
+	if p.percentile == 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.latencies) < HedgeWindowSize {
+		p.latencies = append(p.latencies, latency)
+		return
+	}
+	p.latencies[p.next] = latency
+	p.next = (p.next + 1) % HedgeWindowSize
+}
+
+// spend removes a hedge from the budget, returning whether the budget allowed it.
+func (p *HedgePolicy) spend() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.budget < 1 {
+		return false
+	}
+	p.budget--
+	return true
+}
+
+// toMap returns a map representing the HedgePolicy attributes.
+func (p *HedgePolicy) toMap() map[string]any {
+	return map[string]any{
+		"delay":      p.Delay().String(),
+		"max_hedges": p.maxHedges,
+		"methods":    p.methods,
+		"percentile": p.percentile,
+		"ratio":      p.ratio,
+	}
+}
+
+// NewHedgeTransport creates a new http.RoundTripper that hedges requests sent using the provided http.RoundTripper
+// according to the HedgePolicy. If the HedgePolicy is nil, one with default values is used.
+func NewHedgeTransport(transport gohttp.RoundTripper, policy *HedgePolicy) gohttp.RoundTripper {
+	if policy == nil {
+		policy = NewHedgePolicy()
+	}
+	return Chain(transport, policy.Middleware())
+}
+
+// discard closes the responses of the remaining requests of a hedged request once received.
+func discard(results <-chan hedgeResult, pending int) {
+	for range pending {
+		res := <-results
+		if res.resp != nil {
+			_ = res.resp.Body.Close()
+		}
+		res.cancel()
+	}
+}
@@ -0,0 +1,104 @@
+package http
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestHedgePolicy(t *testing.T) {
+	const slow = 500 * time.Millisecond
+
+	var (
+		canceled atomic.Int64
+		requests atomic.Int64
+	)
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		n := requests.Add(1)
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(gohttp.StatusServiceUnavailable)
+			return
+		case "/fast":
+		default:
+			// only the first request is slow
+			if n%2 == 1 {
+				select {
+				case <-r.Context().Done():
+					canceled.Add(1)
+				case <-time.After(slow):
+				}
+				return
+			}
+		}
+		_, _ = io.WriteString(w, r.Method+" "+strconv.FormatInt(n, 10))
+	}))
+	defer srv.Close()
+
+	get := func(rt gohttp.RoundTripper, method string, path string, header gohttp.Header) (*gohttp.Response, string) {
+		req := mustRequest(t, method, srv.URL+path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(b)
+	}
+
+	policy := NewHedgePolicy(WithHedgeDelay(20*time.Millisecond), WithHedgeRatio(1))
+	rt := NewHedgeTransport(srv.Client().Transport, policy)
+
+	// the hedge wins, and the slow request is canceled
+	start := time.Now()
+	_, body := get(rt, gohttp.MethodGet, "/", nil)
+	assert.Equal(t, "GET 2", body)
+	assert.Less(t, time.Since(start), slow)
+	assert.Eventually(t, func() bool { return canceled.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// requests with other methods are only hedged with the Idempotency-Key header set
+	requests.Store(0)
+	_, body = get(rt, gohttp.MethodPost, "/", gohttp.Header{HeaderIdempotencyKey: {"k"}})
+	assert.Equal(t, "POST 2", body)
+
+	requests.Store(0)
+	start = time.Now()
+	resp, _ := get(rt, gohttp.MethodPost, "/", nil)
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+	assert.GreaterOrEqual(t, time.Since(start), slow)
+	assert.Equal(t, int64(1), requests.Load())
+
+	// the last failure is returned if all requests fail
+	requests.Store(0)
+	resp, _ = get(NewHedgeTransport(srv.Client().Transport, NewHedgePolicy(WithHedgeDelay(0), WithHedgeRatio(1))),
+		gohttp.MethodGet, "/unavailable", nil)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, resp.StatusCode)
+
+	// requests are not hedged until enough of the budget has been earned
+	requests.Store(0)
+	rt = NewHedgeTransport(srv.Client().Transport, NewHedgePolicy(WithHedgeDelay(20*time.Millisecond), WithHedgeRatio(0.5)))
+	start = time.Now()
+	get(rt, gohttp.MethodGet, "/", nil)
+	assert.GreaterOrEqual(t, time.Since(start), slow)
+	assert.Equal(t, int64(1), requests.Load())
+
+	// the delay is derived from the observed latencies once enough have been recorded
+	policy = NewHedgePolicy(WithHedgeDelay(time.Minute), WithHedgePercentile(0.9))
+	rt = NewHedgeTransport(srv.Client().Transport, policy)
+	for range hedgeMinSamples {
+		get(rt, gohttp.MethodGet, "/fast", nil)
+	}
+	assert.Less(t, policy.Delay(), time.Second)
+	assert.Contains(t, policy.String(), `"percentile":0.9`)
+}
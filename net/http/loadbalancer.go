@@ -0,0 +1,356 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/anchor/net/http/proxy"
+	"github.com/transientvariable/log-go"
+
+	gohttp "net/http"
+)
+
+// LoadBalancerOption is a container for optional properties that can be used for initializing a LoadBalancer.
+type LoadBalancerOption struct {
+	maxAttempts int
+	methods     []string
+	poolOptions []func(*proxy.PoolOption)
+	statusCodes []int
+	transport   gohttp.RoundTripper
+}
+
+// WithLoadBalancerMaxAttempts sets the maximum number of endpoints a request is attempted on, including the first.
+func WithLoadBalancerMaxAttempts(n int) func(*LoadBalancerOption) {
+	return func(o *LoadBalancerOption) {
+		o.maxAttempts = n
+	}
+}
+
+// WithLoadBalancerMethods sets the request methods that are retried on another endpoint after any failure. Requests
+// with other methods are only retried if they have the Idempotency-Key header set, or if the connection to the endpoint
+// could not be established.
+func WithLoadBalancerMethods(methods ...string) func(*LoadBalancerOption) {
+	return func(o *LoadBalancerOption) {
+		o.methods = methods
+	}
+}
+
+// WithLoadBalancerPoolOptions appends to the options used for creating the proxy.Pool of endpoints, e.g. for setting the
+// proxy.Selector, the failure threshold, or active health checking.
+func WithLoadBalancerPoolOptions(options ...func(*proxy.PoolOption)) func(*LoadBalancerOption) {
+	return func(o *LoadBalancerOption) {
+		o.poolOptions = append(o.poolOptions, options...)
+	}
+}
+
+// WithLoadBalancerStatusCodes sets the response status codes that are counted as failures of an endpoint.
+func WithLoadBalancerStatusCodes(codes ...int) func(*LoadBalancerOption) {
+	return func(o *LoadBalancerOption) {
+		o.statusCodes = codes
+	}
+}
+
+// WithLoadBalancerTransport sets the http.RoundTripper used by LoadBalancer.RoundTrip for sending requests. If nil, an
+// http.Transport created by DefaultTransport is used.
+func WithLoadBalancerTransport(transport gohttp.RoundTripper) func(*LoadBalancerOption) {
+	return func(o *LoadBalancerOption) {
+		o.transport = transport
+	}
+}
+
+// LoadBalancer balances outbound requests across the endpoints identified by a list of base URLs, selecting an endpoint
+// for each request using the proxy.Selector of the underlying proxy.Pool.
+//
+// The scheme and host of a request URL are replaced with those of the selected base URL, and its path and query are
+// appended to those of the base URL, so requests can be created using either relative or absolute URLs.
+//
+// Requests failing with an error, or a response with one of the failure status codes, are marked failed with the pool,
+// ejecting the endpoint once the failure threshold of the pool has been reached. A failed request is attempted on
+// another endpoint if its method is idempotent, or it has the Idempotency-Key header set, and its body can be replayed
+// using http.Request.GetBody. Requests with other methods are only attempted on another endpoint if the connection to
+// the endpoint could not be established, as the request was never sent.
+//
+// A LoadBalancer is safe for concurrent use, and can be used either as an http.RoundTripper, or as a Middleware.
+type LoadBalancer struct {
+	maxAttempts int
+	methods     []string
+	pool        *proxy.Pool
+	statusCodes []int
+	transport   gohttp.RoundTripper
+}
+
+// NewLoadBalancer creates a new LoadBalancer from the provided base URLs and options.
+//
+// By default, requests are attempted on up to as many endpoints as there are base URLs, and the status codes 502, 503
+// and 504 are counted as failures. Requests using the GET, HEAD, OPTIONS, TRACE, PUT and DELETE methods are retried on
+// another endpoint after any failure.
+func NewLoadBalancer(baseURLs []string, options ...func(*LoadBalancerOption)) (*LoadBalancer, error) {
+	opts := &LoadBalancerOption{
+		maxAttempts: len(baseURLs),
+		methods: []string{
+			MethodDelete,
+			MethodGet,
+			MethodHead,
+			MethodOptions,
+			MethodPut,
+			MethodTrace,
+		},
+		statusCodes: []int{
+			gohttp.StatusBadGateway,
+			gohttp.StatusServiceUnavailable,
+			gohttp.StatusGatewayTimeout,
+		},
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	var hosts []*proxy.Host
+	for _, u := range baseURLs {
+		h, err := proxy.NewHost(u)
+		if err != nil {
+			return nil, fmt.Errorf("load_balancer: %w", err)
+		}
+
+		t, err := h.Target()
+		if err != nil {
+			return nil, fmt.Errorf("load_balancer: %w", err)
+		}
+
+		if !supportedScheme(t) {
+			return nil, fmt.Errorf("load_balancer: unsupported base URL scheme %q", t.Scheme)
+		}
+
+		if t.Host == "" {
+			return nil, fmt.Errorf("load_balancer: base URL host is required: %s", t.Redacted())
+		}
+		hosts = append(hosts, h)
+	}
+
+	pool, err := proxy.NewPool(hosts, opts.poolOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("load_balancer: %w", err)
+	}
+
+	if opts.transport == nil {
+		opts.transport = DefaultTransport()
+	}
+
+	return &LoadBalancer{
+		maxAttempts: max(1, opts.maxAttempts),
+		methods:     opts.methods,
+		pool:        pool,
+		statusCodes: opts.statusCodes,
+		transport:   opts.transport,
+	}, nil
+}
+
+// Close stops active health checking of the endpoints, if enabled.
+func (b *LoadBalancer) Close() error {
+	return b.pool.Close()
+}
+
+// CloseIdleConnections closes any idle connections of the http.RoundTripper used by RoundTrip.
+func (b *LoadBalancer) CloseIdleConnections() {
+	if t, ok := b.transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// Middleware returns a Middleware that balances requests across the endpoints of the LoadBalancer, sending them using
+// the wrapped http.RoundTripper instead of the one used by RoundTrip.
+func (b *LoadBalancer) Middleware() Middleware {
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			return b.do(req, next.RoundTrip)
+		})
+	}
+}
+
+// Pool returns the proxy.Pool of endpoints.
+func (b *LoadBalancer) Pool() *proxy.Pool {
+	return b.pool
+}
+
+// RoundTrip sends the request to one of the endpoints of the LoadBalancer.
+func (b *LoadBalancer) RoundTrip(req *gohttp.Request) (*gohttp.Response, error) {
+	return b.do(req, b.transport.RoundTrip)
+}
+
+// String returns a string representation of the LoadBalancer.
+func (b *LoadBalancer) String() string {
+	var endpoints []string
+	for _, h := range b.pool.Hosts() {
+		if t, err := h.Target(); err == nil {
+			endpoints = append(endpoints, t.Redacted())
+		}
+	}
+	return string(anchor.ToJSON(map[string]any{
+		"active":       len(b.pool.Active()),
+		"endpoints":    endpoints,
+		"max_attempts": b.maxAttempts,
+		"methods":      b.methods,
+		"status_codes": b.statusCodes,
+	}))
+}
+
+// do sends the request to the selected endpoints using the provided function until it succeeds, or is no longer
+// retryable.
+func (b *LoadBalancer) do(req *gohttp.Request, send func(*gohttp.Request) (*gohttp.Response, error)) (*gohttp.Response, error) {
+	ctx := req.Context()
+
+	var tried []*proxy.Host
+	for attempt := 1; ; attempt++ {
+		h, err := b.selectHost(tried)
+		if err != nil {
+			return nil, fmt.Errorf("load_balancer: %w", err)
+		}
+		tried = append(tried, h)
+
+		t, err := h.Target()
+		if err != nil {
+			return nil, fmt.Errorf("load_balancer: %w", err)
+		}
+
+		r, err := rewriteRequest(req, t, attempt > 1)
+		if err != nil {
+			return nil, fmt.Errorf("load_balancer: %w", err)
+		}
+
+		// the request remains in flight until the body of the response is closed, or the response is discarded
+		done := h.StartRequest()
+		resp, err := send(r)
+		if err != nil {
+			done()
+		} else {
+			resp.Body = newCancelBody(resp.Body, done)
+		}
+
+		if ctx.Err() != nil {
+			return resp, err
+		}
+
+		if err == nil && !slices.Contains(b.statusCodes, resp.StatusCode) {
+			b.pool.MarkHealthy(h)
+			return resp, nil
+		}
+
+		if err != nil && Classify(resp, err) == ErrorClassCanceled {
+			return resp, err
+		}
+
+		log.Debug("[http:balancer] endpoint failed",
+			log.String("endpoint", t.Redacted()),
+			log.String("url", r.URL.Redacted()),
+			log.Int("attempt", attempt),
+			log.Err(b.failure(resp, err)))
+		b.pool.MarkFailed(h)
+
+		if attempt >= b.maxAttempts || !b.retryable(req, resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			drain(resp.Body)
+		}
+	}
+}
+
+// failure returns the error describing the failure of an attempt.
+func (b *LoadBalancer) failure(resp *gohttp.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+}
+
+// retryable returns whether the failed request can be attempted on another endpoint.
+func (b *LoadBalancer) retryable(req *gohttp.Request, resp *gohttp.Response, err error) bool {
+	if req.Body != nil && req.Body != gohttp.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if slices.Contains(b.methods, req.Method) || req.Header.Get(HeaderIdempotencyKey) != "" {
+		return true
+	}
+
+	// requests are never sent to endpoints that could not be connected to
+	switch Classify(resp, err) {
+	case ErrorClassDNS, ErrorClassConnRefused, ErrorClassDialTimeout:
+		return true
+	}
+	return false
+}
+
+// selectHost selects an endpoint from the pool. If the selected endpoint has already been attempted, the first active
+// endpoint that has not is used instead, if any.
+func (b *LoadBalancer) selectHost(tried []*proxy.Host) (*proxy.Host, error) {
+	h, err := b.pool.Select()
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(tried, h) {
+		for _, a := range b.pool.Active() {
+			if !slices.Contains(tried, a) {
+				return a, nil
+			}
+		}
+	}
+	return h, nil
+}
+
+// rewriteRequest returns a clone of the request sent to the provided base URL. If replay is true, the body of the clone
+// is obtained using http.Request.GetBody.
+func rewriteRequest(req *gohttp.Request, base *url.URL, replay bool) (*gohttp.Request, error) {
+	if req.URL == nil {
+		return nil, errors.New("request URL is required")
+	}
+
+	r := req.Clone(req.Context())
+	if replay && req.GetBody != nil && req.Body != nil && req.Body != gohttp.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	r.URL.Scheme = base.Scheme
+	r.URL.Host = base.Host
+	r.URL.Path, r.URL.RawPath = joinURLPath(base, req.URL)
+	if base.RawQuery == "" || req.URL.RawQuery == "" {
+		r.URL.RawQuery = base.RawQuery + req.URL.RawQuery
+	} else {
+		r.URL.RawQuery = base.RawQuery + "&" + req.URL.RawQuery
+	}
+
+	// the Host header is derived from the URL of the endpoint
+	r.Host = ""
+	return r, nil
+}
+
+// joinURLPath returns the path and raw path of the URL appended to the path of the base URL.
+func joinURLPath(base *url.URL, u *url.URL) (string, string) {
+	if base.RawPath == "" && u.RawPath == "" {
+		return singleJoiningSlash(base.Path, u.Path), ""
+	}
+	return singleJoiningSlash(base.Path, u.Path), singleJoiningSlash(base.EscapedPath(), u.EscapedPath())
+}
+
+// singleJoiningSlash joins the paths using a single slash.
+func singleJoiningSlash(a string, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
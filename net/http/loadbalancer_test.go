@@ -0,0 +1,121 @@
+package http
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/transientvariable/anchor/net/http/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gonet "net"
+	gohttp "net/http"
+)
+
+func TestLoadBalancer(t *testing.T) {
+	var healthy atomic.Int64
+	healthySrv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		healthy.Add(1)
+		b, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, r.Host+" "+r.URL.RequestURI()+" "+string(b))
+	}))
+	defer healthySrv.Close()
+
+	var unavailable atomic.Int64
+	unavailableSrv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		unavailable.Add(1)
+		w.WriteHeader(gohttp.StatusServiceUnavailable)
+	}))
+	defer unavailableSrv.Close()
+
+	// reserve an address that refuses connections
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refused := "http://" + l.Addr().String()
+	require.NoError(t, l.Close())
+
+	lb, err := NewLoadBalancer([]string{refused, unavailableSrv.URL + "/api", healthySrv.URL + "/api?v=1"},
+		WithLoadBalancerPoolOptions(proxy.WithPoolFailureThreshold(1), proxy.WithPoolSelector(firstSelector{})))
+	require.NoError(t, err)
+	defer lb.Close()
+
+	client := &gohttp.Client{Transport: lb}
+	do := func(method string, url string, body string, header gohttp.Header) (*gohttp.Response, string) {
+		req, err := gohttp.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(b)
+	}
+
+	// the refused and unavailable endpoints are attempted before the healthy one, and are ejected
+	resp, body := do(gohttp.MethodPut, "/users?id=1", "a", nil)
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+	assert.Equal(t, healthySrv.Listener.Addr().String()+" /api/users?v=1&id=1 a", body)
+	assert.Equal(t, int64(1), unavailable.Load())
+
+	active := lb.Pool().Active()
+	require.Len(t, active, 1)
+	target, err := active[0].Target()
+	require.NoError(t, err)
+	assert.Equal(t, healthySrv.URL+"/api?v=1", target.String())
+
+	resp, body = do(gohttp.MethodPost, "http://example.com/users", "b", gohttp.Header{HeaderIdempotencyKey: {"k"}})
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+	assert.Equal(t, healthySrv.Listener.Addr().String()+" /api/users?v=1 b", body)
+	assert.Equal(t, int64(0), active[0].Inflight())
+
+	// requests remain in flight until the response body is closed
+	resp, err = client.Get("/")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), active[0].Inflight())
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, int64(0), active[0].Inflight())
+
+	// requests with other methods are only attempted on another endpoint if the connection could not be established
+	lb, err = NewLoadBalancer([]string{refused, unavailableSrv.URL, healthySrv.URL},
+		WithLoadBalancerPoolOptions(proxy.WithPoolSelector(firstSelector{})))
+	require.NoError(t, err)
+	defer lb.Close()
+
+	client = &gohttp.Client{Transport: lb}
+	resp, _ = do(gohttp.MethodPost, "/users", "c", nil)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int64(2), unavailable.Load())
+	assert.Equal(t, int64(3), healthy.Load())
+	assert.Len(t, lb.Pool().Active(), 3)
+	for _, h := range lb.Pool().Hosts() {
+		assert.Zero(t, h.Inflight())
+	}
+
+	// the load balancer can be used as the Middleware of a client
+	client = NewClient(WithMiddleware(lb.Middleware()))
+	resp, err = client.Get("/")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+
+	_, err = NewLoadBalancer([]string{"ftp://example.com"})
+	assert.ErrorContains(t, err, "unsupported base URL scheme")
+
+	_, err = NewLoadBalancer(nil)
+	assert.Error(t, err)
+}
+
+// firstSelector is a proxy.Selector that always selects the first host.
+type firstSelector struct{}
+
+func (firstSelector) Select(hosts ...*proxy.Host) (*proxy.Host, error) {
+	return hosts[0], nil
+}
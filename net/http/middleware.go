@@ -0,0 +1,347 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+
+	gohttp "net/http"
+)
+
+const (
+	// LoggingBodyMaxSize sets the default maximum number of bytes of a request or response body that is logged.
+	LoggingBodyMaxSize = 4 * anchor.KiB
+
+	// Redacted is the value logged in place of the values of redacted headers.
+	Redacted = "[REDACTED]"
+)
+
+// Middleware defines a function wrapping an http.RoundTripper, e.g. for modifying outbound requests or observing their
+// responses.
+type Middleware func(gohttp.RoundTripper) gohttp.RoundTripper
+
+// RoundTripperFunc is an adapter allowing the use of an ordinary function as an http.RoundTripper.
+type RoundTripperFunc func(*gohttp.Request) (*gohttp.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *gohttp.Request) (*gohttp.Response, error) {
+	return f(req)
+}
+
+// Chain returns an http.RoundTripper wrapping the provided http.RoundTripper with the middleware, the first middleware
+// being the outermost. If the provided http.RoundTripper is nil, http.DefaultTransport is used.
+//
+// Calling CloseIdleConnections on the returned http.RoundTripper closes the idle connections of the provided
+// http.RoundTripper.
+func Chain(transport gohttp.RoundTripper, middleware ...Middleware) gohttp.RoundTripper {
+	if transport == nil {
+		transport = gohttp.DefaultTransport
+	}
+
+	rt := transport
+	for i := len(middleware) - 1; i >= 0; i-- {
+		if middleware[i] != nil {
+			rt = middleware[i](rt)
+		}
+	}
+	return &chain{RoundTripper: rt, transport: transport}
+}
+
+// chain is the http.RoundTripper returned by Chain.
+type chain struct {
+	gohttp.RoundTripper
+	transport gohttp.RoundTripper
+}
+
+// CloseIdleConnections closes any idle connections of the underlying http.RoundTripper.
+func (c *chain) CloseIdleConnections() {
+	if t, ok := c.transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// DefaultHeaders returns a Middleware that sets the provided headers on outbound requests that do not already have them
+// set.
+func DefaultHeaders(header gohttp.Header) Middleware {
+	header = header.Clone()
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			var r *gohttp.Request
+			for name, values := range header {
+				if len(values) == 0 || len(req.Header.Values(name)) > 0 {
+					continue
+				}
+
+				// a RoundTripper must not modify the provided request, so the headers are set on a clone
+				if r == nil {
+					r = req.Clone(req.Context())
+				}
+				r.Header[gohttp.CanonicalHeaderKey(name)] = slices.Clone(values)
+			}
+
+			if r == nil {
+				return next.RoundTrip(req)
+			}
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+// UserAgent returns a Middleware that sets the User-Agent header on outbound requests that do not already have it set.
+func UserAgent(userAgent string) Middleware {
+	return DefaultHeaders(gohttp.Header{HeaderUserAgent: {userAgent}})
+}
+
+// RequestTimeout returns a Middleware that limits the duration of outbound requests, including reading the response
+// body. A timeout that is not greater than zero means no limit.
+func RequestTimeout(timeout time.Duration) Middleware {
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		if timeout <= 0 {
+			return next
+		}
+
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			resp, err := next.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				cancel()
+				return resp, err
+			}
+			resp.Body = newCancelBody(resp.Body, cancel)
+			return resp, nil
+		})
+	}
+}
+
+// cancelBody is a response body cancelling the context of its request once closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// readWriteCancelBody is a cancelBody for the body of a 101 Switching Protocols response, which is also the
+// io.Writer for the upgraded connection.
+type readWriteCancelBody struct {
+	*cancelBody
+	io.Writer
+}
+
+// newCancelBody returns a response body cancelling the context of its request once closed, retaining the io.Writer of
+// upgraded connections.
+func newCancelBody(body io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+	b := &cancelBody{ReadCloser: body, cancel: cancel}
+	if w, ok := body.(io.ReadWriteCloser); ok {
+		return &readWriteCancelBody{cancelBody: b, Writer: w}
+	}
+	return b
+}
+
+// LoggingOption is a container for optional properties that can be used for initializing the Logging Middleware.
+type LoggingOption struct {
+	body          bool
+	bodyMaxSize   int64
+	level         log.Level
+	redactBody    func(contentType string, body []byte) []byte
+	redactHeaders []string
+}
+
+// WithLoggingBody sets whether the request and response bodies are logged, up to the provided maximum number of bytes.
+// If maxSize is not greater than zero, LoggingBodyMaxSize is used.
+func WithLoggingBody(enable bool, maxSize int64) func(*LoggingOption) {
+	return func(o *LoggingOption) {
+		o.body = enable
+		o.bodyMaxSize = maxSize
+	}
+}
+
+// WithLoggingLevel sets the log.Level used for logging requests and responses. Failed requests are always logged using
+// log.LevelError.
+func WithLoggingLevel(level log.Level) func(*LoggingOption) {
+	return func(o *LoggingOption) {
+		o.level = level
+	}
+}
+
+// WithLoggingRedactBody sets the function returning the logged representation of a request or response body given its
+// content type, e.g. for masking credentials.
+func WithLoggingRedactBody(redact func(contentType string, body []byte) []byte) func(*LoggingOption) {
+	return func(o *LoggingOption) {
+		o.redactBody = redact
+	}
+}
+
+// WithLoggingRedactHeaders appends to the list of headers whose values are logged as Redacted. The Authorization,
+// Cookie, Proxy-Authorization and Set-Cookie headers are always redacted.
+func WithLoggingRedactHeaders(names ...string) func(*LoggingOption) {
+	return func(o *LoggingOption) {
+		for _, n := range names {
+			o.redactHeaders = append(o.redactHeaders, gohttp.CanonicalHeaderKey(n))
+		}
+	}
+}
+
+// Logging returns a Middleware that logs outbound requests and their responses using the provided options. Header values
+// and bodies are logged after applying the redaction options.
+func Logging(options ...func(*LoggingOption)) Middleware {
+	opts := &LoggingOption{
+		level: log.LevelDebug,
+		redactHeaders: []string{
+			HeaderAuthorization,
+			HeaderCookie,
+			HeaderProxyAuthorization,
+			"Set-Cookie",
+		},
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	if opts.bodyMaxSize <= 0 {
+		opts.bodyMaxSize = LoggingBodyMaxSize
+	}
+
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			enabled := log.Default().GetLevel() <= opts.level
+			start := time.Now()
+
+			if enabled {
+				attrs := []func(*log.Record){
+					log.String("method", req.Method),
+					log.String("url", req.URL.Redacted()),
+					log.Any("headers", opts.headers(req.Header)),
+				}
+
+				log.Log(opts.level, "[http:client] request", attrs...)
+
+				if opts.body && req.Body != nil && req.Body != gohttp.NoBody {
+					r := req.Clone(req.Context())
+					r.Body = opts.logBody(req.Body, func(b []byte) {
+						log.Log(opts.level, "[http:client] request body",
+							log.String("method", req.Method),
+							log.String("url", req.URL.Redacted()),
+							log.String("body", opts.bodyString(req.Header, b)))
+					})
+					req = r
+				}
+			}
+
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				log.Error("[http:client] request failed",
+					log.String("method", req.Method),
+					log.String("url", req.URL.Redacted()),
+					log.String("duration", time.Since(start).String()),
+					log.Err(err))
+				return resp, err
+			}
+
+			if enabled {
+				attrs := []func(*log.Record){
+					log.String("method", req.Method),
+					log.String("url", req.URL.Redacted()),
+					log.Int("status", resp.StatusCode),
+					log.String("duration", time.Since(start).String()),
+					log.Any("headers", opts.headers(resp.Header)),
+				}
+
+				log.Log(opts.level, "[http:client] response", attrs...)
+
+				// the body of a 101 Switching Protocols response is the upgraded connection
+				if opts.body && resp.Body != nil && resp.Body != gohttp.NoBody &&
+					resp.StatusCode != gohttp.StatusSwitchingProtocols {
+					resp.Body = opts.logBody(resp.Body, func(b []byte) {
+						log.Log(opts.level, "[http:client] response body",
+							log.String("method", req.Method),
+							log.String("url", req.URL.Redacted()),
+							log.Int("status", resp.StatusCode),
+							log.String("body", opts.bodyString(resp.Header, b)))
+					})
+				}
+			}
+			return resp, nil
+		})
+	}
+}
+
+// bodyString returns the logged representation of the leading bytes of a body.
+func (o *LoggingOption) bodyString(header gohttp.Header, b []byte) string {
+	if o.redactBody != nil {
+		b = o.redactBody(header.Get(HeaderContentType), b)
+	}
+	return string(b)
+}
+
+// headers returns a copy of the headers with the values of the redacted headers replaced.
+func (o *LoggingOption) headers(header gohttp.Header) map[string][]string {
+	m := make(map[string][]string, len(header))
+	for name, values := range header {
+		if slices.Contains(o.redactHeaders, gohttp.CanonicalHeaderKey(name)) {
+			m[name] = []string{Redacted}
+			continue
+		}
+		m[name] = values
+	}
+	return m
+}
+
+// logBody returns a body capturing up to the maximum number of logged bytes while the body is read, without delaying
+// the reader, e.g. for streamed responses. The captured bytes are passed to the provided function once the maximum is
+// reached, the body is read to the end, or it is closed.
+func (o *LoggingOption) logBody(body io.ReadCloser, logFn func([]byte)) io.ReadCloser {
+	return &loggedBody{ReadCloser: body, log: logFn, maxSize: o.bodyMaxSize}
+}
+
+// loggedBody is a body capturing its leading bytes for logging while it is read.
+type loggedBody struct {
+	io.ReadCloser
+	buf     bytes.Buffer
+	log     func([]byte)
+	logged  bool
+	maxSize int64
+	mutex   sync.Mutex
+}
+
+// Read reads from the body, capturing the bytes read until the maximum number of logged bytes is reached.
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if remaining := b.maxSize - int64(b.buf.Len()); remaining > 0 && !b.logged {
+		b.buf.Write(p[:min(int64(n), remaining)])
+	}
+
+	if err != nil || int64(b.buf.Len()) >= b.maxSize {
+		b.flush()
+	}
+	return n, err
+}
+
+// Close logs the captured bytes, if not already logged, and closes the body.
+func (b *loggedBody) Close() error {
+	b.mutex.Lock()
+	b.flush()
+	b.mutex.Unlock()
+	return b.ReadCloser.Close()
+}
+
+// flush logs the captured bytes once. The mutex must be held by the caller.
+func (b *loggedBody) flush() {
+	if b.logged {
+		return
+	}
+	b.logged = true
+	b.log(b.buf.Bytes())
+}
@@ -0,0 +1,224 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/transientvariable/anchor/net"
+	"github.com/transientvariable/log-go"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+			return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	var header gohttp.Header
+	transport := &closeIdleTransport{RoundTripper: RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+		header = req.Header
+		return &gohttp.Response{StatusCode: gohttp.StatusOK, Body: gohttp.NoBody}, nil
+	})}
+
+	rt := Chain(transport,
+		record("a"),
+		nil,
+		record("b"),
+		UserAgent("anchor"),
+		DefaultHeaders(gohttp.Header{"X-Tenant": {"t1"}, HeaderAccept: {"application/json"}}),
+		RequestID())
+
+	req := mustRequest(t, gohttp.MethodGet, "http://example.com", nil)
+	req.Header.Set(HeaderAccept, "text/plain")
+	req = req.WithContext(net.ContextWithRequestID(req.Context(), "id"))
+
+	_, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, order)
+	assert.Equal(t, "anchor", header.Get(HeaderUserAgent))
+	assert.Equal(t, "t1", header.Get("X-Tenant"))
+	assert.Equal(t, "text/plain", header.Get(HeaderAccept))
+	assert.Equal(t, "id", header.Get(HeaderXRequestID))
+
+	// the provided request is not modified
+	assert.Empty(t, req.Header.Get(HeaderUserAgent))
+	assert.Empty(t, req.Header.Get(HeaderXRequestID))
+
+	rt.(interface{ CloseIdleConnections() }).CloseIdleConnections()
+	assert.True(t, transport.closed)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	client := &gohttp.Client{Transport: Chain(srv.Client().Transport, RequestTimeout(100*time.Millisecond))}
+	_, err := client.Get(srv.URL + "/slow")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the timeout is not cancelled before the body has been read
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "ok", string(b))
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.Default().Output(&buf).Level(log.LevelDebug)
+	defaultLogger := log.Default()
+	require.NoError(t, log.SetDefault(&logger))
+	defer func() {
+		_ = log.SetDefault(defaultLogger)
+	}()
+
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write(bytes.ToUpper(b))
+	}))
+	defer srv.Close()
+
+	rt := Chain(srv.Client().Transport, Logging(
+		WithLoggingBody(true, 8),
+		WithLoggingRedactHeaders("x-api-key"),
+		WithLoggingRedactBody(func(contentType string, body []byte) []byte {
+			return bytes.ReplaceAll(body, []byte("pass"), []byte("****"))
+		})))
+
+	req := mustRequest(t, gohttp.MethodPost, srv.URL+"/login", strings.NewReader("password=hunter2"))
+	req.Header.Set(HeaderAuthorization, "Bearer token")
+	req.Header.Set("X-Api-Key", "key")
+
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// bodies are forwarded in full
+	assert.Equal(t, "PASSWORD=HUNTER2", string(b))
+
+	out := buf.String()
+	assert.Contains(t, out, `"body":"****word"`)
+	assert.Contains(t, out, `"body":"PASSWORD"`)
+	assert.Contains(t, out, `"status":200`)
+	assert.Contains(t, out, srv.URL+"/login")
+	assert.NotContains(t, out, "token")
+	assert.NotContains(t, out, `"key"`)
+	assert.NotContains(t, out, "session=secret")
+	assert.NotContains(t, out, "hunter2")
+}
+
+func TestLoggingStreaming(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.Default().Output(&buf).Level(log.LevelDebug)
+	defaultLogger := log.Default()
+	require.NoError(t, log.SetDefault(&logger))
+	defer func() {
+		_ = log.SetDefault(defaultLogger)
+	}()
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			w.Header().Set(HeaderConnection, "Upgrade")
+			w.Header().Set("Upgrade", "echo")
+			w.WriteHeader(gohttp.StatusSwitchingProtocols)
+			conn, rw, err := gohttp.NewResponseController(w).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = io.Copy(conn, rw)
+			return
+		}
+
+		w.Header().Set(HeaderContentType, "text/event-stream")
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		_ = gohttp.NewResponseController(w).Flush()
+		<-release
+		_, _ = io.WriteString(w, "data: 2\n\n")
+	}))
+	defer srv.Close()
+
+	rt := Chain(srv.Client().Transport, Logging(WithLoggingBody(true, 1024)))
+
+	// streamed responses are returned before the logged bytes have been received
+	resp, err := rt.RoundTrip(mustRequest(t, gohttp.MethodGet, srv.URL, nil))
+	require.NoError(t, err)
+
+	read := make(chan string)
+	go func() {
+		b := make([]byte, 64)
+		n, _ := resp.Body.Read(b)
+		read <- string(b[:n])
+	}()
+
+	select {
+	case s := <-read:
+		assert.Equal(t, "data: 1\n\n", s)
+	case <-time.After(time.Second):
+		t.Fatal("reading the first event was blocked")
+	}
+	assert.NotContains(t, buf.String(), "response body")
+
+	close(release)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Contains(t, buf.String(), `"body":"data: 1\n\ndata: 2\n\n"`)
+
+	// the body of a 101 Switching Protocols response remains writable
+	req := mustRequest(t, gohttp.MethodGet, srv.URL, nil)
+	req.Header.Set(HeaderConnection, "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, gohttp.StatusSwitchingProtocols, resp.StatusCode)
+	defer resp.Body.Close()
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	require.True(t, ok)
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b))
+}
+
+type closeIdleTransport struct {
+	gohttp.RoundTripper
+	closed bool
+}
+
+func (t *closeIdleTransport) CloseIdleConnections() {
+	t.closed = true
+}
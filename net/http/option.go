@@ -0,0 +1,252 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/transientvariable/anchor"
+
+	gohttp "net/http"
+)
+
+// Option is a container for options used for configuring an http.Client and its http.Transport.
+type Option struct {
+	bufferSizeRead        int
+	bufferSizeWrite       int
+	connIdleTimeout       time.Duration
+	connMaxIdle           int
+	connMaxIdlePerHost    int
+	connMaxPerHost        int
+	dialContext           func(context.Context, string, string) (net.Conn, error)
+	dialKeepAlive         time.Duration
+	dialTimeout           time.Duration
+	disableKeepAlives     bool
+	expectContinueTimeout time.Duration
+	hedgePolicy           *HedgePolicy
+	http2                 bool
+	http2Config           *gohttp.HTTP2Config
+	middleware            []Middleware
+	proxy                 func(*gohttp.Request) (*url.URL, error)
+	responseHeaderTimeout time.Duration
+	retryPolicy           *RetryPolicy
+	timeout               time.Duration
+	tlsConfig             *tls.Config
+	tlsHandshakeTimeout   time.Duration
+}
+
+// newOption returns a new Option with the default values of NewTransport and NewClient, updated using the provided
+// options.
+func newOption(options ...func(*Option)) *Option {
+	opts := &Option{
+		bufferSizeRead:        BufferSizeRead,
+		bufferSizeWrite:       BufferSizeWrite,
+		connIdleTimeout:       ConnIdleTimeout,
+		connMaxIdle:           ConnMaxIdle,
+		connMaxIdlePerHost:    runtime.GOMAXPROCS(0) + 1,
+		connMaxPerHost:        ConnMaxPerHost,
+		dialKeepAlive:         DialKeepAlive,
+		dialTimeout:           DialTimeout,
+		disableKeepAlives:     DisableKeepAlives,
+		expectContinueTimeout: ExpectContinueTimeout,
+		http2:                 true,
+		proxy:                 gohttp.ProxyFromEnvironment,
+		timeout:               Timeout,
+		tlsHandshakeTimeout:   TLSHandshakeTimeout,
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+	return opts
+}
+
+// String returns a string representation of the Option.
+func (o *Option) String() string {
+	options := make(map[string]any)
+	options["buffer_size_read"] = o.bufferSizeRead
+	options["buffer_size_write"] = o.bufferSizeWrite
+	options["conn_idle_timeout"] = o.connIdleTimeout
+	options["conn_max_idle"] = o.connMaxIdle
+	options["conn_max_idle_per_host"] = o.connMaxIdlePerHost
+	options["conn_max_per_host"] = o.connMaxPerHost
+	options["dial_context"] = o.dialContext != nil
+	options["dial_keep_alive"] = o.dialKeepAlive
+	options["dial_timeout"] = o.dialTimeout
+	options["disable_keep_alives"] = o.disableKeepAlives
+	options["expect_continue_timeout"] = o.expectContinueTimeout
+	options["http2"] = o.http2
+	options["http2_config"] = o.http2Config
+	options["middleware"] = len(o.middleware)
+	options["proxy"] = o.proxy != nil
+	options["response_header_timeout"] = o.responseHeaderTimeout
+	options["timeout"] = o.timeout
+	options["tls_config"] = o.tlsConfig != nil
+	options["tls_handshake_timeout"] = o.tlsHandshakeTimeout
+	if o.hedgePolicy != nil {
+		options["hedge_policy"] = o.hedgePolicy.toMap()
+	}
+
+	if o.retryPolicy != nil {
+		options["retry_policy"] = o.retryPolicy.toMap()
+	}
+	return string(anchor.ToJSONFormatted(options))
+}
+
+// WithBufferSizeRead sets the size of the read buffer used for each connection.
+func WithBufferSizeRead(size int) func(*Option) {
+	return func(o *Option) {
+		o.bufferSizeRead = size
+	}
+}
+
+// WithBufferSizeWrite sets the size of the write buffer used for each connection.
+func WithBufferSizeWrite(size int) func(*Option) {
+	return func(o *Option) {
+		o.bufferSizeWrite = size
+	}
+}
+
+// WithConnIdleTimeout sets the maximum amount of time an idle connection remains open. Zero means no limit.
+func WithConnIdleTimeout(timeout time.Duration) func(*Option) {
+	return func(o *Option) {
+		o.connIdleTimeout = timeout
+	}
+}
+
+// WithConnMaxIdle sets the maximum number of idle connections across all hosts. Zero means no limit.
+func WithConnMaxIdle(n int) func(*Option) {
+	return func(o *Option) {
+		o.connMaxIdle = n
+	}
+}
+
+// WithConnMaxIdlePerHost sets the maximum number of idle connections per host.
+func WithConnMaxIdlePerHost(n int) func(*Option) {
+	return func(o *Option) {
+		o.connMaxIdlePerHost = n
+	}
+}
+
+// WithConnMaxPerHost sets the maximum number of connections per host, including connections in the dialing, active,
+// and idle states. Zero means no limit.
+func WithConnMaxPerHost(n int) func(*Option) {
+	return func(o *Option) {
+		o.connMaxPerHost = n
+	}
+}
+
+// WithDialContext sets the function used for creating unencrypted TCP connections, e.g. EgressPool.DialContext. The
+// dial timeout and keep-alive options are ignored if set.
+func WithDialContext(dialContext func(context.Context, string, string) (net.Conn, error)) func(*Option) {
+	return func(o *Option) {
+		o.dialContext = dialContext
+	}
+}
+
+// WithDialKeepAlive sets the interval between keep-alive probes for active connections.
+func WithDialKeepAlive(keepAlive time.Duration) func(*Option) {
+	return func(o *Option) {
+		o.dialKeepAlive = keepAlive
+	}
+}
+
+// WithDialTimeout sets the maximum amount of time a dial waits for a connection to complete.
+func WithDialTimeout(timeout time.Duration) func(*Option) {
+	return func(o *Option) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithDisableKeepAlives sets whether HTTP keep-alives are disabled, in which case each connection is only used for a
+// single request.
+func WithDisableKeepAlives(disable bool) func(*Option) {
+	return func(o *Option) {
+		o.disableKeepAlives = disable
+	}
+}
+
+// WithExpectContinueTimeout sets the amount of time to wait for the first response headers of a request with the
+// "Expect: 100-continue" header.
+func WithExpectContinueTimeout(timeout time.Duration) func(*Option) {
+	return func(o *Option) {
+		o.expectContinueTimeout = timeout
+	}
+}
+
+// WithHedgePolicy sets the HedgePolicy used for hedging the requests of an http.Client.
+func WithHedgePolicy(policy *HedgePolicy) func(*Option) {
+	return func(o *Option) {
+		o.hedgePolicy = policy
+	}
+}
+
+// WithHTTP2 sets whether HTTP/2 is attempted for TLS connections.
+func WithHTTP2(enable bool) func(*Option) {
+	return func(o *Option) {
+		o.http2 = enable
+	}
+}
+
+// WithHTTP2Config sets the http.HTTP2Config used for HTTP/2 connections, e.g. for the maximum frame size or the health
+// check ping interval.
+func WithHTTP2Config(config *gohttp.HTTP2Config) func(*Option) {
+	return func(o *Option) {
+		o.http2Config = config
+	}
+}
+
+// WithMiddleware appends to the list of Middleware wrapping the http.RoundTripper of an http.Client. The first provided
+// Middleware is the outermost.
+func WithMiddleware(middleware ...Middleware) func(*Option) {
+	return func(o *Option) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// WithProxy sets the function returning the proxy for a request. A nil function, or a nil url.URL, means no proxy is
+// used.
+func WithProxy(proxy func(*gohttp.Request) (*url.URL, error)) func(*Option) {
+	return func(o *Option) {
+		o.proxy = proxy
+	}
+}
+
+// WithResponseHeaderTimeout sets the amount of time to wait for the response headers after fully writing a request.
+// Zero means no limit.
+func WithResponseHeaderTimeout(timeout time.Duration) func(*Option) {
+	return func(o *Option) {
+		o.responseHeaderTimeout = timeout
+	}
+}
+
+// WithRetryPolicy sets the RetryPolicy used for retrying the requests of an http.Client.
+func WithRetryPolicy(policy *RetryPolicy) func(*Option) {
+	return func(o *Option) {
+		o.retryPolicy = policy
+	}
+}
+
+// WithTimeout sets the time limit for requests made by an http.Client, including connection time, redirects, and
+// reading the response body. Zero means no limit.
+func WithTimeout(timeout time.Duration) func(*Option) {
+	return func(o *Option) {
+		o.timeout = timeout
+	}
+}
+
+// WithTLSConfig sets the tls.Config used for TLS connections.
+func WithTLSConfig(config *tls.Config) func(*Option) {
+	return func(o *Option) {
+		o.tlsConfig = config
+	}
+}
+
+// WithTLSHandshakeTimeout sets the maximum amount of time to wait for a TLS handshake. Zero means no limit.
+func WithTLSHandshakeTimeout(timeout time.Duration) func(*Option) {
+	return func(o *Option) {
+		o.tlsHandshakeTimeout = timeout
+	}
+}
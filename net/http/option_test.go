@@ -0,0 +1,99 @@
+package http
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestNewTransport(t *testing.T) {
+	transport := NewTransport()
+	assert.True(t, transport.DisableKeepAlives)
+	assert.True(t, transport.ForceAttemptHTTP2)
+	assert.Equal(t, ConnMaxPerHost, transport.MaxConnsPerHost)
+	assert.Equal(t, BufferSizeRead, transport.ReadBufferSize)
+	assert.NotNil(t, transport.Proxy)
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS13}
+	transport = NewTransport(
+		WithBufferSizeRead(64*1024),
+		WithBufferSizeWrite(32*1024),
+		WithConnIdleTimeout(time.Minute),
+		WithConnMaxIdle(10),
+		WithConnMaxIdlePerHost(5),
+		WithConnMaxPerHost(20),
+		WithDisableKeepAlives(false),
+		WithHTTP2(false),
+		WithHTTP2Config(&gohttp.HTTP2Config{MaxConcurrentStreams: 50}),
+		WithProxy(nil),
+		WithResponseHeaderTimeout(5*time.Second),
+		WithTLSConfig(tlsConfig),
+		WithTLSHandshakeTimeout(time.Second))
+
+	assert.Equal(t, 64*1024, transport.ReadBufferSize)
+	assert.Equal(t, 32*1024, transport.WriteBufferSize)
+	assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	assert.Equal(t, 10, transport.MaxIdleConns)
+	assert.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 20, transport.MaxConnsPerHost)
+	assert.False(t, transport.DisableKeepAlives)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.Equal(t, 50, transport.HTTP2.MaxConcurrentStreams)
+	assert.Nil(t, transport.Proxy)
+	assert.Equal(t, 5*time.Second, transport.ResponseHeaderTimeout)
+	assert.Same(t, tlsConfig, transport.TLSClientConfig)
+	assert.Equal(t, time.Second, transport.TLSHandshakeTimeout)
+}
+
+func TestNewClient(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if attempts++; attempts == 1 {
+			w.WriteHeader(gohttp.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("X-Order")))
+	}))
+	defer srv.Close()
+
+	appendOrder := func(name string) Middleware {
+		return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+			return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+				req = req.Clone(req.Context())
+				req.Header.Set("X-Order", req.Header.Get("X-Order")+name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	client := NewClient(
+		WithTimeout(time.Second),
+		WithMiddleware(appendOrder("a"), appendOrder("b")),
+		WithRetryPolicy(NewRetryPolicy(WithRetryBackOff(func() backoff.BackOff { return &backoff.ZeroBackOff{} }))))
+	assert.Equal(t, time.Second, client.Timeout)
+
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, attempts)
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ab", string(b))
+
+	var options map[string]any
+	require.NoError(t, json.Unmarshal([]byte(newOption(WithMiddleware(appendOrder("a"))).String()), &options))
+	assert.Equal(t, float64(1), options["middleware"])
+	assert.Equal(t, true, options["disable_keep_alives"])
+}
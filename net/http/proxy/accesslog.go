@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/transientvariable/anchor/net"
+	"github.com/transientvariable/log-go"
+)
+
+// AccessLog returns a Middleware that logs a record for every request once it has been served.
+//
+// The record includes the request ID stored in the request context, so AccessLog should be applied after the
+// RequestID Middleware.
+func AccessLog() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w}
+			start := time.Now()
+			next.ServeHTTP(sw, r)
+
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			fields := []func(*log.Record){
+				log.String("method", r.Method),
+				log.String("host", r.Host),
+				log.String("path", r.URL.Path),
+				log.String("protocol", r.Proto),
+				log.String("remote_addr", r.RemoteAddr),
+				log.Int("status", status),
+				log.Int64("bytes", sw.bytes),
+				log.String("duration", time.Since(start).String()),
+				log.String("user_agent", r.UserAgent()),
+			}
+
+			if id, ok := net.RequestIDFromContext(r.Context()); ok {
+				fields = append(fields, log.String("request_id", id))
+			}
+			log.Info("[proxy:access]", fields...)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/transientvariable/log-go"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.Default().Output(&buf).Level(log.LevelInfo)
+	defaultLogger := log.Default()
+	require.NoError(t, log.SetDefault(&logger))
+	defer func() {
+		_ = log.SetDefault(defaultLogger)
+	}()
+
+	h := chain(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.WriteHeader(gohttp.StatusCreated)
+		_, _ = io.WriteString(w, "created")
+	}), RequestID(WithRequestIDGenerator(func() string { return "generated" })), AccessLog())
+
+	req := httptest.NewRequest(gohttp.MethodPost, "http://example.com/items?q=1", nil)
+	req.Header.Set("User-Agent", "test")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &record), buf.String())
+	assert.Equal(t, gohttp.MethodPost, record["method"])
+	assert.Equal(t, "example.com", record["host"])
+	assert.Equal(t, "/items", record["path"])
+	assert.Equal(t, "HTTP/1.1", record["protocol"])
+	assert.Equal(t, "192.0.2.1:1234", record["remote_addr"])
+	assert.Equal(t, float64(gohttp.StatusCreated), record["status"])
+	assert.Equal(t, float64(len("created")), record["bytes"])
+	assert.Equal(t, "test", record["user_agent"])
+	assert.Equal(t, "generated", record["request_id"])
+	assert.NotEmpty(t, record["duration"])
+}
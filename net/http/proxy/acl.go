@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/anchor/net"
+	"github.com/transientvariable/log-go"
+)
+
+const headerXForwardedFor = "X-Forwarded-For"
+
+// ACLOption is a container for optional properties that can be used for initializing an ACL.
+type ACLOption struct {
+	allow           []netip.Prefix
+	deny            []netip.Prefix
+	forwardedHeader string
+	trusted         []netip.Prefix
+}
+
+// WithACLAllow appends to the list of network prefixes of the clients that are allowed.
+func WithACLAllow(prefixes ...netip.Prefix) func(*ACLOption) {
+	return func(o *ACLOption) {
+		o.allow = append(o.allow, prefixes...)
+	}
+}
+
+// WithACLDeny appends to the list of network prefixes of the clients that are denied.
+func WithACLDeny(prefixes ...netip.Prefix) func(*ACLOption) {
+	return func(o *ACLOption) {
+		o.deny = append(o.deny, prefixes...)
+	}
+}
+
+// WithACLForwardedHeader sets the name of the header the trusted proxies report the address of the client with, either
+// Forwarded, or X-Forwarded-For or a header using the same format. Defaults to X-Forwarded-For.
+//
+// Only the configured header is used, since trusted proxies commonly pass other headers through unchanged, which
+// would allow clients to report an arbitrary address.
+func WithACLForwardedHeader(name string) func(*ACLOption) {
+	return func(o *ACLOption) {
+		o.forwardedHeader = name
+	}
+}
+
+// WithACLTrustedProxies appends to the list of network prefixes of the proxies that are trusted to report the address
+// of the client using the header set with WithACLForwardedHeader.
+func WithACLTrustedProxies(prefixes ...netip.Prefix) func(*ACLOption) {
+	return func(o *ACLOption) {
+		o.trusted = append(o.trusted, prefixes...)
+	}
+}
+
+// ACL controls access to HTTP requests based on the IP address of the client.
+//
+// The most specific prefix containing the client address in either the allow or deny list determines whether the
+// request is allowed, with deny taking precedence for identical prefixes. Addresses not contained in any prefix are
+// denied if the allow list is not empty, and allowed otherwise. Requests whose client address cannot be determined are
+// always denied.
+//
+// The client address is the remote address of the request, unless it is a trusted proxy, in which case the hops of the
+// forwarded header of the trusted proxies, X-Forwarded-For by default, are walked from the most recent, and the first
+// address that is not a trusted proxy is used.
+//
+// The allow and deny lists can be replaced at runtime using SetPrefixes.
+type ACL struct {
+	forwardedHeader string
+	rules           atomic.Pointer[aclRules]
+	trusted         atomic.Pointer[net.PrefixTrie[struct{}]]
+}
+
+// aclRules is an immutable set of allow and deny prefixes, mapping each prefix to whether it is allowed, along with the
+// number of allowed prefixes provided.
+type aclRules struct {
+	allowed int
+	trie    net.PrefixTrie[bool]
+}
+
+// NewACL creates a new ACL using the provided options.
+func NewACL(options ...func(*ACLOption)) *ACL {
+	opts := &ACLOption{}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	a := &ACL{forwardedHeader: http.CanonicalHeaderKey(strings.TrimSpace(opts.forwardedHeader))}
+	if a.forwardedHeader == "" {
+		a.forwardedHeader = headerXForwardedFor
+	}
+	a.SetPrefixes(opts.allow, opts.deny)
+	a.SetTrustedProxies(opts.trusted...)
+	return a
+}
+
+// SetPrefixes atomically replaces the allow and deny lists of the ACL.
+func (a *ACL) SetPrefixes(allow []netip.Prefix, deny []netip.Prefix) {
+	r := &aclRules{}
+	for _, p := range allow {
+		if p.IsValid() {
+			r.trie.Insert(p, true)
+			r.allowed++
+		}
+	}
+
+	for _, p := range deny {
+		if p.IsValid() {
+			r.trie.Insert(p, false)
+		}
+	}
+	a.rules.Store(r)
+}
+
+// SetTrustedProxies atomically replaces the list of trusted proxies of the ACL.
+func (a *ACL) SetTrustedProxies(prefixes ...netip.Prefix) {
+	t := &net.PrefixTrie[struct{}]{}
+	for _, p := range prefixes {
+		t.Insert(p, struct{}{})
+	}
+	a.trusted.Store(t)
+}
+
+// Allowed returns whether the IP address is allowed. Invalid addresses, e.g. when the address of the client cannot be
+// determined, are never allowed.
+func (a *ACL) Allowed(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+
+	r := a.rules.Load()
+	if _, allowed, ok := r.trie.Lookup(addr); ok {
+		return allowed
+	}
+	return r.allowed == 0
+}
+
+// AllowedRequest returns whether the client of the request is allowed, along with the client address.
+func (a *ACL) AllowedRequest(r *http.Request) (netip.Addr, bool) {
+	addr := a.ClientIP(r)
+	return addr, a.Allowed(addr)
+}
+
+// ClientIP returns the IP address of the client of the request, taking the hops reported by trusted proxies into
+// account. The returned address is invalid if it cannot be determined.
+func (a *ACL) ClientIP(r *http.Request) netip.Addr {
+	addr := parseNode(r.RemoteAddr)
+	trusted := a.trusted.Load()
+	if !trusted.Contains(addr) {
+		return addr
+	}
+
+	hops := forwardedHops(r.Header, a.forwardedHeader)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr = parseNode(hops[i])
+		if !trusted.Contains(addr) {
+			return addr
+		}
+	}
+	return addr
+}
+
+// Handler returns a http.Handler that rejects requests from clients that are not allowed with http.StatusForbidden
+// before passing them to the provided http.Handler.
+func (a *ACL) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := a.AllowedRequest(r); !ok {
+			log.Debug("[proxy:acl] client not allowed",
+				log.String("client", addr.String()),
+				log.String("remote_addr", r.RemoteAddr))
+			writeError(w, r, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// String returns a string representation of the ACL.
+func (a *ACL) String() string {
+	return string(anchor.ToJSON(a.toMap()))
+}
+
+// toMap returns a map representing the ACL attributes.
+func (a *ACL) toMap() map[string]any {
+	r := a.rules.Load()
+	var allow, deny []string
+	for p, allowed := range r.trie.All() {
+		if allowed {
+			allow = append(allow, p.String())
+		} else {
+			deny = append(deny, p.String())
+		}
+	}
+
+	var trusted []string
+	for p := range a.trusted.Load().All() {
+		trusted = append(trusted, p.String())
+	}
+	return map[string]any{
+		"allow":            allow,
+		"deny":             deny,
+		"forwarded_header": a.forwardedHeader,
+		"trusted_proxies":  trusted,
+	}
+}
+
+// forwardedHops returns the node identifiers of the hops reported by proxies using the named header, ordered from the
+// earliest to the most recent. Headers other than Forwarded are parsed as a list of addresses like X-Forwarded-For.
+func forwardedHops(h http.Header, name string) []string {
+	var hops []string
+	if name == headerForwarded {
+		for _, v := range h.Values(headerForwarded) {
+			for _, elem := range strings.Split(v, ",") {
+				var node string
+				for _, pair := range strings.Split(elem, ";") {
+					if k, v, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(k, "for") {
+						node = strings.Trim(v, `"`)
+					}
+				}
+				hops = append(hops, node)
+			}
+		}
+		return hops
+	}
+
+	for _, v := range h.Values(name) {
+		for _, node := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(node))
+		}
+	}
+	return hops
+}
+
+// parseNode parses the IP address of a node identifier, which can be an address with or without a port, and with or
+// without brackets for IPv6 addresses. The returned address is invalid for unknown or obfuscated identifiers.
+func parseNode(node string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(node); err == nil {
+		return ap.Addr().Unmap().WithZone("")
+	}
+
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	if a, err := netip.ParseAddr(node); err == nil {
+		return a.Unmap().WithZone("")
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	gohttp "net/http"
+)
+
+func TestACL(t *testing.T) {
+	acl := NewACL(
+		WithACLAllow(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")),
+		WithACLDeny(netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("2001:db8::/32")),
+		WithACLTrustedProxies(netip.MustParsePrefix("192.0.2.0/24")))
+
+	for addr, allowed := range map[string]bool{
+		"10.0.0.1":        true,
+		"10.1.0.1":        false,
+		"::ffff:10.0.0.1": true,
+		"2001:db8::1":     false,
+		"198.51.100.1":    false,
+	} {
+		assert.Equal(t, allowed, acl.Allowed(netip.MustParseAddr(addr)), addr)
+	}
+	assert.False(t, acl.Allowed(netip.Addr{}))
+
+	// without an allow list, clients are allowed unless denied, except for invalid addresses
+	acl.SetPrefixes(nil, []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16"), {}})
+	assert.True(t, acl.Allowed(netip.MustParseAddr("198.51.100.1")))
+	assert.False(t, acl.Allowed(netip.MustParseAddr("10.1.0.1")))
+	assert.False(t, acl.Allowed(netip.Addr{}))
+	assert.NotContains(t, acl.String(), "invalid Prefix")
+
+	denied := NewACL(WithACLDeny(netip.MustParsePrefix("10.1.0.0/16")))
+	for remoteAddr, status := range map[string]int{
+		"198.51.100.1:1234": gohttp.StatusOK,
+		"unknown":           gohttp.StatusForbidden,
+		"":                  gohttp.StatusForbidden,
+	} {
+		r := httptest.NewRequest(gohttp.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		denied.Handler(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {})).ServeHTTP(w, r)
+		assert.Equal(t, status, w.Code, remoteAddr)
+	}
+
+	acl.SetPrefixes([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, nil)
+	forwarded := NewACL(
+		WithACLAllow(netip.MustParsePrefix("10.0.0.0/8")),
+		WithACLForwardedHeader("forwarded"),
+		WithACLTrustedProxies(netip.MustParsePrefix("192.0.2.0/24")))
+
+	for _, tc := range []struct {
+		acl        *ACL
+		client     string
+		forwarded  string
+		remoteAddr string
+		status     int
+		xff        string
+	}{
+		{remoteAddr: "10.0.0.1:1234", client: "10.0.0.1", status: gohttp.StatusOK},
+		{remoteAddr: "198.51.100.1:1234", client: "198.51.100.1", status: gohttp.StatusForbidden},
+
+		// forwarded hops are ignored unless reported by a trusted proxy
+		{remoteAddr: "198.51.100.1:1234", xff: "10.0.0.1", client: "198.51.100.1", status: gohttp.StatusForbidden},
+		{remoteAddr: "192.0.2.1:1234", xff: "10.0.0.1, 192.0.2.2", client: "10.0.0.1", status: gohttp.StatusOK},
+		{remoteAddr: "192.0.2.1:1234", xff: "10.0.0.1, 198.51.100.1", client: "198.51.100.1", status: gohttp.StatusForbidden},
+
+		// only the header of the trusted proxies is used, so clients cannot spoof their address using the other one
+		{remoteAddr: "192.0.2.1:1234", forwarded: "for=10.0.0.1", xff: "198.51.100.1",
+			client: "198.51.100.1", status: gohttp.StatusForbidden},
+		{remoteAddr: "192.0.2.1:1234", forwarded: "for=10.0.0.1", client: "192.0.2.1", status: gohttp.StatusForbidden},
+		{acl: forwarded, remoteAddr: "192.0.2.1:1234", forwarded: "for=198.51.100.1", xff: "10.0.0.1",
+			client: "198.51.100.1", status: gohttp.StatusForbidden},
+		{acl: forwarded, remoteAddr: "192.0.2.1:1234", xff: "10.0.0.1", client: "192.0.2.1", status: gohttp.StatusForbidden},
+
+		{acl: forwarded, remoteAddr: "192.0.2.1:1234", forwarded: `for=198.51.100.1, for="10.0.0.1:80";proto=https`,
+			client: "10.0.0.1", status: gohttp.StatusOK},
+		{acl: forwarded, remoteAddr: "192.0.2.1:1234", forwarded: `for="[2001:db8::1]"`, client: "2001:db8::1",
+			status: gohttp.StatusForbidden},
+		{acl: forwarded, remoteAddr: "192.0.2.1:1234", forwarded: "for=unknown", client: "invalid IP",
+			status: gohttp.StatusForbidden},
+		{remoteAddr: "192.0.2.1:1234", client: "192.0.2.1", status: gohttp.StatusForbidden},
+	} {
+		a := acl
+		if tc.acl != nil {
+			a = tc.acl
+		}
+
+		r := httptest.NewRequest(gohttp.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwarded != "" {
+			r.Header.Set(headerForwarded, tc.forwarded)
+		}
+
+		if tc.xff != "" {
+			r.Header.Set(headerXForwardedFor, tc.xff)
+		}
+		assert.Equal(t, tc.client, a.ClientIP(r).String(), tc)
+
+		w := httptest.NewRecorder()
+		a.Handler(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {})).ServeHTTP(w, r)
+		assert.Equal(t, tc.status, w.Code, tc)
+	}
+}
@@ -1,15 +1,21 @@
 package proxy
 
 import (
-	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/transientvariable/anchor"
 	"github.com/transientvariable/log-go"
+
+	"google.golang.org/grpc/codes"
 )
 
 // Selector defines the behavior for selecting a Host proxy from a Pool.
@@ -35,85 +41,185 @@ func (s *roundRobinSelector) Select(hosts ...*Host) (*Host, error) {
 	return h, nil
 }
 
+type leastConnectionsSelector struct {
+	next atomic.Uint64
+}
+
+// NewLeastConnectionsSelector returns a Selector that selects the Host with the fewest requests in flight and open
+// connections, including connections upgraded from HTTP. Ties are broken in a round-robin manner.
+func NewLeastConnectionsSelector() Selector {
+	return &leastConnectionsSelector{}
+}
+
+// Select returns the Host proxy with the lowest load.
+func (s *leastConnectionsSelector) Select(hosts ...*Host) (*Host, error) {
+	offset := int(s.next.Add(1) % uint64(len(hosts)))
+
+	var (
+		h    *Host
+		load int64
+	)
+	for i := range hosts {
+		c := hosts[(offset+i)%len(hosts)]
+		if l := c.load(); h == nil || l < load {
+			h, load = c, l
+		}
+	}
+
+	log.Trace("[proxy:balancer] selected host", log.String("target", h.target.String()), log.Int64("load", load))
+
+	return h, nil
+}
+
+type randomSelector struct{}
+
+// Select returns a Host proxy chosen uniformly at random.
+func (s *randomSelector) Select(hosts ...*Host) (*Host, error) {
+	i := rand.IntN(len(hosts))
+
+	log.Trace("[proxy:balancer] selected host", log.Int("index", i))
+
+	return hosts[i], nil
+}
+
+// Middleware defines a function for wrapping the http.Handler of a Balancer.
+type Middleware func(http.Handler) http.Handler
+
 // Balancer defines the behavior for multiplexing HTTP requests amongst of a number of Host proxies.
+//
+// Each request is balanced individually, so the streams of a single HTTP/2 connection, e.g. gRPC calls, may be served
+// by different hosts.
 type Balancer interface {
 	http.Handler
+	io.Closer
+
+	// Pool returns the Pool of Host proxies for the Balancer.
+	Pool() *Pool
 
 	// Targets returns the list of URLs of available Host proxies.
 	Targets() ([]*url.URL, error)
 }
 
 type balancer struct {
-	active                 []*Host
-	inactive               []*Host
-	mutex                  sync.RWMutex
-	reviveTimeout          time.Duration
-	reviveTimeoutThreshold int
-	selector               Selector
+	handler            http.Handler
+	pool               *Pool
+	upgradeIdleTimeout time.Duration
+	upgradeMaxLifetime time.Duration
 }
 
 // NewBalancer creates a new proxy Balancer using the provided Selector and Host proxy list.
 //
 // If the provided Selector is nil, a default one based the round-robin algorithm is used.
 func NewBalancer(hosts []*Host, options ...func(*LBOption)) (Balancer, error) {
-	l := &balancer{
-		active:   []*Host{},
-		inactive: []*Host{},
+	opts := &LBOption{
+		reviveTimeout:          PoolReviveTimeout,
+		reviveTimeoutThreshold: PoolFailureThreshold,
+	}
+	for _, opt := range options {
+		opt(opts)
 	}
 
-	// sanitize the list of provided hosts and add them to the load balancer as active hosts
-	for _, h := range hosts {
-		if h != nil {
-			t, err := h.Target()
-			if err != nil {
-				return nil, fmt.Errorf("load_balancer: %w", err)
-			}
-			log.Debug("[proxy:balancer] adding host", log.String("target", t.String()))
-			l.active = append(l.active, h)
-		}
+	poolOpts := []func(*PoolOption){
+		WithPoolFailureThreshold(opts.reviveTimeoutThreshold),
+		WithPoolReviveTimeout(opts.reviveTimeout),
+		WithPoolSelector(opts.selector),
 	}
 
-	// we need at least one host to load balance
-	if len(l.active) == 0 {
-		return nil, errors.New("load_balancer: at least one host must be provided")
+	if opts.healthCheck != nil {
+		poolOpts = append(poolOpts, WithPoolHealthCheck(*opts.healthCheck))
 	}
 
-	opts := &LBOption{}
-	for _, opt := range options {
-		opt(opts)
+	pool, err := NewPool(hosts, poolOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("load_balancer: %w", err)
 	}
 
-	if opts.selector == nil {
-		// if the option to set a Selector is nil, use the round-robin selector as the default
-		l.selector = &roundRobinSelector{current: -1}
+	if opts.limiter != nil {
+		for _, h := range pool.Hosts() {
+			// hosts shared with a previous Balancer keep their limiter, retaining the limit observed so far
+			if h.Limiter() == nil {
+				h.setLimiter(opts.limiter())
+			}
+		}
 	}
+
+	l := &balancer{
+		pool:               pool,
+		upgradeIdleTimeout: opts.upgradeIdleTimeout,
+		upgradeMaxLifetime: opts.upgradeMaxLifetime,
+	}
+	l.handler = chain(http.HandlerFunc(l.serveHTTP), opts.middleware...)
 	log.Debug(fmt.Sprintf("[proxy:balancer]: \n%s", l))
 	return l, nil
 }
 
 // ServeHTTP performs the HTTP request using one of the active Host proxies of the Balancer.
 func (b *balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
+	b.handler.ServeHTTP(w, r)
+}
 
-	if len(b.active) > 0 {
-		h, err := b.selector.Select(b.active...)
-		if err != nil {
-			http.Error(w, "Service not available", http.StatusServiceUnavailable)
-			return
-		}
-		h.serveHTTP(w, r)
+// serveHTTP performs the HTTP request using the selected Host proxy after the Middleware for the Balancer has been
+// applied.
+func (b *balancer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	h, err := b.pool.Select()
+	if err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Service not available")
+		return
+	}
+
+	// upgraded connections are long-lived, so they are excluded from the round-trip times observed by the Limiter
+	upgrade := isUpgrade(r)
+	if !upgrade && !h.acquire() {
+		log.Debug("[proxy:balancer] concurrency limit exceeded", log.String("target", h.target.String()))
+		writeError(w, r, http.StatusServiceUnavailable, "Service not available")
 		return
 	}
-	http.Error(w, "Service not available", http.StatusServiceUnavailable)
+
+	sw := &statusWriter{ResponseWriter: w}
+	var rw http.ResponseWriter = sw
+	if upgrade {
+		rw = &upgradeWriter{
+			ResponseWriter: sw,
+			host:           h,
+			idleTimeout:    b.upgradeIdleTimeout,
+			maxLifetime:    b.upgradeMaxLifetime,
+			websocket:      strings.EqualFold(r.Header.Get("Upgrade"), "websocket"),
+		}
+	}
+
+	// the capacity is returned even if the handler is aborted by panicking with http.ErrAbortHandler, e.g. when the
+	// client disconnects while the response body is being copied
+	h.inflight.Add(1)
+	start := time.Now()
+	defer func() {
+		h.inflight.Add(-1)
+		if !upgrade {
+			h.release(time.Since(start), sw.dropped())
+		}
+	}()
+	h.serveHTTP(rw, r)
+
+	if sw.dropped() {
+		b.pool.MarkFailed(h)
+	} else if r.Context().Err() == nil {
+		b.pool.MarkHealthy(h)
+	}
+}
+
+// Close stops active health checking for the Balancer, if enabled.
+func (b *balancer) Close() error {
+	return b.pool.Close()
+}
+
+// Pool returns the Pool of Host proxies for the Balancer.
+func (b *balancer) Pool() *Pool {
+	return b.pool
 }
 
 // Targets returns the list of URLs of available Host proxies for the Balancer.
 func (b *balancer) Targets() ([]*url.URL, error) {
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
 	var t []*url.URL
-	for _, h := range b.active {
+	for _, h := range b.pool.Active() {
 		a, err := h.Target()
 		if err != nil {
 			return t, err
@@ -130,32 +236,20 @@ func (b *balancer) String() string {
 
 // toMap returns a map representing the balancer attributes.
 func (b *balancer) toMap() map[string]any {
-	b.mutex.RLock()
-	defer b.mutex.RUnlock()
 	m := make(map[string]any)
+	m["pool"] = b.pool.toMap()
+	return m
+}
 
-	var activeHosts []map[string]any
-	for _, h := range b.active {
-		activeHosts = append(activeHosts, h.toMap())
-	}
-
-	var inactiveHosts []map[string]any
-	for _, h := range b.inactive {
-		inactiveHosts = append(inactiveHosts, h.toMap())
-	}
-
-	m["pool"] = map[string]any{
-		"hosts": len(b.active) + len(b.inactive),
-		"active": map[string]any{
-			"count": len(activeHosts),
-			"hosts": activeHosts,
-		},
-		"inactive": map[string]any{
-			"count": len(inactiveHosts),
-			"hosts": inactiveHosts,
-		},
+// chain wraps the provided http.Handler with the list of Middleware so that the first Middleware in the list is the
+// outermost handler.
+func chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		if middleware[i] != nil {
+			h = middleware[i](h)
+		}
 	}
-	return m
+	return h
 }
 
 func writeStatus(w http.ResponseWriter, sc int) (string, error) {
@@ -173,3 +267,44 @@ func writeStatus(w http.ResponseWriter, sc int) (string, error) {
 	}
 	return st, nil
 }
+
+// statusWriter wraps a http.ResponseWriter for capturing the status code and number of bytes written by a Host proxy.
+type statusWriter struct {
+	http.ResponseWriter
+	bytes      int64
+	grpcStatus string
+	status     int
+}
+
+// WriteHeader records the status code, and the gRPC status of trailers-only responses, and writes it to the underlying
+// http.ResponseWriter.
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.grpcStatus = w.Header().Get(headerGRPCStatus)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the data to the underlying http.ResponseWriter, recording an implicit http.StatusOK.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// dropped returns whether the written status indicates the upstream could not handle the request.
+func (w *statusWriter) dropped() bool {
+	return w.status == http.StatusBadGateway ||
+		w.status == http.StatusServiceUnavailable ||
+		w.status == http.StatusGatewayTimeout ||
+		w.grpcStatus == strconv.Itoa(int(codes.Unavailable))
+}
@@ -1,10 +1,14 @@
 package proxy
 
 import (
+	"context"
+	"io"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/transientvariable/log-go"
+	"github.com/transientvariable/anchor/net/http/proxy/proxytest"
 
 	"github.com/stretchr/testify/assert"
 
@@ -12,44 +16,345 @@ import (
 )
 
 func TestBalancer(t *testing.T) {
-	t.Skip("TODO: complete testing :)")
+	c, err := proxytest.NewCluster(2, proxytest.WithSeed(1))
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h)
+	assert.NoError(t, err)
+
+	targets, err := b.Targets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2)
+
+	for _, key := range "abc" {
+		w := serve(b, "/foo/bar?key="+string(key))
+		assert.Equal(t, gohttp.StatusOK, w.Code)
+	}
+	c.AssertSequence(t, 0, 1, 0)
+
+	for _, r := range c.Records() {
+		assert.Equal(t, "/foo/bar", r.Path)
+	}
+}
+
+func TestBalancerWithSelector(t *testing.T) {
+	c, err := proxytest.NewCluster(3)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h, WithSelector(selectorFunc(func(hosts ...*Host) (*Host, error) {
+		return hosts[2], nil
+	})))
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, gohttp.StatusOK, serve(b, "/").Code)
+	}
+	c.AssertServedBy(t, 2, 5)
+	c.AssertNotServedBy(t, 0, 1)
+}
+
+func TestBalancerWithLimiter(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	c, err := proxytest.NewCluster(1, proxytest.WithBehavior(proxytest.Behavior{
+		Handler: func(w gohttp.ResponseWriter, r *gohttp.Request) {
+			received <- struct{}{}
+			<-release
+		},
+	}))
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h, WithLimiter(func() Limiter {
+		return NewAIMDLimiter(WithInitialLimit(1), WithMaxLimit(1))
+	}))
+	assert.NoError(t, err)
+
+	// the second request is sent once the upstream is serving the first one, so it exceeds the limit
+	done := make(chan int)
+	go func() {
+		done <- serve(b, "/").Code
+	}()
+	<-received
+
+	assert.Equal(t, gohttp.StatusServiceUnavailable, serve(b, "/").Code)
+	close(release)
+	assert.Equal(t, gohttp.StatusOK, <-done)
+	c.AssertServedBy(t, 0, 1)
+}
+
+func TestBalancerWithLimiterClientAbort(t *testing.T) {
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.URL.Path == "/stream" {
+			_, _ = io.WriteString(w, "partial")
+			w.(gohttp.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	h, err := prepareHosts(upstream.URL)
+	assert.NoError(t, err)
+
+	limiter := NewAIMDLimiter(WithInitialLimit(1), WithMaxLimit(1))
+	b, err := NewBalancer(h, WithLimiter(func() Limiter { return limiter }))
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	// the client disconnects mid-body, so the ReverseProxy aborts the handler by panicking with http.ErrAbortHandler
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := gohttp.NewRequestWithContext(ctx, gohttp.MethodGet, srv.URL+"/stream", nil)
+	assert.NoError(t, err)
+	resp, err := srv.Client().Do(req)
+	assert.NoError(t, err)
+	_, err = io.ReadFull(resp.Body, make([]byte, len("partial")))
+	assert.NoError(t, err)
+	cancel()
+	_ = resp.Body.Close()
+
+	assert.Eventually(t, func() bool {
+		return h[0].Inflight() == 0 && limiter.Inflight() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	resp, err = srv.Client().Get(srv.URL + "/")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+}
+
+func TestBalancerUpstreamDown(t *testing.T) {
+	c, err := proxytest.NewCluster(2)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h)
+	assert.NoError(t, err)
+
+	c.Upstream(1).Down()
+	assert.Equal(t, gohttp.StatusOK, serve(b, "/").Code)
+	assert.Equal(t, gohttp.StatusBadGateway, serve(b, "/").Code)
+
+	assert.NoError(t, c.Upstream(1).Up())
+	assert.Equal(t, gohttp.StatusOK, serve(b, "/").Code)
+	assert.Equal(t, gohttp.StatusOK, serve(b, "/").Code)
+	c.AssertSequence(t, 0, 0, 1)
+}
+
+func TestBalancerLeastConnections(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	c, err := proxytest.NewCluster(2, proxytest.WithBehavior(proxytest.Behavior{
+		Handler: func(w gohttp.ResponseWriter, r *gohttp.Request) {
+			if r.URL.Path == "/hold" {
+				received <- struct{}{}
+				<-release
+			}
+			_, _ = io.WriteString(w, "ok")
+		},
+	}))
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
 
-	h1 := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
-		log.Info("[proxy:test] server 1", log.String("url", r.URL.String()))
+	b, err := NewBalancer(h, WithSelector(NewLeastConnectionsSelector()))
+	assert.NoError(t, err)
+
+	// requests are sent to the other upstream while a request is held in flight
+	done := make(chan int)
+	go func() {
+		done <- serve(b, "/hold").Code
+	}()
+	<-received
+	held := c.Sequence()[0]
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, gohttp.StatusOK, serve(b, "/").Code)
+	}
+	c.AssertServedBy(t, held, 1)
+	c.AssertServedBy(t, 1-held, 10)
+
+	close(release)
+	assert.Equal(t, gohttp.StatusOK, <-done)
+
+	// ties are broken in a round-robin manner
+	c.Reset()
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, gohttp.StatusOK, serve(b, "/").Code)
+	}
+	c.AssertEven(t, 0)
+}
+
+func TestBalancerLeastConnectionsLatency(t *testing.T) {
+	c, err := proxytest.NewCluster(3, proxytest.WithSeed(1), proxytest.WithBehavior(proxytest.Behavior{
+		Latency: proxytest.UniformLatency(0, 2*time.Millisecond),
+	}))
+	assert.NoError(t, err)
+	defer c.Close()
+	c.Upstream(0).SetBehavior(proxytest.Behavior{Latency: proxytest.NormalLatency(50*time.Millisecond, 5*time.Millisecond)})
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h, WithSelector(NewLeastConnectionsSelector()))
+	assert.NoError(t, err)
+
+	// requests to the slow upstream stay in flight for longer, so it is selected less often
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				assert.Equal(t, gohttp.StatusOK, serve(b, "/").Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.AssertDistribution(t, 0.2, 0, 1, 1)
+	counts := c.Counts()
+	assert.Less(t, counts[0], counts[1])
+	assert.Less(t, counts[0], counts[2])
+}
+
+func TestBalancerRandom(t *testing.T) {
+	c, err := proxytest.NewCluster(3)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h, WithSelector(newSelector(SelectorRandom)))
+	assert.NoError(t, err)
+
+	for i := 0; i < 600; i++ {
+		assert.Equal(t, gohttp.StatusOK, serve(b, "/").Code)
+	}
+	c.AssertEven(t, 0.1)
+}
+
+func TestBalancerErrorRate(t *testing.T) {
+	c, err := proxytest.NewCluster(2)
+	assert.NoError(t, err)
+	defer c.Close()
+	c.Upstream(1).SetBehavior(proxytest.Behavior{ErrorRate: 1, ErrorStatus: gohttp.StatusServiceUnavailable})
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h, WithReviveTimeout(time.Minute, 1))
+	assert.NoError(t, err)
+
+	// the failing upstream is ejected after its first failure
+	var codes []int
+	for i := 0; i < 6; i++ {
+		codes = append(codes, serve(b, "/").Code)
+	}
+	assert.Equal(t, []int{
+		gohttp.StatusOK,
+		gohttp.StatusServiceUnavailable,
+		gohttp.StatusOK,
+		gohttp.StatusOK,
+		gohttp.StatusOK,
+		gohttp.StatusOK,
+	}, codes)
+	c.AssertSequence(t, 0, 1, 0, 0, 0, 0)
+	assert.Len(t, b.Pool().Active(), 1)
+}
+
+func TestBalancerHealthCheck(t *testing.T) {
+	c, err := proxytest.NewCluster(2)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h, WithHealthCheck(HealthCheck{
+		HealthyThreshold:   1,
+		Interval:           10 * time.Millisecond,
+		Path:               "/health",
+		Timeout:            time.Second,
+		UnhealthyThreshold: 1,
 	}))
-	defer h1.Close()
+	assert.NoError(t, err)
+	defer b.Close()
 
-	h2 := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
-		log.Info("[proxy:test] server 2", log.String("url", r.URL.String()))
+	// upstreams failing their health check are not selected until they recover
+	c.Upstream(1).SetBehavior(proxytest.Behavior{ErrorRate: 1})
+	assert.Eventually(t, func() bool { return len(b.Pool().Active()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, gohttp.StatusOK, serve(b, "/api").Code)
+	}
+
+	var served []int
+	for _, r := range c.Records() {
+		if r.Path == "/api" {
+			served = append(served, r.Upstream)
+		}
+	}
+	assert.Equal(t, []int{0, 0, 0, 0}, served)
+
+	c.Upstream(1).SetBehavior(proxytest.Behavior{})
+	assert.Eventually(t, func() bool { return len(b.Pool().Active()) == 2 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestBalancerSlowBody(t *testing.T) {
+	c, err := proxytest.NewCluster(1, proxytest.WithBehavior(proxytest.Behavior{
+		Body:       "0123456789",
+		BodyChunks: 5,
+		BodyDelay:  20 * time.Millisecond,
 	}))
-	defer h2.Close()
-
-	h, err := prepareHosts(h1.URL, h2.URL)
-	assert.NoError(t, err)
-
-	_, err = NewBalancer(h)
-	assert.NoError(t, err)
-
-	//client := http.DefaultClient()
-	//keys := "abc"
-	//for _, key := range keys {
-	//	req, err := gohttp.NewRequest("GET", "http://replace.me/foo/bar?key="+string(key), nil)
-	//	assert.NoError(t, err)
-	//
-	//	resp, err := client.Do(req)
-	//	assert.NoError(t, err)
-	//
-	//	_, err = io.Copy(os.Stdout, resp.Body)
-	//	assert.NoError(t, err)
-	//
-	//	err := resp.Body.Close()
-	//	assert.NoError(t, err)
-	//}
-
-	// Output:
-	// A: /foo/bar?key=a
-	// B: /foo/bar?key=b
-	// A: /foo/bar?key=c
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h, err := prepareHosts(c.URLs()...)
+	assert.NoError(t, err)
+
+	b, err := NewBalancer(h)
+	assert.NoError(t, err)
+
+	// bodies written in chunks are relayed in full
+	start := time.Now()
+	w := serve(b, "/")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+}
+
+type selectorFunc func(...*Host) (*Host, error)
+
+func (f selectorFunc) Select(hosts ...*Host) (*Host, error) {
+	return f(hosts...)
+}
+
+func serve(h gohttp.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(gohttp.MethodGet, target, nil))
+	_, _ = io.Copy(io.Discard, w.Result().Body)
+	return w
 }
 
 func prepareHosts(targets ...string) ([]*Host, error) {
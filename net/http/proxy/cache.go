@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/cache"
+	"github.com/transientvariable/log-go"
+)
+
+const (
+	headerAge         = "Age"
+	headerCacheStatus = "Cache-Status"
+	headerRange       = "Range"
+)
+
+// Cache returns a Middleware that serves responses from a shared cache, as defined by RFC 9111, using the provided
+// cache.Store and the cache.Policy options.
+//
+// Responses to GET requests are stored according to their Cache-Control, Expires, ETag and Last-Modified headers. Stale
+// responses are revalidated using If-None-Match and If-Modified-Since, and are served while being revalidated in the
+// background or when the upstream fails if allowed by the stale-while-revalidate and stale-if-error directives.
+// Concurrent misses for the same response are collapsed into a single upstream request. Successful requests using
+// unsafe methods invalidate the stored response for the same URL.
+//
+// The outcome for each request is reported using the Cache-Status header defined by RFC 9211.
+func Cache(store cache.Store, options ...func(*cache.Option)) Middleware {
+	policy := cache.NewPolicy(store, true, options...)
+	return func(next http.Handler) http.Handler {
+		c := &responseCache{
+			next:   next,
+			policy: policy,
+		}
+		return http.HandlerFunc(c.serveHTTP)
+	}
+}
+
+// responseCache is the state of the Cache Middleware for a http.Handler.
+type responseCache struct {
+	group  cache.Group[*cacheResult]
+	next   http.Handler
+	policy *cache.Policy
+}
+
+// cacheResult is the outcome of forwarding a request to the upstream.
+type cacheResult struct {
+	// entry is the Entry to serve, or nil if the response has already been written by the caller that forwarded the
+	// request.
+	entry *cache.Entry
+
+	// status is the Cache-Status parameters describing the outcome.
+	status string
+}
+
+func (c *responseCache) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if isUpgrade(r) {
+		c.bypass(w, r, "fwd=bypass")
+		return
+	}
+
+	l := c.policy.Lookup(r, time.Now())
+	switch l.Action {
+	case cache.ActionInvalidate:
+		sw := &statusWriter{ResponseWriter: w}
+		c.next.ServeHTTP(sw, r)
+		c.policy.Invalidate(r, sw.status)
+		return
+	case cache.ActionBypass:
+		c.bypass(w, r, "fwd=bypass")
+		return
+	case cache.ActionHit:
+		c.serve(w, r, l.Entry, "hit")
+		return
+	case cache.ActionHitStale:
+		c.serve(w, r, l.Entry, "hit")
+		c.revalidate(l.Key, r, l.Entry)
+		return
+	case cache.ActionUnsatisfiable:
+		w.Header().Set(headerCacheStatus, c.policy.Status("fwd=miss"))
+		http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+		return
+	}
+
+	res, shared, err := c.group.Do(r.Method+" "+l.Key, func() (*cacheResult, error) {
+		return c.forward(w, r, l.Key, l.Entry), nil
+	})
+
+	switch {
+	case err != nil || (shared && (res.entry == nil || !res.entry.Matches(r))):
+		// the response for the collapsed request could not be shared, so the request is forwarded separately
+		c.bypass(w, r, "fwd=miss")
+	case res.entry != nil && shared:
+		c.serve(w, r, res.entry, res.status+"; collapsed")
+	case res.entry != nil:
+		c.serve(w, r, res.entry, res.status)
+	}
+}
+
+// forward forwards the request to the upstream, revalidating the stale Entry if provided. The response is written to
+// the http.ResponseWriter if it cannot be stored, otherwise it is returned in the cacheResult for serving it to all
+// the callers of a collapsed request.
+func (c *responseCache) forward(w http.ResponseWriter, r *http.Request, key string, stale *cache.Entry) *cacheResult {
+	req, conditional := c.policy.Conditional(r, stale)
+
+	now := time.Now()
+	cw := &cacheWriter{
+		dispose: func(status int, header http.Header) cache.Disposition {
+			return c.policy.Dispose(req, stale, conditional, status, header, now)
+		},
+		header:       make(http.Header),
+		maxEntrySize: c.policy.MaxEntrySize(),
+		w:            w,
+	}
+
+	c.next.ServeHTTP(cw, req)
+	if cw.mode == cacheWriterPending {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.buffered() {
+		return &cacheResult{}
+	}
+	responseTime := time.Now()
+
+	switch cw.disposition {
+	case cache.DispositionRevalidated:
+		e := c.policy.Revalidated(key, stale, cw.header, now, responseTime)
+		return &cacheResult{entry: e, status: cache.Forwarded(true, cw.status)}
+	case cache.DispositionStale:
+		log.Debug("[proxy:cache] serving stale response on upstream error",
+			log.String("key", key),
+			log.Int("status", cw.status))
+		return &cacheResult{entry: stale, status: cache.Forwarded(true, cw.status)}
+	}
+
+	e, stored := c.policy.Store(key, req, cw.status, cw.header, bytes.Clone(cw.body.Bytes()), now, responseTime)
+	status := cache.Forwarded(stale != nil, cw.status)
+	if stored {
+		status += "; stored"
+	}
+	return &cacheResult{entry: e, status: status}
+}
+
+// revalidate revalidates the stale Entry in the background. The request is cloned before the revalidation is started,
+// since it must not be used once the handler has returned.
+func (c *responseCache) revalidate(key string, r *http.Request, stale *cache.Entry) {
+	req, cancel := c.policy.Revalidation(r)
+	go func() {
+		defer cancel()
+		defer func() {
+			// the upstream response could not be copied, e.g. when the connection is reset
+			if v := recover(); v != nil && v != http.ErrAbortHandler {
+				panic(v)
+			}
+		}()
+
+		_, _, _ = c.group.Do(req.Method+" "+key, func() (*cacheResult, error) {
+			return c.forward(nil, req, key, stale), nil
+		})
+	}()
+}
+
+// bypass forwards the request without using the cache.
+func (c *responseCache) bypass(w http.ResponseWriter, r *http.Request, status string) {
+	w.Header().Set(headerCacheStatus, c.policy.Status(status))
+	c.next.ServeHTTP(w, r)
+}
+
+// serve writes the Entry as the response to the request.
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, e *cache.Entry, status string) {
+	code, header, body := c.policy.Response(r, e, status, time.Now())
+
+	h := w.Header()
+	for name, values := range header {
+		h[name] = values
+	}
+
+	w.WriteHeader(code)
+	if len(body) > 0 {
+		if _, err := w.Write(body); err != nil {
+			log.Debug("[proxy:cache] could not write cached response", log.Err(err))
+		}
+	}
+}
+
+// cacheWriter is a http.ResponseWriter that buffers the response from the upstream when it can be stored or is needed
+// for revalidation, and otherwise writes it through to the client. Without a client, e.g. when revalidating in the
+// background, responses that are not buffered are discarded.
+type cacheWriter struct {
+	body         bytes.Buffer
+	dispose      func(status int, header http.Header) cache.Disposition
+	disposition  cache.Disposition
+	header       http.Header
+	maxEntrySize int64
+	mode         cacheWriterMode
+	status       int
+	w            http.ResponseWriter
+}
+
+type cacheWriterMode int
+
+const (
+	cacheWriterPending cacheWriterMode = iota
+	cacheWriterBuffering
+	cacheWriterPassing
+)
+
+// Header returns the header of the response.
+func (cw *cacheWriter) Header() http.Header {
+	if cw.mode == cacheWriterPassing && cw.w != nil {
+		return cw.w.Header()
+	}
+	return cw.header
+}
+
+// WriteHeader decides whether the response is buffered or written through to the client. Informational responses are
+// not forwarded.
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.mode != cacheWriterPending || status < http.StatusOK {
+		return
+	}
+	cw.status = status
+
+	cw.disposition = cw.dispose(status, cw.header)
+	if cw.disposition == cache.DispositionPass {
+		cw.pass()
+		return
+	}
+	cw.mode = cacheWriterBuffering
+}
+
+// Write buffers the data, or writes it through to the client once the response cannot be stored.
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.mode == cacheWriterPending {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.mode == cacheWriterBuffering {
+		if int64(cw.body.Len()+len(b)) <= cw.maxEntrySize {
+			return cw.body.Write(b)
+		}
+
+		// error responses are discarded regardless of their size in favor of the stale response
+		if cw.disposition == cache.DispositionStale {
+			return len(b), nil
+		}
+
+		buffered := cw.body.Bytes()
+		cw.body = bytes.Buffer{}
+		cw.pass()
+		if len(buffered) > 0 && cw.w != nil {
+			if _, err := cw.w.Write(buffered); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if cw.w == nil {
+		return len(b), nil
+	}
+	return cw.w.Write(b)
+}
+
+// Flush flushes the response written through to the client.
+func (cw *cacheWriter) Flush() {
+	if cw.mode == cacheWriterPassing && cw.w != nil {
+		_ = http.NewResponseController(cw.w).Flush()
+	}
+}
+
+// buffered returns whether the complete response has been buffered.
+func (cw *cacheWriter) buffered() bool {
+	return cw.mode == cacheWriterBuffering
+}
+
+// pass writes the response through to the client.
+func (cw *cacheWriter) pass() {
+	cw.mode = cacheWriterPassing
+	if cw.w == nil {
+		return
+	}
+
+	h := cw.w.Header()
+	for name, values := range cw.header {
+		h[name] = values
+	}
+	cw.w.WriteHeader(cw.status)
+}
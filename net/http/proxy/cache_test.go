@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/cache"
+
+	"github.com/stretchr/testify/assert"
+
+	gohttp "net/http"
+)
+
+func TestCache(t *testing.T) {
+	var (
+		failing  atomic.Bool
+		hits     sync.Map
+		release  = make(chan struct{})
+		revalids atomic.Int64
+		tenant   atomic.Value
+	)
+
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		n, _ := hits.LoadOrStore(r.URL.Path, new(atomic.Int64))
+		n.(*atomic.Int64).Add(1)
+
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"v1"`)
+		case "/collapsed":
+			<-release
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/revalidate":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				revalids.Add(1)
+				w.WriteHeader(gohttp.StatusNotModified)
+				return
+			}
+		case "/swr":
+			tenant.Store(r.Header.Get("X-Tenant"))
+			w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		case "/sie":
+			if failing.Load() {
+				gohttp.Error(w, "unavailable", gohttp.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		case "/large":
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = io.WriteString(w, strings.Repeat("a", 64))
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	count := func(path string) int64 {
+		if n, ok := hits.Load(path); ok {
+			return n.(*atomic.Int64).Load()
+		}
+		return 0
+	}
+
+	h, err := NewHost(upstream.URL)
+	assert.NoError(t, err)
+	store := cache.NewMemoryStore(0)
+	b, err := NewBalancer([]*Host{h}, WithMiddleware(Cache(store, cache.WithMaxEntrySize(32))))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(gohttp.MethodGet, "http://example.com"+path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/fresh")
+	assert.Equal(t, "/fresh", w.Body.String())
+	assert.Equal(t, "anchor; fwd=miss; fwd-status=200; stored", w.Header().Get(headerCacheStatus))
+
+	w = get("/fresh")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, "/fresh", w.Body.String())
+	assert.Regexp(t, `^anchor; hit; ttl=(59|60)$`, w.Header().Get(headerCacheStatus))
+	assert.Equal(t, "0", w.Header().Get(headerAge))
+
+	w = get("/fresh", "If-None-Match", `"v1"`)
+	assert.Equal(t, gohttp.StatusNotModified, w.Code)
+
+	w = get("/fresh", "Cache-Control", "no-store")
+	assert.Equal(t, "anchor; fwd=bypass", w.Header().Get(headerCacheStatus))
+	assert.Equal(t, int64(2), count("/fresh"))
+
+	// concurrent misses are collapsed into a single request
+	var wg sync.WaitGroup
+	statuses := make([]string, 5)
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := get("/collapsed")
+			assert.Equal(t, "/collapsed", w.Body.String())
+			statuses[i] = w.Header().Get(headerCacheStatus)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), count("/collapsed"))
+	var collapsed int
+	for _, s := range statuses {
+		if strings.HasSuffix(s, "; collapsed") {
+			collapsed++
+		}
+	}
+	assert.Equal(t, 4, collapsed)
+
+	// stale responses are revalidated
+	get("/revalidate")
+	w = get("/revalidate")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, "/revalidate", w.Body.String())
+	assert.Equal(t, "anchor; fwd=stale; fwd-status=304", w.Header().Get(headerCacheStatus))
+	assert.Equal(t, int64(1), revalids.Load())
+
+	// stale responses are served while being revalidated in the background
+	get("/swr")
+	w = get("/swr")
+	assert.Equal(t, "/swr", w.Body.String())
+	assert.True(t, strings.HasPrefix(w.Header().Get(headerCacheStatus), "anchor; hit"))
+	assert.Eventually(t, func() bool { return count("/swr") == 2 }, time.Second, 10*time.Millisecond)
+
+	// the request is revalidated as it was received, even if it is changed once the handler has returned
+	r := httptest.NewRequest(gohttp.MethodGet, "http://example.com/swr", nil)
+	r.Header.Set("X-Tenant", "a")
+	b.ServeHTTP(httptest.NewRecorder(), r)
+	r.Header.Set("X-Tenant", "b")
+	assert.Eventually(t, func() bool { return count("/swr") == 3 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "a", tenant.Load())
+
+	// stale responses are served when the upstream fails
+	get("/sie")
+	failing.Store(true)
+	w = get("/sie")
+	assert.Equal(t, gohttp.StatusOK, w.Code)
+	assert.Equal(t, "/sie", w.Body.String())
+	assert.Equal(t, "anchor; fwd=stale; fwd-status=503", w.Header().Get(headerCacheStatus))
+
+	// responses exceeding the maximum entry size and private responses are not stored
+	for _, path := range []string{"/large", "/private"} {
+		get(path)
+		w = get(path)
+		assert.True(t, strings.HasSuffix(w.Body.String(), path))
+		assert.Equal(t, int64(2), count(path))
+	}
+
+	// unsafe methods invalidate the stored response
+	r = httptest.NewRequest(gohttp.MethodPost, "http://example.com/fresh", nil)
+	b.ServeHTTP(httptest.NewRecorder(), r)
+	_, err = store.Get("http://example.com/fresh")
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	w = get("/fresh", "Cache-Control", "only-if-cached")
+	assert.Equal(t, gohttp.StatusGatewayTimeout, w.Code)
+}
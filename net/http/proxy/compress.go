@@ -0,0 +1,505 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+)
+
+// CompressMinSize is the default minimum size of a response body for it to be compressed.
+const CompressMinSize = 1 * anchor.KiB
+
+// Enumeration of content codings supported by the Compress Middleware without additional encoders.
+const (
+	EncodingDeflate  = "deflate"
+	EncodingGzip     = "gzip"
+	EncodingIdentity = "identity"
+)
+
+const (
+	headerAcceptEncoding  = "Accept-Encoding"
+	headerAcceptRanges    = "Accept-Ranges"
+	headerContentEncoding = "Content-Encoding"
+	headerContentLength   = "Content-Length"
+	headerContentRange    = "Content-Range"
+	headerETag            = "ETag"
+	headerVary            = "Vary"
+)
+
+// CompressContentTypes is the default list of media types of responses that are compressed.
+var CompressContentTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/wasm",
+	"application/xml",
+	"application/*+json",
+	"application/*+xml",
+	"image/svg+xml",
+	"text/*",
+}
+
+// Encoder defines the behavior for compressing response bodies using a content coding.
+//
+// Encoders for codings not provided by the standard library, e.g. brotli ("br") or zstd ("zstd"), can be created using
+// NewEncoder.
+type Encoder interface {
+	// Encoding returns the name of the content coding, as used in the Accept-Encoding and Content-Encoding headers.
+	Encoding() string
+
+	// NewWriter returns a writer compressing the data written to it to w. The writer is closed once the response has
+	// been written, and may implement Flush() error for flushing buffered data.
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+// NewEncoder creates a new Encoder for the content coding using the provided function for creating writers.
+func NewEncoder(encoding string, newWriter func(io.Writer) io.WriteCloser) Encoder {
+	return &encoderFunc{encoding: strings.ToLower(encoding), newWriter: newWriter}
+}
+
+// NewGzipEncoder creates a new Encoder for the gzip content coding using the compression level, e.g.
+// gzip.DefaultCompression.
+func NewGzipEncoder(level int) (Encoder, error) {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, fmt.Errorf("proxy_compress: %w", err)
+	}
+
+	pool := &sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}}
+	return NewEncoder(EncodingGzip, func(w io.Writer) io.WriteCloser {
+		gw := pool.Get().(*gzip.Writer)
+		gw.Reset(w)
+		return &pooledWriter{pool: pool, writer: gw}
+	}), nil
+}
+
+// NewDeflateEncoder creates a new Encoder for the deflate content coding using the compression level, e.g.
+// flate.DefaultCompression. As defined by RFC 9110, the deflate coding uses the zlib format.
+func NewDeflateEncoder(level int) (Encoder, error) {
+	if _, err := zlib.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, fmt.Errorf("proxy_compress: %w", err)
+	}
+
+	pool := &sync.Pool{New: func() any {
+		w, _ := zlib.NewWriterLevel(io.Discard, level)
+		return w
+	}}
+	return NewEncoder(EncodingDeflate, func(w io.Writer) io.WriteCloser {
+		zw := pool.Get().(*zlib.Writer)
+		zw.Reset(w)
+		return &pooledWriter{pool: pool, writer: zw}
+	}), nil
+}
+
+// CompressOption is a container for optional properties that can be used for initializing the Compress Middleware.
+type CompressOption struct {
+	contentTypes []string
+	encoders     []Encoder
+	minSize      int64
+}
+
+// WithCompressContentTypes sets the list of media types of responses that are compressed. A media type can use a
+// wildcard for the subtype, e.g. "text/*", or for the part of the subtype before a structured syntax suffix, e.g.
+// "application/*+json".
+func WithCompressContentTypes(contentTypes ...string) func(*CompressOption) {
+	return func(o *CompressOption) {
+		o.contentTypes = contentTypes
+	}
+}
+
+// WithCompressEncoders sets the list of Encoders used for compressing responses. When a client accepts several of
+// them with the same preference, the first Encoder in the list is used. Defaults to gzip and deflate.
+func WithCompressEncoders(encoders ...Encoder) func(*CompressOption) {
+	return func(o *CompressOption) {
+		o.encoders = encoders
+	}
+}
+
+// WithCompressMinSize sets the minimum size of a response body for it to be compressed.
+func WithCompressMinSize(size int64) func(*CompressOption) {
+	return func(o *CompressOption) {
+		o.minSize = size
+	}
+}
+
+// String returns a string representation of the CompressOption.
+func (o *CompressOption) String() string {
+	var encodings []string
+	for _, e := range o.encoders {
+		encodings = append(encodings, e.Encoding())
+	}
+
+	options := make(map[string]any)
+	options["content_types"] = o.contentTypes
+	options["encoders"] = encodings
+	options["min_size"] = o.minSize
+	return string(anchor.ToJSONFormatted(options))
+}
+
+// Compress returns a Middleware that compresses responses using the content coding preferred by the client, as
+// indicated by the quality values of the Accept-Encoding header.
+//
+// Responses are only compressed if their media type is allowed and their body is at least the minimum size. Responses
+// that already have a content coding, partial responses, and responses with the no-transform cache directive are not
+// compressed. The Vary header of eligible responses includes Accept-Encoding, and the ETag of compressed responses is
+// weakened, since the compressed representation is not byte-for-byte identical to the upstream response.
+//
+// When combined with the Cache Middleware, Compress should precede it in the list of Middleware, so that responses are
+// stored uncompressed and compressed for each client.
+func Compress(options ...func(*CompressOption)) Middleware {
+	opts := &CompressOption{
+		contentTypes: CompressContentTypes,
+		minSize:      CompressMinSize,
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	if opts.encoders == nil {
+		gz, _ := NewGzipEncoder(gzip.DefaultCompression)
+		deflate, _ := NewDeflateEncoder(flate.DefaultCompression)
+		opts.encoders = []Encoder{gz, deflate}
+	}
+	log.Debug(fmt.Sprintf("[proxy:compress] options:\n%s", opts))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isUpgrade(r) || r.Header.Get(headerRange) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoder:        negotiateEncoder(r.Header.Values(headerAcceptEncoding), opts.encoders),
+				head:           r.Method == http.MethodHead,
+				options:        opts,
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoder returns the Encoder with the highest quality value in the Accept-Encoding header values, or nil if
+// none of the Encoders are acceptable.
+func negotiateEncoder(values []string, encoders []Encoder) Encoder {
+	if len(values) == 0 {
+		return nil
+	}
+
+	qvalues := make(map[string]float64)
+	for _, v := range values {
+		for _, member := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(member, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+
+			q := 1.0
+			for _, p := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(p, "=")
+				if strings.EqualFold(strings.TrimSpace(name), "q") {
+					if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && f >= 0 && f <= 1 {
+						q = f
+					} else {
+						q = 0
+					}
+				}
+			}
+
+			if _, ok := qvalues[coding]; !ok {
+				qvalues[coding] = q
+			}
+		}
+	}
+
+	var (
+		best  Encoder
+		bestQ float64
+	)
+	for _, e := range encoders {
+		q, ok := qvalues[e.Encoding()]
+		if !ok {
+			q = qvalues["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// compressWriter wraps a http.ResponseWriter, compressing the response body once the response is known to be eligible
+// for compression. Bodies without a Content-Length are buffered until the minimum size has been reached.
+type compressWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	encoder     Encoder
+	head        bool
+	options     *CompressOption
+	status      int
+	state       compressState
+	wroteHeader bool
+	writer      io.WriteCloser
+}
+
+type compressState int
+
+const (
+	compressPending compressState = iota
+	compressBuffering
+	compressEncoding
+	compressPassing
+)
+
+// WriteHeader determines whether the response is eligible for compression, writing the header unless the response
+// body must be buffered first.
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.state != compressPending {
+		return
+	}
+
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+
+	if !cw.eligible() {
+		cw.pass()
+		return
+	}
+
+	// the response varies on Accept-Encoding regardless of whether this particular request is compressed
+	addVary(cw.Header(), headerAcceptEncoding)
+	if cw.encoder == nil || cw.head {
+		cw.pass()
+		return
+	}
+
+	if cl := cw.Header().Get(headerContentLength); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n < cw.options.minSize {
+			cw.pass()
+			return
+		}
+		cw.encode()
+		return
+	}
+	cw.state = compressBuffering
+}
+
+// Write writes the data to the response, compressing it if the response is eligible for compression.
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.state == compressPending {
+		if cw.Header().Get(headerContentType) == "" {
+			cw.Header().Set(headerContentType, http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	switch cw.state {
+	case compressBuffering:
+		n, _ := cw.buf.Write(b)
+		if int64(cw.buf.Len()) >= cw.options.minSize {
+			if err := cw.encode(); err != nil {
+				return 0, err
+			}
+		}
+		return n, nil
+	case compressEncoding:
+		return cw.writer.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush flushes any buffered data to the client. Buffered responses are compressed regardless of their size, since the
+// handler is streaming the response.
+func (cw *compressWriter) Flush() {
+	if cw.state == compressBuffering {
+		if err := cw.encode(); err != nil {
+			return
+		}
+	}
+
+	if cw.state == compressEncoding {
+		if f, ok := cw.writer.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return
+			}
+		}
+	}
+
+	if cw.state != compressPending {
+		_ = http.NewResponseController(cw.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close completes the response, writing responses buffered below the minimum size uncompressed.
+func (cw *compressWriter) close() {
+	switch cw.state {
+	case compressBuffering:
+		buffered := cw.buf.Bytes()
+		cw.pass()
+		if len(buffered) > 0 {
+			_, _ = cw.ResponseWriter.Write(buffered)
+		}
+	case compressEncoding:
+		if err := cw.writer.Close(); err != nil {
+			log.Debug("[proxy:compress] could not complete compressed response", log.Err(err))
+		}
+	}
+}
+
+// eligible returns whether the response can be compressed.
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+	switch cw.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+
+	if ce := h.Get(headerContentEncoding); ce != "" && !strings.EqualFold(ce, EncodingIdentity) {
+		return false
+	}
+
+	if h.Get(headerContentRange) != "" || headerContainsToken(h, "Cache-Control", "no-transform") {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(h.Get(headerContentType))
+	if err != nil {
+		return false
+	}
+
+	for _, ct := range cw.options.contentTypes {
+		if matchMediaType(ct, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// encode writes the header for the compressed response, followed by any buffered data.
+func (cw *compressWriter) encode() error {
+	h := cw.Header()
+	h.Del(headerContentLength)
+	h.Del(headerAcceptRanges)
+	h.Set(headerContentEncoding, cw.encoder.Encoding())
+	if etag := h.Get(headerETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set(headerETag, "W/"+etag)
+	}
+
+	cw.state = compressEncoding
+	cw.writer = cw.encoder.NewWriter(cw.ResponseWriter)
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if cw.buf.Len() > 0 {
+		_, err := cw.writer.Write(cw.buf.Bytes())
+		cw.buf = bytes.Buffer{}
+		return err
+	}
+	return nil
+}
+
+// pass writes the header for the uncompressed response.
+func (cw *compressWriter) pass() {
+	cw.state = compressPassing
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// addVary adds the header field name to the Vary header unless it is already present.
+func addVary(h http.Header, name string) {
+	if headerContainsToken(h, headerVary, name) || headerContainsToken(h, headerVary, "*") {
+		return
+	}
+	h.Add(headerVary, name)
+}
+
+// matchMediaType returns whether the media type matches the pattern, which can use a wildcard for the subtype, or for
+// the part of the subtype before a structured syntax suffix.
+func matchMediaType(pattern string, mediaType string) bool {
+	pattern = strings.ToLower(pattern)
+	typ, subtype, ok := strings.Cut(pattern, "/")
+	if !ok {
+		return false
+	}
+
+	mt, mst, _ := strings.Cut(mediaType, "/")
+	if typ != mt {
+		return false
+	}
+
+	switch {
+	case subtype == "*":
+		return true
+	case strings.HasPrefix(subtype, "*+"):
+		return strings.HasSuffix(mst, subtype[1:])
+	}
+	return subtype == mst
+}
+
+// encoderFunc is an Encoder using a function for creating writers.
+type encoderFunc struct {
+	encoding  string
+	newWriter func(io.Writer) io.WriteCloser
+}
+
+// Encoding returns the name of the content coding.
+func (e *encoderFunc) Encoding() string {
+	return e.encoding
+}
+
+// NewWriter returns a writer compressing the data written to it to w.
+func (e *encoderFunc) NewWriter(w io.Writer) io.WriteCloser {
+	return e.newWriter(w)
+}
+
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// pooledWriter is a compressing writer that is returned to a sync.Pool once closed.
+type pooledWriter struct {
+	pool   *sync.Pool
+	writer resettableWriter
+}
+
+// Write compresses the data.
+func (w *pooledWriter) Write(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
+
+// Flush flushes any pending compressed data.
+func (w *pooledWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+// Close writes any remaining compressed data, returning the writer to the pool.
+func (w *pooledWriter) Close() error {
+	err := w.writer.Close()
+	w.writer.Reset(io.Discard)
+	w.pool.Put(w.writer)
+	return err
+}
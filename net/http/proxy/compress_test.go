@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	gohttp "net/http"
+)
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"key":"value"}`, 100)
+	handler := gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		switch r.URL.Path {
+		case "/encoded":
+			w.Header().Set(headerContentEncoding, "br")
+		case "/image":
+			w.Header().Set(headerContentType, "image/png")
+		case "/small":
+			w.Header().Set(headerContentType, "application/json")
+			_, _ = io.WriteString(w, "{}")
+			return
+		case "/stream":
+			w.Header().Set(headerContentType, "text/event-stream")
+			_, _ = io.WriteString(w, "data: 1\n\n")
+			gohttp.NewResponseController(w).Flush()
+			_, _ = io.WriteString(w, "data: 2\n\n")
+			return
+		}
+
+		if w.Header().Get(headerContentType) == "" {
+			w.Header().Set(headerContentType, "application/json; charset=utf-8")
+		}
+		w.Header().Set(headerContentLength, "1500")
+		w.Header().Set(headerETag, `"v1"`)
+		w.Header().Set(headerAcceptRanges, "bytes")
+		_, _ = io.WriteString(w, body)
+	})
+
+	brotli := NewEncoder("br", func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} })
+	gz, err := NewGzipEncoder(gzip.BestSpeed)
+	assert.NoError(t, err)
+	h := Compress(WithCompressEncoders(gz, brotli, mustDeflate(t)))(handler)
+
+	serve := func(path string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(gohttp.MethodGet, path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/", headerAcceptEncoding, "deflate;q=0.5, gzip")
+	assert.Equal(t, EncodingGzip, w.Header().Get(headerContentEncoding))
+	assert.Equal(t, headerAcceptEncoding, w.Header().Get(headerVary))
+	assert.Equal(t, `W/"v1"`, w.Header().Get(headerETag))
+	assert.Empty(t, w.Header().Get(headerContentLength))
+	assert.Empty(t, w.Header().Get(headerAcceptRanges))
+	gr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(b))
+
+	w = serve("/", headerAcceptEncoding, "gzip;q=0.5, deflate")
+	assert.Equal(t, EncodingDeflate, w.Header().Get(headerContentEncoding))
+	zr, err := zlib.NewReader(w.Body)
+	assert.NoError(t, err)
+	b, err = io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(b))
+
+	// encoders with the same preference are selected in the order they are provided
+	assert.Equal(t, EncodingGzip, serve("/", headerAcceptEncoding, "br, gzip").Header().Get(headerContentEncoding))
+	assert.Equal(t, "br", serve("/", headerAcceptEncoding, "*, gzip;q=0").Header().Get(headerContentEncoding))
+
+	for _, ae := range []string{"", "gzip;q=0", "identity"} {
+		w = serve("/", headerAcceptEncoding, ae)
+		assert.Empty(t, w.Header().Get(headerContentEncoding), ae)
+		assert.Equal(t, headerAcceptEncoding, w.Header().Get(headerVary), ae)
+		assert.Equal(t, `"v1"`, w.Header().Get(headerETag), ae)
+		assert.Equal(t, body, w.Body.String(), ae)
+	}
+
+	w = serve("/small", headerAcceptEncoding, "gzip")
+	assert.Empty(t, w.Header().Get(headerContentEncoding))
+	assert.Equal(t, "{}", w.Body.String())
+
+	w = serve("/encoded", headerAcceptEncoding, "gzip")
+	assert.Equal(t, "br", w.Header().Get(headerContentEncoding))
+	assert.Empty(t, w.Header().Get(headerVary))
+
+	w = serve("/image", headerAcceptEncoding, "gzip")
+	assert.Empty(t, w.Header().Get(headerContentEncoding))
+	assert.Empty(t, w.Header().Get(headerVary))
+
+	w = serve("/", headerAcceptEncoding, "gzip", headerRange, "bytes=0-10")
+	assert.Empty(t, w.Header().Get(headerContentEncoding))
+
+	// streamed responses are compressed once flushed, regardless of their size
+	w = serve("/stream", headerAcceptEncoding, "gzip")
+	assert.Equal(t, EncodingGzip, w.Header().Get(headerContentEncoding))
+	assert.True(t, w.Flushed)
+	gr, err = gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	b, err = io.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, "data: 1\n\ndata: 2\n\n", string(b))
+}
+
+func TestMatchMediaType(t *testing.T) {
+	assert.True(t, matchMediaType("text/*", "text/html"))
+	assert.True(t, matchMediaType("application/*+json", "application/problem+json"))
+	assert.True(t, matchMediaType("Application/JSON", "application/json"))
+	assert.False(t, matchMediaType("application/*+json", "application/json"))
+	assert.False(t, matchMediaType("text/*", "image/png"))
+}
+
+func mustDeflate(t *testing.T) Encoder {
+	e, err := NewDeflateEncoder(zlib.DefaultCompression)
+	assert.NoError(t, err)
+	return e
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
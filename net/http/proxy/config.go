@@ -0,0 +1,594 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/anchor/net"
+
+	"golang.org/x/net/http/httpguts"
+	"gopkg.in/yaml.v3"
+)
+
+// Enumeration of supported configuration file formats.
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+)
+
+// Enumeration of selector names supported by the configuration.
+const (
+	SelectorLeastConnections = "least_connections"
+	SelectorRandom           = "random"
+	SelectorRoundRobin       = "round_robin"
+)
+
+// Enumeration of health check protocols supported by the configuration.
+const (
+	HealthCheckProtocolGRPC = "grpc"
+	HealthCheckProtocolHTTP = "http"
+	HealthCheckProtocolTCP  = "tcp"
+)
+
+// Enumeration of limiter algorithms supported by the configuration.
+const (
+	LimiterAIMD     = "aimd"
+	LimiterGradient = "gradient"
+)
+
+// Enumeration of rate limit keys supported by the configuration. Header keys are specified as "header:<name>".
+const (
+	RateLimitKeyClientIP = "client_ip"
+	RateLimitKeyHeader   = "header:"
+)
+
+// Config defines the declarative configuration for a set of Balancers and the routes used for dispatching requests to
+// them.
+type Config struct {
+	Balancers []BalancerConfig `json:"balancers" yaml:"balancers"`
+	Routes    []RouteConfig    `json:"routes" yaml:"routes"`
+}
+
+// BalancerConfig defines the configuration for a single Balancer.
+type BalancerConfig struct {
+	ACL              *ACLConfig         `json:"acl,omitempty" yaml:"acl,omitempty"`
+	FailureThreshold int                `json:"failure_threshold,omitempty" yaml:"failure_threshold,omitempty"`
+	HealthCheck      *HealthCheckConfig `json:"health_check,omitempty" yaml:"health_check,omitempty"`
+	Hosts            []HostConfig       `json:"hosts" yaml:"hosts"`
+	Limiter          *LimiterConfig     `json:"limiter,omitempty" yaml:"limiter,omitempty"`
+	Name             string             `json:"name" yaml:"name"`
+	RateLimit        *RateLimitConfig   `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	ReviveTimeout    Duration           `json:"revive_timeout,omitempty" yaml:"revive_timeout,omitempty"`
+	Selector         string             `json:"selector,omitempty" yaml:"selector,omitempty"`
+	Upgrade          *UpgradeConfig     `json:"upgrade,omitempty" yaml:"upgrade,omitempty"`
+}
+
+// ACLConfig defines the configuration for an ACL. Prefixes are specified in CIDR notation, or as single IP addresses.
+// The forwarded header is the header the trusted proxies report the address of the client with, X-Forwarded-For by
+// default.
+type ACLConfig struct {
+	Allow           []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny            []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+	ForwardedHeader string   `json:"forwarded_header,omitempty" yaml:"forwarded_header,omitempty"`
+	TrustedProxies  []string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies,omitempty"`
+}
+
+// HostConfig defines the configuration for a single Host.
+type HostConfig struct {
+	ProxyProtocol int    `json:"proxy_protocol,omitempty" yaml:"proxy_protocol,omitempty"`
+	Target        string `json:"target" yaml:"target"`
+}
+
+// HealthCheckConfig defines the configuration for actively checking the health of the hosts of a Balancer.
+type HealthCheckConfig struct {
+	HealthyThreshold   int      `json:"healthy_threshold,omitempty" yaml:"healthy_threshold,omitempty"`
+	Interval           Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Path               string   `json:"path,omitempty" yaml:"path,omitempty"`
+	Protocol           string   `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Service            string   `json:"service,omitempty" yaml:"service,omitempty"`
+	Timeout            Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	UnhealthyThreshold int      `json:"unhealthy_threshold,omitempty" yaml:"unhealthy_threshold,omitempty"`
+}
+
+// LimiterConfig defines the configuration for the adaptive concurrency Limiter of each Host of a Balancer.
+type LimiterConfig struct {
+	Algorithm    string  `json:"algorithm" yaml:"algorithm"`
+	BackoffRatio float64 `json:"backoff_ratio,omitempty" yaml:"backoff_ratio,omitempty"`
+	InitialLimit int     `json:"initial_limit,omitempty" yaml:"initial_limit,omitempty"`
+	MaxLimit     int     `json:"max_limit,omitempty" yaml:"max_limit,omitempty"`
+	MinLimit     int     `json:"min_limit,omitempty" yaml:"min_limit,omitempty"`
+}
+
+// RateLimitConfig defines the configuration for the RateLimiter of a Balancer.
+type RateLimitConfig struct {
+	Burst   int     `json:"burst" yaml:"burst"`
+	Key     string  `json:"key,omitempty" yaml:"key,omitempty"`
+	MaxKeys int     `json:"max_keys,omitempty" yaml:"max_keys,omitempty"`
+	Rate    float64 `json:"rate" yaml:"rate"`
+}
+
+// UpgradeConfig defines the timeouts for connections upgraded from HTTP, e.g. WebSocket connections.
+type UpgradeConfig struct {
+	IdleTimeout Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	MaxLifetime Duration `json:"max_lifetime,omitempty" yaml:"max_lifetime,omitempty"`
+}
+
+// RouteConfig defines the criteria for dispatching requests to a Balancer.
+type RouteConfig struct {
+	ACL        *ACLConfig        `json:"acl,omitempty" yaml:"acl,omitempty"`
+	Balancer   string            `json:"balancer" yaml:"balancer"`
+	Headers    map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Host       string            `json:"host,omitempty" yaml:"host,omitempty"`
+	Methods    []string          `json:"methods,omitempty" yaml:"methods,omitempty"`
+	PathPrefix string            `json:"path_prefix,omitempty" yaml:"path_prefix,omitempty"`
+}
+
+// Duration is a time.Duration that is represented in configuration files as a string, e.g. "1m30s".
+type Duration time.Duration
+
+// MarshalJSON returns the JSON string representation of the Duration.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON parses the Duration from a JSON string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"10s\": %w", err)
+	}
+	return d.parse(s)
+}
+
+// UnmarshalYAML parses the Duration from a YAML scalar.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"10s\": %w", err)
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ConfigError records a validation error and the path to the offending attribute of a Config.
+type ConfigError struct {
+	Err  error
+	Path string
+}
+
+// Error returns the error message prefixed by the path of the offending attribute.
+func (e *ConfigError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// LoadConfig reads, decodes, and validates the configuration file at the provided path. The format is determined by
+// the file extension: ".json" for JSON, and YAML otherwise.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("proxy_config: %w", err)
+	}
+
+	format := ConfigFormatYAML
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = ConfigFormatJSON
+	}
+
+	cfg, err := ParseConfig(b, format)
+	if err != nil {
+		return nil, fmt.Errorf("proxy_config: %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig decodes and validates the configuration in the provided format. Unknown attributes are rejected.
+func ParseConfig(b []byte, format string) (*Config, error) {
+	cfg := &Config{}
+	switch format {
+	case ConfigFormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, err
+		}
+	case ConfigFormatYAML:
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate validates the entire Config, returning all errors found joined together. Each error is a ConfigError.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(path string, format string, args ...any) {
+		errs = append(errs, &ConfigError{Err: fmt.Errorf(format, args...), Path: path})
+	}
+
+	if len(c.Balancers) == 0 {
+		fail("balancers", "at least one balancer must be provided")
+	}
+
+	names := make(map[string]bool)
+	for i, b := range c.Balancers {
+		p := fmt.Sprintf("balancers[%d]", i)
+		if strings.TrimSpace(b.Name) == "" {
+			fail(p+".name", "name is required")
+		} else if names[b.Name] {
+			fail(p+".name", "duplicate balancer name %q", b.Name)
+		}
+		names[b.Name] = true
+
+		if len(b.Hosts) == 0 {
+			fail(p+".hosts", "at least one host must be provided")
+		}
+
+		for j, h := range b.Hosts {
+			if err := validateTarget(h.Target); err != nil {
+				fail(fmt.Sprintf("%s.hosts[%d].target", p, j), "%w", err)
+			}
+
+			if h.ProxyProtocol < 0 || h.ProxyProtocol > 2 {
+				fail(fmt.Sprintf("%s.hosts[%d].proxy_protocol", p, j), "unsupported version %d", h.ProxyProtocol)
+			}
+		}
+
+		if b.ACL != nil {
+			b.ACL.validate(p+".acl", fail)
+		}
+
+		if b.Selector != "" && newSelector(b.Selector) == nil {
+			fail(p+".selector", "unknown selector %q", b.Selector)
+		}
+
+		if b.FailureThreshold < 0 {
+			fail(p+".failure_threshold", "must not be negative")
+		}
+
+		if b.ReviveTimeout < 0 {
+			fail(p+".revive_timeout", "must not be negative")
+		}
+
+		if u := b.Upgrade; u != nil {
+			if u.IdleTimeout < 0 {
+				fail(p+".upgrade.idle_timeout", "must not be negative")
+			}
+
+			if u.MaxLifetime < 0 {
+				fail(p+".upgrade.max_lifetime", "must not be negative")
+			}
+		}
+
+		if hc := b.HealthCheck; hc != nil {
+			if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+				fail(p+".health_check.path", "must start with \"/\"")
+			}
+
+			switch hc.Protocol {
+			case "", HealthCheckProtocolGRPC, HealthCheckProtocolHTTP, HealthCheckProtocolTCP:
+			default:
+				fail(p+".health_check.protocol", "unknown protocol %q", hc.Protocol)
+			}
+
+			if hc.Service != "" && hc.Protocol != HealthCheckProtocolGRPC {
+				fail(p+".health_check.service", "only supported for the %q protocol", HealthCheckProtocolGRPC)
+			}
+
+			if hc.Interval < 0 {
+				fail(p+".health_check.interval", "must not be negative")
+			}
+
+			if hc.Timeout < 0 {
+				fail(p+".health_check.timeout", "must not be negative")
+			}
+
+			if hc.HealthyThreshold < 0 {
+				fail(p+".health_check.healthy_threshold", "must not be negative")
+			}
+
+			if hc.UnhealthyThreshold < 0 {
+				fail(p+".health_check.unhealthy_threshold", "must not be negative")
+			}
+		}
+
+		if l := b.Limiter; l != nil {
+			if l.Algorithm != LimiterAIMD && l.Algorithm != LimiterGradient {
+				fail(p+".limiter.algorithm", "unknown algorithm %q, expected one of %q or %q",
+					l.Algorithm, LimiterAIMD, LimiterGradient)
+			}
+
+			if l.MinLimit < 0 || l.MaxLimit < 0 || l.InitialLimit < 0 {
+				fail(p+".limiter", "limits must not be negative")
+			}
+
+			if l.MaxLimit > 0 && l.MinLimit > l.MaxLimit {
+				fail(p+".limiter.min_limit", "must not be greater than max_limit")
+			}
+
+			if l.BackoffRatio < 0 || l.BackoffRatio >= 1 {
+				fail(p+".limiter.backoff_ratio", "must be in the range (0, 1)")
+			}
+		}
+
+		if rl := b.RateLimit; rl != nil {
+			if rl.Rate <= 0 {
+				fail(p+".rate_limit.rate", "must be greater than zero")
+			}
+
+			if rl.Burst <= 0 {
+				fail(p+".rate_limit.burst", "must be greater than zero")
+			}
+
+			if rl.MaxKeys < 0 {
+				fail(p+".rate_limit.max_keys", "must not be negative")
+			}
+
+			if _, err := newKeyFunc(rl.Key); err != nil {
+				fail(p+".rate_limit.key", "%w", err)
+			}
+		}
+	}
+
+	for i, r := range c.Routes {
+		p := fmt.Sprintf("routes[%d]", i)
+		if r.Balancer == "" {
+			fail(p+".balancer", "balancer is required")
+		} else if !names[r.Balancer] {
+			fail(p+".balancer", "unknown balancer %q", r.Balancer)
+		}
+
+		if r.ACL != nil {
+			r.ACL.validate(p+".acl", fail)
+		}
+
+		if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+			fail(p+".path_prefix", "must start with \"/\"")
+		}
+
+		for j, m := range r.Methods {
+			if m == "" || strings.ContainsFunc(m, func(c rune) bool { return c <= ' ' || c >= 0x7f }) {
+				fail(fmt.Sprintf("%s.methods[%d]", p, j), "invalid method %q", m)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String returns a string representation of the Config.
+func (c *Config) String() string {
+	return string(anchor.ToJSONFormatted(c))
+}
+
+// options returns the list of options for creating a Balancer from the BalancerConfig.
+func (c *BalancerConfig) options() ([]func(*LBOption), error) {
+	var options []func(*LBOption)
+	if c.Selector != "" {
+		options = append(options, WithSelector(newSelector(c.Selector)))
+	}
+
+	if c.ACL != nil {
+		acl, err := c.ACL.acl()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithACL(acl))
+	}
+
+	threshold := PoolFailureThreshold
+	if c.FailureThreshold > 0 {
+		threshold = c.FailureThreshold
+	}
+
+	revive := PoolReviveTimeout
+	if c.ReviveTimeout > 0 {
+		revive = time.Duration(c.ReviveTimeout)
+	}
+	options = append(options, WithReviveTimeout(revive, threshold))
+
+	if c.Upgrade != nil {
+		options = append(options, WithUpgradeTimeouts(time.Duration(c.Upgrade.IdleTimeout), time.Duration(c.Upgrade.MaxLifetime)))
+	}
+
+	if hc := c.HealthCheck; hc != nil {
+		check := HealthCheck{
+			HealthyThreshold:   hc.HealthyThreshold,
+			Interval:           time.Duration(hc.Interval),
+			Path:               hc.Path,
+			Timeout:            time.Duration(hc.Timeout),
+			UnhealthyThreshold: hc.UnhealthyThreshold,
+		}
+
+		switch hc.Protocol {
+		case HealthCheckProtocolGRPC:
+			check.Check = CheckGRPC(hc.Service)
+		case HealthCheckProtocolTCP:
+			check.Check = CheckTCP
+		}
+		options = append(options, WithHealthCheck(check))
+	}
+
+	if l := c.Limiter; l != nil {
+		var limitOpts []func(*LimitOption)
+		if l.BackoffRatio > 0 {
+			limitOpts = append(limitOpts, WithBackoffRatio(l.BackoffRatio))
+		}
+
+		if l.InitialLimit > 0 {
+			limitOpts = append(limitOpts, WithInitialLimit(l.InitialLimit))
+		}
+
+		if l.MaxLimit > 0 {
+			limitOpts = append(limitOpts, WithMaxLimit(l.MaxLimit))
+		}
+
+		if l.MinLimit > 0 {
+			limitOpts = append(limitOpts, WithMinLimit(l.MinLimit))
+		}
+
+		algorithm := l.Algorithm
+		options = append(options, WithLimiter(func() Limiter {
+			if algorithm == LimiterAIMD {
+				return NewAIMDLimiter(limitOpts...)
+			}
+			return NewGradientLimiter(limitOpts...)
+		}))
+	}
+
+	if rl := c.RateLimit; rl != nil {
+		keyFunc, err := newKeyFunc(rl.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		limiter, err := NewRateLimiter(
+			WithRateLimit(rl.Rate, rl.Burst),
+			WithRateLimitKey(keyFunc),
+			WithRateLimitMaxKeys(rl.MaxKeys))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithRateLimiter(limiter))
+	}
+	return options, nil
+}
+
+// acl returns a new ACL for the ACLConfig.
+func (c *ACLConfig) acl() (*ACL, error) {
+	options := []func(*ACLOption){WithACLForwardedHeader(c.ForwardedHeader)}
+	for _, l := range []struct {
+		option   func(...netip.Prefix) func(*ACLOption)
+		prefixes []string
+	}{
+		{option: WithACLAllow, prefixes: c.Allow},
+		{option: WithACLDeny, prefixes: c.Deny},
+		{option: WithACLTrustedProxies, prefixes: c.TrustedProxies},
+	} {
+		for _, s := range l.prefixes {
+			p, err := net.ParsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, l.option(p))
+		}
+	}
+	return NewACL(options...), nil
+}
+
+// validate validates the prefixes and forwarded header of the ACLConfig, reporting errors using the provided function.
+func (c *ACLConfig) validate(path string, fail func(string, string, ...any)) {
+	if h := strings.TrimSpace(c.ForwardedHeader); h != "" && !httpguts.ValidHeaderFieldName(h) {
+		fail(path+".forwarded_header", "invalid header name %q", c.ForwardedHeader)
+	}
+
+	for _, l := range []struct {
+		name     string
+		prefixes []string
+	}{
+		{name: "allow", prefixes: c.Allow},
+		{name: "deny", prefixes: c.Deny},
+		{name: "trusted_proxies", prefixes: c.TrustedProxies},
+	} {
+		for i, s := range l.prefixes {
+			if _, err := net.ParsePrefix(s); err != nil {
+				fail(fmt.Sprintf("%s.%s[%d]", path, l.name, i), "%w", err)
+			}
+		}
+	}
+}
+
+// hostKey returns the key identifying a Host of the BalancerConfig across configuration reloads. Hosts are only
+// reused if neither the target nor the attributes of the balancer affecting the Host have changed.
+func (c *BalancerConfig) hostKey(h HostConfig) string {
+	return fmt.Sprintf("%s|%s|%d|%s", c.Name, strings.TrimSpace(h.Target), h.ProxyProtocol, anchor.ToJSON(c.Limiter))
+}
+
+// route returns the Route for the RouteConfig.
+func (c *RouteConfig) route() Route {
+	return Route{
+		Headers:    c.Headers,
+		Host:       c.Host,
+		Methods:    c.Methods,
+		PathPrefix: c.PathPrefix,
+	}
+}
+
+// newKeyFunc returns the KeyFunc for the rate limit key name.
+func newKeyFunc(key string) (KeyFunc, error) {
+	switch {
+	case key == "" || key == RateLimitKeyClientIP:
+		return KeyByClientIP(), nil
+	case strings.HasPrefix(key, RateLimitKeyHeader):
+		name := strings.TrimSpace(strings.TrimPrefix(key, RateLimitKeyHeader))
+		if name == "" {
+			return nil, errors.New("header name is required")
+		}
+		return KeyByHeader(name), nil
+	}
+	return nil, fmt.Errorf("unknown key %q, expected %q or \"%s<name>\"", key, RateLimitKeyClientIP, RateLimitKeyHeader)
+}
+
+// newSelector returns a new Selector for the selector name, or nil if the name is unknown.
+func newSelector(name string) Selector {
+	switch name {
+	case SelectorLeastConnections:
+		return NewLeastConnectionsSelector()
+	case SelectorRandom:
+		return &randomSelector{}
+	case SelectorRoundRobin:
+		return &roundRobinSelector{current: -1}
+	}
+	return nil
+}
+
+// validateTarget validates a Host target URL.
+func validateTarget(target string) error {
+	if strings.TrimSpace(target) == "" {
+		return errors.New("target is required")
+	}
+
+	u, err := url.Parse(strings.TrimSpace(target))
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != SchemeH2C {
+		return fmt.Errorf("unsupported scheme %q, expected \"http\", \"https\", or \"h2c\"", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return errors.New("host is required")
+	}
+	return nil
+}
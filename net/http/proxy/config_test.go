@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/transientvariable/anchor/net/http/proxy/proxytest"
+
+	"github.com/stretchr/testify/assert"
+
+	gohttp "net/http"
+)
+
+func TestConfigValidate(t *testing.T) {
+	_, err := ParseConfig([]byte(`
+balancers:
+  - name: api
+    selector: fastest
+    hosts:
+      - target: ftp://example.com
+      - target: ""
+    rate_limit:
+      rate: 0
+      burst: 1
+      key: cookie
+    health_check:
+      protocol: udp
+      service: api
+    acl:
+      allow: [10.0.0.0/8, 10.0.0.0/33]
+  - name: api
+    hosts: []
+routes:
+  - balancer: web
+    path_prefix: v1
+    acl:
+      forwarded_header: "X Forwarded"
+      trusted_proxies: [localhost]
+`), ConfigFormatYAML)
+	assert.Error(t, err)
+
+	var paths []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var ce *ConfigError
+		if assert.True(t, errors.As(e, &ce)) {
+			paths = append(paths, ce.Path)
+		}
+	}
+
+	assert.ElementsMatch(t, []string{
+		"balancers[0].hosts[0].target",
+		"balancers[0].hosts[1].target",
+		"balancers[0].selector",
+		"balancers[0].rate_limit.rate",
+		"balancers[0].rate_limit.key",
+		"balancers[0].health_check.protocol",
+		"balancers[0].health_check.service",
+		"balancers[0].acl.allow[1]",
+		"balancers[1].name",
+		"balancers[1].hosts",
+		"routes[0].balancer",
+		"routes[0].path_prefix",
+		"routes[0].acl.forwarded_header",
+		"routes[0].acl.trusted_proxies[0]",
+	}, paths)
+
+	_, err = ParseConfig([]byte(`{"balancers": [], "unknown": true}`), ConfigFormatJSON)
+	assert.Error(t, err)
+}
+
+func TestRouterApply(t *testing.T) {
+	c, err := proxytest.NewCluster(3)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	config := func(targets ...string) *Config {
+		var hosts string
+		for _, t := range targets {
+			hosts += fmt.Sprintf("\n      - target: %s", t)
+		}
+
+		cfg, err := ParseConfig([]byte(fmt.Sprintf(`
+balancers:
+  - name: api
+    hosts:%s
+  - name: static
+    hosts:
+      - target: %s
+routes:
+  - balancer: static
+    path_prefix: /static
+  - balancer: api
+    path_prefix: /admin
+    acl:
+      allow: [10.0.0.0/8]
+  - balancer: api
+`, hosts, targets[0])), ConfigFormatYAML)
+		assert.NoError(t, err)
+		return cfg
+	}
+
+	urls := c.URLs()
+	r, err := NewRouter(config(urls[0], urls[1]))
+	assert.NoError(t, err)
+	defer r.Close()
+
+	assert.Equal(t, gohttp.StatusOK, serve(r, "/static/app.js").Code)
+	assert.Equal(t, gohttp.StatusForbidden, serve(r, "/admin").Code)
+	assert.Equal(t, gohttp.StatusOK, serve(r, "/").Code)
+	assert.Equal(t, gohttp.StatusOK, serve(r, "/").Code)
+	c.AssertSequence(t, 0, 0, 1)
+
+	b, ok := r.Balancer("api")
+	assert.True(t, ok)
+	first := b.Pool().Hosts()[0]
+	b.Pool().MarkFailed(first)
+
+	// hosts that did not change keep their state
+	assert.NoError(t, r.Apply(config(urls[0], urls[2])))
+	b, ok = r.Balancer("api")
+	assert.True(t, ok)
+
+	hosts := b.Pool().Hosts()
+	assert.Len(t, hosts, 2)
+	assert.Same(t, first, hosts[0])
+	assert.Equal(t, 1, hosts[0].Failures())
+
+	// invalid configurations leave the current one in place
+	assert.Error(t, r.Apply(&Config{}))
+	_, ok = r.Balancer("api")
+	assert.True(t, ok)
+}
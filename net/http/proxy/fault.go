@@ -0,0 +1,316 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+)
+
+var errFaultTruncated = errors.New("proxy_fault: response body truncated")
+
+// FaultRule defines the faults injected into requests matching a Route.
+//
+// Faults are applied in the following order: delay, reset, abort, and truncate. A delay can be combined with any of the
+// other faults.
+type FaultRule struct {
+	// AbortStatus is the HTTP status code the request is aborted with when greater than zero.
+	AbortStatus int
+
+	// Delay is the fixed delay applied before the request is forwarded.
+	Delay time.Duration
+
+	// DelayJitter is the upper bound of a random delay added to Delay.
+	DelayJitter time.Duration
+
+	// Percentage is the percentage, in the range [0, 100], of matching requests the faults are injected into.
+	Percentage float64
+
+	// Reset causes the client connection to be reset without sending a response.
+	Reset bool
+
+	// Route is the criteria a request must satisfy for the rule to be applied.
+	Route Route
+
+	// TruncateAfter causes the response body to be truncated after the number of bytes when greater than zero.
+	TruncateAfter int64
+}
+
+// validate returns an error if the percentage of the FaultRule is outside the range [0, 100].
+func (r FaultRule) validate() error {
+	if !(r.Percentage >= 0 && r.Percentage <= 100) {
+		return fmt.Errorf("percentage %v is not in the range [0, 100]", r.Percentage)
+	}
+	return nil
+}
+
+// FaultOption is a container for optional properties that can be used for initializing a FaultInjector.
+type FaultOption struct {
+	activation func(*http.Request) bool
+	disabled   bool
+	rules      []FaultRule
+	seed       *uint64
+}
+
+// WithFaultActivation sets the function used for determining whether faults can be injected into a request, e.g. only
+// when a specific header is present. By default, all requests are eligible.
+func WithFaultActivation(activation func(*http.Request) bool) func(*FaultOption) {
+	return func(o *FaultOption) {
+		o.activation = activation
+	}
+}
+
+// WithFaultDisabled sets whether the FaultInjector is initially disabled.
+func WithFaultDisabled(disabled bool) func(*FaultOption) {
+	return func(o *FaultOption) {
+		o.disabled = disabled
+	}
+}
+
+// WithFaultRule appends a FaultRule. Rules are evaluated in the order they are provided, and the first rule matching
+// a request is applied.
+func WithFaultRule(rule FaultRule) func(*FaultOption) {
+	return func(o *FaultOption) {
+		o.rules = append(o.rules, rule)
+	}
+}
+
+// WithFaultSeed sets the seed used for selecting requests and computing jitter, making fault injection reproducible.
+func WithFaultSeed(seed uint64) func(*FaultOption) {
+	return func(o *FaultOption) {
+		o.seed = &seed
+	}
+}
+
+// FaultHeaderActivation returns an activation function that only allows faults to be injected into requests with the
+// provided header, e.g. "X-Fault".
+func FaultHeaderActivation(name string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		return strings.TrimSpace(r.Header.Get(name)) != ""
+	}
+}
+
+// FaultInjector injects delays, aborts, truncated responses, and connection resets into HTTP requests for testing the
+// resilience of clients and services.
+type FaultInjector struct {
+	activation func(*http.Request) bool
+	enabled    atomic.Bool
+	mutex      sync.Mutex
+	random     *rand.Rand
+	rules      atomic.Pointer[[]FaultRule]
+}
+
+// NewFaultInjector creates a new FaultInjector using the provided options. An error is returned if a FaultRule is
+// invalid.
+func NewFaultInjector(options ...func(*FaultOption)) (*FaultInjector, error) {
+	opts := &FaultOption{}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	f := &FaultInjector{activation: opts.activation}
+	if opts.seed != nil {
+		f.random = rand.New(rand.NewPCG(*opts.seed, *opts.seed))
+	} else {
+		f.random = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	f.enabled.Store(!opts.disabled)
+	if err := f.SetRules(opts.rules...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Enable enables fault injection.
+func (f *FaultInjector) Enable() {
+	f.enabled.Store(true)
+	log.Info("[proxy:fault] fault injection enabled")
+}
+
+// Disable disables fault injection.
+func (f *FaultInjector) Disable() {
+	f.enabled.Store(false)
+	log.Info("[proxy:fault] fault injection disabled")
+}
+
+// Enabled returns whether fault injection is enabled.
+func (f *FaultInjector) Enabled() bool {
+	return f.enabled.Load()
+}
+
+// Rules returns the list of FaultRule currently used by the FaultInjector.
+func (f *FaultInjector) Rules() []FaultRule {
+	rules := *f.rules.Load()
+	return append([]FaultRule(nil), rules...)
+}
+
+// SetRules atomically replaces the list of FaultRule used by the FaultInjector. The current rules are left unchanged
+// if any of the provided rules is invalid.
+func (f *FaultInjector) SetRules(rules ...FaultRule) error {
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("proxy_fault: rules[%d]: %w", i, err)
+		}
+	}
+
+	r := append([]FaultRule(nil), rules...)
+	f.rules.Store(&r)
+	return nil
+}
+
+// Handler returns a http.Handler that injects faults into requests before passing them to the provided http.Handler.
+func (f *FaultInjector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := f.match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log.Debug("[proxy:fault] injecting fault",
+			log.String("method", r.Method),
+			log.String("url", r.URL.String()),
+			log.Any("rule", faultRuleMap(rule)))
+
+		if d := rule.Delay + f.jitter(rule.DelayJitter); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-r.Context().Done():
+				t.Stop()
+				return
+			}
+		}
+
+		if rule.Reset {
+			resetConn(w)
+			return
+		}
+
+		if rule.AbortStatus > 0 {
+			http.Error(w, http.StatusText(rule.AbortStatus), rule.AbortStatus)
+			return
+		}
+
+		if rule.TruncateAfter > 0 {
+			tw := &truncatingWriter{ResponseWriter: w, remaining: rule.TruncateAfter}
+			next.ServeHTTP(tw, r)
+			if tw.truncated {
+				// aborting the handler ensures the client observes an incomplete response rather than a short body
+				panic(http.ErrAbortHandler)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// String returns a string representation of the FaultInjector.
+func (f *FaultInjector) String() string {
+	var rules []map[string]any
+	for _, r := range f.Rules() {
+		rules = append(rules, faultRuleMap(r))
+	}
+	return string(anchor.ToJSON(map[string]any{
+		"enabled": f.Enabled(),
+		"rules":   rules,
+	}))
+}
+
+// match returns the first FaultRule matching the request if the request has been selected for fault injection.
+func (f *FaultInjector) match(r *http.Request) (FaultRule, bool) {
+	if !f.Enabled() || (f.activation != nil && !f.activation(r)) {
+		return FaultRule{}, false
+	}
+
+	for _, rule := range *f.rules.Load() {
+		if rule.Route.Match(r) {
+			f.mutex.Lock()
+			p := f.random.Float64() * 100
+			f.mutex.Unlock()
+			return rule, p < rule.Percentage
+		}
+	}
+	return FaultRule{}, false
+}
+
+func (f *FaultInjector) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return time.Duration(f.random.Int64N(int64(d)))
+}
+
+// truncatingWriter wraps a http.ResponseWriter, discarding the response body after a number of bytes.
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int64
+	truncated bool
+}
+
+// Write writes the data to the underlying http.ResponseWriter until the limit is reached.
+func (w *truncatingWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) <= w.remaining {
+		n, err := w.ResponseWriter.Write(b)
+		w.remaining -= int64(n)
+		return n, err
+	}
+
+	n, err := w.ResponseWriter.Write(b[:w.remaining])
+	w.remaining -= int64(n)
+	w.truncated = true
+	if err != nil {
+		return n, err
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, errFaultTruncated
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with http.ResponseController.
+func (w *truncatingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// resetConn closes the client connection without a response, sending a TCP reset where possible.
+func resetConn(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// the connection cannot be hijacked (e.g. HTTP/2), so abort the stream instead
+		panic(http.ErrAbortHandler)
+	}
+
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if err := tc.SetLinger(0); err != nil {
+			log.Error("[proxy:fault] could not set linger for connection reset", log.Err(err))
+		}
+	}
+
+	if err := conn.Close(); err != nil {
+		log.Error("[proxy:fault] could not close connection", log.Err(err))
+	}
+}
+
+func faultRuleMap(r FaultRule) map[string]any {
+	return map[string]any{
+		"abort_status":   r.AbortStatus,
+		"delay":          r.Delay.String(),
+		"delay_jitter":   r.DelayJitter.String(),
+		"percentage":     r.Percentage,
+		"reset":          r.Reset,
+		"route":          r.Route.toMap(),
+		"truncate_after": r.TruncateAfter,
+	}
+}
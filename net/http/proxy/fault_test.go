@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestFaultInjector(t *testing.T) {
+	f := newFaultInjector(t,
+		WithFaultSeed(1),
+		WithFaultRule(FaultRule{AbortStatus: gohttp.StatusServiceUnavailable, Percentage: 100, Route: Route{PathPrefix: "/abort"}}),
+		WithFaultRule(FaultRule{Delay: 100 * time.Millisecond, Percentage: 100, Route: Route{PathPrefix: "/delay"}}),
+		WithFaultRule(FaultRule{Percentage: 100, Reset: true, Route: Route{PathPrefix: "/reset"}}),
+		WithFaultRule(FaultRule{Percentage: 100, Route: Route{PathPrefix: "/truncate"}, TruncateAfter: 4}),
+		WithFaultRule(FaultRule{
+			AbortStatus: gohttp.StatusTeapot,
+			Percentage:  100,
+			Route:       Route{Headers: map[string]string{"X-Tenant": "a"}, Methods: []string{gohttp.MethodGet}},
+		}),
+	)
+	srv, get := faultServer(t, f)
+	defer srv.Close()
+
+	code, _, err := get("/abort", nil)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, code)
+
+	start := time.Now()
+	code, body, err := get("/delay", nil)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusOK, code)
+	assert.Equal(t, "0123456789", body)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	_, _, err = get("/reset", nil)
+	assert.Error(t, err)
+
+	code, body, err = get("/truncate", nil)
+	assert.Equal(t, gohttp.StatusOK, code)
+	assert.Equal(t, "0123", body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// rules are matched using the headers and method of the request
+	code, _, err = get("/", gohttp.Header{"X-Tenant": {"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusTeapot, code)
+
+	code, _, err = get("/", gohttp.Header{"X-Tenant": {"b"}})
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusOK, code)
+
+	// rules can be replaced at runtime
+	require.NoError(t, f.SetRules(FaultRule{AbortStatus: gohttp.StatusBadGateway, Percentage: 100}))
+	code, _, err = get("/delay", nil)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusBadGateway, code)
+	assert.Len(t, f.Rules(), 1)
+
+	// rules with a percentage outside [0, 100] are rejected
+	assert.Error(t, f.SetRules(FaultRule{Percentage: 100}, FaultRule{Percentage: 101}))
+	assert.Error(t, f.SetRules(FaultRule{Percentage: -1}))
+	assert.Len(t, f.Rules(), 1)
+
+	_, err = NewFaultInjector(WithFaultRule(FaultRule{Percentage: 150}))
+	assert.ErrorContains(t, err, "proxy_fault: rules[0]: percentage 150")
+}
+
+func TestFaultInjectorJitter(t *testing.T) {
+	f := newFaultInjector(t)
+	f.random = rand.New(&sequenceSource{values: []uint64{1<<63 | 1}})
+
+	assert.Equal(t, 100*time.Millisecond, f.jitter(200*time.Millisecond))
+	assert.Zero(t, f.jitter(0))
+
+	f = newFaultInjector(t,
+		WithFaultSeed(1),
+		WithFaultRule(FaultRule{Delay: 50 * time.Millisecond, DelayJitter: 50 * time.Millisecond, Percentage: 100}),
+	)
+	srv, get := faultServer(t, f)
+	defer srv.Close()
+
+	start := time.Now()
+	code, _, err := get("/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusOK, code)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestFaultInjectorPercentage(t *testing.T) {
+	f := newFaultInjector(t, WithFaultRule(FaultRule{AbortStatus: gohttp.StatusServiceUnavailable, Percentage: 50}))
+	f.random = rand.New(&sequenceSource{values: []uint64{percent(10), percent(90), percent(49.9), percent(50)}})
+
+	srv, get := faultServer(t, f)
+	defer srv.Close()
+
+	var codes []int
+	for range 4 {
+		code, _, err := get("/", nil)
+		require.NoError(t, err)
+		codes = append(codes, code)
+	}
+	assert.Equal(t, []int{
+		gohttp.StatusServiceUnavailable,
+		gohttp.StatusOK,
+		gohttp.StatusServiceUnavailable,
+		gohttp.StatusOK,
+	}, codes)
+}
+
+func TestFaultInjectorActivation(t *testing.T) {
+	f := newFaultInjector(t,
+		WithFaultActivation(FaultHeaderActivation("X-Fault")),
+		WithFaultDisabled(true),
+		WithFaultRule(FaultRule{AbortStatus: gohttp.StatusServiceUnavailable, Percentage: 100}),
+	)
+	srv, get := faultServer(t, f)
+	defer srv.Close()
+
+	fault := gohttp.Header{"X-Fault": {"1"}}
+	code, _, err := get("/", fault)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusOK, code)
+
+	f.Enable()
+	assert.True(t, f.Enabled())
+	code, _, err = get("/", fault)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, code)
+
+	// requests without the activation header are not eligible
+	code, _, err = get("/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusOK, code)
+
+	f.Disable()
+	code, _, err = get("/", fault)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusOK, code)
+}
+
+// newFaultInjector creates a new FaultInjector using the provided options, failing the test if any is invalid.
+func newFaultInjector(t *testing.T, options ...func(*FaultOption)) *FaultInjector {
+	f, err := NewFaultInjector(options...)
+	require.NoError(t, err)
+	return f
+}
+
+// faultServer starts a server injecting faults using the FaultInjector into a handler responding with "0123456789",
+// returning a function for sending a GET request that returns the status code, the body read, and any error.
+func faultServer(t *testing.T, f *FaultInjector) (*httptest.Server, func(string, gohttp.Header) (int, string, error)) {
+	srv := httptest.NewServer(f.Handler(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		_, _ = io.WriteString(w, "0123456789")
+	})))
+
+	return srv, func(path string, header gohttp.Header) (int, string, error) {
+		req, err := gohttp.NewRequest(gohttp.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b), err
+	}
+}
+
+// sequenceSource is a rand.Source returning the provided values in order, repeating them once exhausted.
+type sequenceSource struct {
+	i      int
+	values []uint64
+}
+
+func (s *sequenceSource) Uint64() uint64 {
+	v := s.values[s.i%len(s.values)]
+	s.i++
+	return v
+}
+
+// percent returns the value of a sequenceSource for which rand.Rand.Float64 returns p percent.
+func percent(p float64) uint64 {
+	return uint64(p / 100 * (1 << 53))
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const headerForwarded = "Forwarded"
+
+// setForwarded appends an element describing the client connection of the request to the Forwarded header defined by
+// RFC 7239, preserving the elements added by previous proxies.
+func setForwarded(r *http.Request) {
+	var elem []string
+	if node := forwardedNode(r.RemoteAddr); node != "" {
+		elem = append(elem, "for="+node)
+	}
+
+	if r.Host != "" {
+		elem = append(elem, "host="+forwardedValue(r.Host))
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	elem = append(elem, "proto="+proto)
+
+	values := append(r.Header.Values(headerForwarded), strings.Join(elem, ";"))
+	r.Header.Set(headerForwarded, strings.Join(values, ", "))
+}
+
+// forwardedNode returns the node identifier for the remote address, quoting IPv6 addresses in brackets as required by
+// RFC 7239, or an obfuscated identifier if the address is not an IP address.
+func forwardedNode(remoteAddr string) string {
+	if remoteAddr == "" {
+		return ""
+	}
+
+	a, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return "unknown"
+	}
+
+	ip := a.Addr().Unmap()
+	if ip.Is4() {
+		return ip.String()
+	}
+	return `"[` + ip.WithZone("").String() + `]"`
+}
+
+// forwardedValue returns the value, quoted if it is not a valid token.
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// SchemeH2C is the scheme of Host targets that are sent requests using HTTP/2 without TLS (h2c) with prior knowledge,
+// e.g. "h2c://10.0.0.1:50051" for a gRPC server without TLS.
+const SchemeH2C = "h2c"
+
+const (
+	headerContentType = "Content-Type"
+	headerGRPCMessage = "Grpc-Message"
+	headerGRPCStatus  = "Grpc-Status"
+)
+
+// CheckGRPC returns a function for checking the health of a Host using the standard gRPC health checking protocol
+// (grpc.health.v1). The Host is healthy if the status of the service is SERVING. An empty service checks the health
+// of the server as a whole.
+//
+// Hosts with a "https" target are checked using TLS, and other hosts without TLS.
+func CheckGRPC(service string) func(context.Context, *Host) error {
+	return func(ctx context.Context, h *Host) error {
+		t, err := h.Target()
+		if err != nil {
+			return err
+		}
+
+		creds := insecure.NewCredentials()
+		if t.Scheme == "https" {
+			creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12, ServerName: t.Hostname()})
+		}
+
+		conn, err := grpc.NewClient("passthrough:///"+t.Host, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+
+		if s := resp.GetStatus(); s != grpc_health_v1.HealthCheckResponse_SERVING {
+			return fmt.Errorf("proxy_health_check: unexpected gRPC health status %s", s)
+		}
+		return nil
+	}
+}
+
+// isGRPC returns whether the request is a gRPC request.
+func isGRPC(r *http.Request) bool {
+	ct := r.Header.Get(headerContentType)
+	if !strings.HasPrefix(ct, "application/grpc") {
+		return false
+	}
+
+	// gRPC-Web is served over HTTP/1.1 with regular response bodies, so it is excluded
+	rest := ct[len("application/grpc"):]
+	return rest == "" || rest[0] == '+' || rest[0] == ';'
+}
+
+// writeError writes an error response with the provided HTTP status. For gRPC requests, a trailers-only response with
+// the corresponding gRPC status is written instead, since gRPC clients do not interpret HTTP error responses.
+func writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if !isGRPC(r) {
+		http.Error(w, msg, status)
+		return
+	}
+
+	w.Header().Set(headerContentType, "application/grpc")
+	w.Header().Set(headerGRPCStatus, strconv.Itoa(int(grpcCode(status))))
+	w.Header().Set(headerGRPCMessage, grpcMessage(msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcCode returns the gRPC status code for the HTTP status, as defined by the gRPC mapping of HTTP to gRPC status
+// codes.
+func grpcCode(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return codes.Unavailable
+	case StatusClientClosedRequest:
+		return codes.Canceled
+	}
+	return codes.Unknown
+}
+
+// grpcMessage percent-encodes the message for the Grpc-Message header.
+func grpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
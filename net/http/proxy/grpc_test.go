@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	gohttp "net/http"
+)
+
+func TestBalancerGRPC(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	hs := health.NewServer()
+	hs.SetServingStatus("api", grpc_health_v1.HealthCheckResponse_SERVING)
+	upstream := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(upstream, hs)
+	go upstream.Serve(l)
+	defer upstream.Stop()
+
+	h, err := NewHost(SchemeH2C + "://" + l.Addr().String())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, CheckGRPC("api")(ctx, h))
+	assert.Error(t, CheckGRPC("unknown")(ctx, h))
+
+	b, err := NewBalancer([]*Host{h}, WithReviveTimeout(time.Hour, 1))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	s := httptest.NewUnstartedServer(b)
+	s.Config.Protocols = new(gohttp.Protocols)
+	s.Config.Protocols.SetUnencryptedHTTP2(true)
+	s.Start()
+	defer s.Close()
+
+	conn, err := grpc.NewClient("passthrough:///"+s.Listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "api"})
+	assert.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.GetStatus())
+
+	// errors returned by the upstream are forwarded in the trailers
+	_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.True(t, h.Active())
+
+	// upstream failures are reported using a gRPC status and mark the host as failed
+	upstream.Stop()
+	_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "api"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.False(t, h.Active())
+
+	_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "api"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "Service not available")
+}
+
+func TestGRPCMessage(t *testing.T) {
+	assert.Equal(t, "100%25 caf%C3%A9%0A", grpcMessage("100% café\n"))
+}
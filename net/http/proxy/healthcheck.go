@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/transientvariable/log-go"
+)
+
+const (
+	HealthCheckHealthyThreshold   = 1
+	HealthCheckInterval           = 10 * time.Second
+	HealthCheckPath               = "/"
+	HealthCheckTimeout            = 5 * time.Second
+	HealthCheckUnhealthyThreshold = 3
+)
+
+// HealthCheck defines the parameters for actively checking the health of the hosts in a Pool.
+type HealthCheck struct {
+	// Check, if set, is used for checking the health of a Host instead of an HTTP request to Path. A nil error
+	// indicates the Host is healthy.
+	Check func(context.Context, *Host) error
+
+	// HealthyThreshold is the number of consecutive successful checks before an inactive Host is marked active.
+	HealthyThreshold int
+
+	// Interval is the duration between checks.
+	Interval time.Duration
+
+	// Path is the URL path requested on the Host target. Responses with a 2xx or 3xx status are considered healthy.
+	Path string
+
+	// Timeout is the maximum duration of a single check.
+	Timeout time.Duration
+
+	// UnhealthyThreshold is the number of consecutive failed checks before an active Host is marked inactive.
+	UnhealthyThreshold int
+}
+
+// withDefaults returns a copy of the HealthCheck with default values applied for unset attributes.
+func (c HealthCheck) withDefaults() HealthCheck {
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = HealthCheckHealthyThreshold
+	}
+
+	if c.Interval <= 0 {
+		c.Interval = HealthCheckInterval
+	}
+
+	if c.Path == "" {
+		c.Path = HealthCheckPath
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = HealthCheckTimeout
+	}
+
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = HealthCheckUnhealthyThreshold
+	}
+	return c
+}
+
+// toMap returns a map representing the HealthCheck attributes.
+func (c HealthCheck) toMap() map[string]any {
+	return map[string]any{
+		"healthy_threshold":   c.HealthyThreshold,
+		"interval":            c.Interval.String(),
+		"path":                c.Path,
+		"timeout":             c.Timeout.String(),
+		"unhealthy_threshold": c.UnhealthyThreshold,
+	}
+}
+
+type healthChecker struct {
+	cancel  context.CancelFunc
+	config  HealthCheck
+	mutex   sync.Mutex
+	pool    *Pool
+	results map[*Host]int
+	wg      sync.WaitGroup
+}
+
+func newHealthChecker(pool *Pool, config HealthCheck) *healthChecker {
+	return &healthChecker{
+		config:  config.withDefaults(),
+		pool:    pool,
+		results: make(map[*Host]int),
+	}
+}
+
+// start starts checking the hosts of the Pool in the background.
+func (c *healthChecker) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		t := time.NewTicker(c.config.Interval)
+		defer t.Stop()
+		for {
+			c.checkAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// stop stops checking the hosts and waits for in-flight checks to complete.
+func (c *healthChecker) stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+// checkAll checks all hosts of the Pool concurrently.
+func (c *healthChecker) checkAll(ctx context.Context) {
+	hosts := c.pool.Hosts()
+
+	// discard the results for hosts that have been removed from the pool
+	c.mutex.Lock()
+	for h := range c.results {
+		if !slices.Contains(hosts, h) {
+			delete(c.results, h)
+		}
+	}
+	c.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.update(h, c.check(ctx, h))
+		}()
+	}
+	wg.Wait()
+}
+
+// check performs a single health check for the Host.
+func (c *healthChecker) check(ctx context.Context, h *Host) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	if c.config.Check != nil {
+		return c.config.Check(ctx, h)
+	}
+
+	t, err := h.Target()
+	if err != nil {
+		return err
+	}
+	t.Path = c.config.Path
+	t.RawQuery = ""
+	if t.Scheme == SchemeH2C {
+		t.Scheme = "http"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	// the transport of the Host is used, so that checks use the same protocol as proxied requests
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Transport:     h.proxy.Transport,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("proxy_health_check: unexpected HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// update records the result of a check for the Host, changing the Host status once a threshold is reached. Positive
+// results count consecutive successes and negative results count consecutive failures.
+func (c *healthChecker) update(h *Host, err error) {
+	if err != nil && h.Active() {
+		log.Debug("[proxy:health_check] check failed", log.String("target", h.target.String()), log.Err(err))
+	}
+
+	c.mutex.Lock()
+	n := c.results[h]
+	if err == nil {
+		n = max(n, 0) + 1
+	} else {
+		n = min(n, 0) - 1
+	}
+	c.results[h] = n
+	c.mutex.Unlock()
+
+	switch {
+	case err == nil && !h.Active() && n >= c.config.HealthyThreshold:
+		c.pool.MarkHealthy(h)
+	case err != nil && h.Active() && -n >= c.config.UnhealthyThreshold:
+		c.pool.deactivate(h)
+	}
+}
@@ -1,15 +1,20 @@
 package proxy
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+
+	gonet "net"
 )
 
 const (
@@ -19,12 +24,20 @@ const (
 
 // Host defines the attributes and behavior for a network proxy host.
 type Host struct {
+	bytesReceived atomic.Int64
+	bytesSent     atomic.Int64
+	connections   atomic.Int64
 	failures      int
 	inactive      bool
+	inflight      atomic.Int64
 	inactiveSince time.Time
+	limiter       Limiter
 	proxy         *httputil.ReverseProxy
 	mutex         sync.RWMutex
+	proxyProtocol int
 	target        *url.URL
+	upgradeConns  map[*upgradeConn]struct{}
+	upgrades      upgradeStats
 }
 
 // NewHost creates a new Host from the provided address string and options.
@@ -33,12 +46,44 @@ func NewHost(target string, options ...func(*HostOption)) (*Host, error) {
 	if err != nil {
 		return nil, fmt.Errorf("proxy_host: failed to parse target URL %v: %w", t, err)
 	}
-	h := &Host{target: t, proxy: httputil.NewSingleHostReverseProxy(t)}
+
+	upstream := t
+	if t.Scheme == SchemeH2C {
+		u := *t
+		u.Scheme = "http"
+		upstream = &u
+	}
+	h := &Host{target: t, proxy: httputil.NewSingleHostReverseProxy(upstream)}
 
 	opts := &HostOption{}
 	for _, opt := range options {
 		opt(opts)
 	}
+
+	if opts.proxyProtocol < 0 || opts.proxyProtocol > 2 {
+		return nil, fmt.Errorf("proxy_host: unsupported PROXY protocol version %d", opts.proxyProtocol)
+	}
+	h.proxyProtocol = opts.proxyProtocol
+
+	if h.proxyProtocol > 0 && opts.transport != nil {
+		return nil, errors.New("proxy_host: PROXY protocol header cannot be sent using a custom transport")
+	}
+
+	director := h.proxy.Director
+	h.proxy.Director = func(r *http.Request) {
+		director(r)
+		setForwarded(r)
+	}
+
+	h.proxy.Transport = opts.transport
+	if h.proxy.Transport == nil {
+		h.proxy.Transport = h.newTransport()
+	}
+
+	h.proxy.ErrorHandler = opts.errorHandler
+	if h.proxy.ErrorHandler == nil {
+		h.proxy.ErrorHandler = h.handleError
+	}
 	return h, nil
 }
 
@@ -49,6 +94,36 @@ func (h *Host) Active() bool {
 	return !h.inactive
 }
 
+// BytesReceived returns the number of bytes received from the Host by connections proxied at layer 4.
+func (h *Host) BytesReceived() int64 {
+	return h.bytesReceived.Load()
+}
+
+// BytesSent returns the number of bytes sent to the Host by connections proxied at layer 4.
+func (h *Host) BytesSent() int64 {
+	return h.bytesSent.Load()
+}
+
+// Connections returns the number of open connections proxied at layer 4 to the Host.
+func (h *Host) Connections() int64 {
+	return h.connections.Load()
+}
+
+// CloseUpgrades gracefully closes the connections to the Host that have been upgraded from HTTP, sending a close frame
+// to WebSocket clients indicating that the server is going away.
+func (h *Host) CloseUpgrades() {
+	h.mutex.RLock()
+	conns := make([]*upgradeConn, 0, len(h.upgradeConns))
+	for c := range h.upgradeConns {
+		conns = append(conns, c)
+	}
+	h.mutex.RUnlock()
+
+	for _, c := range conns {
+		c.shutdown()
+	}
+}
+
 // Failures returns the number of failures for the Host.
 func (h *Host) Failures() int {
 	h.mutex.RLock()
@@ -57,6 +132,18 @@ func (h *Host) Failures() int {
 	return f
 }
 
+// Inflight returns the number of HTTP requests in flight to the Host.
+func (h *Host) Inflight() int64 {
+	return h.inflight.Load()
+}
+
+// Limiter returns the adaptive concurrency Limiter for the Host, if any.
+func (h *Host) Limiter() Limiter {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.limiter
+}
+
 // InactiveSince returns the timestamp indicating the last time the Host was active.
 func (h *Host) InactiveSince() time.Time {
 	h.mutex.RLock()
@@ -65,6 +152,16 @@ func (h *Host) InactiveSince() time.Time {
 	return s
 }
 
+// StartRequest records an HTTP request in flight to the Host that is not sent by a Balancer, e.g. by a client-side load
+// balancer, so that it is accounted for by the least connections Selector. The returned function records the end of
+// the request.
+func (h *Host) StartRequest() func() {
+	h.inflight.Add(1)
+	return sync.OnceFunc(func() {
+		h.inflight.Add(-1)
+	})
+}
+
 // Target returns the Host url.URL target.
 func (h *Host) Target() (*url.URL, error) {
 	if h.target == nil {
@@ -77,6 +174,11 @@ func (h *Host) Target() (*url.URL, error) {
 	return addr, nil
 }
 
+// Upgrades returns the UpgradeStats for the connections to the Host that have been upgraded from HTTP.
+func (h *Host) Upgrades() UpgradeStats {
+	return h.upgrades.load()
+}
+
 // String returns a string representation of the Host attributes.
 func (h *Host) String() string {
 	return string(anchor.ToJSON(h.toMap()))
@@ -98,19 +200,121 @@ func (h *Host) markHealthy() {
 	h.failures = 0
 }
 
+// markFailed increments the number of failures for the Host, returning the updated count.
+func (h *Host) markFailed() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.failures++
+	return h.failures
+}
+
 // markInactive marks the Host as inactive.
 func (h *Host) markInactive() {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 	h.inactive = true
-	if h.inactiveSince.IsZero() {
-		h.inactiveSince = time.Now().UTC()
+	h.inactiveSince = time.Now().UTC()
+}
+
+// setLimiter sets the adaptive concurrency Limiter for the Host.
+func (h *Host) setLimiter(limiter Limiter) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.limiter = limiter
+}
+
+// acquire reserves capacity for a request using the Limiter for the Host, if any.
+func (h *Host) acquire() bool {
+	h.mutex.RLock()
+	l := h.limiter
+	h.mutex.RUnlock()
+	if l == nil {
+		return true
+	}
+	return l.Acquire()
+}
+
+// release returns the capacity reserved by acquire, recording the observed round-trip time for the request.
+func (h *Host) release(rtt time.Duration, dropped bool) {
+	h.mutex.RLock()
+	l := h.limiter
+	h.mutex.RUnlock()
+	if l != nil {
+		l.Release(rtt, dropped)
+	}
+}
+
+// handleError writes the response for a request that could not be proxied to the Host. Requests canceled by the client
+// are answered with StatusClientClosedRequest, so they are not counted as failures of the Host.
+func (h *Host) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	switch {
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		status = StatusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	default:
+		log.Warn("[proxy:host] could not proxy request", log.String("target", h.target.String()), log.Err(err))
+	}
+
+	text := http.StatusText(status)
+	if status == StatusClientClosedRequest {
+		text = StatusClientClosedRequestText
+	}
+	writeError(w, r, status, text)
+}
+
+// newTransport returns the http.Transport for sending requests to the Host. Targets using the h2c scheme are sent
+// requests using unencrypted HTTP/2, and HTTP/2 is negotiated using ALPN for targets using TLS.
+func (h *Host) newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if h.target.Scheme == SchemeH2C {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	if h.proxyProtocol > 0 {
+		// the PROXY protocol header identifies the client of a connection, so connections are not reused across requests
+		t.DisableKeepAlives = true
+		t.DialContext = func(ctx context.Context, network string, addr string) (gonet.Conn, error) {
+			src, _ := ctx.Value(clientAddrKey{}).(gonet.Addr)
+			dst, _ := ctx.Value(http.LocalAddrContextKey).(gonet.Addr)
+			return h.dialContext(ctx, network, addr, src, dst)
+		}
+	}
+	return t
+}
+
+// load returns the number of requests in flight and open connections to the Host, including upgraded connections.
+func (h *Host) load() int64 {
+	return h.inflight.Load() + h.connections.Load()
+}
+
+// trackUpgrade adds or removes an upgraded connection to the Host.
+func (h *Host) trackUpgrade(c *upgradeConn, add bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if add {
+		if h.upgradeConns == nil {
+			h.upgradeConns = make(map[*upgradeConn]struct{})
+		}
+		h.upgradeConns[c] = struct{}{}
+		h.upgrades.active.Add(1)
+		h.upgrades.total.Add(1)
+		return
+	}
+
+	if _, ok := h.upgradeConns[c]; ok {
+		delete(h.upgradeConns, c)
+		h.upgrades.active.Add(-1)
 	}
-	h.failures++
 }
 
 // serveHTTP performs the request for the Host.
 func (h *Host) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.proxyProtocol > 0 {
+		r = r.WithContext(contextWithClientAddr(r.Context(), r.RemoteAddr))
+	}
 	h.proxy.ServeHTTP(w, r)
 }
 
@@ -122,7 +326,26 @@ func (h *Host) toMap() map[string]any {
 	if h.target != nil {
 		m["target"] = h.target.String()
 	}
-	m["active"] = h.Active()
+	m["active"] = !h.inactive
 	m["failures"] = h.failures
+	if h.proxyProtocol > 0 {
+		m["proxy_protocol"] = h.proxyProtocol
+	}
+	if u := h.upgrades.load(); u.Total > 0 {
+		m["upgrades"] = u.toMap()
+	}
+	if c := h.connections.Load(); c > 0 || h.bytesSent.Load() > 0 {
+		m["tcp"] = map[string]any{
+			"bytes_received": h.bytesReceived.Load(),
+			"bytes_sent":     h.bytesSent.Load(),
+			"connections":    c,
+		}
+	}
+	if h.limiter != nil {
+		m["limit"] = map[string]any{
+			"inflight": h.limiter.Inflight(),
+			"limit":    h.limiter.Limit(),
+		}
+	}
 	return m
 }
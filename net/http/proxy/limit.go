@@ -0,0 +1,326 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/transientvariable/anchor"
+)
+
+const (
+	LimitInitial       = 20
+	LimitMin           = 1
+	LimitMax           = 1000
+	LimitBackoffRatio  = 0.9
+	LimitProbeInterval = 1000
+	LimitRTTTolerance  = 1.5
+	LimitSmoothing     = 0.2
+)
+
+// Limiter defines the behavior for adaptively limiting the number of concurrent requests for a Host.
+type Limiter interface {
+	// Acquire reserves capacity for a single request, returning false if the current limit has been reached.
+	Acquire() bool
+
+	// Release returns the capacity reserved by Acquire and records the observed round-trip time for the request,
+	// along with whether the request was dropped (e.g. timed out or rejected by the upstream).
+	Release(rtt time.Duration, dropped bool)
+
+	// Inflight returns the number of requests currently holding capacity.
+	Inflight() int
+
+	// Limit returns the current concurrency limit.
+	Limit() int
+}
+
+// LimitOption is a container for optional properties that can be used for initializing a Limiter.
+type LimitOption struct {
+	backoffRatio  float64
+	initialLimit  int
+	maxLimit      int
+	minLimit      int
+	probeInterval int
+	rttTolerance  float64
+	smoothing     float64
+}
+
+// String returns a string representation of the LimitOption.
+func (o *LimitOption) String() string {
+	options := make(map[string]any)
+	options["backoff_ratio"] = o.backoffRatio
+	options["initial_limit"] = o.initialLimit
+	options["max_limit"] = o.maxLimit
+	options["min_limit"] = o.minLimit
+	options["probe_interval"] = o.probeInterval
+	options["rtt_tolerance"] = o.rttTolerance
+	options["smoothing"] = o.smoothing
+	return string(anchor.ToJSONFormatted(options))
+}
+
+// WithBackoffRatio sets the ratio applied to the limit when a request is dropped. Values must be in the range (0, 1).
+func WithBackoffRatio(ratio float64) func(*LimitOption) {
+	return func(o *LimitOption) {
+		o.backoffRatio = ratio
+	}
+}
+
+// WithInitialLimit sets the concurrency limit used before any samples have been observed.
+func WithInitialLimit(limit int) func(*LimitOption) {
+	return func(o *LimitOption) {
+		o.initialLimit = limit
+	}
+}
+
+// WithMaxLimit sets the upper bound for the concurrency limit.
+func WithMaxLimit(limit int) func(*LimitOption) {
+	return func(o *LimitOption) {
+		o.maxLimit = limit
+	}
+}
+
+// WithMinLimit sets the lower bound for the concurrency limit.
+func WithMinLimit(limit int) func(*LimitOption) {
+	return func(o *LimitOption) {
+		o.minLimit = limit
+	}
+}
+
+// WithProbeInterval sets the number of samples after which the measured minimum RTT is reset, allowing the gradient
+// limiter to adapt to a changing baseline latency.
+func WithProbeInterval(samples int) func(*LimitOption) {
+	return func(o *LimitOption) {
+		o.probeInterval = samples
+	}
+}
+
+// WithRTTTolerance sets how much the observed RTT may exceed the minimum RTT before the gradient limiter starts
+// reducing the limit.
+func WithRTTTolerance(tolerance float64) func(*LimitOption) {
+	return func(o *LimitOption) {
+		o.rttTolerance = tolerance
+	}
+}
+
+// WithSmoothing sets the factor in the range (0, 1] used for smoothing changes to the gradient limit.
+func WithSmoothing(smoothing float64) func(*LimitOption) {
+	return func(o *LimitOption) {
+		o.smoothing = smoothing
+	}
+}
+
+func newLimitOption(options ...func(*LimitOption)) *LimitOption {
+	opts := &LimitOption{
+		backoffRatio:  LimitBackoffRatio,
+		initialLimit:  LimitInitial,
+		maxLimit:      LimitMax,
+		minLimit:      LimitMin,
+		probeInterval: LimitProbeInterval,
+		rttTolerance:  LimitRTTTolerance,
+		smoothing:     LimitSmoothing,
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	if opts.minLimit < 1 {
+		opts.minLimit = LimitMin
+	}
+
+	if opts.maxLimit < opts.minLimit {
+		opts.maxLimit = opts.minLimit
+	}
+
+	if opts.backoffRatio <= 0 || opts.backoffRatio >= 1 {
+		opts.backoffRatio = LimitBackoffRatio
+	}
+
+	if opts.rttTolerance < 1 {
+		opts.rttTolerance = LimitRTTTolerance
+	}
+
+	if opts.smoothing <= 0 || opts.smoothing > 1 {
+		opts.smoothing = LimitSmoothing
+	}
+	opts.initialLimit = clampLimit(opts.initialLimit, opts.minLimit, opts.maxLimit)
+	return opts
+}
+
+type gradientLimiter struct {
+	estimatedLimit float64
+	inflight       int
+	minRTT         time.Duration
+	mutex          sync.Mutex
+	options        *LimitOption
+	samples        int
+}
+
+// NewGradientLimiter creates a new Limiter that adjusts the concurrency limit based on the gradient between the
+// measured minimum round-trip time and the observed round-trip time, as described by the Netflix concurrency-limits
+// gradient algorithm.
+//
+// When the observed latency approaches the minimum latency the limit grows by roughly the square root of the current
+// limit, allowing for a small queue. As latency increases the limit shrinks proportionally to the gradient.
+func NewGradientLimiter(options ...func(*LimitOption)) Limiter {
+	opts := newLimitOption(options...)
+	return &gradientLimiter{
+		estimatedLimit: float64(opts.initialLimit),
+		options:        opts,
+	}
+}
+
+// Acquire reserves capacity for a single request, returning false if the current limit has been reached.
+func (l *gradientLimiter) Acquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.inflight >= int(l.estimatedLimit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// Release returns the capacity reserved by Acquire and updates the limit using the observed round-trip time.
+func (l *gradientLimiter) Release(rtt time.Duration, dropped bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	inflight := l.inflight
+	if l.inflight > 0 {
+		l.inflight--
+	}
+
+	if rtt <= 0 {
+		return
+	}
+
+	l.samples++
+	if l.options.probeInterval > 0 && l.samples >= l.options.probeInterval {
+		l.samples = 0
+		l.minRTT = 0
+	}
+
+	if l.minRTT == 0 || rtt < l.minRTT {
+		l.minRTT = rtt
+	}
+
+	var newLimit float64
+	if dropped {
+		newLimit = l.estimatedLimit * l.options.backoffRatio
+	} else {
+		// the limit is only increased when the host is being utilized, otherwise an idle host would see its limit grow
+		// unbounded without any evidence that it can handle the load
+		if float64(inflight) < l.estimatedLimit/2 {
+			return
+		}
+
+		gradient := math.Max(0.5, math.Min(1.0, l.options.rttTolerance*float64(l.minRTT)/float64(rtt)))
+		newLimit = l.estimatedLimit*gradient + math.Sqrt(l.estimatedLimit)
+	}
+
+	newLimit = l.estimatedLimit*(1-l.options.smoothing) + newLimit*l.options.smoothing
+	l.estimatedLimit = math.Max(float64(l.options.minLimit), math.Min(float64(l.options.maxLimit), newLimit))
+}
+
+// Inflight returns the number of requests currently holding capacity.
+func (l *gradientLimiter) Inflight() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.inflight
+}
+
+// Limit returns the current concurrency limit.
+func (l *gradientLimiter) Limit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int(l.estimatedLimit)
+}
+
+// String returns a string representation of the gradient Limiter.
+func (l *gradientLimiter) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return string(anchor.ToJSON(map[string]any{
+		"algorithm": "gradient",
+		"inflight":  l.inflight,
+		"limit":     int(l.estimatedLimit),
+		"min_rtt":   l.minRTT.String(),
+	}))
+}
+
+type aimdLimiter struct {
+	inflight int
+	limit    int
+	mutex    sync.Mutex
+	options  *LimitOption
+}
+
+// NewAIMDLimiter creates a new Limiter based on the additive-increase/multiplicative-decrease algorithm.
+//
+// The limit is increased by one for every successful request observed while the host is utilized, and multiplied by
+// the backoff ratio whenever a request is dropped.
+func NewAIMDLimiter(options ...func(*LimitOption)) Limiter {
+	opts := newLimitOption(options...)
+	return &aimdLimiter{
+		limit:   opts.initialLimit,
+		options: opts,
+	}
+}
+
+// Acquire reserves capacity for a single request, returning false if the current limit has been reached.
+func (l *aimdLimiter) Acquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.inflight >= l.limit {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// Release returns the capacity reserved by Acquire and updates the limit.
+func (l *aimdLimiter) Release(_ time.Duration, dropped bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	inflight := l.inflight
+	if l.inflight > 0 {
+		l.inflight--
+	}
+
+	if dropped {
+		l.limit = clampLimit(int(float64(l.limit)*l.options.backoffRatio), l.options.minLimit, l.options.maxLimit)
+		return
+	}
+
+	if inflight*2 >= l.limit {
+		l.limit = clampLimit(l.limit+1, l.options.minLimit, l.options.maxLimit)
+	}
+}
+
+// Inflight returns the number of requests currently holding capacity.
+func (l *aimdLimiter) Inflight() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.inflight
+}
+
+// Limit returns the current concurrency limit.
+func (l *aimdLimiter) Limit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limit
+}
+
+// String returns a string representation of the AIMD Limiter.
+func (l *aimdLimiter) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return string(anchor.ToJSON(map[string]any{
+		"algorithm": "aimd",
+		"inflight":  l.inflight,
+		"limit":     l.limit,
+	}))
+}
+
+func clampLimit(limit int, minLimit int, maxLimit int) int {
+	return max(minLimit, min(maxLimit, limit))
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGradientLimiter(t *testing.T) {
+	l := NewGradientLimiter(WithInitialLimit(10), WithMaxLimit(100), WithSmoothing(1))
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.Acquire())
+	}
+	assert.False(t, l.Acquire())
+	assert.Equal(t, 10, l.Inflight())
+
+	// latency at the minimum RTT grows the limit
+	l.Release(10*time.Millisecond, false)
+	assert.Greater(t, l.Limit(), 10)
+
+	// latency well above the minimum RTT shrinks the limit
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Acquire())
+	}
+	before := l.Limit()
+	l.Release(100*time.Millisecond, false)
+	assert.Less(t, l.Limit(), before)
+}
+
+func TestAIMDLimiter(t *testing.T) {
+	l := NewAIMDLimiter(WithInitialLimit(4), WithMinLimit(2), WithBackoffRatio(0.5))
+
+	for i := 0; i < 4; i++ {
+		assert.True(t, l.Acquire())
+	}
+	assert.False(t, l.Acquire())
+
+	l.Release(time.Millisecond, false)
+	assert.Equal(t, 5, l.Limit())
+
+	l.Release(time.Millisecond, true)
+	assert.Equal(t, 2, l.Limit())
+
+	l.Release(time.Millisecond, true)
+	assert.Equal(t, 2, l.Limit())
+	assert.Equal(t, 1, l.Inflight())
+}
@@ -1,10 +1,80 @@
 package proxy
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 // LBOption is a container for optional properties that can be used for initializing the Balancer.
 type LBOption struct {
-	selector Selector
+	healthCheck            *HealthCheck
+	limiter                func() Limiter
+	middleware             []Middleware
+	reviveTimeout          time.Duration
+	reviveTimeoutThreshold int
+	selector               Selector
+	upgradeIdleTimeout     time.Duration
+	upgradeMaxLifetime     time.Duration
+}
+
+// WithHealthCheck enables active health checking for the hosts of the Balancer.
+func WithHealthCheck(healthCheck HealthCheck) func(*LBOption) {
+	return func(o *LBOption) {
+		o.healthCheck = &healthCheck
+	}
+}
+
+// WithReviveTimeout sets the number of consecutive failures after which a Host is marked inactive, and the duration
+// after which an inactive Host is revived when active health checking is not enabled.
+func WithReviveTimeout(timeout time.Duration, threshold int) func(*LBOption) {
+	return func(o *LBOption) {
+		o.reviveTimeout = timeout
+		o.reviveTimeoutThreshold = threshold
+	}
+}
+
+// WithLimiter sets the function used for creating the adaptive concurrency Limiter for each Host of the Balancer.
+//
+// Requests exceeding the current limit of the selected Host are rejected with http.StatusServiceUnavailable.
+func WithLimiter(limiter func() Limiter) func(*LBOption) {
+	return func(o *LBOption) {
+		o.limiter = limiter
+	}
+}
+
+// WithFaultInjector sets the FaultInjector used for injecting faults into requests accepted by the Balancer.
+func WithFaultInjector(injector *FaultInjector) func(*LBOption) {
+	return func(o *LBOption) {
+		if injector != nil {
+			o.middleware = append(o.middleware, injector.Handler)
+		}
+	}
+}
+
+// WithMiddleware appends the provided Middleware to the list used for wrapping the Balancer. Middleware is applied in
+// the order provided, with the first being the outermost handler.
+func WithMiddleware(middleware ...Middleware) func(*LBOption) {
+	return func(o *LBOption) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// WithACL sets the ACL used for controlling access to the Balancer based on the IP address of the client.
+func WithACL(acl *ACL) func(*LBOption) {
+	return func(o *LBOption) {
+		if acl != nil {
+			o.middleware = append(o.middleware, acl.Handler)
+		}
+	}
+}
+
+// WithRateLimiter sets the RateLimiter used for limiting the rate of requests accepted by the Balancer.
+func WithRateLimiter(limiter *RateLimiter) func(*LBOption) {
+	return func(o *LBOption) {
+		if limiter != nil {
+			o.middleware = append(o.middleware, limiter.Handler)
+		}
+	}
 }
 
 // WithSelector sets the Selector to use for the Balancer.
@@ -14,13 +84,42 @@ func WithSelector(selector Selector) func(*LBOption) {
 	}
 }
 
+// WithUpgradeTimeouts sets the maximum duration a connection upgraded from HTTP, e.g. a WebSocket connection, can be
+// idle in both directions, and the maximum lifetime of the connection. A non-positive duration disables the timeout.
+func WithUpgradeTimeouts(idle time.Duration, maxLifetime time.Duration) func(*LBOption) {
+	return func(o *LBOption) {
+		o.upgradeIdleTimeout = idle
+		o.upgradeMaxLifetime = maxLifetime
+	}
+}
+
 // HostOption is a container for optional properties that can be used for initializing a Host.
 type HostOption struct {
-	errorHandler func(http.ResponseWriter, *http.Request, error)
-	transport    http.RoundTripper
+	errorHandler  func(http.ResponseWriter, *http.Request, error)
+	proxyProtocol int
+	transport     http.RoundTripper
+}
+
+// WithErrorHandler sets the function for writing the response to a request that could not be proxied to a Host.
+func WithErrorHandler(handler func(http.ResponseWriter, *http.Request, error)) func(*HostOption) {
+	return func(o *HostOption) {
+		o.errorHandler = handler
+	}
+}
+
+// WithProxyProtocol sets the version of the PROXY protocol header, 1 or 2, sent to the Host when connecting on behalf
+// of a client. A version of 0 disables sending the header.
+//
+// Since the header identifies the client of a connection, HTTP connections to the Host are not reused across requests.
+// The header is written by the connections dialed by the transport of the Host, so NewHost returns an error if a
+// transport is also provided using WithTransport.
+func WithProxyProtocol(version int) func(*HostOption) {
+	return func(o *HostOption) {
+		o.proxyProtocol = version
+	}
 }
 
-// WithTransport sets the http.RoundTripper transport for a Host.
+// WithTransport sets the http.RoundTripper transport for a Host. It cannot be combined with WithProxyProtocol.
 func WithTransport(transport http.RoundTripper) func(*HostOption) {
 	return func(o *HostOption) {
 		o.transport = transport
@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+)
+
+const (
+	// PoolFailureThreshold sets the default number of consecutive failures before a Host is marked inactive.
+	PoolFailureThreshold = 3
+
+	// PoolReviveTimeout sets the default duration after which an inactive Host is returned to the active list when
+	// active health checking is not enabled.
+	PoolReviveTimeout = 30 * time.Second
+)
+
+// PoolOption is a container for optional properties that can be used for initializing a Pool.
+type PoolOption struct {
+	failureThreshold int
+	healthCheck      *HealthCheck
+	reviveTimeout    time.Duration
+	selector         Selector
+}
+
+// WithPoolFailureThreshold sets the number of consecutive failures before a Host is marked inactive.
+func WithPoolFailureThreshold(threshold int) func(*PoolOption) {
+	return func(o *PoolOption) {
+		o.failureThreshold = threshold
+	}
+}
+
+// WithPoolHealthCheck enables active health checking for the hosts of a Pool.
+func WithPoolHealthCheck(healthCheck HealthCheck) func(*PoolOption) {
+	return func(o *PoolOption) {
+		o.healthCheck = &healthCheck
+	}
+}
+
+// WithPoolReviveTimeout sets the duration after which an inactive Host is returned to the active list when active
+// health checking is not enabled.
+func WithPoolReviveTimeout(timeout time.Duration) func(*PoolOption) {
+	return func(o *PoolOption) {
+		o.reviveTimeout = timeout
+	}
+}
+
+// WithPoolSelector sets the Selector used for selecting hosts from a Pool.
+func WithPoolSelector(selector Selector) func(*PoolOption) {
+	return func(o *PoolOption) {
+		o.selector = selector
+	}
+}
+
+// Pool manages the membership and health of a set of Host proxies, tracking which are active and available for
+// selection, and which are inactive due to failures.
+//
+// Hosts are marked inactive passively, after a number of consecutive failures reported using MarkFailed, or actively
+// using a HealthCheck. Without a HealthCheck, inactive hosts are revived after the revive timeout has elapsed.
+type Pool struct {
+	active                 []*Host
+	checker                *healthChecker
+	inactive               []*Host
+	mutex                  sync.RWMutex
+	reviveTimeout          time.Duration
+	reviveTimeoutThreshold int
+	selector               Selector
+}
+
+// NewPool creates a new Pool containing the provided Host proxies. Hosts retain their status, so hosts that have been
+// marked inactive by another Pool, e.g. prior to a configuration reload, are added as inactive.
+//
+// If a HealthCheck is provided, the Pool starts checking the hosts in the background until Close is called.
+func NewPool(hosts []*Host, options ...func(*PoolOption)) (*Pool, error) {
+	opts := &PoolOption{
+		failureThreshold: PoolFailureThreshold,
+		reviveTimeout:    PoolReviveTimeout,
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	p := &Pool{
+		active:                 []*Host{},
+		inactive:               []*Host{},
+		reviveTimeout:          opts.reviveTimeout,
+		reviveTimeoutThreshold: max(1, opts.failureThreshold),
+		selector:               opts.selector,
+	}
+
+	// sanitize the list of provided hosts and add them to the pool according to their status
+	for _, h := range hosts {
+		if h != nil {
+			t, err := h.Target()
+			if err != nil {
+				return nil, fmt.Errorf("proxy_pool: %w", err)
+			}
+
+			if slices.Contains(p.active, h) || slices.Contains(p.inactive, h) {
+				continue
+			}
+			log.Debug("[proxy:pool] adding host", log.String("target", t.String()))
+
+			if h.Active() {
+				p.active = append(p.active, h)
+			} else {
+				p.inactive = append(p.inactive, h)
+			}
+		}
+	}
+
+	if len(p.active)+len(p.inactive) == 0 {
+		return nil, errors.New("proxy_pool: at least one host must be provided")
+	}
+
+	if p.selector == nil {
+		// if the option to set a Selector is nil, use the round-robin selector as the default
+		p.selector = &roundRobinSelector{current: -1}
+	}
+
+	if opts.healthCheck != nil {
+		p.checker = newHealthChecker(p, *opts.healthCheck)
+		p.checker.start()
+	}
+	return p, nil
+}
+
+// Active returns the list of active Host proxies in the Pool.
+func (p *Pool) Active() []*Host {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return slices.Clone(p.active)
+}
+
+// Add adds the Host to the Pool as an active Host if it is not already a member.
+func (p *Pool) Add(h *Host) {
+	if h == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if slices.Contains(p.active, h) || slices.Contains(p.inactive, h) {
+		return
+	}
+	h.markActive()
+	p.active = append(p.active, h)
+}
+
+// Close stops active health checking for the Pool, if enabled. The hosts of the Pool are left unchanged.
+func (p *Pool) Close() error {
+	if p.checker != nil {
+		p.checker.stop()
+	}
+	return nil
+}
+
+// Hosts returns the list of all Host proxies in the Pool, active hosts first.
+func (p *Pool) Hosts() []*Host {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append(slices.Clone(p.active), p.inactive...)
+}
+
+// MarkFailed records a failure for the Host, moving it to the inactive list once the number of consecutive failures
+// reaches the failure threshold for the Pool.
+func (p *Pool) MarkFailed(h *Host) {
+	if n := h.markFailed(); n >= p.reviveTimeoutThreshold {
+		p.deactivate(h)
+	}
+}
+
+// MarkHealthy resets the failures for the Host and moves it to the active list if it was inactive.
+func (p *Pool) MarkHealthy(h *Host) {
+	if h.Active() && h.Failures() == 0 {
+		return
+	}
+	h.markHealthy()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if i := slices.Index(p.inactive, h); i >= 0 {
+		log.Info("[proxy:pool] host is healthy", log.String("target", h.target.String()))
+		p.inactive = slices.Delete(p.inactive, i, i+1)
+		p.active = append(p.active, h)
+	}
+}
+
+// Remove removes the Host from the Pool, returning whether it was a member.
+func (p *Pool) Remove(h *Host) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if i := slices.Index(p.active, h); i >= 0 {
+		p.active = slices.Delete(p.active, i, i+1)
+		return true
+	}
+
+	if i := slices.Index(p.inactive, h); i >= 0 {
+		p.inactive = slices.Delete(p.inactive, i, i+1)
+		return true
+	}
+	return false
+}
+
+// Select selects one of the active Host proxies using the Selector for the Pool.
+func (p *Pool) Select() (*Host, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.checker == nil {
+		p.revive()
+	}
+
+	if len(p.active) == 0 {
+		return nil, errors.New("proxy_pool: no active hosts")
+	}
+	return p.selector.Select(p.active...)
+}
+
+// String returns a string representation of the Pool.
+func (p *Pool) String() string {
+	return string(anchor.ToJSON(p.toMap()))
+}
+
+// deactivate moves the Host to the inactive list.
+func (p *Pool) deactivate(h *Host) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if i := slices.Index(p.active, h); i >= 0 {
+		log.Warn("[proxy:pool] host is inactive",
+			log.String("target", h.target.String()),
+			log.Int("failures", h.Failures()))
+
+		h.markInactive()
+		p.active = slices.Delete(p.active, i, i+1)
+		p.inactive = append(p.inactive, h)
+	}
+}
+
+// revive moves inactive hosts whose revive timeout has elapsed back to the active list. The failures for revived hosts
+// are retained, so a single subsequent failure marks them inactive again. The caller must hold the lock for the Pool.
+func (p *Pool) revive() {
+	if len(p.inactive) == 0 || p.reviveTimeout <= 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	for i := 0; i < len(p.inactive); {
+		h := p.inactive[i]
+		if now.Sub(h.InactiveSince()) < p.reviveTimeout {
+			i++
+			continue
+		}
+		log.Info("[proxy:pool] reviving host", log.String("target", h.target.String()))
+		h.markActive()
+		p.inactive = slices.Delete(p.inactive, i, i+1)
+		p.active = append(p.active, h)
+	}
+}
+
+// toMap returns a map representing the Pool attributes.
+func (p *Pool) toMap() map[string]any {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	var activeHosts []map[string]any
+	for _, h := range p.active {
+		activeHosts = append(activeHosts, h.toMap())
+	}
+
+	var inactiveHosts []map[string]any
+	for _, h := range p.inactive {
+		inactiveHosts = append(inactiveHosts, h.toMap())
+	}
+
+	m := map[string]any{
+		"hosts": len(p.active) + len(p.inactive),
+		"active": map[string]any{
+			"count": len(activeHosts),
+			"hosts": activeHosts,
+		},
+		"inactive": map[string]any{
+			"count": len(inactiveHosts),
+			"hosts": inactiveHosts,
+		},
+	}
+
+	// the limit of the pool is the sum of the concurrency limits of the active hosts
+	var limit, inflight int
+	limited := false
+	for _, h := range p.active {
+		if l := h.Limiter(); l != nil {
+			limit += l.Limit()
+			inflight += l.Inflight()
+			limited = true
+		}
+	}
+
+	if limited {
+		m["limit"] = map[string]any{
+			"inflight": inflight,
+			"limit":    limit,
+		}
+	}
+
+	if p.checker != nil {
+		m["health_check"] = p.checker.config.toMap()
+	}
+	return m
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/transientvariable/anchor/net"
+
+	gonet "net"
+)
+
+type clientAddrKey struct{}
+
+// contextWithClientAddr returns a copy of the provided context containing the remote address of a request, which is
+// used as the source address of the PROXY protocol header when connecting to a Host.
+func contextWithClientAddr(ctx context.Context, remoteAddr string) context.Context {
+	ap, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clientAddrKey{}, gonet.TCPAddrFromAddrPort(ap))
+}
+
+// dialContext connects to the address of the Host, sending a PROXY protocol header with the provided client source
+// and destination addresses if enabled for the Host. If either address is unknown, the header indicates a connection
+// established by the proxy itself.
+func (h *Host) dialContext(ctx context.Context, network string, addr string, src gonet.Addr, dst gonet.Addr) (gonet.Conn, error) {
+	var d gonet.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil || h.proxyProtocol == 0 {
+		return conn, err
+	}
+
+	header := &net.ProxyHeader{
+		Command:     net.ProxyCommandProxy,
+		Destination: dst,
+		Source:      src,
+		Version:     h.proxyProtocol,
+	}
+
+	if src == nil || dst == nil {
+		header.Command = net.ProxyCommandLocal
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetWriteDeadline(deadline)
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+
+	if _, err := header.WriteTo(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy_host: could not write PROXY protocol header: %w", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/transientvariable/anchor/net"
+
+	"github.com/stretchr/testify/assert"
+
+	gonet "net"
+	gohttp "net/http"
+)
+
+func TestHostProxyProtocol(t *testing.T) {
+	s := httptest.NewUnstartedServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr+" "+r.Header.Get(headerForwarded))
+	}))
+
+	l, err := net.NewProxyProtocolListener(s.Listener,
+		net.WithProxyProtocolTrusted(netip.MustParsePrefix("127.0.0.0/8")),
+		net.WithProxyProtocolRequired(true))
+	assert.NoError(t, err)
+	s.Listener = l
+	s.Start()
+	defer s.Close()
+
+	for _, version := range []int{1, 2} {
+		h, err := NewHost(s.URL, WithProxyProtocol(version))
+		assert.NoError(t, err)
+		b, err := NewBalancer([]*Host{h})
+		assert.NoError(t, err)
+
+		r := httptest.NewRequest(gohttp.MethodGet, "http://example.com/", nil)
+		r.Header.Set(headerForwarded, "for=198.51.100.7")
+		local := gonet.TCPAddrFromAddrPort(netip.MustParseAddrPort("203.0.113.1:80"))
+		r = r.WithContext(context.WithValue(r.Context(), gohttp.LocalAddrContextKey, local))
+
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, r)
+		assert.Equal(t, gohttp.StatusOK, w.Code)
+		assert.Equal(t, "192.0.2.1:1234 for=198.51.100.7, for=192.0.2.1;host=example.com;proto=http", w.Body.String())
+		assert.NoError(t, b.Close())
+	}
+
+	_, err = NewHost(s.URL, WithProxyProtocol(3))
+	assert.Error(t, err)
+
+	// the header cannot be sent using a custom transport
+	_, err = NewHost(s.URL, WithProxyProtocol(1), WithTransport(s.Client().Transport))
+	assert.Error(t, err)
+}
+
+func TestForwardedNode(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1:1234":          "192.0.2.1",
+		"[2001:db8::1]:1234":      `"[2001:db8::1]"`,
+		"[::ffff:192.0.2.1]:1234": "192.0.2.1",
+		"@":                       "unknown",
+		"":                        "",
+	} {
+		assert.Equal(t, want, forwardedNode(addr), addr)
+	}
+	assert.Equal(t, `"example.com:8080"`, forwardedValue("example.com:8080"))
+}
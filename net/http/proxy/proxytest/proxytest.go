@@ -0,0 +1,486 @@
+// Package proxytest provides an in-process cluster of fake upstream HTTP servers with scriptable behavior for testing
+// load balancing, health checking, and failover.
+package proxytest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/transientvariable/anchor"
+)
+
+// HeaderUpstream is the response header containing the index of the Upstream that served a request.
+const HeaderUpstream = "X-Proxytest-Upstream"
+
+// LatencyFunc defines a function for sampling the latency of a response from a random source.
+type LatencyFunc func(*rand.Rand) time.Duration
+
+// FixedLatency returns a LatencyFunc that always returns the provided duration.
+func FixedLatency(d time.Duration) LatencyFunc {
+	return func(*rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency returns a LatencyFunc that samples latencies uniformly from the range [low, high).
+func UniformLatency(low time.Duration, high time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		if high <= low {
+			return low
+		}
+		return low + time.Duration(r.Int64N(int64(high-low)))
+	}
+}
+
+// NormalLatency returns a LatencyFunc that samples latencies from a normal distribution, truncated at zero.
+func NormalLatency(mean time.Duration, stddev time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(math.Max(0, r.NormFloat64()*float64(stddev)+float64(mean)))
+	}
+}
+
+// Behavior defines how an Upstream responds to requests.
+type Behavior struct {
+	// Body is the response body. Defaults to "upstream <index>".
+	Body string
+
+	// BodyChunks is the number of chunks the response body is written in when BodyDelay is set.
+	BodyChunks int
+
+	// BodyDelay is the delay between writing chunks of the response body, simulating a slow body.
+	BodyDelay time.Duration
+
+	// ErrorRate is the fraction, in the range [0, 1], of requests responded to with ErrorStatus.
+	ErrorRate float64
+
+	// ErrorStatus is the status code used for error responses. Defaults to http.StatusInternalServerError.
+	ErrorStatus int
+
+	// Handler, if set, is called for writing the response instead of the default behavior. Latency is still applied.
+	Handler http.HandlerFunc
+
+	// Latency is the function for sampling the delay before response headers are written.
+	Latency LatencyFunc
+
+	// Status is the status code for successful responses. Defaults to http.StatusOK.
+	Status int
+}
+
+// Record contains the attributes of a request served by an Upstream.
+type Record struct {
+	Method   string
+	Path     string
+	Status   int
+	Time     time.Time
+	Upstream int
+}
+
+// Option is a container for optional properties that can be used for initializing a Cluster.
+type Option struct {
+	behavior Behavior
+	seed     *uint64
+}
+
+// WithBehavior sets the initial Behavior for all upstreams of a Cluster.
+func WithBehavior(behavior Behavior) func(*Option) {
+	return func(o *Option) {
+		o.behavior = behavior
+	}
+}
+
+// WithSeed sets the seed for the random source used for sampling latencies and errors, making a Cluster
+// deterministic.
+func WithSeed(seed uint64) func(*Option) {
+	return func(o *Option) {
+		o.seed = &seed
+	}
+}
+
+// Cluster is a set of in-process upstream HTTP servers that records which Upstream served each request.
+type Cluster struct {
+	mutex     sync.Mutex
+	random    *rand.Rand
+	records   []Record
+	upstreams []*Upstream
+}
+
+// NewCluster starts a new Cluster with n upstreams. The Cluster should be closed when no longer needed.
+func NewCluster(n int, options ...func(*Option)) (*Cluster, error) {
+	if n <= 0 {
+		return nil, errors.New("proxytest: at least one upstream is required")
+	}
+
+	opts := &Option{}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	c := &Cluster{}
+	if opts.seed != nil {
+		c.random = rand.New(rand.NewPCG(*opts.seed, *opts.seed))
+	} else {
+		c.random = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+
+	for i := 0; i < n; i++ {
+		u := &Upstream{cluster: c, index: i}
+		u.SetBehavior(opts.behavior)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("proxytest: %w", err)
+		}
+		u.addr = l.Addr().String()
+		u.serve(l)
+		c.upstreams = append(c.upstreams, u)
+	}
+	return c, nil
+}
+
+// Close shuts down all upstreams of the Cluster.
+func (c *Cluster) Close() {
+	for _, u := range c.upstreams {
+		u.Down()
+	}
+}
+
+// Counts returns the number of requests served by each Upstream, indexed by Upstream.
+func (c *Cluster) Counts() []int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts := make([]int, len(c.upstreams))
+	for _, r := range c.records {
+		counts[r.Upstream]++
+	}
+	return counts
+}
+
+// Len returns the number of upstreams in the Cluster.
+func (c *Cluster) Len() int {
+	return len(c.upstreams)
+}
+
+// Records returns the list of Record for the requests served by the Cluster, in the order they were served.
+func (c *Cluster) Records() []Record {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]Record(nil), c.records...)
+}
+
+// Reset clears the list of Record for the Cluster.
+func (c *Cluster) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.records = nil
+}
+
+// Sequence returns the indexes of the upstreams that served each request, in the order they were served.
+func (c *Cluster) Sequence() []int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	seq := make([]int, len(c.records))
+	for i, r := range c.records {
+		seq[i] = r.Upstream
+	}
+	return seq
+}
+
+// String returns a string representation of the Cluster.
+func (c *Cluster) String() string {
+	var upstreams []map[string]any
+	counts := c.Counts()
+	for i, u := range c.upstreams {
+		upstreams = append(upstreams, map[string]any{
+			"down":     u.IsDown(),
+			"requests": counts[i],
+			"url":      u.URL(),
+		})
+	}
+	return string(anchor.ToJSON(map[string]any{"upstreams": upstreams}))
+}
+
+// Upstream returns the Upstream at the provided index.
+func (c *Cluster) Upstream(i int) *Upstream {
+	return c.upstreams[i]
+}
+
+// URLs returns the list of base URLs for the upstreams in the Cluster, indexed by Upstream.
+func (c *Cluster) URLs() []string {
+	urls := make([]string, len(c.upstreams))
+	for i, u := range c.upstreams {
+		urls[i] = u.URL()
+	}
+	return urls
+}
+
+// AssertDistribution asserts that the fraction of requests served by each Upstream is within the tolerance of the
+// provided weights. Weights are normalized, so they can be given as relative values.
+func (c *Cluster) AssertDistribution(t testing.TB, tolerance float64, weights ...float64) bool {
+	t.Helper()
+	if len(weights) != len(c.upstreams) {
+		t.Errorf("proxytest: expected %d weights, but got %d", len(c.upstreams), len(weights))
+		return false
+	}
+
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+
+	counts := c.Counts()
+	var total int
+	for _, n := range counts {
+		total += n
+	}
+
+	if total == 0 || sum == 0 {
+		t.Errorf("proxytest: no requests have been served")
+		return false
+	}
+
+	ok := true
+	for i, n := range counts {
+		actual := float64(n) / float64(total)
+		expected := weights[i] / sum
+		if math.Abs(actual-expected) > tolerance {
+			t.Errorf("proxytest: upstream %d served %.3f of requests, expected %.3f ± %.3f", i, actual, expected, tolerance)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertEven asserts that requests have been distributed evenly across all upstreams within the tolerance.
+func (c *Cluster) AssertEven(t testing.TB, tolerance float64) bool {
+	t.Helper()
+	weights := make([]float64, len(c.upstreams))
+	for i := range weights {
+		weights[i] = 1
+	}
+	return c.AssertDistribution(t, tolerance, weights...)
+}
+
+// AssertNotServedBy asserts that none of the requests have been served by the upstreams with the provided indexes,
+// e.g. after they have been taken down.
+func (c *Cluster) AssertNotServedBy(t testing.TB, indexes ...int) bool {
+	t.Helper()
+	counts := c.Counts()
+	ok := true
+	for _, i := range indexes {
+		if counts[i] > 0 {
+			t.Errorf("proxytest: expected upstream %d to serve no requests, but it served %d", i, counts[i])
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertSequence asserts that requests have been served by upstreams in exactly the provided order.
+func (c *Cluster) AssertSequence(t testing.TB, indexes ...int) bool {
+	t.Helper()
+	seq := c.Sequence()
+	if len(seq) != len(indexes) {
+		t.Errorf("proxytest: expected sequence %v, but got %v", indexes, seq)
+		return false
+	}
+
+	for i := range seq {
+		if seq[i] != indexes[i] {
+			t.Errorf("proxytest: expected sequence %v, but got %v", indexes, seq)
+			return false
+		}
+	}
+	return true
+}
+
+// AssertServedBy asserts that the Upstream with the provided index has served exactly n requests.
+func (c *Cluster) AssertServedBy(t testing.TB, index int, n int) bool {
+	t.Helper()
+	if actual := c.Counts()[index]; actual != n {
+		t.Errorf("proxytest: expected upstream %d to serve %d requests, but it served %d", index, n, actual)
+		return false
+	}
+	return true
+}
+
+func (c *Cluster) record(r Record) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.records = append(c.records, r)
+}
+
+func (c *Cluster) sample(fn func(*rand.Rand)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fn(c.random)
+}
+
+// Upstream is a single fake upstream HTTP server in a Cluster.
+type Upstream struct {
+	addr     string
+	behavior atomic.Pointer[Behavior]
+	cluster  *Cluster
+	down     atomic.Bool
+	index    int
+	mutex    sync.Mutex
+	requests atomic.Int64
+	server   *http.Server
+}
+
+// Down stops the Upstream, closing its listener and all active connections so that new connections are refused.
+func (u *Upstream) Down() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.server == nil {
+		return
+	}
+	u.down.Store(true)
+	_ = u.server.Close()
+	u.server = nil
+}
+
+// Up restarts the Upstream on its original address after it has been stopped with Down.
+func (u *Upstream) Up() error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.server != nil {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", u.addr)
+	if err != nil {
+		return fmt.Errorf("proxytest: could not restart upstream %d: %w", u.index, err)
+	}
+	u.down.Store(false)
+	u.serveLocked(l)
+	return nil
+}
+
+// Index returns the index of the Upstream in its Cluster.
+func (u *Upstream) Index() int {
+	return u.index
+}
+
+// IsDown returns whether the Upstream has been stopped.
+func (u *Upstream) IsDown() bool {
+	return u.down.Load()
+}
+
+// Requests returns the number of requests received by the Upstream.
+func (u *Upstream) Requests() int {
+	return int(u.requests.Load())
+}
+
+// SetBehavior replaces the Behavior for the Upstream.
+func (u *Upstream) SetBehavior(behavior Behavior) {
+	u.behavior.Store(&behavior)
+}
+
+// URL returns the base URL for the Upstream.
+func (u *Upstream) URL() string {
+	return "http://" + u.addr
+}
+
+func (u *Upstream) serve(l net.Listener) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.serveLocked(l)
+}
+
+func (u *Upstream) serveLocked(l net.Listener) {
+	s := &http.Server{Handler: http.HandlerFunc(u.serveHTTP)}
+	u.server = s
+	go func() {
+		_ = s.Serve(l)
+	}()
+}
+
+func (u *Upstream) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	u.requests.Add(1)
+	b := *u.behavior.Load()
+
+	var (
+		latency time.Duration
+		failed  bool
+	)
+	u.cluster.sample(func(random *rand.Rand) {
+		if b.Latency != nil {
+			latency = b.Latency(random)
+		}
+		failed = b.ErrorRate > 0 && random.Float64() < b.ErrorRate
+	})
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		}
+	}
+
+	status := b.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if failed {
+		status = b.ErrorStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+	}
+
+	u.cluster.record(Record{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Status:   status,
+		Time:     time.Now(),
+		Upstream: u.index,
+	})
+	w.Header().Set(HeaderUpstream, strconv.Itoa(u.index))
+
+	if b.Handler != nil && !failed {
+		b.Handler(w, r)
+		return
+	}
+
+	body := b.Body
+	if body == "" {
+		body = "upstream " + strconv.Itoa(u.index)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+
+	if b.BodyDelay <= 0 || b.BodyChunks <= 1 {
+		_, _ = w.Write([]byte(body))
+		return
+	}
+
+	size := (len(body) + b.BodyChunks - 1) / b.BodyChunks
+	for i := 0; i < len(body); i += size {
+		if i > 0 {
+			select {
+			case <-time.After(b.BodyDelay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if _, err := w.Write([]byte(body[i:min(i+size, len(body))])); err != nil {
+			return
+		}
+		_ = http.NewResponseController(w).Flush()
+	}
+}
@@ -0,0 +1,183 @@
+package proxytest
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatency(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 1))
+	assert.Equal(t, time.Second, FixedLatency(time.Second)(random))
+
+	uniform := UniformLatency(10*time.Millisecond, 20*time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		d := uniform(random)
+		assert.GreaterOrEqual(t, d, 10*time.Millisecond)
+		assert.Less(t, d, 20*time.Millisecond)
+	}
+	assert.Equal(t, 10*time.Millisecond, UniformLatency(10*time.Millisecond, 0)(random))
+
+	normal := NormalLatency(10*time.Millisecond, 2*time.Millisecond)
+	var sum time.Duration
+	for i := 0; i < 1000; i++ {
+		sum += normal(random)
+	}
+	assert.InDelta(t, float64(10*time.Millisecond), float64(sum/1000), float64(time.Millisecond))
+
+	// latencies are never negative
+	normal = NormalLatency(0, time.Second)
+	for i := 0; i < 1000; i++ {
+		assert.GreaterOrEqual(t, normal(random), time.Duration(0))
+	}
+}
+
+func TestCluster(t *testing.T) {
+	_, err := NewCluster(0)
+	assert.Error(t, err)
+
+	c, err := NewCluster(2)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, 2, c.Len())
+
+	for i, url := range c.URLs() {
+		code, body, header := get(t, url+"/path")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "upstream "+strconv.Itoa(i), body)
+		assert.Equal(t, strconv.Itoa(i), header.Get(HeaderUpstream))
+	}
+	assert.Equal(t, []int{1, 1}, c.Counts())
+	assert.Equal(t, []int{0, 1}, c.Sequence())
+	assert.Equal(t, 1, c.Upstream(1).Requests())
+
+	records := c.Records()
+	require.Len(t, records, 2)
+	assert.Equal(t, http.MethodGet, records[0].Method)
+	assert.Equal(t, "/path", records[0].Path)
+	assert.Equal(t, http.StatusOK, records[0].Status)
+	assert.Contains(t, c.String(), c.URLs()[0])
+
+	c.Reset()
+	assert.Empty(t, c.Records())
+
+	// stopped upstreams refuse connections until they are restarted
+	c.Upstream(0).Down()
+	assert.True(t, c.Upstream(0).IsDown())
+	_, err = http.Get(c.URLs()[0])
+	assert.Error(t, err)
+
+	require.NoError(t, c.Upstream(0).Up())
+	assert.False(t, c.Upstream(0).IsDown())
+	code, _, _ := get(t, c.URLs()[0])
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestBehavior(t *testing.T) {
+	c, err := NewCluster(1, WithSeed(1), WithBehavior(Behavior{Body: "ok", Status: http.StatusAccepted}))
+	require.NoError(t, err)
+	defer c.Close()
+
+	u := c.Upstream(0)
+	code, body, _ := get(t, u.URL())
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "ok", body)
+
+	// a fraction of the requests fail with the error status
+	u.SetBehavior(Behavior{ErrorRate: 0.25, ErrorStatus: http.StatusServiceUnavailable})
+	var failed int
+	for i := 0; i < 400; i++ {
+		if code, _, _ := get(t, u.URL()); code == http.StatusServiceUnavailable {
+			failed++
+		}
+	}
+	assert.InDelta(t, 100, failed, 30)
+
+	u.SetBehavior(Behavior{ErrorRate: 1})
+	code, _, _ = get(t, u.URL())
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	// latency is applied before the response headers are written
+	u.SetBehavior(Behavior{Latency: FixedLatency(50 * time.Millisecond)})
+	start := time.Now()
+	get(t, u.URL())
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// slow bodies are written in chunks
+	u.SetBehavior(Behavior{Body: "0123456789", BodyChunks: 3, BodyDelay: 20 * time.Millisecond})
+	start = time.Now()
+	_, body, _ = get(t, u.URL())
+	assert.Equal(t, "0123456789", body)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	u.SetBehavior(Behavior{Handler: func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}})
+	code, _, _ = get(t, u.URL())
+	assert.Equal(t, http.StatusTeapot, code)
+}
+
+func TestAssertions(t *testing.T) {
+	c, err := NewCluster(3)
+	require.NoError(t, err)
+	defer c.Close()
+
+	for _, i := range []int{0, 1, 1, 2, 2, 2} {
+		get(t, c.URLs()[i])
+	}
+
+	for _, tc := range []struct {
+		assert func(testing.TB) bool
+		ok     bool
+	}{
+		{assert: func(tb testing.TB) bool { return c.AssertDistribution(tb, 0.01, 1, 2, 3) }, ok: true},
+		{assert: func(tb testing.TB) bool { return c.AssertDistribution(tb, 0.1, 1, 1, 1) }},
+		{assert: func(tb testing.TB) bool { return c.AssertDistribution(tb, 0.1, 1, 1) }},
+		{assert: func(tb testing.TB) bool { return c.AssertEven(tb, 0.35) }, ok: true},
+		{assert: func(tb testing.TB) bool { return c.AssertEven(tb, 0.1) }},
+		{assert: func(tb testing.TB) bool { return c.AssertNotServedBy(tb, 0) }},
+		{assert: func(tb testing.TB) bool { return c.AssertSequence(tb, 0, 1, 1, 2, 2, 2) }, ok: true},
+		{assert: func(tb testing.TB) bool { return c.AssertSequence(tb, 0, 1, 2) }},
+		{assert: func(tb testing.TB) bool { return c.AssertServedBy(tb, 2, 3) }, ok: true},
+		{assert: func(tb testing.TB) bool { return c.AssertServedBy(tb, 2, 1) }},
+	} {
+		tb := &recorder{TB: t}
+		assert.Equal(t, tc.ok, tc.assert(tb))
+		assert.Equal(t, !tc.ok, tb.failed)
+	}
+
+	c.Reset()
+	tb := &recorder{TB: t}
+	assert.False(t, c.AssertEven(tb, 1))
+}
+
+// get sends a GET request to the URL, returning the status code, body, and header of the response.
+func get(t *testing.T, url string) (int, string, http.Header) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(b), resp.Header
+}
+
+// recorder is a testing.TB recording whether an assertion failed instead of failing the test.
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failed = true
+	r.Logf("%s", fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Helper() {}
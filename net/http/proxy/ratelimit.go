@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+)
+
+const (
+	// RateLimitMaxKeys sets the default number of client keys tracked by a RateLimiter before the least recently used
+	// keys are evicted.
+	RateLimitMaxKeys = 10000
+
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+	headerRetryAfter         = "Retry-After"
+)
+
+// KeyFunc defines a function for extracting the client identity used for rate limiting a request. Requests for which
+// an empty key is returned are rate limited by the IP address of the remote peer, so that clients cannot bypass rate
+// limiting by omitting their identity, e.g. the header used by KeyByHeader.
+type KeyFunc func(*http.Request) string
+
+// KeyByClientIP returns a KeyFunc that identifies clients by the IP address of the remote peer.
+func KeyByClientIP() KeyFunc {
+	return func(r *http.Request) string {
+		if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			return h
+		}
+		return r.RemoteAddr
+	}
+}
+
+// KeyByHeader returns a KeyFunc that identifies clients by the value of the provided header, e.g. an API key. Requests
+// without the header are rate limited by the IP address of the remote peer.
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+}
+
+// RateLimitRule defines the token bucket parameters used for requests matching a Route.
+type RateLimitRule struct {
+	// Burst is the maximum number of requests that can be made at once, i.e. the capacity of the token bucket.
+	Burst int
+
+	// Rate is the number of requests per second at which the token bucket is refilled.
+	Rate float64
+
+	// Route is the criteria a request must satisfy for the rule to be applied.
+	Route Route
+}
+
+// RateLimitOption is a container for optional properties that can be used for initializing a RateLimiter.
+type RateLimitOption struct {
+	burst   int
+	keyFunc KeyFunc
+	maxKeys int
+	rate    float64
+	rules   []RateLimitRule
+}
+
+// WithRateLimit sets the default rate, in requests per second, and burst size for requests that do not match any
+// RateLimitRule.
+func WithRateLimit(rate float64, burst int) func(*RateLimitOption) {
+	return func(o *RateLimitOption) {
+		o.rate = rate
+		o.burst = burst
+	}
+}
+
+// WithRateLimitKey sets the KeyFunc used for identifying clients. Defaults to KeyByClientIP.
+func WithRateLimitKey(keyFunc KeyFunc) func(*RateLimitOption) {
+	return func(o *RateLimitOption) {
+		o.keyFunc = keyFunc
+	}
+}
+
+// WithRateLimitMaxKeys sets the maximum number of client keys tracked before the least recently used are evicted.
+func WithRateLimitMaxKeys(maxKeys int) func(*RateLimitOption) {
+	return func(o *RateLimitOption) {
+		o.maxKeys = maxKeys
+	}
+}
+
+// WithRateLimitRule appends a RateLimitRule. Rules are evaluated in the order they are provided, and the first rule
+// matching a request is applied.
+func WithRateLimitRule(rule RateLimitRule) func(*RateLimitOption) {
+	return func(o *RateLimitOption) {
+		o.rules = append(o.rules, rule)
+	}
+}
+
+// RateLimiter limits the rate of HTTP requests per client using token buckets.
+type RateLimiter struct {
+	buckets  map[string]*list.Element
+	eviction *list.List
+	keyFunc  KeyFunc
+	maxKeys  int
+	mutex    sync.Mutex
+	now      func() time.Time
+	rules    []RateLimitRule
+}
+
+// NewRateLimiter creates a new RateLimiter using the provided options.
+func NewRateLimiter(options ...func(*RateLimitOption)) (*RateLimiter, error) {
+	opts := &RateLimitOption{}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	l := &RateLimiter{
+		buckets:  make(map[string]*list.Element),
+		eviction: list.New(),
+		keyFunc:  opts.keyFunc,
+		maxKeys:  opts.maxKeys,
+		now:      time.Now,
+	}
+
+	if l.keyFunc == nil {
+		l.keyFunc = KeyByClientIP()
+	}
+
+	if l.maxKeys <= 0 {
+		l.maxKeys = RateLimitMaxKeys
+	}
+
+	for i, r := range opts.rules {
+		if r.Rate <= 0 || r.Burst <= 0 {
+			return nil, fmt.Errorf("proxy_ratelimit: rule %d: rate and burst must be greater than zero", i)
+		}
+		l.rules = append(l.rules, r)
+	}
+
+	// the default limit is represented as a final rule matching any request
+	if opts.rate > 0 || opts.burst > 0 {
+		if opts.rate <= 0 || opts.burst <= 0 {
+			return nil, errors.New("proxy_ratelimit: default rate and burst must be greater than zero")
+		}
+		l.rules = append(l.rules, RateLimitRule{Burst: opts.burst, Rate: opts.rate})
+	}
+
+	if len(l.rules) == 0 {
+		return nil, errors.New("proxy_ratelimit: at least one rate limit must be provided")
+	}
+	return l, nil
+}
+
+// Handler returns a http.Handler that applies the RateLimiter to requests before passing them to the provided
+// http.Handler. Requests exceeding the limit are rejected with http.StatusTooManyRequests.
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, ok := l.allow(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set(headerRateLimitLimit, strconv.Itoa(res.limit))
+		w.Header().Set(headerRateLimitRemaining, strconv.Itoa(res.remaining))
+		w.Header().Set(headerRateLimitReset, strconv.Itoa(seconds(res.reset)))
+		if !res.allowed {
+			log.Debug("[proxy:ratelimit] rate limit exceeded", log.String("key", res.key))
+			w.Header().Set(headerRetryAfter, strconv.Itoa(seconds(res.retryAfter)))
+			writeError(w, r, http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Len returns the number of client keys currently tracked by the RateLimiter.
+func (l *RateLimiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.eviction.Len()
+}
+
+// String returns a string representation of the RateLimiter.
+func (l *RateLimiter) String() string {
+	var rules []map[string]any
+	for _, r := range l.rules {
+		rules = append(rules, map[string]any{
+			"burst": r.Burst,
+			"rate":  r.Rate,
+			"route": r.Route.toMap(),
+		})
+	}
+	return string(anchor.ToJSON(map[string]any{
+		"keys":     l.Len(),
+		"max_keys": l.maxKeys,
+		"rules":    rules,
+	}))
+}
+
+// allow takes a token from the bucket for the client and rule matching the request. The returned boolean is false if
+// the request is not subject to rate limiting.
+func (l *RateLimiter) allow(r *http.Request) (rateLimitResult, bool) {
+	key, scope := l.keyFunc(r), "key"
+	if key == "" {
+		key, scope = KeyByClientIP()(r), "ip"
+	}
+
+	idx := -1
+	for i := range l.rules {
+		if l.rules[i].Route.Match(r) {
+			idx = i
+			break
+		}
+	}
+
+	if idx < 0 {
+		return rateLimitResult{}, false
+	}
+	rule := l.rules[idx]
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	b := l.bucket(strconv.Itoa(idx)+"|"+scope+"|"+key, rule, now)
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+	b.last = now
+
+	res := rateLimitResult{key: key, limit: rule.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.allowed = true
+	} else {
+		res.retryAfter = time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+	}
+	res.remaining = int(b.tokens)
+	res.reset = time.Duration((float64(rule.Burst) - b.tokens) / rule.Rate * float64(time.Second))
+	return res, true
+}
+
+// bucket returns the token bucket for the provided key, creating it and evicting the least recently used bucket if
+// necessary.
+func (l *RateLimiter) bucket(key string, rule RateLimitRule, now time.Time) *tokenBucket {
+	if e, ok := l.buckets[key]; ok {
+		l.eviction.MoveToFront(e)
+		return e.Value.(*tokenBucket)
+	}
+
+	for l.eviction.Len() >= l.maxKeys {
+		e := l.eviction.Back()
+		l.eviction.Remove(e)
+		delete(l.buckets, e.Value.(*tokenBucket).key)
+	}
+
+	b := &tokenBucket{key: key, last: now, tokens: float64(rule.Burst)}
+	l.buckets[key] = l.eviction.PushFront(b)
+	return b
+}
+
+type tokenBucket struct {
+	key    string
+	last   time.Time
+	tokens float64
+}
+
+type rateLimitResult struct {
+	allowed    bool
+	key        string
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// seconds returns the provided duration in whole seconds, rounded up.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
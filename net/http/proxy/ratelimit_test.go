@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl, err := NewRateLimiter(
+		WithRateLimit(1, 2),
+		WithRateLimitKey(KeyByHeader("X-Api-Key")),
+		WithRateLimitMaxKeys(2),
+		WithRateLimitRule(RateLimitRule{Burst: 1, Rate: 1, Route: Route{PathPrefix: "/admin"}}),
+	)
+	assert.NoError(t, err)
+
+	now := time.Unix(0, 0)
+	rl.now = func() time.Time { return now }
+
+	h := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(key string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("a", "/").Code)
+	w := serve("a", "/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get(headerRateLimitLimit))
+	assert.Equal(t, "0", w.Header().Get(headerRateLimitRemaining))
+
+	w = serve("a", "/")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get(headerRetryAfter))
+	assert.Equal(t, "2", w.Header().Get(headerRateLimitReset))
+
+	// other clients and routes have their own buckets
+	assert.Equal(t, http.StatusOK, serve("b", "/").Code)
+	assert.Equal(t, http.StatusOK, serve("a", "/admin").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("a", "/admin").Code)
+	assert.Equal(t, 2, rl.Len())
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, serve("a", "/admin").Code)
+
+	// requests without a key are limited by client IP, separately from keys with the same value
+	assert.Equal(t, http.StatusOK, serve("", "/admin").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("", "/admin").Code)
+	assert.Equal(t, http.StatusOK, serve("192.0.2.1", "/admin").Code)
+}
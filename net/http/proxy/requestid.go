@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/transientvariable/anchor/net"
+)
+
+const (
+	// RequestIDHeader is the default header used for reading and propagating request IDs.
+	RequestIDHeader = "X-Request-Id"
+
+	// RequestIDMaxLength sets the maximum length of an incoming request ID before it is replaced with a generated one.
+	RequestIDMaxLength = 128
+)
+
+// RequestIDOption is a container for optional properties that can be used for initializing the RequestID Middleware.
+type RequestIDOption struct {
+	generator func() string
+	header    string
+	trust     bool
+}
+
+// WithRequestIDGenerator sets the function used for generating request IDs. Defaults to net.NewRequestID.
+func WithRequestIDGenerator(generator func() string) func(*RequestIDOption) {
+	return func(o *RequestIDOption) {
+		o.generator = generator
+	}
+}
+
+// WithRequestIDHeader sets the name of the header used for reading and propagating request IDs.
+func WithRequestIDHeader(name string) func(*RequestIDOption) {
+	return func(o *RequestIDOption) {
+		o.header = name
+	}
+}
+
+// WithRequestIDTrust sets whether request IDs provided by clients are accepted. Enabled by default.
+func WithRequestIDTrust(trust bool) func(*RequestIDOption) {
+	return func(o *RequestIDOption) {
+		o.trust = trust
+	}
+}
+
+// RequestID returns a Middleware that ensures every request has a request ID.
+//
+// The ID is read from the request header, or generated if missing, and stored in the request context where it can be
+// retrieved using net.RequestIDFromContext. The ID is set on the request header forwarded to the upstream Host as well
+// as on the response header.
+func RequestID(options ...func(*RequestIDOption)) Middleware {
+	opts := &RequestIDOption{
+		generator: net.NewRequestID,
+		header:    RequestIDHeader,
+		trust:     true,
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var id string
+			if opts.trust {
+				id = strings.TrimSpace(r.Header.Get(opts.header))
+			}
+
+			if id == "" || len(id) > RequestIDMaxLength || !printable(id) {
+				id = opts.generator()
+			}
+
+			r = r.WithContext(net.ContextWithRequestID(r.Context(), id))
+			r.Header.Set(opts.header, id)
+			w.Header().Set(opts.header, id)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// printable returns whether the string only contains printable ASCII characters, preventing header injection.
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/transientvariable/anchor/net"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestRequestID(t *testing.T) {
+	var upstream gohttp.Header
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		upstream = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	h, err := prepareHosts(srv.URL)
+	require.NoError(t, err)
+
+	serveID := func(header string, id string, options ...func(*RequestIDOption)) (string, string, string) {
+		var ctxID string
+		b, err := NewBalancer(h, WithMiddleware(RequestID(append([]func(*RequestIDOption){
+			WithRequestIDGenerator(func() string { return "generated" }),
+		}, options...)...), func(next gohttp.Handler) gohttp.Handler {
+			return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+				ctxID, _ = net.RequestIDFromContext(r.Context())
+				next.ServeHTTP(w, r)
+			})
+		}))
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(gohttp.MethodGet, "/", nil)
+		if id != "" {
+			req.Header.Set(header, id)
+		}
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, req)
+		require.Equal(t, gohttp.StatusOK, w.Code)
+
+		// the request ID is stored in the request context, forwarded upstream, and echoed on the response
+		assert.Equal(t, ctxID, upstream.Get(header))
+		return ctxID, upstream.Get(header), w.Header().Get(header)
+	}
+
+	ctxID, upstreamID, respID := serveID(RequestIDHeader, "incoming")
+	assert.Equal(t, "incoming", ctxID)
+	assert.Equal(t, "incoming", upstreamID)
+	assert.Equal(t, "incoming", respID)
+
+	for _, id := range []string{"", "   ", strings.Repeat("a", RequestIDMaxLength+1), "bad id", "bad\x7fid"} {
+		ctxID, _, respID = serveID(RequestIDHeader, id)
+		assert.Equal(t, "generated", ctxID, "%q", id)
+		assert.Equal(t, "generated", respID, "%q", id)
+	}
+
+	ctxID, _, _ = serveID(RequestIDHeader, strings.Repeat("a", RequestIDMaxLength))
+	assert.Equal(t, strings.Repeat("a", RequestIDMaxLength), ctxID)
+
+	// incoming request IDs are replaced if clients are not trusted
+	ctxID, upstreamID, respID = serveID(RequestIDHeader, "incoming", WithRequestIDTrust(false))
+	assert.Equal(t, "generated", ctxID)
+	assert.Equal(t, "generated", upstreamID)
+	assert.Equal(t, "generated", respID)
+
+	ctxID, upstreamID, respID = serveID("X-Correlation-Id", "incoming", WithRequestIDHeader("X-Correlation-Id"))
+	assert.Equal(t, "incoming", ctxID)
+	assert.Equal(t, "incoming", upstreamID)
+	assert.Equal(t, "incoming", respID)
+
+	// the default generator is used if none is provided
+	b, err := NewBalancer(h, WithMiddleware(RequestID()))
+	require.NoError(t, err)
+	w := serve(b, "/")
+	assert.Len(t, w.Header().Get(RequestIDHeader), 26)
+}
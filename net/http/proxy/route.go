@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/transientvariable/anchor"
+)
+
+// Route defines the criteria for matching an HTTP request. Criteria that are not set match any request.
+type Route struct {
+	// Headers that must be present on the request. An empty value only requires the header to be present.
+	Headers map[string]string
+
+	// Host the request must be addressed to, without port. A leading "*." matches any subdomain.
+	Host string
+
+	// Methods of which the request method must be one of.
+	Methods []string
+
+	// PathPrefix the request URL path must start with.
+	PathPrefix string
+}
+
+// Match returns whether the provided http.Request satisfies all criteria for the Route.
+func (r *Route) Match(req *http.Request) bool {
+	if r.Host != "" && !matchHost(r.Host, req.Host) {
+		return false
+	}
+
+	if len(r.Methods) > 0 {
+		matched := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, req.Method) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if r.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+
+	for k, v := range r.Headers {
+		values, ok := req.Header[http.CanonicalHeaderKey(k)]
+		if !ok {
+			return false
+		}
+
+		if v != "" && !slices.Contains(values, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns a string representation of the Route.
+func (r *Route) String() string {
+	return string(anchor.ToJSON(r.toMap()))
+}
+
+// toMap returns a map representing the Route attributes.
+func (r *Route) toMap() map[string]any {
+	m := make(map[string]any)
+	if len(r.Headers) > 0 {
+		m["headers"] = r.Headers
+	}
+
+	if r.Host != "" {
+		m["host"] = r.Host
+	}
+
+	if len(r.Methods) > 0 {
+		m["methods"] = r.Methods
+	}
+
+	if r.PathPrefix != "" {
+		m["path_prefix"] = r.PathPrefix
+	}
+	return m
+}
+
+func matchHost(pattern string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+)
+
+// Router dispatches HTTP requests to the Balancers defined by a Config using the first matching route.
+//
+// The Config of a Router can be replaced atomically using Apply. Hosts that did not change between configurations are
+// carried over, retaining state such as failure counts and concurrency limits.
+type Router struct {
+	mutex sync.Mutex
+	state atomic.Pointer[routerState]
+}
+
+type routerState struct {
+	balancers map[string]Balancer
+	config    *Config
+	hosts     map[string]*Host
+	routes    []routerRoute
+}
+
+type routerRoute struct {
+	acl      *ACL
+	balancer Balancer
+	handler  http.Handler
+	name     string
+	route    Route
+}
+
+// NewRouter creates a new Router from the provided Config.
+func NewRouter(cfg *Config) (*Router, error) {
+	r := &Router{}
+	if err := r.Apply(cfg); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadRouter creates a new Router from the configuration file at the provided path.
+func LoadRouter(path string) (*Router, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewRouter(cfg)
+}
+
+// Apply validates the provided Config and atomically replaces the current configuration of the Router. If the Config
+// is invalid, the current configuration is left unchanged.
+func (r *Router) Apply(cfg *Config) error {
+	if cfg == nil {
+		return errors.New("proxy_router: config is required")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("proxy_router: invalid config: %w", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	prev := r.state.Load()
+	next, err := newRouterState(cfg, prev)
+	if err != nil {
+		return err
+	}
+	r.state.Store(next)
+
+	if prev != nil {
+		for k, h := range prev.hosts {
+			if _, ok := next.hosts[k]; !ok {
+				log.Info("[proxy:router] removed host", log.String("target", h.target.String()))
+				h.CloseUpgrades()
+			}
+		}
+		prev.close()
+	}
+	log.Info("[proxy:router] applied config",
+		log.Int("balancers", len(next.balancers)),
+		log.Int("routes", len(next.routes)))
+	return nil
+}
+
+// Balancer returns the Balancer with the provided name from the current configuration.
+func (r *Router) Balancer(name string) (Balancer, bool) {
+	s := r.state.Load()
+	if s == nil {
+		return nil, false
+	}
+	b, ok := s.balancers[name]
+	return b, ok
+}
+
+// Balancers returns the Balancers of the current configuration keyed by name.
+func (r *Router) Balancers() map[string]Balancer {
+	m := make(map[string]Balancer)
+	if s := r.state.Load(); s != nil {
+		for k, b := range s.balancers {
+			m[k] = b
+		}
+	}
+	return m
+}
+
+// Close stops active health checking for all Balancers of the Router, and gracefully closes the connections to their
+// hosts that have been upgraded from HTTP.
+func (r *Router) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if s := r.state.Load(); s != nil {
+		s.close()
+		for _, h := range s.hosts {
+			h.CloseUpgrades()
+		}
+	}
+	return nil
+}
+
+// Config returns the current Config of the Router.
+func (r *Router) Config() *Config {
+	if s := r.state.Load(); s != nil {
+		return s.config
+	}
+	return nil
+}
+
+// ServeHTTP dispatches the request to the Balancer of the first matching route. If no route matches, the request is
+// rejected with http.StatusNotFound.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s := r.state.Load()
+	if s != nil {
+		for _, rt := range s.routes {
+			if rt.route.Match(req) {
+				rt.handler.ServeHTTP(w, req)
+				return
+			}
+		}
+	}
+	http.NotFound(w, req)
+}
+
+// String returns a string representation of the Router.
+func (r *Router) String() string {
+	return string(anchor.ToJSON(r.toMap()))
+}
+
+// toMap returns a map representing the Router attributes.
+func (r *Router) toMap() map[string]any {
+	m := make(map[string]any)
+	s := r.state.Load()
+	if s == nil {
+		return m
+	}
+
+	balancers := make(map[string]any)
+	for name, b := range s.balancers {
+		balancers[name] = b.Pool().toMap()
+	}
+	m["balancers"] = balancers
+
+	var routes []map[string]any
+	for _, rt := range s.routes {
+		rm := rt.route.toMap()
+		rm["balancer"] = rt.name
+		if rt.acl != nil {
+			rm["acl"] = rt.acl.toMap()
+		}
+		routes = append(routes, rm)
+	}
+	m["routes"] = routes
+	return m
+}
+
+// newRouterState builds the Balancers and routes for the Config, reusing the hosts of the previous state.
+func newRouterState(cfg *Config, prev *routerState) (*routerState, error) {
+	s := &routerState{
+		balancers: make(map[string]Balancer),
+		config:    cfg,
+		hosts:     make(map[string]*Host),
+	}
+
+	for _, bc := range cfg.Balancers {
+		var hosts []*Host
+		for _, hc := range bc.Hosts {
+			key := bc.hostKey(hc)
+			h, ok := s.hosts[key]
+			if !ok && prev != nil {
+				h, ok = prev.hosts[key]
+			}
+
+			if !ok {
+				var err error
+				if h, err = NewHost(strings.TrimSpace(hc.Target), WithProxyProtocol(hc.ProxyProtocol)); err != nil {
+					s.close()
+					return nil, fmt.Errorf("proxy_router: balancer %q: %w", bc.Name, err)
+				}
+			}
+			s.hosts[key] = h
+			hosts = append(hosts, h)
+		}
+
+		options, err := bc.options()
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("proxy_router: balancer %q: %w", bc.Name, err)
+		}
+
+		b, err := NewBalancer(hosts, options...)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("proxy_router: balancer %q: %w", bc.Name, err)
+		}
+		s.balancers[bc.Name] = b
+	}
+
+	for _, rc := range cfg.Routes {
+		rt := routerRoute{
+			balancer: s.balancers[rc.Balancer],
+			handler:  s.balancers[rc.Balancer],
+			name:     rc.Balancer,
+			route:    rc.route(),
+		}
+
+		if rc.ACL != nil {
+			acl, err := rc.ACL.acl()
+			if err != nil {
+				s.close()
+				return nil, fmt.Errorf("proxy_router: route for balancer %q: %w", rc.Balancer, err)
+			}
+			rt.acl = acl
+			rt.handler = acl.Handler(rt.balancer)
+		}
+		s.routes = append(s.routes, rt)
+	}
+
+	// without any routes, all requests are dispatched to the first balancer
+	if len(s.routes) == 0 {
+		name := cfg.Balancers[0].Name
+		s.routes = append(s.routes, routerRoute{balancer: s.balancers[name], handler: s.balancers[name], name: name})
+	}
+	return s, nil
+}
+
+// close stops active health checking for the Balancers of the state.
+func (s *routerState) close() {
+	names := make([]string, 0, len(s.balancers))
+	for name := range s.balancers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := s.balancers[name].Close(); err != nil {
+			log.Error("[proxy:router] could not close balancer", log.String("name", name), log.Err(err))
+		}
+	}
+}
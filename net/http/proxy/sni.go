@@ -0,0 +1,291 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+)
+
+const (
+	SNIPeekTimeout = 10 * time.Second
+)
+
+// errClientHelloPeeked is used to abort the TLS handshake once the ClientHello has been read.
+var errClientHelloPeeked = errors.New("proxy_sni: client hello peeked")
+
+// SNIOption is a container for optional properties that can be used for initializing a SNIRouter.
+type SNIOption struct {
+	defaultProxy *TCPProxy
+	peekTimeout  time.Duration
+	routes       []sniRoute
+}
+
+// WithSNIDefault sets the TCPProxy for connections whose server name does not match any route, including connections
+// that do not indicate a server name.
+func WithSNIDefault(proxy *TCPProxy) func(*SNIOption) {
+	return func(o *SNIOption) {
+		o.defaultProxy = proxy
+	}
+}
+
+// WithSNIPeekTimeout sets the maximum duration for reading the TLS ClientHello from a connection.
+func WithSNIPeekTimeout(timeout time.Duration) func(*SNIOption) {
+	return func(o *SNIOption) {
+		o.peekTimeout = timeout
+	}
+}
+
+// WithSNIRoute routes connections for the server name to the TCPProxy. The server name is either an exact host name,
+// or a wildcard in the form "*.example.com" matching any subdomain.
+func WithSNIRoute(serverName string, proxy *TCPProxy) func(*SNIOption) {
+	return func(o *SNIOption) {
+		o.routes = append(o.routes, sniRoute{proxy: proxy, serverName: serverName})
+	}
+}
+
+type sniRoute struct {
+	proxy      *TCPProxy
+	serverName string
+}
+
+// SNIRouter routes TLS connections to a TCPProxy chosen by the server name indication (SNI) of the ClientHello, without
+// terminating TLS.
+//
+// Exact server names take precedence over wildcards, and longer wildcards take precedence over shorter ones.
+type SNIRouter struct {
+	closed       atomic.Bool
+	defaultProxy *TCPProxy
+	listeners    map[net.Listener]struct{}
+	mutex        sync.Mutex
+	peekTimeout  time.Duration
+	routes       []sniRoute
+}
+
+// NewSNIRouter creates a new SNIRouter using the provided options.
+func NewSNIRouter(options ...func(*SNIOption)) (*SNIRouter, error) {
+	opts := &SNIOption{peekTimeout: SNIPeekTimeout}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	if len(opts.routes) == 0 && opts.defaultProxy == nil {
+		return nil, errors.New("proxy_sni: at least one route or a default proxy must be provided")
+	}
+
+	for _, r := range opts.routes {
+		if r.proxy == nil {
+			return nil, fmt.Errorf("proxy_sni: proxy is required for server name %q", r.serverName)
+		}
+
+		name := strings.TrimPrefix(r.serverName, "*.")
+		if name == "" || strings.Contains(name, "*") {
+			return nil, fmt.Errorf("proxy_sni: invalid server name %q", r.serverName)
+		}
+	}
+
+	return &SNIRouter{
+		defaultProxy: opts.defaultProxy,
+		listeners:    make(map[net.Listener]struct{}),
+		peekTimeout:  opts.peekTimeout,
+		routes:       opts.routes,
+	}, nil
+}
+
+// Serve accepts connections on the listener and routes them until the listener fails or the SNIRouter is closed. The
+// listener is closed when Serve returns.
+func (r *SNIRouter) Serve(l net.Listener) error {
+	r.mutex.Lock()
+	if r.closed.Load() {
+		r.mutex.Unlock()
+		return net.ErrClosed
+	}
+	r.listeners[l] = struct{}{}
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		delete(r.listeners, l)
+		r.mutex.Unlock()
+		_ = l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if r.closed.Load() {
+				return nil
+			}
+			return fmt.Errorf("proxy_sni: %w", err)
+		}
+
+		go r.ServeConn(conn)
+	}
+}
+
+// ServeConn peeks the ClientHello of the connection and hands the connection, including the peeked bytes, to the
+// TCPProxy for the server name. The connection is closed when ServeConn returns.
+func (r *SNIRouter) ServeConn(conn net.Conn) {
+	if r.peekTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(r.peekTimeout))
+	}
+
+	hello, c, err := PeekClientHello(conn)
+	if err != nil {
+		log.Warn("[proxy:sni] could not read client hello", log.String("client", conn.RemoteAddr().String()), log.Err(err))
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	p := r.route(hello.ServerName)
+	if p == nil {
+		log.Debug("[proxy:sni] no route for server name", log.String("server_name", hello.ServerName))
+		_ = conn.Close()
+		return
+	}
+
+	log.Trace("[proxy:sni] routing connection",
+		log.String("client", conn.RemoteAddr().String()),
+		log.String("server_name", hello.ServerName),
+		log.Any("alpn", hello.SupportedProtos))
+	p.ServeConn(c)
+}
+
+// Close stops accepting connections. Connections that have already been routed are owned by their TCPProxy, which
+// drains them when it is closed.
+func (r *SNIRouter) Close() error {
+	if !r.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	r.mutex.Lock()
+	for l := range r.listeners {
+		_ = l.Close()
+	}
+	r.mutex.Unlock()
+	return nil
+}
+
+// String returns a string representation of the SNIRouter.
+func (r *SNIRouter) String() string {
+	routes := make([]map[string]any, len(r.routes))
+	for i, route := range r.routes {
+		routes[i] = map[string]any{
+			"pool":        route.proxy.pool.toMap(),
+			"server_name": route.serverName,
+		}
+	}
+
+	m := map[string]any{
+		"peek_timeout": r.peekTimeout.String(),
+		"routes":       routes,
+	}
+	if r.defaultProxy != nil {
+		m["default"] = r.defaultProxy.pool.toMap()
+	}
+	return string(anchor.ToJSON(m))
+}
+
+// route returns the TCPProxy for the server name, or the default TCPProxy if there is no matching route.
+func (r *SNIRouter) route(serverName string) *TCPProxy {
+	if serverName == "" {
+		return r.defaultProxy
+	}
+
+	var match *sniRoute
+	for i, route := range r.routes {
+		if !matchHost(route.serverName, serverName) {
+			continue
+		}
+
+		if !strings.HasPrefix(route.serverName, "*.") {
+			return route.proxy
+		}
+
+		if match == nil || len(route.serverName) > len(match.serverName) {
+			match = &r.routes[i]
+		}
+	}
+
+	if match != nil {
+		return match.proxy
+	}
+	return r.defaultProxy
+}
+
+// PeekClientHello reads the TLS ClientHello from the connection without completing the handshake, returning the
+// ClientHello and a net.Conn that replays the bytes read from the connection before continuing with it.
+//
+// The ClientHello is parsed by crypto/tls, so only the fields available on a server-side tls.ClientHelloInfo, such as
+// the server name and the supported application protocols (ALPN), are populated. The Conn field is nil.
+func PeekClientHello(conn net.Conn) (*tls.ClientHelloInfo, net.Conn, error) {
+	var (
+		buf   bytes.Buffer
+		hello *tls.ClientHelloInfo
+	)
+
+	err := tls.Server(readOnlyConn{r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			c := *h
+			c.Conn = nil
+			hello = &c
+			return nil, errClientHelloPeeked
+		},
+	}).Handshake()
+
+	if hello == nil {
+		if err == nil {
+			err = errors.New("handshake completed without client hello")
+		}
+		return nil, nil, fmt.Errorf("proxy_sni: %w", err)
+	}
+	return hello, &peekedConn{Conn: conn, r: io.MultiReader(&buf, conn)}, nil
+}
+
+// peekedConn is a net.Conn that reads the peeked bytes before reading from the underlying connection.
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read reads from the peeked bytes, and then from the underlying connection.
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite shuts down the write side of the underlying connection, if supported.
+func (c *peekedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// NetConn returns the underlying connection.
+func (c *peekedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// readOnlyConn is a net.Conn that only supports reading, for peeking a handshake without responding to the client.
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)       { return c.r.Read(p) }
+func (c readOnlyConn) Write([]byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                     { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr              { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr             { return nil }
+func (c readOnlyConn) SetDeadline(time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(time.Time) error { return nil }
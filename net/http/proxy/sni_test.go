@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	gohttp "net/http"
+)
+
+func TestPeekClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go tls.Client(client, &tls.Config{ServerName: "api.example.com", NextProtos: []string{"h2", "http/1.1"}}).Handshake()
+
+	hello, conn, err := PeekClientHello(server)
+	assert.NoError(t, err)
+	assert.Equal(t, "api.example.com", hello.ServerName)
+	assert.Equal(t, []string{"h2", "http/1.1"}, hello.SupportedProtos)
+
+	// the peeked bytes are replayed, so the handshake can be parsed again
+	replayed, _, err := PeekClientHello(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, hello.ServerName, replayed.ServerName)
+}
+
+func TestSNIRouter(t *testing.T) {
+	proxies := make(map[string]*TCPProxy)
+	for _, name := range []string{"api", "wildcard", "default"} {
+		s := httptest.NewTLSServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+		defer s.Close()
+
+		h, err := NewHost("tcp://" + s.Listener.Addr().String())
+		assert.NoError(t, err)
+		pool, err := NewPool([]*Host{h})
+		assert.NoError(t, err)
+		p, err := NewTCPProxy(pool)
+		assert.NoError(t, err)
+		defer p.Close()
+		proxies[name] = p
+	}
+
+	r, err := NewSNIRouter(
+		WithSNIRoute("*.example.com", proxies["wildcard"]),
+		WithSNIRoute("api.example.com", proxies["api"]),
+		WithSNIDefault(proxies["default"]))
+	assert.NoError(t, err)
+	defer r.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go r.Serve(l)
+
+	client := &gohttp.Client{Transport: &gohttp.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, l.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	defer client.CloseIdleConnections()
+
+	for host, want := range map[string]string{
+		"api.example.com":     "api",
+		"www.example.com":     "wildcard",
+		"a.b.example.com":     "wildcard",
+		"example.com":         "default",
+		"api.example.org":     "default",
+		"127.0.0.1":           "default",
+		"API.EXAMPLE.COM.":    "api",
+		"static.example.com.": "wildcard",
+	} {
+		resp, err := client.Get("https://" + host + "/")
+		if assert.NoError(t, err, host) {
+			b, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, want, string(b), host)
+			resp.Body.Close()
+		}
+	}
+
+	_, err = NewSNIRouter(WithSNIRoute("*.*.example.com", proxies["api"]))
+	assert.Error(t, err)
+}
@@ -0,0 +1,444 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+)
+
+const (
+	TCPBufferSize     = 32 * anchor.KiB
+	TCPConnectTimeout = 5 * time.Second
+	TCPDrainTimeout   = 30 * time.Second
+	TCPIdleTimeout    = 5 * time.Minute
+)
+
+// TCPOption is a container for optional properties that can be used for initializing a TCPProxy.
+type TCPOption struct {
+	connectTimeout time.Duration
+	drainTimeout   time.Duration
+	idleTimeout    time.Duration
+}
+
+// String returns a string representation of the TCPOption.
+func (o *TCPOption) String() string {
+	options := make(map[string]any)
+	options["connect_timeout"] = o.connectTimeout.String()
+	options["drain_timeout"] = o.drainTimeout.String()
+	options["idle_timeout"] = o.idleTimeout.String()
+	return string(anchor.ToJSONFormatted(options))
+}
+
+// WithConnectTimeout sets the maximum duration for connecting to a backend Host.
+func WithConnectTimeout(timeout time.Duration) func(*TCPOption) {
+	return func(o *TCPOption) {
+		o.connectTimeout = timeout
+	}
+}
+
+// WithDrainTimeout sets the maximum duration connections to a backend Host are allowed to complete after the Host has
+// been removed, or the TCPProxy has been closed, before they are closed.
+func WithDrainTimeout(timeout time.Duration) func(*TCPOption) {
+	return func(o *TCPOption) {
+		o.drainTimeout = timeout
+	}
+}
+
+// WithIdleTimeout sets the maximum duration a connection can be idle in both directions before it is closed. A
+// non-positive timeout disables idle timeouts.
+func WithIdleTimeout(timeout time.Duration) func(*TCPOption) {
+	return func(o *TCPOption) {
+		o.idleTimeout = timeout
+	}
+}
+
+// CheckTCP checks the health of a Host by opening a TCP connection to its target. It can be used as the Check function
+// of a HealthCheck for hosts that do not speak HTTP.
+func CheckTCP(ctx context.Context, h *Host) error {
+	t, err := h.Target()
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.Host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// TCPProxy is a layer 4 load balancer that splices client connections to backend hosts selected from a Pool.
+//
+// The Host targets are URLs whose host component is the backend address, e.g. "tcp://10.0.0.1:5432". Hosts that cannot
+// be connected to are passively marked as failed, and the connection is retried with another Host.
+type TCPProxy struct {
+	cancel    context.CancelFunc
+	closed    atomic.Bool
+	conns     map[*Host]map[*tcpConn]struct{}
+	ctx       context.Context
+	listeners map[net.Listener]struct{}
+	mutex     sync.Mutex
+	options   *TCPOption
+	pool      *Pool
+	wg        sync.WaitGroup
+}
+
+// NewTCPProxy creates a new TCPProxy for the provided Pool.
+func NewTCPProxy(pool *Pool, options ...func(*TCPOption)) (*TCPProxy, error) {
+	if pool == nil {
+		return nil, errors.New("proxy_tcp: pool is required")
+	}
+
+	opts := &TCPOption{
+		connectTimeout: TCPConnectTimeout,
+		drainTimeout:   TCPDrainTimeout,
+		idleTimeout:    TCPIdleTimeout,
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &TCPProxy{
+		cancel:    cancel,
+		conns:     make(map[*Host]map[*tcpConn]struct{}),
+		ctx:       ctx,
+		listeners: make(map[net.Listener]struct{}),
+		options:   opts,
+		pool:      pool,
+	}
+	log.Debug(fmt.Sprintf("[proxy:tcp]: \n%s", opts))
+	return p, nil
+}
+
+// Serve accepts connections on the listener and proxies them to backend hosts until the listener fails or the
+// TCPProxy is closed. The listener is closed when Serve returns.
+func (p *TCPProxy) Serve(l net.Listener) error {
+	if !p.trackListener(l, true) {
+		return net.ErrClosed
+	}
+	defer p.trackListener(l, false)
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if p.closed.Load() {
+				return nil
+			}
+
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// back off on temporary errors, e.g. too many open files, in the same manner as http.Server
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				log.Warn("[proxy:tcp] accept failed", log.Err(err), log.String("retry", delay.String()))
+				time.Sleep(delay)
+				continue
+			}
+			return fmt.Errorf("proxy_tcp: %w", err)
+		}
+		delay = 0
+
+		go p.ServeConn(conn)
+	}
+}
+
+// ServeConn proxies a single client connection to a backend Host, blocking until either side closes the connection.
+// The client connection is closed when ServeConn returns.
+func (p *TCPProxy) ServeConn(conn net.Conn) {
+	if p.closed.Load() {
+		_ = conn.Close()
+		return
+	}
+
+	h, backend, err := p.dial(conn)
+	if err != nil {
+		log.Warn("[proxy:tcp] no backend available", log.String("client", conn.RemoteAddr().String()), log.Err(err))
+		_ = conn.Close()
+		return
+	}
+
+	c := newTCPConn(conn, backend, h, p.options.idleTimeout)
+	if !p.trackConn(h, c, true) {
+		c.close()
+		return
+	}
+	defer p.trackConn(h, c, false)
+
+	log.Trace("[proxy:tcp] proxying connection",
+		log.String("client", conn.RemoteAddr().String()),
+		log.String("backend", backend.RemoteAddr().String()))
+	c.splice()
+}
+
+// AddHost adds the Host to the Pool of the TCPProxy.
+func (p *TCPProxy) AddHost(h *Host) {
+	p.pool.Add(h)
+}
+
+// RemoveHost removes the Host from the Pool of the TCPProxy. Existing connections to the Host are allowed to complete
+// until the drain timeout has elapsed, after which they are closed.
+func (p *TCPProxy) RemoveHost(h *Host) bool {
+	if !p.pool.Remove(h) {
+		return false
+	}
+
+	p.mutex.Lock()
+	n := len(p.conns[h])
+	p.mutex.Unlock()
+
+	if n > 0 {
+		log.Info("[proxy:tcp] draining host", log.String("target", h.target.String()), log.Int("connections", n))
+		time.AfterFunc(p.options.drainTimeout, func() {
+			p.closeConns(h)
+		})
+	}
+	return true
+}
+
+// Close stops accepting connections and waits for existing connections to complete until the drain timeout has
+// elapsed, after which they are closed.
+func (p *TCPProxy) Close() error {
+	if !p.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	p.mutex.Lock()
+	for l := range p.listeners {
+		_ = l.Close()
+	}
+	p.mutex.Unlock()
+	p.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(p.options.drainTimeout):
+		p.closeConns(nil)
+		<-done
+	}
+	return nil
+}
+
+// Pool returns the Pool of backend hosts for the TCPProxy.
+func (p *TCPProxy) Pool() *Pool {
+	return p.pool
+}
+
+// String returns a string representation of the TCPProxy.
+func (p *TCPProxy) String() string {
+	p.mutex.Lock()
+	conns := 0
+	for _, c := range p.conns {
+		conns += len(c)
+	}
+	p.mutex.Unlock()
+
+	return string(anchor.ToJSON(map[string]any{
+		"connections": conns,
+		"pool":        p.pool.toMap(),
+	}))
+}
+
+// dial connects to a backend Host selected from the Pool on behalf of the client, trying other hosts if the connection
+// fails.
+func (p *TCPProxy) dial(client net.Conn) (*Host, net.Conn, error) {
+	var errs []error
+	attempts := len(p.pool.Hosts())
+	for i := 0; i < attempts; i++ {
+		h, err := p.pool.Select()
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		t, err := h.Target()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(p.ctx, p.options.connectTimeout)
+		backend, err := h.dialContext(ctx, "tcp", t.Host, client.RemoteAddr(), client.LocalAddr())
+		cancel()
+		if err != nil {
+			log.Warn("[proxy:tcp] could not connect to backend", log.String("target", t.String()), log.Err(err))
+			p.pool.MarkFailed(h)
+			errs = append(errs, err)
+			continue
+		}
+		p.pool.MarkHealthy(h)
+		return h, backend, nil
+	}
+	return nil, nil, fmt.Errorf("proxy_tcp: %w", errors.Join(errs...))
+}
+
+// closeConns closes the connections to the provided Host, or all connections if the Host is nil.
+func (p *TCPProxy) closeConns(h *Host) {
+	p.mutex.Lock()
+	var conns []*tcpConn
+	for host, hc := range p.conns {
+		if h == nil || host == h {
+			for c := range hc {
+				conns = append(conns, c)
+			}
+		}
+	}
+	p.mutex.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+}
+
+func (p *TCPProxy) trackConn(h *Host, c *tcpConn, add bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if add {
+		if p.closed.Load() {
+			return false
+		}
+
+		if p.conns[h] == nil {
+			p.conns[h] = make(map[*tcpConn]struct{})
+		}
+		p.conns[h][c] = struct{}{}
+		p.wg.Add(1)
+		h.connections.Add(1)
+		return true
+	}
+
+	delete(p.conns[h], c)
+	if len(p.conns[h]) == 0 {
+		delete(p.conns, h)
+	}
+	p.wg.Done()
+	h.connections.Add(-1)
+	return true
+}
+
+func (p *TCPProxy) trackListener(l net.Listener, add bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if add {
+		if p.closed.Load() {
+			return false
+		}
+		p.listeners[l] = struct{}{}
+		return true
+	}
+	delete(p.listeners, l)
+	_ = l.Close()
+	return true
+}
+
+// tcpConn is a client connection spliced to a backend connection.
+type tcpConn struct {
+	activity    atomic.Int64
+	backend     net.Conn
+	client      net.Conn
+	closeOnce   sync.Once
+	host        *Host
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+}
+
+func newTCPConn(client net.Conn, backend net.Conn, h *Host, idleTimeout time.Duration) *tcpConn {
+	c := &tcpConn{
+		backend:     backend,
+		client:      client,
+		host:        h,
+		idleTimeout: idleTimeout,
+	}
+	c.activity.Store(time.Now().UnixNano())
+	if idleTimeout > 0 {
+		// the timer is armed after it has been assigned, since checkIdle reschedules it
+		c.idleTimer = time.AfterFunc(math.MaxInt64, c.checkIdle)
+		c.idleTimer.Reset(idleTimeout)
+	}
+	return c
+}
+
+// splice copies data in both directions until both directions are done or the connection is closed.
+func (c *tcpConn) splice() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.copy(c.backend, c.client, &c.host.bytesSent)
+	}()
+	go func() {
+		defer wg.Done()
+		c.copy(c.client, c.backend, &c.host.bytesReceived)
+	}()
+	wg.Wait()
+	c.close()
+}
+
+// copy copies from src to dst, counting the bytes written. When src is done, the write side of dst is closed so the
+// peer observes EOF while the other direction may still be in progress.
+func (c *tcpConn) copy(dst net.Conn, src net.Conn, counter *atomic.Int64) {
+	buf := make([]byte, TCPBufferSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			c.activity.Store(time.Now().UnixNano())
+			w, werr := dst.Write(buf[:n])
+			counter.Add(int64(w))
+			if werr != nil {
+				c.close()
+				return
+			}
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.close()
+				return
+			}
+
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+				_ = cw.CloseWrite()
+			} else {
+				c.close()
+			}
+			return
+		}
+	}
+}
+
+// checkIdle closes the connection if there has been no activity within the idle timeout, otherwise the check is
+// rescheduled for when the idle timeout would next elapse.
+func (c *tcpConn) checkIdle() {
+	idle := time.Since(time.Unix(0, c.activity.Load()))
+	if idle >= c.idleTimeout {
+		log.Debug("[proxy:tcp] closing idle connection", log.String("client", c.client.RemoteAddr().String()))
+		c.close()
+		return
+	}
+	c.idleTimer.Reset(c.idleTimeout - idle)
+}
+
+func (c *tcpConn) close() {
+	c.closeOnce.Do(func() {
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
+		_ = c.client.Close()
+		_ = c.backend.Close()
+	})
+}
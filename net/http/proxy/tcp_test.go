@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPProxy(t *testing.T) {
+	backends := []net.Listener{echoServer(t, "a"), echoServer(t, "b")}
+	defer backends[0].Close()
+	defer backends[1].Close()
+
+	var hosts []*Host
+	for _, b := range backends {
+		h, err := NewHost("tcp://" + b.Addr().String())
+		assert.NoError(t, err)
+		hosts = append(hosts, h)
+	}
+
+	pool, err := NewPool(hosts)
+	assert.NoError(t, err)
+
+	p, err := NewTCPProxy(pool, WithDrainTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go p.Serve(l)
+
+	for _, want := range []string{"a:ping", "b:ping", "a:ping"} {
+		assert.Equal(t, want, roundTrip(t, l.Addr().String(), "ping"))
+	}
+	assert.Eventually(t, func() bool { return hosts[0].Connections() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(10), hosts[0].BytesSent())
+	assert.Equal(t, int64(14), hosts[0].BytesReceived())
+
+	// connections to a removed host are drained
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.Eventually(t, func() bool { return hosts[1].Connections() == 1 }, time.Second, 10*time.Millisecond)
+	assert.True(t, p.RemoveHost(hosts[1]))
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "a:ping", roundTrip(t, l.Addr().String(), "ping"))
+
+	// connection failures are retried with another host and passively mark the host as failed
+	assert.NoError(t, backends[0].Close())
+	p.AddHost(hosts[1])
+	for range 2 {
+		assert.Equal(t, "b:ping", roundTrip(t, l.Addr().String(), "ping"))
+	}
+	assert.Equal(t, 1, hosts[0].Failures())
+
+	assert.NoError(t, p.Close())
+	_, err = net.Dial("tcp", l.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestTCPProxyIdleTimeout(t *testing.T) {
+	backend := echoServer(t, "a")
+	defer backend.Close()
+
+	h, err := NewHost("tcp://" + backend.Addr().String())
+	assert.NoError(t, err)
+	pool, err := NewPool([]*Host{h})
+	assert.NoError(t, err)
+
+	p, err := NewTCPProxy(pool, WithIdleTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	defer p.Close()
+
+	client, server := net.Pipe()
+	go p.ServeConn(server)
+
+	start := time.Now()
+	_, err = io.ReadAll(client)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+// echoServer starts a TCP server that echoes each line it receives prefixed with the provided name.
+func echoServer(t *testing.T, name string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					fmt.Fprintf(conn, "%s:%s\n", name, s.Text())
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+// roundTrip sends the message as a single line over a new connection to addr, returning the response.
+func roundTrip(t *testing.T, addr string, msg string) string {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "%s\n", msg)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	b, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	return string(b[:max(0, len(b)-1)])
+}
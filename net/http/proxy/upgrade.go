@@ -0,0 +1,334 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/transientvariable/log-go"
+)
+
+const (
+	// UpgradeCloseTimeout is the duration an upgraded connection is given for completing the closing handshake after
+	// a WebSocket close frame has been sent, before it is closed.
+	UpgradeCloseTimeout = 5 * time.Second
+
+	// websocketCloseGoingAway is the WebSocket close code indicating that the server is going away.
+	websocketCloseGoingAway = 1001
+)
+
+// UpgradeStats defines the statistics for the connections of a Host that have been upgraded from HTTP to another
+// protocol, e.g. WebSocket.
+type UpgradeStats struct {
+	// Active is the number of open upgraded connections.
+	Active int64
+
+	// BytesReceived is the number of bytes received from the Host.
+	BytesReceived int64
+
+	// BytesSent is the number of bytes sent to the Host.
+	BytesSent int64
+
+	// Duration is the cumulative duration of the upgraded connections that have been closed.
+	Duration time.Duration
+
+	// Total is the number of connections that have been upgraded.
+	Total int64
+}
+
+// toMap returns a map representing the UpgradeStats attributes.
+func (s UpgradeStats) toMap() map[string]any {
+	return map[string]any{
+		"active":         s.Active,
+		"bytes_received": s.BytesReceived,
+		"bytes_sent":     s.BytesSent,
+		"duration":       s.Duration.String(),
+		"total":          s.Total,
+	}
+}
+
+// upgradeStats records the UpgradeStats for a Host.
+type upgradeStats struct {
+	active        atomic.Int64
+	bytesReceived atomic.Int64
+	bytesSent     atomic.Int64
+	duration      atomic.Int64
+	total         atomic.Int64
+}
+
+func (s *upgradeStats) load() UpgradeStats {
+	return UpgradeStats{
+		Active:        s.active.Load(),
+		BytesReceived: s.bytesReceived.Load(),
+		BytesSent:     s.bytesSent.Load(),
+		Duration:      time.Duration(s.duration.Load()),
+		Total:         s.total.Load(),
+	}
+}
+
+// isUpgrade returns whether the request asks for the connection to be upgraded to another protocol.
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && headerContainsToken(r.Header, "Connection", "upgrade")
+}
+
+func headerContainsToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWriter wraps a http.ResponseWriter for tracking the connection hijacked by httputil.ReverseProxy when the
+// Host switches protocols.
+type upgradeWriter struct {
+	http.ResponseWriter
+	host        *Host
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	websocket   bool
+}
+
+// Hijack hijacks the underlying connection, returning a connection that is tracked by the Host.
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the response switching protocols is written using the returned bufio.ReadWriter, so writes to it bypass the
+	// connection for counting and tracking frames from the first byte written by the Host
+	c := newUpgradeConn(conn, brw.Reader, w.host, w.websocket, w.idleTimeout, w.maxLifetime)
+	return c, bufio.NewReadWriter(bufio.NewReader(c), brw.Writer), nil
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with http.ResponseController.
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// upgradeConn is a client connection upgraded from HTTP that is tracked by a Host.
+//
+// The frames of WebSocket connections written to the client are tracked, so that a close frame can be sent between
+// frames when the connection is closed gracefully.
+type upgradeConn struct {
+	net.Conn
+	activity    atomic.Int64
+	closeOnce   sync.Once
+	closeSent   bool
+	closing     bool
+	frames      *websocketFrames
+	host        *Host
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	lifeTimer   *time.Timer
+	r           *bufio.Reader
+	start       time.Time
+	writeMutex  sync.Mutex
+}
+
+func newUpgradeConn(conn net.Conn, r *bufio.Reader, h *Host, websocket bool, idleTimeout, maxLifetime time.Duration) *upgradeConn {
+	c := &upgradeConn{
+		Conn:        conn,
+		host:        h,
+		idleTimeout: idleTimeout,
+		r:           r,
+		start:       time.Now(),
+	}
+	c.activity.Store(c.start.UnixNano())
+	h.trackUpgrade(c, true)
+
+	if websocket {
+		c.frames = &websocketFrames{}
+	}
+
+	if idleTimeout > 0 {
+		// the timer is armed after it has been assigned, since checkIdle reschedules it
+		c.idleTimer = time.AfterFunc(math.MaxInt64, c.checkIdle)
+		c.idleTimer.Reset(idleTimeout)
+	}
+
+	if maxLifetime > 0 {
+		c.lifeTimer = time.AfterFunc(maxLifetime, func() {
+			log.Debug("[proxy:upgrade] closing connection exceeding max lifetime", log.String("target", h.target.String()))
+			c.shutdown()
+		})
+	}
+	return c
+}
+
+// Read reads data from the client, counting the bytes sent to the Host.
+func (c *upgradeConn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if n > 0 {
+		c.activity.Store(time.Now().UnixNano())
+		c.host.upgrades.bytesSent.Add(int64(n))
+	}
+	return n, err
+}
+
+// Write writes data to the client, counting the bytes received from the Host. Once the connection is closing, data is
+// written up to the end of the current WebSocket frame, followed by a close frame.
+func (c *upgradeConn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closeSent {
+		return 0, net.ErrClosed
+	}
+
+	n := len(b)
+	if c.frames != nil {
+		n = c.frames.advance(b, c.closing)
+	}
+
+	w, err := c.Conn.Write(b[:n])
+	if w > 0 {
+		c.activity.Store(time.Now().UnixNano())
+		c.host.upgrades.bytesReceived.Add(int64(w))
+	}
+
+	if err == nil && c.closing && c.frames.boundary() {
+		c.sendClose()
+		if n < len(b) {
+			err = net.ErrClosed
+		}
+	}
+	return w, err
+}
+
+// Close closes the connection, recording its duration for the Host.
+func (c *upgradeConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
+
+		if c.lifeTimer != nil {
+			c.lifeTimer.Stop()
+		}
+		c.host.upgrades.duration.Add(int64(time.Since(c.start)))
+		c.host.trackUpgrade(c, false)
+	})
+	return err
+}
+
+// shutdown closes the connection gracefully. For WebSocket connections, a close frame indicating that the server is
+// going away is sent once the current frame has been written, and the connection is closed after the client has had
+// time to complete the closing handshake. Other connections are closed immediately.
+func (c *upgradeConn) shutdown() {
+	if c.frames == nil {
+		_ = c.Close()
+		return
+	}
+
+	c.writeMutex.Lock()
+	c.closing = true
+	if c.frames.boundary() {
+		c.sendClose()
+	}
+	c.writeMutex.Unlock()
+	time.AfterFunc(UpgradeCloseTimeout, func() { _ = c.Close() })
+}
+
+// sendClose writes a WebSocket close frame to the client. The write mutex must be held.
+func (c *upgradeConn) sendClose() {
+	if c.closeSent {
+		return
+	}
+	c.closeSent = true
+
+	frame := []byte{0x88, 0x02, 0, 0}
+	binary.BigEndian.PutUint16(frame[2:], websocketCloseGoingAway)
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(UpgradeCloseTimeout))
+	_, _ = c.Conn.Write(frame)
+}
+
+// checkIdle closes the connection if there has been no activity within the idle timeout, otherwise the check is
+// rescheduled for when the idle timeout would next elapse.
+func (c *upgradeConn) checkIdle() {
+	idle := time.Since(time.Unix(0, c.activity.Load()))
+	if idle >= c.idleTimeout {
+		log.Debug("[proxy:upgrade] closing idle connection", log.String("target", c.host.target.String()))
+		c.shutdown()
+		return
+	}
+	c.idleTimer.Reset(c.idleTimeout - idle)
+}
+
+// websocketFrames tracks the boundaries of the WebSocket frames written to a connection, as defined by RFC 6455.
+type websocketFrames struct {
+	header    []byte
+	remaining uint64
+}
+
+// boundary returns whether the next byte written starts a new frame.
+func (f *websocketFrames) boundary() bool {
+	return f.remaining == 0 && len(f.header) == 0
+}
+
+// advance advances through the frames in b, returning the number of bytes consumed. If stop is true, advancing stops
+// at the first frame boundary.
+func (f *websocketFrames) advance(b []byte, stop bool) int {
+	var n int
+	for n < len(b) {
+		if stop && f.boundary() {
+			return n
+		}
+
+		if f.remaining > 0 {
+			m := min(uint64(len(b)-n), f.remaining)
+			f.remaining -= m
+			n += int(m)
+			continue
+		}
+
+		f.header = append(f.header, b[n])
+		n++
+		if size, ok := f.headerSize(); ok && len(f.header) == size {
+			f.remaining = f.payloadLength()
+			f.header = f.header[:0]
+		}
+	}
+	return n
+}
+
+// headerSize returns the size of the frame header being read, once it can be determined.
+func (f *websocketFrames) headerSize() (int, bool) {
+	if len(f.header) < 2 {
+		return 0, false
+	}
+
+	size := 2
+	switch f.header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+
+	if f.header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size, true
+}
+
+func (f *websocketFrames) payloadLength() uint64 {
+	switch l := f.header[1] & 0x7f; l {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(f.header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(f.header[2:10])
+	default:
+		return uint64(l)
+	}
+}
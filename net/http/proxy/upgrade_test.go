@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	gohttp "net/http"
+)
+
+func TestBalancerUpgrade(t *testing.T) {
+	// the upstream echoes the bytes received after switching protocols
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		conn, brw, err := gohttp.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_, _ = io.Copy(conn, brw)
+	}))
+	defer upstream.Close()
+
+	h, err := NewHost(upstream.URL)
+	assert.NoError(t, err)
+	b, err := NewBalancer([]*Host{h}, WithSelector(NewLeastConnectionsSelector()))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	s := httptest.NewServer(b)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	assert.NoError(t, err)
+	r := bufio.NewReader(conn)
+	resp, err := gohttp.ReadResponse(r, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, gohttp.StatusSwitchingProtocols, resp.StatusCode)
+
+	// a masked text frame with a 5 byte payload, sent in two parts
+	frame := []byte{0x81, 0x85, 1, 2, 3, 4, 'h' ^ 1, 'e' ^ 2, 'l' ^ 3, 'l' ^ 4, 'o' ^ 1}
+	_, err = conn.Write(frame[:4])
+	assert.NoError(t, err)
+	echo := make([]byte, len(frame))
+	_, err = io.ReadFull(r, echo[:4])
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(1), h.Upgrades().Active)
+	assert.Equal(t, int64(1), h.load())
+
+	// the close frame is sent once the frame in progress has been written
+	h.CloseUpgrades()
+	_, err = conn.Write(frame[4:])
+	assert.NoError(t, err)
+	_, err = io.ReadFull(r, echo[4:])
+	assert.NoError(t, err)
+	assert.Equal(t, frame, echo)
+
+	closeFrame := make([]byte, 4)
+	_, err = io.ReadFull(r, closeFrame)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x88, 0x02, 0x03, 0xe9}, closeFrame)
+
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool { return h.Upgrades().Active == 0 }, time.Second, 10*time.Millisecond)
+	stats := h.Upgrades()
+	assert.Equal(t, int64(1), stats.Total)
+	assert.Equal(t, int64(len(frame)), stats.BytesSent)
+	assert.Equal(t, int64(len(frame)), stats.BytesReceived)
+	assert.Positive(t, stats.Duration)
+	assert.Equal(t, int64(0), h.load())
+}
+
+func TestUpgradeIdleTimeout(t *testing.T) {
+	upstream := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		conn, brw, err := gohttp.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: custom\r\nConnection: Upgrade\r\n\r\n")
+		_, _ = io.Copy(conn, brw)
+	}))
+	defer upstream.Close()
+
+	h, err := NewHost(upstream.URL)
+	assert.NoError(t, err)
+	b, err := NewBalancer([]*Host{h}, WithUpgradeTimeouts(50*time.Millisecond, 0))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	s := httptest.NewServer(b)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: custom\r\nConnection: Upgrade\r\n\r\n")
+	assert.NoError(t, err)
+	r := bufio.NewReader(conn)
+	resp, err := gohttp.ReadResponse(r, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, gohttp.StatusSwitchingProtocols, resp.StatusCode)
+
+	start := time.Now()
+	_, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestWebsocketFrames(t *testing.T) {
+	var f websocketFrames
+	assert.True(t, f.boundary())
+
+	// unmasked frames with 7-bit, 16-bit, and 64-bit payload lengths
+	frames := append([]byte{0x82, 0x02, 0, 0}, 0x82, 126, 0x01, 0x00)
+	frames = append(frames, make([]byte, 256)...)
+	frames = append(frames, 0x82, 127, 0, 0, 0, 0, 0, 0, 0, 3, 1, 2, 3)
+
+	assert.Equal(t, len(frames), f.advance(frames, false))
+	assert.True(t, f.boundary())
+
+	// advancing stops at the end of the current frame
+	assert.Equal(t, 3, f.advance(frames[:3], false))
+	assert.False(t, f.boundary())
+	assert.Equal(t, 1, f.advance(frames[3:], true))
+	assert.True(t, f.boundary())
+	assert.Equal(t, 0, f.advance(frames[4:], true))
+}
+
+func TestLeastConnectionsSelector(t *testing.T) {
+	hosts, err := prepareHosts("http://a", "http://b", "http://c")
+	assert.NoError(t, err)
+	hosts[0].inflight.Add(2)
+	hosts[1].connections.Add(1)
+	hosts[2].inflight.Add(1)
+
+	s := NewLeastConnectionsSelector()
+	seen := make(map[*Host]int)
+	for range 4 {
+		h, err := s.Select(hosts...)
+		assert.NoError(t, err)
+		seen[h]++
+	}
+	assert.Zero(t, seen[hosts[0]])
+	assert.Positive(t, seen[hosts[1]])
+	assert.Positive(t, seen[hosts[2]])
+
+	hosts[2].inflight.Add(-1)
+	h, err := s.Select(hosts...)
+	assert.NoError(t, err)
+	assert.Same(t, hosts[2], h)
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/transientvariable/log-go"
+)
+
+// WatchInterval sets the default interval at which a configuration file is checked for changes.
+const WatchInterval = 2 * time.Second
+
+// WatchOption is a container for optional properties that can be used for watching a configuration file.
+type WatchOption struct {
+	interval time.Duration
+	onApply  func(*Config, error)
+	signals  []os.Signal
+}
+
+// WithWatchInterval sets the interval at which the configuration file is checked for changes. A non-positive interval
+// disables checking, so the configuration is only reloaded when a signal is received.
+func WithWatchInterval(interval time.Duration) func(*WatchOption) {
+	return func(o *WatchOption) {
+		o.interval = interval
+	}
+}
+
+// WithWatchOnApply sets a function that is called after every reload attempt with the loaded Config, or the error
+// that prevented it from being applied.
+func WithWatchOnApply(onApply func(*Config, error)) func(*WatchOption) {
+	return func(o *WatchOption) {
+		o.onApply = onApply
+	}
+}
+
+// WithWatchSignals sets the list of signals that trigger a reload of the configuration file. Defaults to SIGHUP.
+func WithWatchSignals(signals ...os.Signal) func(*WatchOption) {
+	return func(o *WatchOption) {
+		o.signals = signals
+	}
+}
+
+// Watch reloads the configuration file at the provided path and applies it to the Router whenever the file changes or
+// one of the watched signals is received, until the provided context is done.
+//
+// Configurations that fail to load or validate are logged and discarded, leaving the current configuration of the
+// Router in place.
+func Watch(ctx context.Context, path string, router *Router, options ...func(*WatchOption)) error {
+	if router == nil {
+		return errors.New("proxy_watch: router is required")
+	}
+
+	opts := &WatchOption{
+		interval: WatchInterval,
+		signals:  []os.Signal{syscall.SIGHUP},
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		return fmt.Errorf("proxy_watch: %w", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	if len(opts.signals) > 0 {
+		signal.Notify(sig, opts.signals...)
+		defer signal.Stop(sig)
+	}
+
+	var tick <-chan time.Time
+	if opts.interval > 0 {
+		t := time.NewTicker(opts.interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	reload := func(reason string) {
+		cfg, err := LoadConfig(path)
+		if err == nil {
+			err = router.Apply(cfg)
+		}
+
+		if err != nil {
+			log.Error("[proxy:watch] could not reload config", log.String("path", path), log.Err(err))
+		} else {
+			log.Info("[proxy:watch] reloaded config", log.String("path", path), log.String("reason", reason))
+		}
+
+		if opts.onApply != nil {
+			opts.onApply(cfg, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case s := <-sig:
+			if d, err := fileDigest(path); err == nil {
+				digest = d
+			}
+			reload(s.String())
+		case <-tick:
+			d, err := fileDigest(path)
+			if err != nil {
+				log.Error("[proxy:watch] could not read config", log.String("path", path), log.Err(err))
+				continue
+			}
+
+			if !bytes.Equal(d, digest) {
+				digest = d
+				reload("file changed")
+			}
+		}
+	}
+}
+
+// fileDigest returns the SHA-256 digest of the file contents, so that reloads are only triggered by actual changes.
+func fileDigest(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := sha256.Sum256(b)
+	return d[:], nil
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/transientvariable/anchor/net/http/proxy/proxytest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestWatch(t *testing.T) {
+	c, err := proxytest.NewCluster(3)
+	require.NoError(t, err)
+	defer c.Close()
+
+	urls := c.URLs()
+	path := filepath.Join(t.TempDir(), "proxy.yaml")
+	writeWatchConfig(t, path, watchConfig(urls[0], urls[1]))
+
+	r, err := LoadRouter(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	b, ok := r.Balancer("api")
+	require.True(t, ok)
+	first := b.Pool().Hosts()[0]
+	b.Pool().MarkFailed(first)
+
+	applied := make(chan error, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, path, r,
+			WithWatchInterval(10*time.Millisecond),
+			WithWatchSignals(),
+			WithWatchOnApply(func(_ *Config, err error) { applied <- err }))
+	}()
+
+	// requests served while the configuration is reloaded are dispatched using either the previous or the next one
+	var (
+		failed  atomic.Int64
+		serving sync.WaitGroup
+		stop    atomic.Bool
+	)
+	serving.Add(1)
+	go func() {
+		defer serving.Done()
+		for !stop.Load() {
+			if code := serve(r, "/static").Code; code != gohttp.StatusOK {
+				failed.Add(1)
+			}
+		}
+	}()
+
+	require.NoError(t, reload(t, path, watchConfig(urls[0], urls[2]), applied))
+
+	stop.Store(true)
+	serving.Wait()
+	assert.Zero(t, failed.Load())
+
+	// hosts that did not change keep their state
+	b, ok = r.Balancer("api")
+	require.True(t, ok)
+	hosts := b.Pool().Hosts()
+	require.Len(t, hosts, 2)
+	assert.Same(t, first, hosts[0])
+	assert.Equal(t, 1, hosts[0].Failures())
+	assert.Equal(t, urls[2], hosts[1].target.String())
+
+	// invalid configurations leave the current one in place
+	cfg := r.Config()
+	assert.Error(t, reload(t, path, "balancers: [", applied))
+	assert.Same(t, cfg, r.Config())
+
+	b, ok = r.Balancer("api")
+	require.True(t, ok)
+	assert.Same(t, first, b.Pool().Hosts()[0])
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestWatchSignal(t *testing.T) {
+	c, err := proxytest.NewCluster(2)
+	require.NoError(t, err)
+	defer c.Close()
+
+	urls := c.URLs()
+	path := filepath.Join(t.TempDir(), "proxy.yaml")
+	writeWatchConfig(t, path, watchConfig(urls[0]))
+
+	r, err := LoadRouter(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	// the signal is also delivered to the test, so it does not terminate the process before Watch is notified of it
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	applied := make(chan error, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = Watch(ctx, path, r, WithWatchInterval(0), WithWatchOnApply(func(_ *Config, err error) { applied <- err }))
+	}()
+
+	// changes are only applied once the signal is received
+	writeWatchConfig(t, path, watchConfig(urls[0], urls[1]))
+	require.Eventually(t, func() bool {
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+		select {
+		case err := <-applied:
+			require.NoError(t, err)
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	b, ok := r.Balancer("api")
+	require.True(t, ok)
+	assert.Len(t, b.Pool().Hosts(), 2)
+}
+
+// watchConfig returns a configuration with the "api" balancer for the provided targets, and a "static" balancer for the
+// first target.
+func watchConfig(targets ...string) string {
+	var hosts string
+	for _, t := range targets {
+		hosts += fmt.Sprintf("\n      - target: %s", t)
+	}
+	return fmt.Sprintf(`
+balancers:
+  - name: api
+    hosts:%s
+  - name: static
+    hosts:
+      - target: %s
+routes:
+  - balancer: static
+    path_prefix: /static
+  - balancer: api
+`, hosts, targets[0])
+}
+
+// writeWatchConfig replaces the configuration file by renaming a temporary file, so that it is never read partially
+// written.
+func writeWatchConfig(t *testing.T, path string, cfg string) {
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(cfg), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+// reload writes the configuration file until a reload attempt is made, returning its result. The file is rewritten
+// with a distinct trailing comment, since changes written before Watch reads the file initially are not detected.
+func reload(t *testing.T, path string, cfg string, applied <-chan error) error {
+	deadline := time.After(5 * time.Second)
+	for i := 0; ; i++ {
+		writeWatchConfig(t, path, fmt.Sprintf("%s\n# %d\n", cfg, i))
+		select {
+		case err := <-applied:
+			return err
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			require.FailNow(t, "config not reloaded")
+		}
+	}
+}
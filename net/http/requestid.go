@@ -0,0 +1,35 @@
+package http
+
+import (
+	"github.com/transientvariable/anchor/net"
+
+	gohttp "net/http"
+)
+
+// RequestID returns a Middleware that sets the X-Request-Id header on outbound requests using the request ID stored in
+// the request context, if any.
+//
+// Requests that already have the header set are left unchanged.
+func RequestID() Middleware {
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			id, ok := net.RequestIDFromContext(req.Context())
+			if !ok || req.Header.Get(HeaderXRequestID) != "" {
+				return next.RoundTrip(req)
+			}
+
+			// a RoundTripper must not modify the provided request, so the header is set on a clone
+			r := req.Clone(req.Context())
+			r.Header.Set(HeaderXRequestID, id)
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+// NewRequestIDTransport creates a new http.RoundTripper that sets the X-Request-Id header on outbound requests using the
+// request ID stored in the request context, if any, before delegating to the provided http.RoundTripper.
+//
+// Requests that already have the header set are left unchanged.
+func NewRequestIDTransport(transport gohttp.RoundTripper) gohttp.RoundTripper {
+	return Chain(transport, RequestID())
+}
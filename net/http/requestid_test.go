@@ -0,0 +1,47 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/transientvariable/anchor/net"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestRequestID(t *testing.T) {
+	var header gohttp.Header
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		header = r.Header
+	}))
+	defer srv.Close()
+
+	rt := NewRequestIDTransport(srv.Client().Transport)
+	roundTrip := func(req *gohttp.Request) {
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	// the request ID from the context is set on a clone of the request
+	req, err := gohttp.NewRequest(gohttp.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req = req.WithContext(net.ContextWithRequestID(req.Context(), "id"))
+	roundTrip(req)
+	assert.Equal(t, "id", header.Get(HeaderXRequestID))
+	assert.Empty(t, req.Header.Get(HeaderXRequestID))
+
+	// request IDs set explicitly are left unchanged
+	req.Header.Set(HeaderXRequestID, "explicit")
+	roundTrip(req)
+	assert.Equal(t, "explicit", header.Get(HeaderXRequestID))
+
+	// requests without a request ID in their context are left unchanged
+	req, err = gohttp.NewRequest(gohttp.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	roundTrip(req)
+	assert.Empty(t, header.Get(HeaderXRequestID))
+}
@@ -0,0 +1,361 @@
+package http
+
+import (
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/log-go"
+
+	"github.com/cenkalti/backoff/v4"
+
+	gohttp "net/http"
+)
+
+const (
+	// RetryAfterMax sets the default maximum delay requested by a server using the Retry-After or RateLimit-Reset
+	// headers that is honored. Responses requesting longer delays are not retried.
+	RetryAfterMax = 1 * time.Minute
+
+	// RetryDrainMax sets the number of bytes read from the body of a response that is retried, so that the connection
+	// can be reused.
+	RetryDrainMax = 4 * anchor.KiB
+)
+
+// RetryHook defines a function called before a request is retried, with the number of the attempt that failed, its
+// response or error, and the delay before the next attempt. The body of the response has already been closed.
+type RetryHook func(req *gohttp.Request, attempt int, resp *gohttp.Response, err error, delay time.Duration)
+
+// RetryOption is a container for optional properties that can be used for initializing a RetryPolicy.
+type RetryOption struct {
+	afterMax       time.Duration
+	errorClasses   []ErrorClass
+	maxAttempts    int
+	maxElapsedTime time.Duration
+	methods        []string
+	newBackOff     func() backoff.BackOff
+	onRetry        RetryHook
+	statusCodes    []int
+}
+
+// WithRetryAfterMax sets the maximum delay requested by a server using the Retry-After or RateLimit-Reset headers that
+// is honored. Responses requesting longer delays are not retried.
+func WithRetryAfterMax(d time.Duration) func(*RetryOption) {
+	return func(o *RetryOption) {
+		o.afterMax = d
+	}
+}
+
+// WithRetryBackOff sets the function creating the backoff.BackOff strategy used for the attempts of a request.
+func WithRetryBackOff(newBackOff func() backoff.BackOff) func(*RetryOption) {
+	return func(o *RetryOption) {
+		o.newBackOff = newBackOff
+	}
+}
+
+// WithRetryErrorClasses sets the ErrorClass values of the errors that are retried.
+func WithRetryErrorClasses(classes ...ErrorClass) func(*RetryOption) {
+	return func(o *RetryOption) {
+		o.errorClasses = classes
+	}
+}
+
+// WithRetryMaxAttempts sets the maximum number of attempts for a request, including the first.
+func WithRetryMaxAttempts(n int) func(*RetryOption) {
+	return func(o *RetryOption) {
+		o.maxAttempts = n
+	}
+}
+
+// WithRetryMaxElapsedTime sets the duration from the first attempt after which a request is no longer retried. Zero
+// means no limit.
+func WithRetryMaxElapsedTime(d time.Duration) func(*RetryOption) {
+	return func(o *RetryOption) {
+		o.maxElapsedTime = d
+	}
+}
+
+// WithRetryMethods sets the request methods that are retried. Requests with other methods are only retried if they
+// have the Idempotency-Key header set.
+func WithRetryMethods(methods ...string) func(*RetryOption) {
+	return func(o *RetryOption) {
+		o.methods = methods
+	}
+}
+
+// WithRetryOnRetry sets the RetryHook called before a request is retried.
+func WithRetryOnRetry(hook RetryHook) func(*RetryOption) {
+	return func(o *RetryOption) {
+		o.onRetry = hook
+	}
+}
+
+// WithRetryStatusCodes sets the response status codes that are retried.
+func WithRetryStatusCodes(codes ...int) func(*RetryOption) {
+	return func(o *RetryOption) {
+		o.statusCodes = codes
+	}
+}
+
+// RetryPolicy defines when and how HTTP requests are retried.
+//
+// A request is retried if it failed with a retryable error or its response has a retryable status code, provided its
+// method is idempotent, or it has the Idempotency-Key header set, and its body can be replayed using
+// http.Request.GetBody. The delay between attempts is determined by the backoff strategy, extended to the delay
+// requested by the server using the Retry-After header, or the RateLimit-Reset header for http.StatusTooManyRequests.
+//
+// A RetryPolicy is safe for concurrent use, and can be used either with an http.Client using Do, or as a Middleware.
+type RetryPolicy struct {
+	afterMax       time.Duration
+	errorClasses   []ErrorClass
+	maxAttempts    int
+	maxElapsedTime time.Duration
+	methods        []string
+	newBackOff     func() backoff.BackOff
+	onRetry        RetryHook
+	statusCodes    []int
+}
+
+// NewRetryPolicy creates a new RetryPolicy using the provided options.
+//
+// By default, requests are attempted up to RetryMax+1 times using exponential backoff. Requests using the GET, HEAD,
+// OPTIONS, TRACE, PUT and DELETE methods are retried on connection, DNS and timeout errors, as well as errors of
+// unknown class, and on the status codes 408, 429, 500, 502, 503 and 504.
+func NewRetryPolicy(options ...func(*RetryOption)) *RetryPolicy {
+	opts := &RetryOption{
+		afterMax: RetryAfterMax,
+		errorClasses: []ErrorClass{
+			ErrorClassUnknown,
+			ErrorClassDNS,
+			ErrorClassConnRefused,
+			ErrorClassConnReset,
+			ErrorClassDialTimeout,
+			ErrorClassTLSHandshakeTimeout,
+			ErrorClassResponseHeaderTimeout,
+			ErrorClassTimeout,
+		},
+		maxAttempts: RetryMax + 1,
+		methods: []string{
+			MethodDelete,
+			MethodGet,
+			MethodHead,
+			MethodOptions,
+			MethodPut,
+			MethodTrace,
+		},
+		newBackOff: func() backoff.BackOff {
+			b := backoff.NewExponentialBackOff()
+			b.MaxElapsedTime = 0
+			return b
+		},
+		statusCodes: []int{
+			gohttp.StatusRequestTimeout,
+			gohttp.StatusTooManyRequests,
+			gohttp.StatusInternalServerError,
+			gohttp.StatusBadGateway,
+			gohttp.StatusServiceUnavailable,
+			gohttp.StatusGatewayTimeout,
+		},
+	}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	return &RetryPolicy{
+		afterMax:       opts.afterMax,
+		errorClasses:   opts.errorClasses,
+		maxAttempts:    max(1, opts.maxAttempts),
+		maxElapsedTime: opts.maxElapsedTime,
+		methods:        opts.methods,
+		newBackOff:     opts.newBackOff,
+		onRetry:        opts.onRetry,
+		statusCodes:    opts.statusCodes,
+	}
+}
+
+// Do sends the request using the http.Client, retrying it according to the RetryPolicy. The response of the last
+// attempt is returned.
+func (p *RetryPolicy) Do(client *gohttp.Client, req *gohttp.Request) (*gohttp.Response, error) {
+	return p.do(req, client.Do)
+}
+
+// Middleware returns a Middleware that retries requests according to the RetryPolicy.
+func (p *RetryPolicy) Middleware() Middleware {
+	return func(next gohttp.RoundTripper) gohttp.RoundTripper {
+		return RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+			return p.do(req, next.RoundTrip)
+		})
+	}
+}
+
+// String returns a string representation of the RetryPolicy.
+func (p *RetryPolicy) String() string {
+	return string(anchor.ToJSON(p.toMap()))
+}
+
+// do sends the request using the provided function until it succeeds, or is no longer retryable.
+func (p *RetryPolicy) do(req *gohttp.Request, send func(*gohttp.Request) (*gohttp.Response, error)) (*gohttp.Response, error) {
+	if !p.replayable(req) {
+		return send(req)
+	}
+
+	ctx := req.Context()
+	start := time.Now()
+	b := p.newBackOff()
+	b.Reset()
+
+	r := req
+	for attempt := 1; ; attempt++ {
+		resp, err := send(r)
+		if attempt >= p.maxAttempts || ctx.Err() != nil || !p.retryable(resp, err) {
+			return resp, err
+		}
+
+		delay := b.NextBackOff()
+		if delay == backoff.Stop {
+			return resp, err
+		}
+
+		if after, ok := retryAfter(resp); ok {
+			if after > p.afterMax {
+				return resp, err
+			}
+			delay = max(delay, after)
+		}
+
+		if p.maxElapsedTime > 0 && time.Since(start)+delay > p.maxElapsedTime {
+			return resp, err
+		}
+
+		if d, ok := ctx.Deadline(); ok && time.Until(d) < delay {
+			return resp, err
+		}
+
+		next := req.Clone(ctx)
+		if req.GetBody != nil && req.Body != nil && req.Body != gohttp.NoBody {
+			body, gerr := req.GetBody()
+			if gerr != nil {
+				return resp, err
+			}
+			next.Body = body
+		}
+
+		if resp != nil {
+			drain(resp.Body)
+		}
+
+		log.Trace("[http:retry] retrying request",
+			log.String("url", req.URL.Redacted()),
+			log.Int("attempt", attempt),
+			log.String("delay", delay.String()))
+
+		if p.onRetry != nil {
+			p.onRetry(req, attempt, resp, err, delay)
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		r = next
+	}
+}
+
+// toMap returns a map representing the RetryPolicy attributes.
+func (p *RetryPolicy) toMap() map[string]any {
+	return map[string]any{
+		"after_max":        p.afterMax.String(),
+		"error_classes":    p.errorClassNames(),
+		"max_attempts":     p.maxAttempts,
+		"max_elapsed_time": p.maxElapsedTime.String(),
+		"methods":          p.methods,
+		"status_codes":     p.statusCodes,
+	}
+}
+
+// errorClassNames returns the names of the retried ErrorClass values.
+func (p *RetryPolicy) errorClassNames() []string {
+	names := make([]string, len(p.errorClasses))
+	for i, c := range p.errorClasses {
+		names[i] = c.String()
+	}
+	return names
+}
+
+// replayable returns whether the request can be retried based on its method and body.
+func (p *RetryPolicy) replayable(req *gohttp.Request) bool {
+	if p.maxAttempts < 2 {
+		return false
+	}
+
+	if !slices.Contains(p.methods, req.Method) && req.Header.Get(HeaderIdempotencyKey) == "" {
+		return false
+	}
+	return req.Body == nil || req.Body == gohttp.NoBody || req.GetBody != nil
+}
+
+// retryable returns whether the result of an attempt is retryable.
+func (p *RetryPolicy) retryable(resp *gohttp.Response, err error) bool {
+	if err != nil {
+		return slices.Contains(p.errorClasses, Classify(resp, err))
+	}
+	return slices.Contains(p.statusCodes, resp.StatusCode)
+}
+
+// NewRetryTransport creates a new http.RoundTripper that retries requests sent using the provided http.RoundTripper
+// according to the RetryPolicy. If the RetryPolicy is nil, one with default values is used.
+func NewRetryTransport(transport gohttp.RoundTripper, policy *RetryPolicy) gohttp.RoundTripper {
+	if policy == nil {
+		policy = NewRetryPolicy()
+	}
+	return Chain(transport, policy.Middleware())
+}
+
+// retryAfter returns the delay requested by the server using the Retry-After header, either as a number of seconds or
+// an HTTP date, or the RateLimit-Reset header for http.StatusTooManyRequests.
+func retryAfter(resp *gohttp.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	if v := strings.TrimSpace(resp.Header.Get(HeaderRetryAfter)); v != "" {
+		if d, ok := deltaSeconds(v); ok {
+			return d, true
+		}
+
+		if t, err := gohttp.ParseTime(v); err == nil {
+			return max(0, time.Until(t)), true
+		}
+	}
+
+	if resp.StatusCode == gohttp.StatusTooManyRequests {
+		return deltaSeconds(strings.TrimSpace(resp.Header.Get(HeaderRateLimitReset)))
+	}
+	return 0, false
+}
+
+// deltaSeconds parses a non-negative number of seconds, saturating at the maximum time.Duration.
+func deltaSeconds(v string) (time.Duration, bool) {
+	s, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || s < 0 {
+		return 0, false
+	}
+	return time.Duration(min(s, math.MaxInt64/int64(time.Second))) * time.Second, true
+}
+
+// drain reads a bounded number of bytes from the response body before closing it, so that the connection can be
+// reused.
+func drain(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+	_, _ = io.CopyN(io.Discard, body, RetryDrainMax)
+	_ = body.Close()
+}
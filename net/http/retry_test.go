@@ -0,0 +1,169 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gohttp "net/http"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var attempts, failures atomic.Int64
+	failures.Store(2)
+	srv := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if n := attempts.Add(1); n <= failures.Load() {
+			w.WriteHeader(gohttp.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, "unavailable")
+			return
+		}
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	var retries []int
+	policy := NewRetryPolicy(
+		WithRetryBackOff(func() backoff.BackOff { return &backoff.ZeroBackOff{} }),
+		WithRetryOnRetry(func(req *gohttp.Request, attempt int, resp *gohttp.Response, err error, delay time.Duration) {
+			assert.Equal(t, gohttp.StatusServiceUnavailable, resp.StatusCode)
+			retries = append(retries, attempt)
+		}))
+
+	// the body of retried requests is replayed
+	req, err := gohttp.NewRequest(gohttp.MethodPut, srv.URL, strings.NewReader("payload"))
+	require.NoError(t, err)
+
+	resp, err := policy.Do(srv.Client(), req)
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(b))
+	assert.Equal(t, []int{1, 2}, retries)
+	assert.Equal(t, int64(3), attempts.Load())
+
+	// the response of the last attempt is returned
+	attempts.Store(0)
+	failures.Store(5)
+	resp, err = policy.Do(srv.Client(), mustRequest(t, gohttp.MethodGet, srv.URL, nil))
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int64(RetryMax+1), attempts.Load())
+
+	// non-idempotent requests are only retried with an idempotency key
+	attempts.Store(0)
+	resp, err = policy.Do(srv.Client(), mustRequest(t, gohttp.MethodPost, srv.URL, strings.NewReader("payload")))
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int64(1), attempts.Load())
+
+	attempts.Store(0)
+	failures.Store(1)
+	req = mustRequest(t, gohttp.MethodPost, srv.URL, strings.NewReader("payload"))
+	req.Header.Set(HeaderIdempotencyKey, "key")
+	resp, err = policy.Do(srv.Client(), req)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(2), attempts.Load())
+
+	// bodies that cannot be replayed are not retried
+	attempts.Store(0)
+	req = mustRequest(t, gohttp.MethodPut, srv.URL, io.NopCloser(strings.NewReader("payload")))
+	resp, err = policy.Do(srv.Client(), req)
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int64(1), attempts.Load())
+
+	// the maximum elapsed time includes the delay before the next attempt
+	attempts.Store(0)
+	policy = NewRetryPolicy(
+		WithRetryBackOff(func() backoff.BackOff { return backoff.NewConstantBackOff(time.Second) }),
+		WithRetryMaxElapsedTime(100*time.Millisecond))
+	resp, err = policy.Do(srv.Client(), mustRequest(t, gohttp.MethodGet, srv.URL, nil))
+	require.NoError(t, err)
+	assert.Equal(t, gohttp.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int64(1), attempts.Load())
+}
+
+func TestRetryTransport(t *testing.T) {
+	var attempts int
+	var err error
+	transport := NewRetryTransport(RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+		attempts++
+		return nil, err
+	}), NewRetryPolicy(WithRetryBackOff(func() backoff.BackOff { return &backoff.ZeroBackOff{} })))
+
+	err = errors.New("connection reset")
+	_, rerr := transport.RoundTrip(mustRequest(t, gohttp.MethodGet, "http://example.com", nil))
+	assert.Equal(t, err, rerr)
+	assert.Equal(t, RetryMax+1, attempts)
+
+	attempts = 0
+	policy := NewRetryPolicy(
+		WithRetryBackOff(func() backoff.BackOff { return &backoff.ZeroBackOff{} }),
+		WithRetryErrorClasses(ErrorClassConnRefused))
+	_, rerr = NewRetryTransport(RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+		attempts++
+		return nil, err
+	}), policy).RoundTrip(mustRequest(t, gohttp.MethodGet, "http://example.com", nil))
+	assert.Equal(t, err, rerr)
+	assert.Equal(t, 1, attempts)
+
+	// delays requested by the server that are too long are not honored
+	attempts = 0
+	_, _ = NewRetryTransport(RoundTripperFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+		attempts++
+		resp := &gohttp.Response{StatusCode: gohttp.StatusTooManyRequests, Header: gohttp.Header{}, Body: gohttp.NoBody}
+		resp.Header.Set(HeaderRetryAfter, strconv.Itoa(int(RetryAfterMax.Seconds())+1))
+		return resp, nil
+	}), nil).RoundTrip(mustRequest(t, gohttp.MethodGet, "http://example.com", nil))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		headers map[string]string
+		ok      bool
+		status  int
+		want    time.Duration
+	}{
+		{headers: map[string]string{HeaderRetryAfter: "120"}, ok: true, status: gohttp.StatusServiceUnavailable, want: 2 * time.Minute},
+		{headers: map[string]string{HeaderRetryAfter: "Wed, 21 Oct 2015 07:28:00 GMT"}, ok: true, status: gohttp.StatusServiceUnavailable},
+		{headers: map[string]string{HeaderRetryAfter: "-1"}, status: gohttp.StatusServiceUnavailable},
+		{headers: map[string]string{HeaderRateLimitReset: "5"}, ok: true, status: gohttp.StatusTooManyRequests, want: 5 * time.Second},
+		{headers: map[string]string{HeaderRateLimitReset: "5"}, status: gohttp.StatusServiceUnavailable},
+		{headers: map[string]string{HeaderRetryAfter: "1", HeaderRateLimitReset: "5"}, ok: true, status: gohttp.StatusTooManyRequests, want: time.Second},
+		{headers: map[string]string{}, status: gohttp.StatusTooManyRequests},
+	} {
+		resp := &gohttp.Response{Header: gohttp.Header{}, StatusCode: tc.status}
+		for k, v := range tc.headers {
+			resp.Header.Set(k, v)
+		}
+
+		d, ok := retryAfter(resp)
+		assert.Equal(t, tc.ok, ok, tc.headers)
+		assert.Equal(t, tc.want, d, tc.headers)
+	}
+
+	resp := &gohttp.Response{Header: gohttp.Header{}}
+	resp.Header.Set(HeaderRetryAfter, time.Now().Add(time.Hour).UTC().Format(gohttp.TimeFormat))
+	d, ok := retryAfter(resp)
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour, d, float64(2*time.Second))
+}
+
+func mustRequest(t *testing.T, method string, url string, body io.Reader) *gohttp.Request {
+	req, err := gohttp.NewRequest(method, url, body)
+	require.NoError(t, err)
+	return req
+}
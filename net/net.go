@@ -11,16 +11,34 @@ import (
 	"golang.org/x/net/proxy"
 )
 
-// NewSOCKS5DialContext creates a new proxy.ContextDialer for SOCKS5 proxy.
+// NewSOCKS5DialContext creates a new proxy.ContextDialer for SOCKS5 proxy. The user information of the proxy URL, if
+// any, is used for username/password authentication.
 func NewSOCKS5DialContext(proxyURL *url.URL) (func(context.Context, string, string) (net.Conn, error), error) {
-	if proxyURL == nil {
-		return nil, fmt.Errorf("socks5: proxy addr is required")
-	}
-
-	conn, err := proxy.SOCKS5("tcp", proxyURL.String(), nil, &net.Dialer{
+	return NewSOCKS5DialContextWithDialer(proxyURL, &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
 	})
+}
+
+// NewSOCKS5DialContextWithDialer creates a new proxy.ContextDialer for SOCKS5 proxy like NewSOCKS5DialContext, using
+// the provided net.Dialer for connecting to the proxy.
+func NewSOCKS5DialContextWithDialer(proxyURL *url.URL, dialer *net.Dialer) (func(context.Context, string, string) (net.Conn, error), error) {
+	if proxyURL == nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("socks5: proxy addr is required")
+	}
+
+	var auth *proxy.Auth
+	if u := proxyURL.User; u != nil {
+		auth = &proxy.Auth{User: u.Username()}
+		auth.Password, _ = u.Password()
+	}
+
+	addr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		addr = net.JoinHostPort(proxyURL.Hostname(), "1080")
+	}
+
+	conn, err := proxy.SOCKS5("tcp", addr, auth, dialer)
 	if err != nil {
 		return nil, fmt.Errorf("socks5: %w", err)
 	}
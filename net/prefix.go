@@ -0,0 +1,194 @@
+package net
+
+import (
+	"fmt"
+	"iter"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefix parses an IP network prefix in CIDR notation, e.g. "192.0.2.0/24", or a single IP address, which is
+// treated as a prefix covering only that address. IPv4-mapped IPv6 prefixes are converted to IPv4 prefixes.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("prefix: %w", err)
+		}
+		a = a.Unmap().WithZone("")
+		return netip.PrefixFrom(a, a.BitLen()), nil
+	}
+
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("prefix: %w", err)
+	}
+	return normalizePrefix(p), nil
+}
+
+// PrefixTrie is a binary trie of IP network prefixes, providing longest prefix matching of IP addresses in time
+// proportional to the address length regardless of the number of prefixes. IPv4 and IPv6 prefixes are kept separate,
+// and IPv4-mapped IPv6 addresses are matched against the IPv4 prefixes.
+//
+// The zero value is an empty PrefixTrie ready to use. A PrefixTrie is not safe for concurrent modification, but can be
+// read concurrently once no longer modified.
+type PrefixTrie[V any] struct {
+	len   int
+	root4 *prefixNode[V]
+	root6 *prefixNode[V]
+}
+
+type prefixNode[V any] struct {
+	children [2]*prefixNode[V]
+	prefix   netip.Prefix
+	set      bool
+	value    V
+}
+
+// Insert adds the prefix with the associated value, replacing the value of an existing identical prefix. The prefix is
+// masked, so that only its leading bits are significant. Invalid prefixes are ignored.
+func (t *PrefixTrie[V]) Insert(p netip.Prefix, value V) {
+	if !p.IsValid() {
+		return
+	}
+	p = normalizePrefix(p)
+
+	root := t.root(p.Addr(), true)
+	n := *root
+	b := addrBytes(p.Addr())
+	for i := 0; i < p.Bits(); i++ {
+		bit := bitAt(b, i)
+		if n.children[bit] == nil {
+			n.children[bit] = &prefixNode[V]{}
+		}
+		n = n.children[bit]
+	}
+
+	if !n.set {
+		t.len++
+	}
+	n.prefix = p
+	n.set = true
+	n.value = value
+}
+
+// Delete removes the prefix, returning whether it was present.
+func (t *PrefixTrie[V]) Delete(p netip.Prefix) bool {
+	if !p.IsValid() {
+		return false
+	}
+	p = normalizePrefix(p)
+
+	n := *t.root(p.Addr(), false)
+	b := addrBytes(p.Addr())
+	for i := 0; i < p.Bits() && n != nil; i++ {
+		n = n.children[bitAt(b, i)]
+	}
+
+	if n == nil || !n.set {
+		return false
+	}
+
+	var zero V
+	n.set = false
+	n.value = zero
+	t.len--
+	return true
+}
+
+// Lookup returns the longest prefix containing the address along with its value. The returned bool is false if no
+// prefix contains the address.
+func (t *PrefixTrie[V]) Lookup(a netip.Addr) (netip.Prefix, V, bool) {
+	var (
+		match *prefixNode[V]
+		zero  V
+	)
+
+	if !a.IsValid() {
+		return netip.Prefix{}, zero, false
+	}
+	a = a.Unmap()
+
+	n := *t.root(a, false)
+	b := addrBytes(a)
+	for i := 0; n != nil; i++ {
+		if n.set {
+			match = n
+		}
+
+		if i == a.BitLen() {
+			break
+		}
+		n = n.children[bitAt(b, i)]
+	}
+
+	if match == nil {
+		return netip.Prefix{}, zero, false
+	}
+	return match.prefix, match.value, true
+}
+
+// Contains returns whether any prefix contains the address.
+func (t *PrefixTrie[V]) Contains(a netip.Addr) bool {
+	_, _, ok := t.Lookup(a)
+	return ok
+}
+
+// Len returns the number of prefixes.
+func (t *PrefixTrie[V]) Len() int {
+	return t.len
+}
+
+// All returns an iterator over the prefixes and their values, IPv4 prefixes first, each in ascending order of address
+// and length.
+func (t *PrefixTrie[V]) All() iter.Seq2[netip.Prefix, V] {
+	return func(yield func(netip.Prefix, V) bool) {
+		var walk func(n *prefixNode[V]) bool
+		walk = func(n *prefixNode[V]) bool {
+			if n == nil {
+				return true
+			}
+
+			if n.set && !yield(n.prefix, n.value) {
+				return false
+			}
+			return walk(n.children[0]) && walk(n.children[1])
+		}
+		_ = walk(t.root4) && walk(t.root6)
+	}
+}
+
+// root returns a pointer to the root node for the address family of the address, creating the root if requested.
+func (t *PrefixTrie[V]) root(a netip.Addr, create bool) **prefixNode[V] {
+	root := &t.root6
+	if a.Is4() {
+		root = &t.root4
+	}
+
+	if *root == nil && create {
+		*root = &prefixNode[V]{}
+	}
+	return root
+}
+
+// normalizePrefix masks the prefix, converting IPv4-mapped IPv6 prefixes to IPv4 prefixes.
+func normalizePrefix(p netip.Prefix) netip.Prefix {
+	if a := p.Addr(); a.Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(a.Unmap(), p.Bits()-96)
+	}
+	return p.Masked()
+}
+
+func addrBytes(a netip.Addr) []byte {
+	if a.Is4() {
+		b := a.As4()
+		return b[:]
+	}
+	b := a.As16()
+	return b[:]
+}
+
+func bitAt(b []byte, i int) int {
+	return int(b[i/8]>>(7-i%8)) & 1
+}
@@ -0,0 +1,77 @@
+package net
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrefix(t *testing.T) {
+	for s, want := range map[string]string{
+		"192.0.2.1":            "192.0.2.1/32",
+		" 192.0.2.7/24 ":       "192.0.2.0/24",
+		"2001:db8::1":          "2001:db8::1/128",
+		"::ffff:192.0.2.0/120": "192.0.2.0/24",
+	} {
+		p, err := ParsePrefix(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, p.String(), s)
+	}
+
+	for _, s := range []string{"", "192.0.2.256", "192.0.2.0/33", "example.com"} {
+		_, err := ParsePrefix(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPrefixTrie(t *testing.T) {
+	var trie PrefixTrie[string]
+	for _, s := range []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "2001:db8::/32", "2001:db8:1::/48"} {
+		trie.Insert(netip.MustParsePrefix(s), s)
+	}
+	trie.Insert(netip.MustParsePrefix("10.1.0.0/16"), "replaced")
+	assert.Equal(t, 6, trie.Len())
+
+	for addr, want := range map[string]string{
+		"10.1.2.3":        "10.1.2.3/32",
+		"10.1.2.4":        "replaced",
+		"10.2.0.1":        "10.0.0.0/8",
+		"192.0.2.1":       "0.0.0.0/0",
+		"::ffff:10.1.2.3": "10.1.2.3/32",
+		"2001:db8:1::1":   "2001:db8:1::/48",
+		"2001:db8:2::1":   "2001:db8::/32",
+	} {
+		_, v, ok := trie.Lookup(netip.MustParseAddr(addr))
+		assert.True(t, ok, addr)
+		assert.Equal(t, want, v, addr)
+	}
+	assert.False(t, trie.Contains(netip.MustParseAddr("2001:db9::1")))
+	assert.False(t, trie.Contains(netip.Addr{}))
+
+	assert.True(t, trie.Delete(netip.MustParsePrefix("10.1.2.3/32")))
+	assert.False(t, trie.Delete(netip.MustParsePrefix("10.1.2.3/32")))
+	assert.False(t, trie.Delete(netip.MustParsePrefix("2001:db9::/32")))
+	p, _, _ := trie.Lookup(netip.MustParseAddr("10.1.2.3"))
+	assert.Equal(t, "10.1.0.0/16", p.String())
+
+	var prefixes []string
+	for p := range trie.All() {
+		prefixes = append(prefixes, p.String())
+	}
+	assert.Equal(t, []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "2001:db8::/32", "2001:db8:1::/48"}, prefixes)
+}
+
+func BenchmarkPrefixTrieLookup(b *testing.B) {
+	var trie PrefixTrie[bool]
+	for i := range 10000 {
+		trie.Insert(netip.MustParsePrefix(fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)), true)
+	}
+	addr := netip.MustParseAddr("10.20.30.40")
+
+	b.ResetTimer()
+	for range b.N {
+		trie.Lookup(addr)
+	}
+}
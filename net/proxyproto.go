@@ -0,0 +1,558 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/transientvariable/anchor"
+)
+
+// ProxyProtocolTimeout is the default maximum duration for reading a PROXY protocol header.
+const ProxyProtocolTimeout = 5 * time.Second
+
+// PROXY protocol v2 TLV types.
+const (
+	ProxyTLVTypeALPN      byte = 0x01
+	ProxyTLVTypeAuthority byte = 0x02
+	ProxyTLVTypeCRC32C    byte = 0x03
+	ProxyTLVTypeNoop      byte = 0x04
+	ProxyTLVTypeUniqueID  byte = 0x05
+	ProxyTLVTypeSSL       byte = 0x20
+	ProxyTLVTypeNetNS     byte = 0x30
+)
+
+const (
+	proxyV1MaxLength = 107
+	proxyV1Prefix    = "PROXY "
+	proxyV2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+)
+
+// ErrNoProxyHeader is returned when a connection does not start with a PROXY protocol header.
+var ErrNoProxyHeader = errors.New("proxy_protocol: no header")
+
+// ProxyCommand defines the command of a PROXY protocol header.
+type ProxyCommand byte
+
+const (
+	// ProxyCommandLocal indicates that the connection was established by the proxy itself, e.g. for health checks, and
+	// the addresses of the connection should be used.
+	ProxyCommandLocal ProxyCommand = 0x0
+
+	// ProxyCommandProxy indicates that the connection was relayed on behalf of the client identified by the header.
+	ProxyCommandProxy ProxyCommand = 0x1
+)
+
+// ProxyTLV is a type-length-value vector of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader defines the attributes of a PROXY protocol header, which conveys the addresses of the original client
+// connection through a layer 4 proxy.
+//
+// The source and destination addresses are nil if the header does not indicate them, e.g. for the "UNKNOWN" protocol
+// of version 1, or an unspecified address family of version 2.
+type ProxyHeader struct {
+	Command     ProxyCommand
+	Destination net.Addr
+	Source      net.Addr
+	TLVs        []ProxyTLV
+	Version     int
+}
+
+// TLV returns the value of the first ProxyTLV of the provided type.
+func (h *ProxyHeader) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// String returns a string representation of the ProxyHeader.
+func (h *ProxyHeader) String() string {
+	m := map[string]any{
+		"command": h.Command,
+		"tlvs":    len(h.TLVs),
+		"version": h.Version,
+	}
+	if h.Source != nil {
+		m["source"] = h.Source.String()
+	}
+	if h.Destination != nil {
+		m["destination"] = h.Destination.String()
+	}
+	return string(anchor.ToJSON(m))
+}
+
+// WriteTo writes the ProxyHeader to w using the format of its version, which defaults to version 1. TLVs are only
+// written for version 2.
+//
+// If the source and destination addresses are not both TCP addresses, the header is written with the "UNKNOWN"
+// protocol for version 1, or the "LOCAL" command for version 2.
+func (h *ProxyHeader) WriteTo(w io.Writer) (int64, error) {
+	var b []byte
+	switch h.Version {
+	case 0, 1:
+		b = h.appendV1(nil)
+	case 2:
+		var err error
+		if b, err = h.appendV2(nil); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("proxy_protocol: unsupported version %d", h.Version)
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (h *ProxyHeader) appendV1(b []byte) []byte {
+	src, dst, ok := h.addrPorts()
+	if !ok || h.Command == ProxyCommandLocal {
+		return append(b, "PROXY UNKNOWN\r\n"...)
+	}
+
+	proto := "TCP4"
+	if !src.Addr().Is4() {
+		proto = "TCP6"
+	}
+	return fmt.Appendf(b, "PROXY %s %s %s %d %d\r\n", proto, src.Addr(), dst.Addr(), src.Port(), dst.Port())
+}
+
+func (h *ProxyHeader) appendV2(b []byte) ([]byte, error) {
+	var (
+		addrs  []byte
+		family byte
+	)
+
+	cmd := h.Command
+	if src, dst, ok := h.addrPorts(); ok && cmd == ProxyCommandProxy {
+		if src.Addr().Is4() {
+			family = 0x11
+			addrs = append(src.Addr().AsSlice(), dst.Addr().AsSlice()...)
+		} else {
+			family = 0x21
+			s, d := src.Addr().As16(), dst.Addr().As16()
+			addrs = append(s[:], d[:]...)
+		}
+		addrs = binary.BigEndian.AppendUint16(addrs, src.Port())
+		addrs = binary.BigEndian.AppendUint16(addrs, dst.Port())
+	} else {
+		cmd = ProxyCommandLocal
+	}
+
+	for _, tlv := range h.TLVs {
+		if len(tlv.Value) > 0xffff {
+			return nil, fmt.Errorf("proxy_protocol: TLV %#x exceeds maximum length", tlv.Type)
+		}
+		addrs = append(addrs, tlv.Type)
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(len(tlv.Value)))
+		addrs = append(addrs, tlv.Value...)
+	}
+
+	if len(addrs) > 0xffff {
+		return nil, errors.New("proxy_protocol: header exceeds maximum length")
+	}
+
+	b = append(b, proxyV2Signature...)
+	b = append(b, 0x20|byte(cmd), family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+	return append(b, addrs...), nil
+}
+
+// addrPorts returns the source and destination addresses of the ProxyHeader if they are both TCP addresses. IPv4
+// addresses are mapped to IPv6 if the address families differ.
+func (h *ProxyHeader) addrPorts() (netip.AddrPort, netip.AddrPort, bool) {
+	s, ok := h.Source.(*net.TCPAddr)
+	if !ok || s == nil {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	d, ok := h.Destination.(*net.TCPAddr)
+	if !ok || d == nil {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	src, dst := s.AddrPort(), d.AddrPort()
+	if !src.Addr().IsValid() || !dst.Addr().IsValid() {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	if src.Addr().Is4() != dst.Addr().Is4() {
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+	}
+	return src, dst, true
+}
+
+// ReadProxyHeader reads a version 1 or version 2 PROXY protocol header from r. If r does not start with a header,
+// ErrNoProxyHeader is returned and no bytes are consumed.
+func ReadProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		if b, err = r.Peek(len(proxyV1Prefix)); err != nil || string(b) != proxyV1Prefix {
+			return nil, errors.Join(ErrNoProxyHeader, err)
+		}
+		return readProxyHeaderV1(r)
+	case proxyV2Signature[0]:
+		if b, err = r.Peek(len(proxyV2Signature)); err != nil || string(b) != proxyV2Signature {
+			return nil, errors.Join(ErrNoProxyHeader, err)
+		}
+		return readProxyHeaderV2(r)
+	}
+	return nil, ErrNoProxyHeader
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxy_protocol: %w", err)
+		}
+
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("proxy_protocol: invalid v1 header: missing CRLF")
+	}
+
+	h := &ProxyHeader{Command: ProxyCommandProxy, Version: 1}
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// the remainder of an UNKNOWN header must be ignored
+		return h, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxy_protocol: invalid v1 header: %q", s)
+	}
+
+	var err error
+	if h.Source, err = parseProxyV1Addr(fields[1], fields[2], fields[4]); err != nil {
+		return nil, err
+	}
+	if h.Destination, err = parseProxyV1Addr(fields[1], fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseProxyV1Addr(proto string, addr string, port string) (net.Addr, error) {
+	a, err := netip.ParseAddr(addr)
+	if err != nil || a.Is4() != (proto == "TCP4") || a.Zone() != "" {
+		return nil, fmt.Errorf("proxy_protocol: invalid v1 address %q", addr)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("proxy_protocol: invalid v1 port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, uint16(p))), nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (*ProxyHeader, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, fmt.Errorf("proxy_protocol: %w", err)
+	}
+
+	if v := fixed[12] >> 4; v != 2 {
+		return nil, fmt.Errorf("proxy_protocol: invalid v2 version %d", v)
+	}
+
+	h := &ProxyHeader{Command: ProxyCommand(fixed[12] & 0x0f), Version: 2}
+	if h.Command != ProxyCommandLocal && h.Command != ProxyCommandProxy {
+		return nil, fmt.Errorf("proxy_protocol: invalid v2 command %#x", h.Command)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("proxy_protocol: %w", err)
+	}
+
+	var n int
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+	switch family {
+	case 0x0:
+	case 0x1, 0x2:
+		size := 4
+		if family == 0x2 {
+			size = 16
+		}
+
+		n = 2*size + 4
+		if len(payload) < n {
+			return nil, errors.New("proxy_protocol: v2 address block too short")
+		}
+
+		src, _ := netip.AddrFromSlice(payload[:size])
+		dst, _ := netip.AddrFromSlice(payload[size : 2*size])
+		sport := binary.BigEndian.Uint16(payload[2*size:])
+		dport := binary.BigEndian.Uint16(payload[2*size+2:])
+		h.Source = inetAddr(transport, netip.AddrPortFrom(src, sport))
+		h.Destination = inetAddr(transport, netip.AddrPortFrom(dst, dport))
+	case 0x3:
+		n = 216
+		if len(payload) < n {
+			return nil, errors.New("proxy_protocol: v2 address block too short")
+		}
+
+		network := "unix"
+		if transport == 0x2 {
+			network = "unixgram"
+		}
+		h.Source = &net.UnixAddr{Name: string(bytes.TrimRight(payload[:108], "\x00")), Net: network}
+		h.Destination = &net.UnixAddr{Name: string(bytes.TrimRight(payload[108:216], "\x00")), Net: network}
+	default:
+		return nil, fmt.Errorf("proxy_protocol: invalid v2 address family %#x", family)
+	}
+
+	for tlvs := payload[n:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, errors.New("proxy_protocol: truncated v2 TLV")
+		}
+
+		l := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+l {
+			return nil, errors.New("proxy_protocol: truncated v2 TLV")
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+l]})
+		tlvs = tlvs[3+l:]
+	}
+	return h, nil
+}
+
+func inetAddr(transport byte, ap netip.AddrPort) net.Addr {
+	if transport == 0x2 {
+		return net.UDPAddrFromAddrPort(ap)
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+// ProxyProtocolOption is a container for optional properties that can be used for initializing a PROXY protocol
+// listener.
+type ProxyProtocolOption struct {
+	required bool
+	timeout  time.Duration
+	trusted  []netip.Prefix
+}
+
+// WithProxyProtocolRequired sets whether connections from trusted sources must start with a PROXY protocol header.
+// If not required, connections from trusted sources without a header use the addresses of the connection.
+func WithProxyProtocolRequired(required bool) func(*ProxyProtocolOption) {
+	return func(o *ProxyProtocolOption) {
+		o.required = required
+	}
+}
+
+// WithProxyProtocolTimeout sets the maximum duration for reading the PROXY protocol header of a connection.
+func WithProxyProtocolTimeout(timeout time.Duration) func(*ProxyProtocolOption) {
+	return func(o *ProxyProtocolOption) {
+		o.timeout = timeout
+	}
+}
+
+// WithProxyProtocolTrusted appends to the list of network prefixes of the sources that are trusted to send a PROXY
+// protocol header, e.g. the addresses of a load balancer.
+func WithProxyProtocolTrusted(prefixes ...netip.Prefix) func(*ProxyProtocolOption) {
+	return func(o *ProxyProtocolOption) {
+		o.trusted = append(o.trusted, prefixes...)
+	}
+}
+
+// NewProxyProtocolListener returns a net.Listener that reads the PROXY protocol header of connections accepted from
+// trusted sources. The RemoteAddr and LocalAddr of these connections return the source and destination addresses of
+// the header, and the header can be retrieved using ProxyHeaderFromConn.
+//
+// Connections from untrusted sources are returned as is, so a header sent by an untrusted source is passed on to the
+// application protocol. At least one trusted prefix is required; use "0.0.0.0/0" and "::/0" to trust all sources.
+//
+// The header is read on the first call to Read, RemoteAddr, or LocalAddr, so a slow client does not block Accept.
+func NewProxyProtocolListener(l net.Listener, options ...func(*ProxyProtocolOption)) (net.Listener, error) {
+	opts := &ProxyProtocolOption{timeout: ProxyProtocolTimeout}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	if len(opts.trusted) == 0 {
+		return nil, errors.New("proxy_protocol: at least one trusted prefix is required")
+	}
+
+	for i, p := range opts.trusted {
+		if !p.IsValid() {
+			return nil, fmt.Errorf("proxy_protocol: invalid trusted prefix %q", p)
+		}
+		opts.trusted[i] = p.Masked()
+	}
+	return &proxyListener{Listener: l, options: opts}, nil
+}
+
+type proxyListener struct {
+	net.Listener
+	options *ProxyProtocolOption
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, options: l.options, r: bufio.NewReader(conn)}, nil
+}
+
+func (l *proxyListener) trusted(addr net.Addr) bool {
+	a, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	ip := a.AddrPort().Addr().Unmap()
+	for _, p := range l.options.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a net.Conn accepted from a trusted source that may start with a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	deadline atomic.Int64
+	err      error
+	header   *ProxyHeader
+	once     sync.Once
+	options  *ProxyProtocolOption
+	r        *bufio.Reader
+}
+
+// Read reads data from the connection following the PROXY protocol header.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// LocalAddr returns the destination address of the PROXY protocol header, or the local address of the connection.
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && c.header.Command == ProxyCommandProxy && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// RemoteAddr returns the source address of the PROXY protocol header, or the remote address of the connection.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && c.header.Command == ProxyCommandProxy && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines for the connection.
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.deadline.Store(t.UnixNano())
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline for the connection.
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.deadline.Store(t.UnixNano())
+	return c.Conn.SetReadDeadline(t)
+}
+
+// CloseWrite shuts down the write side of the underlying connection, if supported.
+func (c *proxyConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// NetConn returns the underlying connection.
+func (c *proxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *proxyConn) readHeader() {
+	if c.options.timeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.options.timeout))
+
+		// restore the deadline set by the application, if any
+		defer func() {
+			var t time.Time
+			if d := c.deadline.Load(); d != 0 {
+				t = time.Unix(0, d)
+			}
+			_ = c.Conn.SetReadDeadline(t)
+		}()
+	}
+
+	h, err := ReadProxyHeader(c.r)
+	if err != nil {
+		// without a required header, a client waiting for the server to speak first must not fail the connection
+		if !c.options.required &&
+			(errors.Is(err, ErrNoProxyHeader) || (errors.Is(err, os.ErrDeadlineExceeded) && c.r.Buffered() == 0)) {
+			return
+		}
+		c.err = err
+		return
+	}
+	c.header = h
+}
+
+// ProxyHeaderFromConn returns the PROXY protocol header read from the connection, if any. Connections wrapping the
+// connection accepted by a PROXY protocol listener, such as a *tls.Conn, are unwrapped using their NetConn method.
+func ProxyHeaderFromConn(conn net.Conn) (*ProxyHeader, bool) {
+	for conn != nil {
+		if c, ok := conn.(*proxyConn); ok {
+			c.once.Do(c.readHeader)
+			return c.header, c.header != nil
+		}
+
+		u, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = u.NetConn()
+	}
+	return nil, false
+}
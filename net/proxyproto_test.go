@@ -0,0 +1,134 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyHeader(t *testing.T) {
+	src := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:56324"))
+	dst := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:443"))
+	src6 := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("[2001:db8::1]:56324"))
+
+	var buf bytes.Buffer
+	_, err := (&ProxyHeader{Command: ProxyCommandProxy, Source: src, Destination: dst, Version: 1}).WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", buf.String())
+
+	for _, h := range []*ProxyHeader{
+		{Command: ProxyCommandProxy, Source: src, Destination: dst, Version: 1},
+		{Command: ProxyCommandProxy, Source: src6, Destination: dst, Version: 1},
+		{Command: ProxyCommandProxy, Source: src, Destination: dst, Version: 2},
+		{Command: ProxyCommandProxy, Source: src6, Destination: dst, Version: 2, TLVs: []ProxyTLV{
+			{Type: ProxyTLVTypeALPN, Value: []byte("h2")},
+			{Type: ProxyTLVTypeAuthority, Value: []byte("example.com")},
+		}},
+		{Command: ProxyCommandLocal, Version: 2},
+	} {
+		buf.Reset()
+		_, err := h.WriteTo(&buf)
+		assert.NoError(t, err)
+		buf.WriteString("payload")
+
+		r := bufio.NewReader(&buf)
+		parsed, err := ReadProxyHeader(r)
+		if !assert.NoError(t, err, h.String()) {
+			continue
+		}
+		assert.Equal(t, h.Version, parsed.Version)
+		assert.Equal(t, h.Command, parsed.Command)
+		assert.Equal(t, h.TLVs, parsed.TLVs)
+		if h.Command == ProxyCommandProxy {
+			assert.Equal(t, h.Source.(*net.TCPAddr).AddrPort(), parsed.Source.(*net.TCPAddr).AddrPort())
+			assert.Equal(t, 443, parsed.Destination.(*net.TCPAddr).Port)
+		}
+
+		b, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "payload", string(b))
+	}
+
+	for _, s := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 056324 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n",
+		"PROXY " + strings.Repeat("A", 120),
+		proxyV2Signature + "\x31\x11\x00\x0c",
+		proxyV2Signature + "\x21\x11\x00\x04\x00\x00\x00\x00",
+	} {
+		_, err := ReadProxyHeader(bufio.NewReader(strings.NewReader(s)))
+		assert.Error(t, err, s)
+		assert.NotErrorIs(t, err, ErrNoProxyHeader, s)
+	}
+
+	r := bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n"))
+	_, err = ReadProxyHeader(r)
+	assert.ErrorIs(t, err, ErrNoProxyHeader)
+	assert.Equal(t, len("GET / HTTP/1.1\r\n"), r.Buffered())
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	_, err := NewProxyProtocolListener(nil)
+	assert.Error(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	accept := func(l net.Listener, header string) (net.Conn, string) {
+		c, err := net.Dial("tcp", l.Addr().String())
+		assert.NoError(t, err)
+		defer c.Close()
+		_, err = io.WriteString(c, header+"payload")
+		assert.NoError(t, err)
+		assert.NoError(t, c.(*net.TCPConn).CloseWrite())
+
+		conn, err := l.Accept()
+		assert.NoError(t, err)
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		b, _ := io.ReadAll(conn)
+		return conn, string(b)
+	}
+
+	trusted, err := NewProxyProtocolListener(l, WithProxyProtocolTrusted(netip.MustParsePrefix("127.0.0.0/8")))
+	assert.NoError(t, err)
+
+	conn, payload := accept(trusted, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	assert.Equal(t, "payload", payload)
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.1:443", conn.LocalAddr().String())
+	h, ok := ProxyHeaderFromConn(conn)
+	assert.True(t, ok)
+	assert.Equal(t, 1, h.Version)
+
+	// the header is optional unless required
+	conn, payload = accept(trusted, "")
+	assert.Equal(t, "payload", payload)
+	assert.Equal(t, l.Addr().(*net.TCPAddr).IP.String(), conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	_, ok = ProxyHeaderFromConn(conn)
+	assert.False(t, ok)
+
+	required, err := NewProxyProtocolListener(l,
+		WithProxyProtocolTrusted(netip.MustParsePrefix("127.0.0.0/8")),
+		WithProxyProtocolRequired(true))
+	assert.NoError(t, err)
+	_, payload = accept(required, "")
+	assert.Empty(t, payload)
+
+	// headers from untrusted sources are not parsed
+	untrusted, err := NewProxyProtocolListener(l, WithProxyProtocolTrusted(netip.MustParsePrefix("192.0.2.0/24")))
+	assert.NoError(t, err)
+	conn, payload = accept(untrusted, "PROXY UNKNOWN\r\n")
+	assert.Equal(t, "PROXY UNKNOWN\r\npayload", payload)
+	_, ok = ProxyHeaderFromConn(conn)
+	assert.False(t, ok)
+}
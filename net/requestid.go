@@ -0,0 +1,58 @@
+package net
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+)
+
+// MetadataRequestID is the gRPC metadata key used for propagating request IDs.
+const MetadataRequestID = "x-request-id"
+
+// crockford is the Crockford base32 alphabet, which preserves the sort order of the encoded bytes.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type requestIDKey struct{}
+
+// NewRequestID generates a new unique request ID.
+//
+// The ID is a 26 character string composed of a 48-bit millisecond timestamp followed by 80 random bits, encoded
+// using Crockford base32. IDs generated at different milliseconds sort lexicographically by time.
+func NewRequestID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		// crypto/rand does not fail on supported platforms, but fall back to the time alone
+		clear(b[6:])
+	}
+	return encodeCrockford(b)
+}
+
+// ContextWithRequestID returns a copy of the provided context containing the request ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored in the provided context, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// encodeCrockford encodes the 128-bit value as 26 Crockford base32 characters, most significant bits first.
+func encodeCrockford(b [16]byte) string {
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
@@ -0,0 +1,47 @@
+package net
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRequestID(t *testing.T) {
+	var ids []string
+	for range 3 {
+		id := NewRequestID()
+		assert.Len(t, id, 26)
+		assert.Empty(t, strings.Trim(id, crockford), id)
+		ids = append(ids, id)
+
+		// IDs generated at different milliseconds sort by time
+		time.Sleep(2 * time.Millisecond)
+	}
+	assert.True(t, slices.IsSorted(ids), ids)
+	assert.NotEqual(t, NewRequestID(), NewRequestID())
+}
+
+func TestEncodeCrockford(t *testing.T) {
+	assert.Equal(t, strings.Repeat("0", 26), encodeCrockford([16]byte{}))
+	assert.Equal(t, strings.Repeat("0", 25)+"1", encodeCrockford([16]byte{15: 1}))
+	assert.Equal(t, "7"+strings.Repeat("Z", 25), encodeCrockford([16]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}))
+}
+
+func TestRequestIDContext(t *testing.T) {
+	ctx := ContextWithRequestID(context.Background(), "id")
+	id, ok := RequestIDFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "id", id)
+
+	_, ok = RequestIDFromContext(context.Background())
+	assert.False(t, ok)
+
+	_, ok = RequestIDFromContext(ContextWithRequestID(context.Background(), ""))
+	assert.False(t, ok)
+}